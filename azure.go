@@ -0,0 +1,26 @@
+// azure.go
+package main
+
+import "strings"
+
+// Azure OpenAI support.
+//
+// Azure routes by deployment rather than model:
+// /openai/deployments/{deployment}/chat/completions?api-version=...
+// The newer v1 surface (/openai/v1/chat/completions, /openai/v1/responses)
+// takes the deployment as "model" in the body. Auth is an "api-key" header or
+// an Entra ID bearer token. Payloads are OpenAI-compatible, so parsing,
+// session tracking and streaming reuse the OpenAI code paths; conversation
+// paths are registered with the built-in "azure" provider.
+
+// extractAzureDeployment extracts the deployment name from an Azure OpenAI path.
+// Path format: /openai/deployments/{deployment}/chat/completions
+// Returns empty string for paths without a deployment segment.
+func extractAzureDeployment(path string) string {
+	rest, ok := strings.CutPrefix(path, "/openai/deployments/")
+	if !ok {
+		return ""
+	}
+	deployment, _, _ := strings.Cut(rest, "/")
+	return deployment
+}
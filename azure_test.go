@@ -0,0 +1,116 @@
+// azure_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExtractAzureDeployment(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/openai/deployments/gpt-4o/chat/completions", "gpt-4o"},
+		{"/openai/deployments/my-prod-deploy/completions", "my-prod-deploy"},
+		{"/openai/v1/chat/completions", ""},
+		{"/v1/chat/completions", ""},
+	}
+	for _, tt := range tests {
+		if got := extractAzureDeployment(tt.path); got != tt.want {
+			t.Errorf("extractAzureDeployment(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAzureLokiModelLabel(t *testing.T) {
+	entry := map[string]interface{}{
+		"type": "request",
+		"path": "/openai/deployments/gpt-4o-prod/chat/completions",
+		"body": `{"messages":[{"role":"user","content":"hi"}],"stream":true}`,
+	}
+	model, _, stream, _, _, _ := extractExtendedLabels(entry, "request")
+	if model != "gpt-4o-prod" {
+		t.Errorf("model = %q, want gpt-4o-prod", model)
+	}
+	if stream != "true" {
+		t.Errorf("stream = %q", stream)
+	}
+
+	// A model in the body (v1 surface) wins over the path.
+	entry["path"] = "/openai/v1/chat/completions"
+	entry["body"] = `{"model":"gpt-4.1-mini","messages":[]}`
+	if model, _, _, _, _, _ := extractExtendedLabels(entry, "request"); model != "gpt-4.1-mini" {
+		t.Errorf("model = %q, want gpt-4.1-mini", model)
+	}
+}
+
+func TestAzureProxySessionAndObfuscation(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	var gotPath, gotQuery, gotKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		gotKey = r.Header.Get("api-key")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":3}}`))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	const apiKey = "0123456789abcdef0123456789abcdef"
+	for i := 0; i < 2; i++ {
+		body := `{"messages":[{"role":"user","content":"hi"}],"metadata":{"session_id":"azure-session-1"}}`
+		req := httptest.NewRequest("POST", "/azure/"+upstreamHost+"/openai/deployments/gpt-4o/chat/completions?api-version=2024-10-21", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("api-key", apiKey)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	logger.Close()
+
+	if gotPath != "/openai/deployments/gpt-4o/chat/completions" || gotQuery != "api-version=2024-10-21" {
+		t.Errorf("upstream got %s?%s", gotPath, gotQuery)
+	}
+	if gotKey != apiKey {
+		t.Errorf("upstream api-key = %q, want the original key", gotKey)
+	}
+
+	if len(emitter.TurnStartEvents) != 2 {
+		t.Fatalf("Expected 2 turn_start events, got %d", len(emitter.TurnStartEvents))
+	}
+	if emitter.TurnStartEvents[0].Provider != "azure" {
+		t.Errorf("provider = %q, want azure", emitter.TurnStartEvents[0].Provider)
+	}
+	if emitter.TurnStartEvents[1].SessionID != emitter.TurnStartEvents[0].SessionID {
+		t.Error("requests with the same session_id should share a session")
+	}
+	if len(emitter.TurnEndEvents) != 2 || emitter.TurnEndEvents[0].StopReason != "stop" {
+		t.Errorf("TurnEndEvents = %+v", emitter.TurnEndEvents)
+	}
+
+	// The raw key must never reach the session logs.
+	filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".jsonl") {
+			return nil
+		}
+		data, _ := os.ReadFile(path)
+		if strings.Contains(string(data), apiKey) {
+			t.Errorf("%s contains the unobfuscated api-key", path)
+		}
+		return nil
+	})
+}
@@ -5,19 +5,21 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
+	"sort"
 	"strings"
 	"sync/atomic"
 	"time"
 
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
 	"github.com/google/uuid"
@@ -27,148 +29,328 @@ import (
 // Allows alphanumeric, dots, hyphens, underscores, and optional :version suffix.
 var validModelID = regexp.MustCompile(`^[a-zA-Z0-9._-]+(:[0-9]+)?$`)
 
+// validModelARN validates Bedrock model and inference profile ARNs, e.g.
+// arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-...
+// The resource ID is held to the same character set as validModelID.
+var validModelARN = regexp.MustCompile(`^arn:aws(-[a-z]+)*:bedrock:[a-z0-9-]+:([0-9]{12})?:(inference-profile|application-inference-profile|foundation-model|provisioned-model|custom-model-deployment)/[a-zA-Z0-9._-]+(:[0-9]+)?$`)
+
+// bedrockRegionHeader lets a request pick its Bedrock region.
+const bedrockRegionHeader = "X-Bedrock-Region"
+
+// bedrockRegionPrefix is the optional path prefix for picking a region:
+// /bedrock/{region}/model/{modelId}/...
+const bedrockRegionPrefix = "/bedrock/"
+
 // bedrockMaxRequestBody is the max request body size for Bedrock requests (16 MB).
 const bedrockMaxRequestBody = 16 << 20
 
-// bedrockMaxBuffer is the max buffer size for observability decode (4 MB).
+// bedrockMaxFrame is the max size of a single eventstream frame held for
+// decoding (4 MB). Only the current partial frame is buffered, so decode memory
+// is bounded regardless of total response size.
+const bedrockMaxFrame = 4 << 20
+
+// bedrockMaxBuffer is the max size of the decoded chunks kept for
+// observability (4 MB). Later content deltas are still forwarded but not
+// recorded; the other events, which carry the stop reason and usage, are.
 const bedrockMaxBuffer = 4 << 20
 
-// bedrockMaxConcurrent is the max concurrent Bedrock requests.
-const bedrockMaxConcurrent = 5
+// bedrockFramePrelude is the eventstream prelude length (total length, headers
+// length, prelude CRC).
+const bedrockFramePrelude = 12
 
 // bedrockMaxErrorBody is the max error response body to read (1 MB).
 const bedrockMaxErrorBody = 1 << 20
 
-// LimitedWriter stops writing after N bytes. NEVER returns an error —
-// this is critical because io.TeeReader propagates Write errors to io.Copy,
-// which would break the client stream.
-type LimitedWriter struct {
-	W        io.Writer
-	N        int64
-	Overflow bool
-}
-
-func (lw *LimitedWriter) Write(p []byte) (int, error) {
-	if lw.Overflow {
-		return len(p), nil
-	}
-	if int64(len(p)) > lw.N {
-		lw.Overflow = true
-		return len(p), nil
-	}
-	n, _ := lw.W.Write(p)
-	lw.N -= int64(n)
-	return len(p), nil
-}
-
 // extractModelID extracts and validates the model ID from a Bedrock URL path.
-// Path format: /model/{modelId}/invoke or /model/{modelId}/invoke-with-response-stream
+// Path format: /model/{modelId}/{invoke,invoke-with-response-stream,converse,converse-stream}
+// The model ID segment may be percent-encoded (ARNs arrive with %3A and %2F),
+// so path should be the escaped request path.
 func extractModelID(path string) (string, error) {
+	modelID, _, err := splitBedrockPath(path)
+	return modelID, err
+}
+
+// splitBedrockPath splits an escaped Bedrock path into the decoded, validated
+// model ID and the operation that follows it.
+func splitBedrockPath(path string) (modelID, operation string, err error) {
 	trimmed := strings.TrimPrefix(path, "/model/")
 	if trimmed == path {
-		return "", fmt.Errorf("path does not start with /model/")
+		return "", "", fmt.Errorf("path does not start with /model/")
 	}
 	parts := strings.SplitN(trimmed, "/", 2)
 	if len(parts) == 0 || parts[0] == "" {
-		return "", fmt.Errorf("empty model ID in path %q", path)
+		return "", "", fmt.Errorf("empty model ID in path %q", path)
+	}
+	modelID, err = url.PathUnescape(parts[0])
+	if err != nil {
+		return "", "", fmt.Errorf("invalid model ID: %q", parts[0])
 	}
-	modelID := parts[0]
-	if !validModelID.MatchString(modelID) {
-		return "", fmt.Errorf("invalid model ID: %q", modelID)
+	if !validModelID.MatchString(modelID) && !validModelARN.MatchString(modelID) {
+		return "", "", fmt.Errorf("invalid model ID: %q", modelID)
 	}
-	return modelID, nil
+	if len(parts) == 2 {
+		operation = parts[1]
+	}
+	return modelID, operation, nil
+}
+
+// bedrockModelPath builds the upstream path for a model ID and operation,
+// percent-encoding the ID the way the AWS SDKs do so the signed path is
+// canonical regardless of how the client encoded it.
+func bedrockModelPath(modelID, operation string) string {
+	escaped := strings.NewReplacer(":", "%3A", "/", "%2F").Replace(modelID)
+	return "/model/" + escaped + "/" + operation
 }
 
-// isBedrockStreaming returns true if the path ends with invoke-with-response-stream.
+// splitBedrockRegionPrefix strips an optional /bedrock/{region} prefix,
+// returning the region ("" if absent) and the remaining /model/... path.
+func splitBedrockRegionPrefix(path string) (region, rest string) {
+	trimmed := strings.TrimPrefix(path, bedrockRegionPrefix)
+	if trimmed == path {
+		return "", path
+	}
+	i := strings.Index(trimmed, "/")
+	if i < 0 {
+		return trimmed, ""
+	}
+	return trimmed[:i], trimmed[i:]
+}
+
+// isBedrockPath returns true for /model/... paths, with or without a
+// /bedrock/{region} prefix.
+func isBedrockPath(path string) bool {
+	_, rest := splitBedrockRegionPrefix(path)
+	return strings.HasPrefix(rest, "/model/")
+}
+
+// isBedrockStreaming returns true if the path ends with invoke-with-response-stream
+// or converse-stream.
 func isBedrockStreaming(path string) bool {
-	return strings.HasSuffix(path, "/invoke-with-response-stream")
+	return strings.HasSuffix(path, "/invoke-with-response-stream") || strings.HasSuffix(path, "/converse-stream")
 }
 
-// decodeBedrockEventstream decodes a complete Bedrock eventstream response buffer
-// into normalized StreamChunks (with "data: " prefix for parser compatibility).
-// Returns any successfully decoded chunks even if the buffer is truncated.
-func decodeBedrockEventstream(buf []byte) ([]StreamChunk, error) {
-	if len(buf) == 0 {
-		return nil, nil
+// bedrockStreamDecoder decodes Bedrock eventstream frames incrementally as
+// bytes pass through to the client. Write NEVER returns an error — this is
+// critical because io.TeeReader propagates Write errors to io.Copy, which would
+// break the client stream. Decode failures are reported by Finish instead.
+type bedrockStreamDecoder struct {
+	decoder    *eventstream.Decoder
+	start      time.Time
+	firstByte  time.Time
+	pending    []byte // current partial frame
+	chunks     []StreamChunk
+	size       int                // bytes of chunk data recorded
+	overflow   bool               // content deltas past bedrockMaxBuffer were dropped
+	exceptions []bedrockException // exception frames, in stream order
+	err        error
+	failed     bool // framing lost; remaining bytes are ignored
+}
+
+// bedrockException is a mid-stream exception frame, e.g. a throttlingException
+// or modelStreamErrorException sent after the 200 response.
+type bedrockException struct {
+	Type    string
+	Message string
+}
+
+func newBedrockStreamDecoder(start time.Time) *bedrockStreamDecoder {
+	return &bedrockStreamDecoder{
+		decoder: eventstream.NewDecoder(),
+		start:   start,
 	}
+}
 
-	decoder := eventstream.NewDecoder()
-	reader := bytes.NewReader(buf)
-	var chunks []StreamChunk
-	var lastErr error
+func (d *bedrockStreamDecoder) Write(p []byte) (int, error) {
+	if len(p) == 0 || d.failed {
+		return len(p), nil
+	}
+	now := time.Now()
+	if d.firstByte.IsZero() {
+		d.firstByte = now
+	}
+	d.pending = append(d.pending, p...)
 
-	for reader.Len() > 0 {
-		msg, err := decoder.Decode(reader, nil)
-		if err != nil {
-			lastErr = fmt.Errorf("eventstream decode: %w", err)
+	for len(d.pending) >= 4 {
+		total := int(binary.BigEndian.Uint32(d.pending[:4]))
+		if total < bedrockFramePrelude || total > bedrockMaxFrame {
+			d.fail(fmt.Errorf("eventstream decode: invalid frame length %d", total))
+			break
+		}
+		if len(d.pending) < total {
 			break
 		}
 
-		// Parse the frame payload: {"bytes": "<base64>", "p": "<padding>"}
-		var payload struct {
-			Bytes string `json:"bytes"`
+		msg, err := d.decoder.Decode(bytes.NewReader(d.pending[:total]), nil)
+		if err != nil {
+			d.fail(fmt.Errorf("eventstream decode: %w", err))
+			break
 		}
-		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			// Skip frames with non-JSON payload (e.g., exception frames)
+		d.pending = append(d.pending[:0], d.pending[total:]...)
+
+		raw, err := bedrockFrameData(msg)
+		if err != nil {
+			d.err = err
 			continue
 		}
-		if payload.Bytes == "" {
+		if raw == "" {
+			if ex, ok := bedrockExceptionFrame(msg); ok {
+				d.exceptions = append(d.exceptions, ex)
+			}
 			continue
 		}
-
-		// Base64-decode to get the Anthropic event JSON
-		decoded, err := base64.StdEncoding.DecodeString(payload.Bytes)
-		if err != nil {
-			// Try URL-safe encoding as fallback
-			decoded, err = base64.URLEncoding.DecodeString(payload.Bytes)
-			if err != nil {
-				lastErr = fmt.Errorf("base64 decode: %w", err)
+		if d.overflow || d.size+len(raw) > bedrockMaxBuffer {
+			d.overflow = true
+			if bedrockContentDelta(raw) {
 				continue
 			}
 		}
-
-		// Prepend "data: " for compatibility with ParseStreamingResponse
-		chunks = append(chunks, StreamChunk{
-			Raw:       "data: " + string(decoded),
-			Timestamp: time.Now(),
+		d.size += len(raw)
+		d.chunks = append(d.chunks, StreamChunk{
+			Timestamp: now,
+			DeltaMs:   now.Sub(d.start).Milliseconds(),
+			Raw:       "data: " + raw,
 		})
 	}
+	return len(p), nil
+}
 
-	return chunks, lastErr
+// bedrockContentDelta reports whether a decoded event is a content delta, in
+// the Anthropic (content_block_delta) or Converse (contentBlockDelta) form.
+func bedrockContentDelta(raw string) bool {
+	var event struct {
+		Type  string          `json:"type"`
+		Delta json.RawMessage `json:"contentBlockDelta"`
+	}
+	json.Unmarshal([]byte(raw), &event)
+	return event.Type == "content_block_delta" || event.Delta != nil
 }
 
-// bedrockState holds per-proxy Bedrock resources initialized at startup.
-type bedrockState struct {
-	region     string
-	credProv   aws.CredentialsProvider
-	signer    *v4.Signer
-	client    *http.Client
-	semaphore chan struct{}
-	decodeErrors int64 // atomic counter
+func (d *bedrockStreamDecoder) fail(err error) {
+	d.err = err
+	d.failed = true
+	d.pending = nil
 }
 
-// initBedrock initializes Bedrock resources. Returns nil if Bedrock is not configured.
-func initBedrock(region string) (*bedrockState, error) {
-	if region == "" {
-		return nil, nil
+// Finish returns the decoded chunks and the last decode error, including an
+// error if the stream ended mid-frame.
+func (d *bedrockStreamDecoder) Finish() ([]StreamChunk, error) {
+	if !d.failed && len(d.pending) > 0 {
+		d.fail(fmt.Errorf("eventstream decode: truncated frame (%d bytes)", len(d.pending)))
 	}
+	return d.chunks, d.err
+}
 
-	if err := ValidateBedrockRegion(region); err != nil {
-		return nil, err
+// TTFB returns the time from start to the first response byte, or 0 if no
+// bytes were written.
+func (d *bedrockStreamDecoder) TTFB() time.Duration {
+	if d.firstByte.IsZero() {
+		return 0
 	}
+	return d.firstByte.Sub(d.start)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// decodeBedrockEventstream decodes a complete Bedrock eventstream response buffer
+// into normalized StreamChunks (with "data: " prefix for parser compatibility).
+// Returns any successfully decoded chunks even if the buffer is truncated.
+func decodeBedrockEventstream(buf []byte) ([]StreamChunk, error) {
+	d := newBedrockStreamDecoder(time.Now())
+	d.Write(buf)
+	return d.Finish()
+}
 
-	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(region))
+// bedrockFrameData extracts the event JSON from a decoded eventstream frame.
+// Returns "" for frames that carry no event (e.g., exception frames).
+func bedrockFrameData(msg eventstream.Message) (string, error) {
+	// Parse the frame payload: {"bytes": "<base64>", "p": "<padding>"}
+	var payload struct {
+		Bytes string `json:"bytes"`
+	}
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		// Skip frames with non-JSON payload (e.g., exception frames)
+		return "", nil
+	}
+	if payload.Bytes == "" {
+		// ConverseStream frames carry plain JSON named by :event-type
+		if eventType := bedrockEventType(msg); eventType != "" {
+			if normalized, ok := normalizeConverseFrame(eventType, msg.Payload); ok {
+				return normalized, nil
+			}
+		}
+		return "", nil
+	}
+
+	// Base64-decode to get the Anthropic event JSON
+	decoded, err := base64.StdEncoding.DecodeString(payload.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("load AWS config: %w", err)
+		// Try URL-safe encoding as fallback
+		decoded, err = base64.URLEncoding.DecodeString(payload.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("base64 decode: %w", err)
+		}
 	}
+	return string(decoded), nil
+}
+
+// bedrockEventType returns the :event-type header of an event frame, or ""
+// for exception and error frames.
+func bedrockEventType(msg eventstream.Message) string {
+	if mt := msg.Headers.Get(":message-type"); mt != nil && mt.String() != "event" {
+		return ""
+	}
+	if et := msg.Headers.Get(":event-type"); et != nil {
+		return et.String()
+	}
+	return ""
+}
+
+// bedrockExceptionFrame returns the exception carried by an exception frame.
+func bedrockExceptionFrame(msg eventstream.Message) (bedrockException, bool) {
+	mt := msg.Headers.Get(":message-type")
+	if mt == nil || mt.String() != "exception" {
+		return bedrockException{}, false
+	}
+	ex := bedrockException{}
+	if et := msg.Headers.Get(":exception-type"); et != nil {
+		ex.Type = et.String()
+	}
+	var payload struct {
+		Message string `json:"message"`
+	}
+	json.Unmarshal(msg.Payload, &payload)
+	ex.Message = payload.Message
+	return ex, true
+}
 
-	return &bedrockState{
-		region:   region,
-		credProv: cfg.Credentials,
-		signer:   v4.NewSigner(),
+// bedrockRegion holds the signer for one region.
+type bedrockRegion struct {
+	region string
+	signer *v4.Signer
+}
+
+// bedrockState holds per-proxy Bedrock resources initialized at startup.
+type bedrockState struct {
+	region       string                        // default region
+	regions      map[string]*bedrockRegion     // configured regions, including the default
+	credentials  map[string]*bedrockCredential // named credential sources, including "default"
+	client       *http.Client
+	limits       *bedrockLimits
+	decodeErrors int64 // atomic counter
+}
+
+// initBedrock initializes Bedrock resources for the default region plus any
+// additional regions, the configured credential sources and concurrency
+// limits. Returns nil if Bedrock is not configured.
+func initBedrock(defaultRegion string, regions []string, credentials []BedrockCredentialConfig, concurrency BedrockConcurrencyConfig) (*bedrockState, error) {
+	if defaultRegion == "" && len(regions) > 0 {
+		defaultRegion = regions[0]
+	}
+	if defaultRegion == "" {
+		return nil, nil
+	}
+
+	state := &bedrockState{
+		region:  defaultRegion,
+		regions: make(map[string]*bedrockRegion),
 		client: &http.Client{
 			Transport: &http.Transport{
 				DisableCompression:    true,
@@ -177,10 +359,139 @@ func initBedrock(region string) (*bedrockState, error) {
 			},
 			Timeout: 0,
 		},
-		semaphore: make(chan struct{}, bedrockMaxConcurrent),
+	}
+
+	limits, err := newBedrockLimits(concurrency)
+	if err != nil {
+		return nil, err
+	}
+	state.limits = limits
+
+	for _, region := range append([]string{defaultRegion}, regions...) {
+		if err := ValidateBedrockRegion(region); err != nil {
+			return nil, err
+		}
+		state.regions[region] = &bedrockRegion{region: region, signer: v4.NewSigner()}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	creds, err := initBedrockCredentials(ctx, credentials, defaultRegion)
+	if err != nil {
+		return nil, err
+	}
+	state.credentials = creds
+
+	return state, nil
+}
+
+// regionNames returns the configured region names, sorted.
+func (b *bedrockState) regionNames() []string {
+	names := make([]string, 0, len(b.regions))
+	for name := range b.regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// bedrockRoute is the resolved upstream target for a Bedrock request.
+type bedrockRoute struct {
+	region     *bedrockRegion
+	credential *bedrockCredential
+	modelID    string
+	operation  string
+	path       string // request path with credential and region prefixes removed
+}
+
+// resolve routes a request. Prefixes are stripped outermost first:
+// /{credential prefix}/bedrock/{region}/model/{modelId}/{operation}.
+//
+// The region comes from the /bedrock/{region} path prefix, then the
+// X-Bedrock-Region header, then the default region. Only configured regions
+// are allowed, so the upstream host can't be steered. The credential source is
+// chosen by selectCredential.
+func (b *bedrockState) resolve(r *http.Request) (bedrockRoute, error) {
+	prefixed, path := b.stripCredentialPrefix(r.URL.EscapedPath())
+
+	name, path := splitBedrockRegionPrefix(path)
+	if name == "" {
+		name = r.Header.Get(bedrockRegionHeader)
+	}
+	if name == "" {
+		name = b.region
+	}
+	region, ok := b.regions[name]
+	if !ok {
+		return bedrockRoute{}, fmt.Errorf("Bedrock region %q not configured", name)
+	}
+
+	modelID, operation, err := splitBedrockPath(path)
+	if err != nil {
+		return bedrockRoute{}, err
+	}
+
+	cred, err := b.selectCredential(r, prefixed, modelID)
+	if err != nil {
+		return bedrockRoute{}, err
+	}
+
+	return bedrockRoute{
+		region:     region,
+		credential: cred,
+		modelID:    modelID,
+		operation:  operation,
+		path:       path,
 	}, nil
 }
 
+// bedrockHost returns the Bedrock runtime host for a region.
+func bedrockHost(region string) string {
+	return fmt.Sprintf("bedrock-runtime.%s.amazonaws.com", region)
+}
+
+// Errors from signedRequest. Their messages are returned to clients; the
+// underlying causes are logged.
+var (
+	errBedrockRequest     = errors.New("failed to create request")
+	errBedrockCredentials = errors.New("failed to retrieve AWS credentials")
+	errBedrockSign        = errors.New("failed to sign request")
+)
+
+// signedRequest builds the SigV4-signed upstream request for a route and
+// records the credential's use.
+func (b *bedrockState) signedRequest(ctx context.Context, route bedrockRoute, body []byte, header http.Header) (*http.Request, error) {
+	// The model ID is re-encoded so ARNs are forwarded as a single path segment
+	upstreamURL := fmt.Sprintf("https://%s%s", bedrockHost(route.region.region), bedrockModelPath(route.modelID, route.operation))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, upstreamURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, errBedrockRequest
+	}
+
+	// Whitelist headers — only copy Content-Type and Accept to avoid SigV4 conflicts
+	if ct := header.Get("Content-Type"); ct != "" {
+		req.Header.Set("Content-Type", ct)
+	}
+	if accept := header.Get("Accept"); accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	creds, err := route.credential.provider.Retrieve(ctx)
+	if err != nil {
+		log.Printf("WARNING: Bedrock credential %q: %v", route.credential.name, err)
+		return nil, errBedrockCredentials
+	}
+	if err := route.region.signer.SignHTTP(ctx, creds, req, sha256Hex(body), "bedrock", route.region.region, time.Now()); err != nil {
+		log.Printf("WARNING: Bedrock signing: %v", err)
+		return nil, errBedrockSign
+	}
+
+	route.credential.recordUse(route.modelID)
+	return req, nil
+}
+
 // serveBedrock handles Bedrock pass-through requests. The proxy signs requests
 // with SigV4, forwards to Bedrock, streams the response to the client, and
 // decodes the eventstream for observability after the stream completes.
@@ -192,23 +503,27 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 
 	startTime := time.Now()
 
-	// Extract and validate model ID
-	modelID, err := extractModelID(r.URL.Path)
+	// Resolve region, credential source and validated model ID
+	route, err := p.bedrock.resolve(r)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	modelID := route.modelID
 
-	streaming := isBedrockStreaming(r.URL.Path)
+	streaming := isBedrockStreaming(route.path)
 
-	// Acquire concurrency semaphore
-	select {
-	case p.bedrock.semaphore <- struct{}{}:
-		defer func() { <-p.bedrock.semaphore }()
-	case <-r.Context().Done():
+	// Acquire a request slot for the model, queueing if none is free
+	release, err := p.bedrock.limits.forModel(modelID).acquire(r.Context())
+	if err != nil {
+		if errors.Is(err, errBedrockQueueFull) || errors.Is(err, errBedrockQueueTimeout) {
+			writeBedrockThrottling(w, err)
+			return
+		}
 		http.Error(w, "request cancelled", http.StatusServiceUnavailable)
 		return
 	}
+	defer release()
 
 	// Read request body (capped)
 	var reqBody []byte
@@ -221,14 +536,15 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 		r.Body.Close()
 	}
 
-	// Use provider=anthropic — Bedrock payloads use the Anthropic JSON format
+	// Use provider=anthropic — InvokeModel payloads use the Anthropic JSON format.
+	// Converse payloads are detected by shape in the parsers.
 	provider := "anthropic"
-	upstream := fmt.Sprintf("bedrock-runtime.%s.amazonaws.com", p.bedrock.region)
+	upstream := bedrockHost(route.region.region)
+	denial := p.policies.check(provider, r.URL.Path, r.Header, proxyIdentity(r.Context()), modelID, reqBody)
 
 	// Session tracking and logging setup
 	var sessionID string
 	var seq int
-	var isNewSession bool
 	var requestID string
 	var patternState *PatternState
 
@@ -243,65 +559,26 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 			mw.SetBedrockContext(requestID, modelID)
 			defer mw.ClearBedrockContext(requestID)
 		}
+		defer p.recordIdentity(r, requestID)()
+		p.recordBedrockCredential(requestID, route.credential)
 
-		if p.sessionManager != nil {
-			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, r.URL.Path)
-			if err != nil {
-				sessionID = p.generateSessionID()
-				seq = 1
-				isNewSession = true
-			}
-
-			if p.eventEmitter != nil {
-				patternState, _ = p.sessionManager.LoadPatternState(sessionID)
-				if patternState == nil {
-					patternState = &PatternState{PendingToolIDs: make(map[string]string)}
-				}
-				errorRecovered := patternState.LastWasError
-				hadError := p.processToolResultsAndEmitEvents(reqBody, sessionID, provider, patternState)
-				patternState.LastWasError = hadError
-				patternState.TurnCount++
-				p.eventEmitter.EmitTurnStart(sessionID, provider, p.machineID, patternState.TurnCount, errorRecovered)
-			}
-		} else {
-			sessionID = p.generateSessionID()
-			seq = 1
-			isNewSession = true
-		}
-
-		if isNewSession {
-			p.logger.LogSessionStart(sessionID, provider, upstream)
-		}
-		p.logger.LogRequest(sessionID, provider, seq, r.Method, r.URL.Path, r.Header, reqBody, requestID)
+		sessionID, seq, patternState = p.beginLoggedTurn(r, reqBody, provider, upstream, requestID)
 	}
 
-	// Build upstream URL — path stays the same since CC sends the Bedrock path format
-	upstreamURL := fmt.Sprintf("https://%s%s", upstream, r.URL.Path)
-
-	// Create the upstream request
-	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, bytes.NewReader(reqBody))
-	if err != nil {
-		http.Error(w, "failed to create request", http.StatusInternalServerError)
+	if denial != nil {
+		p.deny(w, dialectBedrock, denial, provider, sessionID, seq, requestID, patternState)
 		return
 	}
-
-	// Whitelist headers — only copy Content-Type and Accept to avoid SigV4 conflicts
-	if ct := r.Header.Get("Content-Type"); ct != "" {
-		proxyReq.Header.Set("Content-Type", ct)
-	}
-	if accept := r.Header.Get("Accept"); accept != "" {
-		proxyReq.Header.Set("Accept", accept)
+	if shouldLog && p.budgets != nil {
+		defer p.budgets.end(requestID)
+		if !p.checkBudgets(w, dialectBedrock, provider, sessionID, seq, requestID, patternState, "") {
+			return
+		}
 	}
 
-	// SigV4 sign the request
-	bodyHash := sha256Hex(reqBody)
-	creds, err := p.bedrock.credProv.Retrieve(r.Context())
+	proxyReq, err := p.bedrock.signedRequest(r.Context(), route, reqBody, r.Header)
 	if err != nil {
-		http.Error(w, "failed to retrieve AWS credentials", http.StatusInternalServerError)
-		return
-	}
-	if err := p.bedrock.signer.SignHTTP(r.Context(), creds, proxyReq, bodyHash, "bedrock", p.bedrock.region, time.Now()); err != nil {
-		http.Error(w, "failed to sign request", http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
@@ -338,12 +615,11 @@ func (p *Proxy) serveBedrock(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// serveBedrockStreaming handles streaming Bedrock responses using buffer-then-decode.
+// serveBedrockStreaming handles streaming Bedrock responses, decoding
+// eventstream frames incrementally as they are forwarded to the client.
 func (p *Proxy) serveBedrockStreaming(w http.ResponseWriter, resp *http.Response, startTime time.Time, modelID, upstream, provider, sessionID string, seq int, reqBody []byte, requestID string, patternState *PatternState, shouldLog bool) {
-	// Set up TeeReader with LimitedWriter for observability buffer
-	observeBuf := bytes.NewBuffer(make([]byte, 0, bedrockMaxBuffer))
-	limitedW := &LimitedWriter{W: observeBuf, N: int64(bedrockMaxBuffer)}
-	tee := io.TeeReader(resp.Body, limitedW)
+	decoder := newBedrockStreamDecoder(startTime)
+	tee := io.TeeReader(resp.Body, decoder)
 
 	// Forward headers and status
 	copyHeaders(w.Header(), resp.Header)
@@ -352,29 +628,23 @@ func (p *Proxy) serveBedrockStreaming(w http.ResponseWriter, resp *http.Response
 	// Stream raw bytes to client — this is the critical path
 	_, copyErr := io.Copy(w, tee)
 
-	// Critical path done — CC has the full response.
-	// Now decode the buffered copy for observability.
-	ttfb := time.Since(startTime)
 	totalTime := time.Since(startTime)
-
-	if limitedW.Overflow {
-		log.Printf("WARNING: Bedrock response exceeded %d byte buffer, observability decode skipped (model=%s session=%s)", bedrockMaxBuffer, modelID, sessionID)
-		atomic.AddInt64(&p.bedrock.decodeErrors, 1)
+	ttfb := decoder.TTFB()
+	if ttfb == 0 {
+		ttfb = totalTime
 	}
 
 	if copyErr != nil {
 		log.Printf("WARNING: Bedrock stream copy error: %v (model=%s session=%s)", copyErr, modelID, sessionID)
 	}
 
-	// Decode eventstream frames from buffer
-	var chunks []StreamChunk
-	if !limitedW.Overflow && observeBuf.Len() > 0 {
-		var decodeErr error
-		chunks, decodeErr = decodeBedrockEventstream(observeBuf.Bytes())
-		if decodeErr != nil {
-			log.Printf("WARNING: Bedrock decode error: %v (model=%s session=%s, decoded %d chunks before error)", decodeErr, modelID, sessionID, len(chunks))
-			atomic.AddInt64(&p.bedrock.decodeErrors, 1)
-		}
+	chunks, decodeErr := decoder.Finish()
+	if decodeErr != nil {
+		log.Printf("WARNING: Bedrock decode error: %v (model=%s session=%s, decoded %d chunks)", decodeErr, modelID, sessionID, len(chunks))
+		atomic.AddInt64(&p.bedrock.decodeErrors, 1)
+	}
+	if decoder.overflow {
+		log.Printf("WARNING: Bedrock response exceeded %d byte buffer, later content deltas not recorded (model=%s session=%s, recorded %d chunks)", bedrockMaxBuffer, modelID, sessionID, len(chunks))
 	}
 
 	if shouldLog {
@@ -387,12 +657,13 @@ func (p *Proxy) serveBedrockStreaming(w http.ResponseWriter, resp *http.Response
 		// Emit agent observability events
 		if p.eventEmitter != nil && patternState != nil && p.sessionManager != nil && len(chunks) > 0 {
 			parsed := ParseStreamingResponse(chunks)
-			emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, patternState, parsed.Content, parsed.Usage, parsed.StopReason, resp.StatusCode, "")
+			emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, patternState, parsed.Content, parsed.Usage, usageCostOf(p.logger, requestID, parsed.Usage), parsed.StopReason, resp.StatusCode, "")
 		}
+		p.recordResponseUsage(requestID, sessionID, resp.StatusCode, nil, chunks)
 	}
 }
 
-// serveBedrockNonStreaming handles non-streaming Bedrock responses (/invoke).
+// serveBedrockNonStreaming handles non-streaming Bedrock responses (/invoke, /converse).
 func (p *Proxy) serveBedrockNonStreaming(w http.ResponseWriter, resp *http.Response, startTime time.Time, modelID, upstream, provider, sessionID string, seq int, reqBody []byte, requestID string, patternState *PatternState, shouldLog bool) {
 	respBody, err := io.ReadAll(io.LimitReader(resp.Body, bedrockMaxRequestBody))
 	if err != nil {
@@ -411,8 +682,9 @@ func (p *Proxy) serveBedrockNonStreaming(w http.ResponseWriter, resp *http.Respo
 
 		if p.eventEmitter != nil && patternState != nil {
 			parsed := ParseResponseBody(string(respBody), upstream)
-			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody))
+			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody), requestID)
 		}
+		p.recordResponseUsage(requestID, sessionID, resp.StatusCode, respBody, nil)
 	}
 
 	copyHeaders(w.Header(), resp.Header)
@@ -0,0 +1,247 @@
+// bedrock_converse.go
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Bedrock Converse API support (/model/{id}/converse and converse-stream).
+//
+// Converse uses its own schema rather than Anthropic JSON: content blocks are
+// keyed by kind ({"text":...}, {"toolUse":{...}}, {"toolResult":{...}},
+// {"reasoningContent":{...}}) and usage is camelCase (inputTokens). Stream
+// frames carry the event name in the :event-type header with a plain JSON
+// payload. decodeBedrockEventstream normalizes each frame to
+// "data: {"<eventType>": payload}" — the same shape as the SDK's union types —
+// so converse streams are stored and parsed like any other SSE log.
+
+// converseStreamEvents lists the ConverseStream event types.
+var converseStreamEvents = []string{"messageStart", "contentBlockStart", "contentBlockDelta", "contentBlockStop", "messageStop", "metadata"}
+
+// isConverseResponse returns true for a non-streaming Converse response body.
+func isConverseResponse(raw map[string]interface{}) bool {
+	output, ok := raw["output"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = output["message"].(map[string]interface{})
+	return ok
+}
+
+// isConverseStreamEvent returns true for a normalized ConverseStream event.
+func isConverseStreamEvent(event map[string]interface{}) bool {
+	for _, name := range converseStreamEvents {
+		if _, ok := event[name].(map[string]interface{}); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeConverseFrame wraps a ConverseStream frame payload under its event
+// type and drops the "p" padding field. Returns false for non-JSON payloads.
+func normalizeConverseFrame(eventType string, payload []byte) (string, bool) {
+	var body map[string]interface{}
+	if err := json.Unmarshal(payload, &body); err != nil {
+		return "", false
+	}
+	delete(body, "p")
+	normalized, err := json.Marshal(map[string]interface{}{eventType: body})
+	if err != nil {
+		return "", false
+	}
+	return string(normalized), true
+}
+
+// parseConverseContentBlock converts a Converse content block into a
+// ContentBlock. Returns false if the block has no recognized Converse key.
+func parseConverseContentBlock(block map[string]interface{}) (ContentBlock, bool) {
+	cb := ContentBlock{Raw: block}
+
+	if text, ok := block["text"].(string); ok {
+		cb.Type = "text"
+		cb.Text = text
+		return cb, true
+	}
+
+	if toolUse, ok := block["toolUse"].(map[string]interface{}); ok {
+		cb.Type = "tool_use"
+		cb.ToolID, _ = toolUse["toolUseId"].(string)
+		cb.ToolName, _ = toolUse["name"].(string)
+		cb.ToolInput, _ = toolUse["input"].(map[string]interface{})
+		return cb, true
+	}
+
+	if toolResult, ok := block["toolResult"].(map[string]interface{}); ok {
+		cb.Type = "tool_result"
+		cb.ToolID, _ = toolResult["toolUseId"].(string)
+		if status, ok := toolResult["status"].(string); ok {
+			cb.IsError = status == "error"
+		}
+		if content, ok := toolResult["content"].([]interface{}); ok {
+			var parts []string
+			for _, c := range content {
+				if part, ok := c.(map[string]interface{}); ok {
+					if text, ok := part["text"].(string); ok {
+						parts = append(parts, text)
+					}
+				}
+			}
+			cb.Text = strings.Join(parts, "\n")
+		}
+		return cb, true
+	}
+
+	if reasoning, ok := block["reasoningContent"].(map[string]interface{}); ok {
+		cb.Type = "thinking"
+		if rt, ok := reasoning["reasoningText"].(map[string]interface{}); ok {
+			cb.Thinking, _ = rt["text"].(string)
+		}
+		return cb, true
+	}
+
+	return cb, false
+}
+
+// parseConverseUsage maps Converse usage onto UsageInfo. inputTokens already
+// excludes cache reads and writes, matching Anthropic semantics.
+func parseConverseUsage(usage map[string]interface{}) UsageInfo {
+	var info UsageInfo
+	if v, ok := usage["inputTokens"].(float64); ok {
+		info.InputTokens = int(v)
+	}
+	if v, ok := usage["outputTokens"].(float64); ok {
+		info.OutputTokens = int(v)
+	}
+	if v, ok := usage["cacheReadInputTokens"].(float64); ok {
+		info.CacheReadInputTokens = int(v)
+	}
+	if v, ok := usage["cacheWriteInputTokens"].(float64); ok {
+		info.CacheCreationInputTokens = int(v)
+	}
+	return info
+}
+
+// parseConverseResponse populates a ParsedResponse from a Converse response.
+func parseConverseResponse(raw map[string]interface{}, parsed *ParsedResponse) {
+	output, _ := raw["output"].(map[string]interface{})
+	message, _ := output["message"].(map[string]interface{})
+	if content, ok := message["content"].([]interface{}); ok {
+		for _, c := range content {
+			if block, ok := c.(map[string]interface{}); ok {
+				if cb, ok := parseConverseContentBlock(block); ok {
+					parsed.Content = append(parsed.Content, cb)
+				}
+			}
+		}
+	}
+
+	if usage, ok := raw["usage"].(map[string]interface{}); ok {
+		parsed.Usage = parseConverseUsage(usage)
+	}
+	if stop, ok := raw["stopReason"].(string); ok {
+		parsed.StopReason = stop
+	}
+}
+
+// converseBlockBuilder accumulates a streamed Converse content block.
+type converseBlockBuilder struct {
+	block     ContentBlock
+	toolInput string
+}
+
+// parseConverseStreamingResponse reconstructs a ParsedResponse from normalized
+// ConverseStream chunks. Blocks are keyed by contentBlockIndex; toolUse input
+// arrives as JSON string fragments and is decoded once complete.
+func parseConverseStreamingResponse(chunks []StreamChunk) ParsedResponse {
+	parsed := ParsedResponse{}
+	blocks := make(map[int]*converseBlockBuilder)
+
+	builderAt := func(event map[string]interface{}) *converseBlockBuilder {
+		idx := 0
+		if i, ok := event["contentBlockIndex"].(float64); ok {
+			idx = int(i)
+		}
+		b, ok := blocks[idx]
+		if !ok {
+			b = &converseBlockBuilder{}
+			blocks[idx] = b
+		}
+		return b
+	}
+
+	for _, chunk := range chunks {
+		data := decodeSSEData(chunk.Raw)
+		if data == nil {
+			continue
+		}
+
+		if start, ok := data["contentBlockStart"].(map[string]interface{}); ok {
+			b := builderAt(start)
+			if s, ok := start["start"].(map[string]interface{}); ok {
+				if toolUse, ok := s["toolUse"].(map[string]interface{}); ok {
+					b.block.Type = "tool_use"
+					b.block.ToolID, _ = toolUse["toolUseId"].(string)
+					b.block.ToolName, _ = toolUse["name"].(string)
+				}
+			}
+		}
+
+		if delta, ok := data["contentBlockDelta"].(map[string]interface{}); ok {
+			b := builderAt(delta)
+			d, _ := delta["delta"].(map[string]interface{})
+			if text, ok := d["text"].(string); ok {
+				if b.block.Type == "" {
+					b.block.Type = "text"
+				}
+				b.block.Text += text
+			}
+			if toolUse, ok := d["toolUse"].(map[string]interface{}); ok {
+				if input, ok := toolUse["input"].(string); ok {
+					b.toolInput += input
+				}
+			}
+			if reasoning, ok := d["reasoningContent"].(map[string]interface{}); ok {
+				if text, ok := reasoning["text"].(string); ok {
+					if b.block.Type == "" {
+						b.block.Type = "thinking"
+					}
+					b.block.Thinking += text
+				}
+			}
+		}
+
+		if stop, ok := data["messageStop"].(map[string]interface{}); ok {
+			if reason, ok := stop["stopReason"].(string); ok {
+				parsed.StopReason = reason
+			}
+		}
+
+		if metadata, ok := data["metadata"].(map[string]interface{}); ok {
+			if usage, ok := metadata["usage"].(map[string]interface{}); ok {
+				parsed.Usage = parseConverseUsage(usage)
+			}
+		}
+	}
+
+	indices := make([]int, 0, len(blocks))
+	for idx := range blocks {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	for _, idx := range indices {
+		b := blocks[idx]
+		if b.block.Type == "" {
+			continue
+		}
+		if b.block.Type == "tool_use" {
+			b.block.ToolInput = parseToolArguments(b.toolInput)
+		}
+		parsed.Content = append(parsed.Content, b.block)
+	}
+
+	return parsed
+}
@@ -0,0 +1,190 @@
+// bedrock_converse_test.go
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+)
+
+// encodeConverseStream encodes ConverseStream events as eventstream frames,
+// one frame per {eventType, payload} pair.
+func encodeConverseStream(t *testing.T, events [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := eventstream.NewEncoder()
+	for _, ev := range events {
+		msg := eventstream.Message{
+			Headers: eventstream.Headers{
+				{Name: ":message-type", Value: eventstream.StringValue("event")},
+				{Name: ":event-type", Value: eventstream.StringValue(ev[0])},
+				{Name: ":content-type", Value: eventstream.StringValue("application/json")},
+			},
+			Payload: []byte(ev[1]),
+		}
+		if err := enc.Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+var testConverseStreamEvents = [][2]string{
+	{"messageStart", `{"p":"abc","role":"assistant"}`},
+	{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"reasoningContent":{"text":"Need the file."}},"p":"ab"}`},
+	{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"text":"Let me "},"p":"a"}`},
+	{"contentBlockDelta", `{"contentBlockIndex":1,"delta":{"text":"check."},"p":"a"}`},
+	{"contentBlockStop", `{"contentBlockIndex":1,"p":"a"}`},
+	{"contentBlockStart", `{"contentBlockIndex":2,"start":{"toolUse":{"toolUseId":"tooluse_1","name":"Read"}},"p":"a"}`},
+	{"contentBlockDelta", `{"contentBlockIndex":2,"delta":{"toolUse":{"input":"{\"path\":"}},"p":"a"}`},
+	{"contentBlockDelta", `{"contentBlockIndex":2,"delta":{"toolUse":{"input":"\"main.go\"}"}},"p":"a"}`},
+	{"contentBlockStop", `{"contentBlockIndex":2,"p":"a"}`},
+	{"messageStop", `{"stopReason":"tool_use","p":"a"}`},
+	{"metadata", `{"usage":{"inputTokens":20,"outputTokens":15,"cacheReadInputTokens":100,"cacheWriteInputTokens":7},"metrics":{"latencyMs":300},"p":"a"}`},
+}
+
+func TestDecodeBedrockEventstream_ConverseStream(t *testing.T) {
+	chunks, err := decodeBedrockEventstream(encodeConverseStream(t, testConverseStreamEvents))
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(chunks) != len(testConverseStreamEvents) {
+		t.Fatalf("got %d chunks, want %d", len(chunks), len(testConverseStreamEvents))
+	}
+	if chunks[0].Raw != `data: {"messageStart":{"role":"assistant"}}` {
+		t.Errorf("chunk[0] = %q, want event-type wrapper without padding", chunks[0].Raw)
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("got %d content blocks, want 3: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Need the file." {
+		t.Errorf("block 0 = %+v", parsed.Content[0])
+	}
+	if parsed.Content[1].Type != "text" || parsed.Content[1].Text != "Let me check." {
+		t.Errorf("block 1 = %+v", parsed.Content[1])
+	}
+	tool := parsed.Content[2]
+	if tool.Type != "tool_use" || tool.ToolID != "tooluse_1" || tool.ToolName != "Read" || tool.ToolInput["path"] != "main.go" {
+		t.Errorf("block 2 = %+v", tool)
+	}
+	if parsed.StopReason != "tool_use" {
+		t.Errorf("StopReason = %q", parsed.StopReason)
+	}
+	want := UsageInfo{InputTokens: 20, OutputTokens: 15, CacheReadInputTokens: 100, CacheCreationInputTokens: 7}
+	if parsed.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", parsed.Usage, want)
+	}
+
+	var raws []string
+	for _, c := range chunks {
+		raws = append(raws, c.Raw)
+	}
+	if sr := findStopReasonInChunks(raws); sr != "tool_use" {
+		t.Errorf("findStopReasonInChunks = %q", sr)
+	}
+}
+
+func TestDecodeBedrockEventstream_ConverseExceptionSkipped(t *testing.T) {
+	var buf bytes.Buffer
+	enc := eventstream.NewEncoder()
+	enc.Encode(&buf, eventstream.Message{
+		Headers: eventstream.Headers{
+			{Name: ":message-type", Value: eventstream.StringValue("exception")},
+			{Name: ":exception-type", Value: eventstream.StringValue("throttlingException")},
+		},
+		Payload: []byte(`{"message":"Too many requests"}`),
+	})
+
+	chunks, err := decodeBedrockEventstream(buf.Bytes())
+	if err != nil || len(chunks) != 0 {
+		t.Errorf("got (%v, %v), want no chunks", chunks, err)
+	}
+}
+
+func TestParseConverseRequestAndResponse(t *testing.T) {
+	req := ParseRequestBody(`{
+		"system":[{"text":"Be brief."}],
+		"inferenceConfig":{"maxTokens":512},
+		"messages":[
+			{"role":"user","content":[{"text":"Read main.go"}]},
+			{"role":"assistant","content":[{"toolUse":{"toolUseId":"tooluse_1","name":"Read","input":{"path":"main.go"}}}]},
+			{"role":"user","content":[{"toolResult":{"toolUseId":"tooluse_1","content":[{"text":"no such file"}],"status":"error"}}]}
+		]}`, "bedrock-runtime.us-west-2.amazonaws.com")
+
+	if req.System != "Be brief." || req.MaxTokens != 512 {
+		t.Errorf("System = %q, MaxTokens = %d", req.System, req.MaxTokens)
+	}
+	if len(req.Messages) != 3 || req.Messages[0].TextContent != "Read main.go" {
+		t.Fatalf("Messages = %+v", req.Messages)
+	}
+	use := req.Messages[1].Content[0]
+	if use.Type != "tool_use" || use.ToolID != "tooluse_1" || use.ToolInput["path"] != "main.go" {
+		t.Errorf("tool_use = %+v", use)
+	}
+	result := req.Messages[2].Content[0]
+	if result.Type != "tool_result" || result.ToolID != "tooluse_1" || !result.IsError || result.Text != "no such file" {
+		t.Errorf("tool_result = %+v", result)
+	}
+
+	body := `{"output":{"message":{"role":"assistant","content":[{"text":"Done."}]}},"stopReason":"end_turn","usage":{"inputTokens":30,"outputTokens":4,"totalTokens":34},"metrics":{"latencyMs":120}}`
+	resp := ParseResponseBody(body, "bedrock-runtime.us-west-2.amazonaws.com")
+	if len(resp.Content) != 1 || resp.Content[0].Text != "Done." {
+		t.Errorf("Content = %+v", resp.Content)
+	}
+	if resp.StopReason != "end_turn" || resp.Usage.InputTokens != 30 || resp.Usage.OutputTokens != 4 {
+		t.Errorf("StopReason = %q, Usage = %+v", resp.StopReason, resp.Usage)
+	}
+	if sr := extractStopReasonFromBody(body); sr != "end_turn" {
+		t.Errorf("extractStopReasonFromBody = %q", sr)
+	}
+
+	msg, err := ExtractAssistantMessage([]byte(body), "anthropic")
+	if err != nil || msg["role"] != "assistant" {
+		t.Errorf("ExtractAssistantMessage = (%v, %v)", msg, err)
+	}
+}
+
+func TestServeBedrock_ConverseStreamEmitsEvents(t *testing.T) {
+	frames := encodeConverseStream(t, testConverseStreamEvents)
+	var gotPath string
+	proxy, mock := newTestBedrockProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(frames)
+	})
+	defer mock.Close()
+	proxy.bedrock.client = &http.Client{
+		Transport: &rewriteTransport{target: strings.TrimPrefix(mock.URL, "http://"), inner: http.DefaultTransport},
+	}
+	emitter := &MockEventEmitter{}
+	proxy.eventEmitter = emitter
+
+	path := "/model/us.anthropic.claude-sonnet-4-5-20250929-v1:0/converse-stream"
+	req := httptest.NewRequest("POST", path, strings.NewReader(`{"messages":[{"role":"user","content":[{"text":"Read main.go"}]}],"inferenceConfig":{"maxTokens":100}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if !bytes.Equal(w.Body.Bytes(), frames) {
+		t.Error("client should receive the raw eventstream bytes")
+	}
+	if gotPath != path {
+		t.Errorf("upstream path = %q", gotPath)
+	}
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "Read" {
+		t.Errorf("ToolCallEvents = %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 || emitter.TurnEndEvents[0].StopReason != "tool_use" {
+		t.Errorf("TurnEndEvents = %+v", emitter.TurnEndEvents)
+	}
+}
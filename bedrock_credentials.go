@@ -0,0 +1,278 @@
+// bedrock_credentials.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// bedrockCredentialHeader lets a request pick a named credential source that
+// allows it. Like path prefixes, it is routing for trusted clients: anyone
+// who can reach the proxy can send it.
+const bedrockCredentialHeader = "X-Bedrock-Credential"
+
+// bedrockDefaultCredential is the name of the credential used when no route
+// matches. Unless configured explicitly, it is the default AWS credential chain.
+const bedrockDefaultCredential = "default"
+
+// bedrockDefaultSessionName is the STS session name used when none is configured.
+const bedrockDefaultSessionName = "llm-proxy"
+
+// validRoleARN validates IAM role ARNs for AssumeRole.
+var validRoleARN = regexp.MustCompile(`^arn:aws(-[a-z]+)*:iam::[0-9]{12}:role/[a-zA-Z0-9+=,.@_/-]+$`)
+
+// validCredentialName restricts credential names to header- and label-safe characters.
+var validCredentialName = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// bedrockCredential is a named AWS credential source with its routing rules
+// and usage stats for /health/bedrock.
+type bedrockCredential struct {
+	name        string
+	profile     string
+	roleARN     string
+	models      []string // path.Match patterns against the model ID
+	pathPrefix  string
+	allowHeader bool // selectable with X-Bedrock-Credential
+	provider    aws.CredentialsProvider
+
+	requests  int64 // atomic counter
+	mu        sync.Mutex
+	lastUsed  time.Time
+	lastModel string
+}
+
+// recordUse updates usage stats after the credential signs a request.
+func (c *bedrockCredential) recordUse(modelID string) {
+	atomic.AddInt64(&c.requests, 1)
+	c.mu.Lock()
+	c.lastUsed = time.Now()
+	c.lastModel = modelID
+	c.mu.Unlock()
+}
+
+// matchesModel returns true if modelID matches one of the credential's model patterns.
+func (c *bedrockCredential) matchesModel(modelID string) bool {
+	for _, pattern := range c.models {
+		if ok, _ := path.Match(pattern, modelID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBedrockCredentials checks credential configs for unique names, valid
+// role ARNs and unique path prefixes that don't shadow Bedrock routes.
+func validateBedrockCredentials(creds []BedrockCredentialConfig) error {
+	seen := make(map[string]bool)
+	prefixes := make(map[string]string) // path prefix → credential name
+	for _, c := range creds {
+		if !validCredentialName.MatchString(c.Name) {
+			return fmt.Errorf("bedrock credential: invalid name %q", c.Name)
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("bedrock credential %q: duplicate name", c.Name)
+		}
+		seen[c.Name] = true
+
+		if c.RoleARN != "" && !validRoleARN.MatchString(c.RoleARN) {
+			return fmt.Errorf("bedrock credential %q: invalid role_arn %q", c.Name, c.RoleARN)
+		}
+		if c.ExternalID != "" && c.RoleARN == "" {
+			return fmt.Errorf("bedrock credential %q: external_id requires role_arn", c.Name)
+		}
+		for _, pattern := range c.Models {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("bedrock credential %q: invalid model pattern %q", c.Name, pattern)
+			}
+		}
+		if c.PathPrefix != "" {
+			prefix := strings.TrimSuffix(c.PathPrefix, "/")
+			if !strings.HasPrefix(prefix, "/") || strings.Count(prefix, "/") != 1 || prefix == "/model" || prefix+"/" == bedrockRegionPrefix {
+				return fmt.Errorf("bedrock credential %q: path_prefix must be a single segment like /team-a, got %q", c.Name, c.PathPrefix)
+			}
+			if other, ok := prefixes[prefix]; ok {
+				return fmt.Errorf("bedrock credential %q: path_prefix %q is already used by %q", c.Name, c.PathPrefix, other)
+			}
+			prefixes[prefix] = c.Name
+		}
+	}
+	return nil
+}
+
+// newBedrockCredential builds a cached credential provider for cfg. Named
+// profiles come from the shared AWS config files; role_arn adds an STS
+// AssumeRole on top of the base credentials. aws.CredentialsCache refreshes
+// credentials shortly before they expire.
+func newBedrockCredential(ctx context.Context, cfg BedrockCredentialConfig, region string) (*bedrockCredential, error) {
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region)}
+	if cfg.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(cfg.Profile))
+	}
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("load AWS config for credential %q: %w", cfg.Name, err)
+	}
+
+	provider := awsCfg.Credentials
+	if cfg.RoleARN != "" {
+		sessionName := cfg.SessionName
+		if sessionName == "" {
+			sessionName = bedrockDefaultSessionName
+		}
+		assume := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), cfg.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = sessionName
+			if cfg.ExternalID != "" {
+				o.ExternalID = aws.String(cfg.ExternalID)
+			}
+		})
+		provider = aws.NewCredentialsCache(assume)
+	}
+
+	return &bedrockCredential{
+		name:        cfg.Name,
+		profile:     cfg.Profile,
+		roleARN:     cfg.RoleARN,
+		models:      cfg.Models,
+		pathPrefix:  strings.TrimSuffix(cfg.PathPrefix, "/"),
+		allowHeader: cfg.AllowHeader,
+		provider:    provider,
+	}, nil
+}
+
+// initBedrockCredentials builds the named credential sources, adding the
+// default credential chain unless a credential is named "default".
+func initBedrockCredentials(ctx context.Context, configs []BedrockCredentialConfig, region string) (map[string]*bedrockCredential, error) {
+	if err := validateBedrockCredentials(configs); err != nil {
+		return nil, err
+	}
+
+	hasDefault := false
+	for _, c := range configs {
+		if c.Name == bedrockDefaultCredential {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		configs = append([]BedrockCredentialConfig{{Name: bedrockDefaultCredential}}, configs...)
+	}
+
+	creds := make(map[string]*bedrockCredential, len(configs))
+	for _, c := range configs {
+		cred, err := newBedrockCredential(ctx, c, region)
+		if err != nil {
+			return nil, err
+		}
+		creds[c.Name] = cred
+	}
+	return creds, nil
+}
+
+// stripCredentialPrefix returns the credential whose path_prefix starts path,
+// and the path with the prefix removed. Returns nil if no prefix matches.
+// Prefixes are unique single segments, checked in name order.
+func (b *bedrockState) stripCredentialPrefix(path string) (*bedrockCredential, string) {
+	if b == nil {
+		return nil, path
+	}
+	for _, name := range b.credentialNames() {
+		if cred := b.credentials[name]; cred.pathPrefix != "" && strings.HasPrefix(path, cred.pathPrefix+"/") {
+			return cred, strings.TrimPrefix(path, cred.pathPrefix)
+		}
+	}
+	return nil, path
+}
+
+// ownsPath returns true if path is a Bedrock path behind a credential path prefix.
+func (b *bedrockState) ownsPath(path string) bool {
+	cred, rest := b.stripCredentialPrefix(path)
+	return cred != nil && isBedrockPath(rest)
+}
+
+// selectCredential picks the credential for a request: the X-Bedrock-Credential
+// header, then a path prefix match, then a model pattern match, then the
+// default. The header may only name the default or a credential with
+// allow_header. Model patterns are checked in name order so routing is stable.
+func (b *bedrockState) selectCredential(r *http.Request, prefixed *bedrockCredential, modelID string) (*bedrockCredential, error) {
+	if name := r.Header.Get(bedrockCredentialHeader); name != "" {
+		cred, ok := b.credentials[name]
+		if !ok {
+			return nil, fmt.Errorf("Bedrock credential %q not configured", name)
+		}
+		if !cred.allowHeader && name != bedrockDefaultCredential {
+			return nil, fmt.Errorf("Bedrock credential %q can't be selected with %s", name, bedrockCredentialHeader)
+		}
+		return cred, nil
+	}
+	if prefixed != nil {
+		return prefixed, nil
+	}
+	for _, name := range b.credentialNames() {
+		if cred := b.credentials[name]; cred.matchesModel(modelID) {
+			return cred, nil
+		}
+	}
+	return b.credentials[bedrockDefaultCredential], nil
+}
+
+// recordBedrockCredential adds the name of the credential signing a logged
+// request to its _meta.
+func (p *Proxy) recordBedrockCredential(requestID string, cred *bedrockCredential) {
+	if rec, ok := p.logger.(requestMetaRecorder); ok && requestID != "" {
+		rec.SetRequestMeta(requestID, "bedrock_credential", cred.name)
+	}
+}
+
+// credentialNames returns the configured credential names, sorted.
+func (b *bedrockState) credentialNames() []string {
+	names := make([]string, 0, len(b.credentials))
+	for name := range b.credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BedrockCredentialHealth reports usage of one credential source on /health/bedrock.
+type BedrockCredentialHealth struct {
+	Name      string `json:"name"`
+	Profile   string `json:"profile,omitempty"`
+	RoleARN   string `json:"role_arn,omitempty"`
+	Requests  int64  `json:"requests"`
+	LastUsed  string `json:"last_used,omitempty"`
+	LastModel string `json:"last_model,omitempty"`
+}
+
+// credentialHealth returns per-credential usage stats, sorted by name.
+func (b *bedrockState) credentialHealth() []BedrockCredentialHealth {
+	var out []BedrockCredentialHealth
+	for _, name := range b.credentialNames() {
+		cred := b.credentials[name]
+		h := BedrockCredentialHealth{
+			Name:     cred.name,
+			Profile:  cred.profile,
+			RoleARN:  cred.roleARN,
+			Requests: atomic.LoadInt64(&cred.requests),
+		}
+		cred.mu.Lock()
+		if !cred.lastUsed.IsZero() {
+			h.LastUsed = cred.lastUsed.UTC().Format(time.RFC3339)
+		}
+		h.LastModel = cred.lastModel
+		cred.mu.Unlock()
+		out = append(out, h)
+	}
+	return out
+}
@@ -0,0 +1,259 @@
+// bedrock_credentials_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// keyCredentials returns static credentials with the given access key ID,
+// so tests can tell which credential signed a request.
+type keyCredentials string
+
+func (k keyCredentials) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	return aws.Credentials{AccessKeyID: string(k), SecretAccessKey: "secret", Source: "test"}, nil
+}
+
+// isolateAWSConfig points the AWS SDK at an empty environment plus the given
+// shared config file contents.
+func isolateAWSConfig(t *testing.T, sharedConfig string) {
+	t.Helper()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "config")
+	if err := os.WriteFile(configPath, []byte(sharedConfig), 0600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", configPath)
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	for _, key := range []string{"AWS_PROFILE", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_ROLE_ARN"} {
+		t.Setenv(key, "")
+		os.Unsetenv(key)
+	}
+}
+
+func TestValidateBedrockCredentials(t *testing.T) {
+	tests := []struct {
+		name    string
+		creds   []BedrockCredentialConfig
+		wantErr bool
+	}{
+		{"valid", []BedrockCredentialConfig{
+			{Name: "team-a", Profile: "team-a", PathPrefix: "/team-a"},
+			{Name: "team-b", RoleARN: "arn:aws:iam::123456789012:role/bedrock-team-b", ExternalID: "xyz", Models: []string{"us.anthropic.claude-opus-*"}},
+		}, false},
+		{"empty name", []BedrockCredentialConfig{{Profile: "x"}}, true},
+		{"duplicate name", []BedrockCredentialConfig{{Name: "a"}, {Name: "a"}}, true},
+		{"bad role arn", []BedrockCredentialConfig{{Name: "a", RoleARN: "arn:aws:s3:::bucket"}}, true},
+		{"external id without role", []BedrockCredentialConfig{{Name: "a", ExternalID: "xyz"}}, true},
+		{"bad model pattern", []BedrockCredentialConfig{{Name: "a", Models: []string{"["}}}, true},
+		{"nested prefix", []BedrockCredentialConfig{{Name: "a", PathPrefix: "/a/b"}}, true},
+		{"prefix shadows model", []BedrockCredentialConfig{{Name: "a", PathPrefix: "/model"}}, true},
+		{"prefix shadows region", []BedrockCredentialConfig{{Name: "a", PathPrefix: "/bedrock"}}, true},
+		{"duplicate prefix", []BedrockCredentialConfig{{Name: "a", PathPrefix: "/team"}, {Name: "b", PathPrefix: "/team/"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBedrockCredentials(tt.creds)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateBedrockCredentials() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServeBedrock_CredentialSelection(t *testing.T) {
+	var gotAuth, gotPath string
+	proxy, mock := newTestBedrockProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.Path
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn"}`))
+	})
+	defer mock.Close()
+	proxy.bedrock.client = &http.Client{
+		Transport: &rewriteTransport{target: strings.TrimPrefix(mock.URL, "http://"), inner: http.DefaultTransport},
+	}
+	proxy.bedrock.credentials = map[string]*bedrockCredential{
+		bedrockDefaultCredential: {name: bedrockDefaultCredential, provider: keyCredentials("AKIADEFAULT")},
+		"team-a":                 {name: "team-a", pathPrefix: "/team-a", allowHeader: true, provider: keyCredentials("AKIATEAMA")},
+		"opus":                   {name: "opus", models: []string{"*.anthropic.claude-opus-*"}, provider: keyCredentials("AKIAOPUS")},
+	}
+	loki := newMockLokiExporter(nil)
+	proxy.logger = NewMultiWriter(newMockFileLogger(), loki)
+	credentialByKey := map[string]string{"AKIADEFAULT": "default", "AKIATEAMA": "team-a", "AKIAOPUS": "opus"}
+
+	const haiku = "/model/anthropic.claude-3-haiku-20240307-v1:0/invoke"
+	const opus = "/model/us.anthropic.claude-opus-4-1-20250805-v1:0/invoke"
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		wantStatus int
+		wantKey    string
+	}{
+		{"default", haiku, "", http.StatusOK, "AKIADEFAULT"},
+		{"model pattern", opus, "", http.StatusOK, "AKIAOPUS"},
+		{"path prefix", "/team-a" + haiku, "", http.StatusOK, "AKIATEAMA"},
+		{"path prefix with region", "/team-a/bedrock/us-east-1" + opus, "", http.StatusOK, "AKIATEAMA"},
+		{"header wins", "/team-a" + opus, "default", http.StatusOK, "AKIADEFAULT"},
+		{"header credential", opus, "team-a", http.StatusOK, "AKIATEAMA"},
+		{"header credential not allowed", haiku, "opus", http.StatusBadRequest, ""},
+		{"unknown header credential", haiku, "nope", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth, gotPath = "", ""
+			loki.pushCalls = nil
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+			if tt.header != "" {
+				req.Header.Set(bedrockCredentialHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			if !strings.Contains(gotAuth, "Credential="+tt.wantKey+"/") {
+				t.Errorf("Authorization = %q, want key %s", gotAuth, tt.wantKey)
+			}
+			if !strings.HasPrefix(gotPath, "/model/") {
+				t.Errorf("upstream path = %q, want prefixes stripped", gotPath)
+			}
+			for _, call := range loki.pushCalls {
+				meta, _ := call.entry["_meta"].(map[string]interface{})
+				if call.entry["type"] == "request" && meta["bedrock_credential"] != credentialByKey[tt.wantKey] {
+					t.Errorf("request _meta = %v, want bedrock_credential %s", meta, credentialByKey[tt.wantKey])
+				}
+			}
+		})
+	}
+
+	health := proxy.bedrock.credentialHealth()
+	byName := make(map[string]BedrockCredentialHealth)
+	for _, h := range health {
+		byName[h.Name] = h
+	}
+	if byName["team-a"].Requests != 3 || byName["opus"].Requests != 1 || byName["default"].Requests != 2 {
+		t.Errorf("credential health = %+v", health)
+	}
+	if byName["opus"].LastModel != "us.anthropic.claude-opus-4-1-20250805-v1:0" || byName["opus"].LastUsed == "" {
+		t.Errorf("opus health = %+v", byName["opus"])
+	}
+}
+
+func TestNewBedrockCredential_Profile(t *testing.T) {
+	isolateAWSConfig(t, "[profile team-a]\naws_access_key_id = AKIATEAMA\naws_secret_access_key = secretA\n")
+
+	cred, err := newBedrockCredential(context.Background(), BedrockCredentialConfig{Name: "team-a", Profile: "team-a"}, "us-west-2")
+	if err != nil {
+		t.Fatalf("newBedrockCredential: %v", err)
+	}
+	creds, err := cred.provider.Retrieve(context.Background())
+	if err != nil || creds.AccessKeyID != "AKIATEAMA" {
+		t.Errorf("Retrieve() = (%q, %v), want AKIATEAMA", creds.AccessKeyID, err)
+	}
+
+	if _, err := newBedrockCredential(context.Background(), BedrockCredentialConfig{Name: "x", Profile: "missing"}, "us-west-2"); err == nil {
+		t.Error("expected error for missing profile")
+	}
+}
+
+func TestNewBedrockCredential_AssumeRoleCached(t *testing.T) {
+	isolateAWSConfig(t, "[profile base]\naws_access_key_id = AKIABASE\naws_secret_access_key = secretBase\n")
+
+	var calls int32
+	var gotForm map[string]string
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		r.ParseForm()
+		gotForm = map[string]string{
+			"Action":          r.Form.Get("Action"),
+			"RoleArn":         r.Form.Get("RoleArn"),
+			"ExternalId":      r.Form.Get("ExternalId"),
+			"RoleSessionName": r.Form.Get("RoleSessionName"),
+			"signedBy":        r.Header.Get("Authorization"),
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>ASIAASSUMED</AccessKeyId>
+      <SecretAccessKey>assumedSecret</SecretAccessKey>
+      <SessionToken>assumedToken</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+    <AssumedRoleUser><Arn>arn:aws:sts::123456789012:assumed-role/bedrock/llm-proxy</Arn><AssumedRoleId>AROA:llm-proxy</AssumedRoleId></AssumedRoleUser>
+  </AssumeRoleResult>
+  <ResponseMetadata><RequestId>req-1</RequestId></ResponseMetadata>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer sts.Close()
+	t.Setenv("AWS_ENDPOINT_URL_STS", sts.URL)
+
+	cred, err := newBedrockCredential(context.Background(), BedrockCredentialConfig{
+		Name:       "team-b",
+		Profile:    "base",
+		RoleARN:    "arn:aws:iam::123456789012:role/bedrock",
+		ExternalID: "ext-123",
+	}, "us-west-2")
+	if err != nil {
+		t.Fatalf("newBedrockCredential: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		creds, err := cred.provider.Retrieve(context.Background())
+		if err != nil {
+			t.Fatalf("Retrieve: %v", err)
+		}
+		if creds.AccessKeyID != "ASIAASSUMED" || creds.SessionToken != "assumedToken" {
+			t.Errorf("creds = %+v", creds)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("STS called %d times, want 1 (cached)", n)
+	}
+	if gotForm["Action"] != "AssumeRole" || gotForm["RoleArn"] != "arn:aws:iam::123456789012:role/bedrock" ||
+		gotForm["ExternalId"] != "ext-123" || gotForm["RoleSessionName"] != bedrockDefaultSessionName {
+		t.Errorf("AssumeRole form = %v", gotForm)
+	}
+	if !strings.Contains(gotForm["signedBy"], "AKIABASE") {
+		t.Errorf("AssumeRole should be signed with the base profile, got %q", gotForm["signedBy"])
+	}
+}
+
+func TestHealthBedrock_ShowsCredentials(t *testing.T) {
+	proxy, mock := newTestBedrockProxy(t, func(w http.ResponseWriter, r *http.Request) {})
+	defer mock.Close()
+	proxy.bedrock.credentials["team-a"] = &bedrockCredential{name: "team-a", profile: "team-a", provider: keyCredentials("AKIATEAMA")}
+	proxy.bedrock.credentials["team-a"].recordUse("anthropic.claude-3-haiku-20240307-v1:0")
+
+	srv := &Server{proxy: proxy}
+	w := httptest.NewRecorder()
+	srv.handleHealthBedrock(w, httptest.NewRequest("GET", "/health/bedrock", nil))
+
+	var resp BedrockHealthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Credentials) != 2 || resp.Credentials[1].Name != "team-a" || resp.Credentials[1].Profile != "team-a" || resp.Credentials[1].Requests != 1 {
+		t.Errorf("credentials = %+v", resp.Credentials)
+	}
+}
@@ -0,0 +1,259 @@
+// bedrock_limits.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults for Bedrock concurrency limiting.
+const (
+	bedrockDefaultMaxConcurrent = 5
+	bedrockDefaultMaxQueue      = 50
+	bedrockDefaultMaxQueueTime  = 60 * time.Second
+)
+
+// bedrockDefaultLimiter is the name of the limiter shared by models without
+// a per-model override.
+const bedrockDefaultLimiter = "default"
+
+// bedrockWaitBuckets are the upper bounds (ms) of the queue wait histogram.
+var bedrockWaitBuckets = []int64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000, 30000, 60000}
+
+var (
+	errBedrockQueueFull    = errors.New("too many requests queued, please wait before trying again")
+	errBedrockQueueTimeout = errors.New("timed out waiting for a request slot, please wait before trying again")
+)
+
+// waitHistogram is a cumulative histogram of queue wait times.
+type waitHistogram struct {
+	mu     sync.Mutex
+	counts []int64 // per bucket, last entry is +Inf
+	count  int64
+	sumMs  int64
+}
+
+func newWaitHistogram() *waitHistogram {
+	return &waitHistogram{counts: make([]int64, len(bedrockWaitBuckets)+1)}
+}
+
+func (h *waitHistogram) observe(d time.Duration) {
+	ms := d.Milliseconds()
+	i := sort.Search(len(bedrockWaitBuckets), func(i int) bool { return ms <= bedrockWaitBuckets[i] })
+	h.mu.Lock()
+	h.counts[i]++
+	h.count++
+	h.sumMs += ms
+	h.mu.Unlock()
+}
+
+// WaitBucket is one cumulative histogram bucket; LeMs is 0 for +Inf.
+type WaitBucket struct {
+	LeMs  int64 `json:"le_ms,omitempty"`
+	Count int64 `json:"count"`
+}
+
+// WaitHistogram is the JSON form of a queue wait histogram.
+type WaitHistogram struct {
+	Count   int64        `json:"count"`
+	SumMs   int64        `json:"sum_ms"`
+	Buckets []WaitBucket `json:"buckets"`
+}
+
+func (h *waitHistogram) snapshot() WaitHistogram {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	out := WaitHistogram{Count: h.count, SumMs: h.sumMs}
+	var cumulative int64
+	for i, n := range h.counts {
+		cumulative += n
+		b := WaitBucket{Count: cumulative}
+		if i < len(bedrockWaitBuckets) {
+			b.LeMs = bedrockWaitBuckets[i]
+		}
+		out.Buckets = append(out.Buckets, b)
+	}
+	return out
+}
+
+// bedrockLimiter bounds in-flight requests with a bounded wait queue.
+type bedrockLimiter struct {
+	name     string // model pattern, or "default"
+	slots    chan struct{}
+	maxQueue int64
+	maxWait  time.Duration
+
+	queued   int64 // atomic
+	rejected int64 // atomic: queue full
+	timedOut int64 // atomic: waited longer than maxWait
+	waits    *waitHistogram
+}
+
+func newBedrockLimiter(name string, maxConcurrent, maxQueue int, maxWait time.Duration) *bedrockLimiter {
+	return &bedrockLimiter{
+		name:     name,
+		slots:    make(chan struct{}, maxConcurrent),
+		maxQueue: int64(maxQueue),
+		maxWait:  maxWait,
+		waits:    newWaitHistogram(),
+	}
+}
+
+// acquire takes a request slot, waiting in the queue if none is free. Returns
+// errBedrockQueueFull or errBedrockQueueTimeout when the request should be
+// shed, or ctx.Err() if the client went away.
+func (l *bedrockLimiter) acquire(ctx context.Context) (release func(), err error) {
+	release = func() { <-l.slots }
+	start := time.Now()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.waits.observe(0)
+		return release, nil
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.maxQueue {
+		atomic.AddInt64(&l.queued, -1)
+		atomic.AddInt64(&l.rejected, 1)
+		return nil, errBedrockQueueFull
+	}
+	defer atomic.AddInt64(&l.queued, -1)
+
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		l.waits.observe(time.Since(start))
+		return release, nil
+	case <-timer.C:
+		atomic.AddInt64(&l.timedOut, 1)
+		return nil, errBedrockQueueTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// bedrockModelLimiter pairs a model pattern with its limiter.
+type bedrockModelLimiter struct {
+	pattern string
+	limiter *bedrockLimiter
+}
+
+// bedrockLimits routes requests to per-model limiters, falling back to a
+// shared default limiter.
+type bedrockLimits struct {
+	defaultLimiter *bedrockLimiter
+	models         []bedrockModelLimiter // sorted by pattern
+}
+
+// newBedrockLimits builds limiters from cfg, applying defaults for unset values.
+func newBedrockLimits(cfg BedrockConcurrencyConfig) (*bedrockLimits, error) {
+	maxConcurrent := cfg.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = bedrockDefaultMaxConcurrent
+	}
+	maxQueue := bedrockDefaultMaxQueue
+	if cfg.MaxQueue != nil {
+		if *cfg.MaxQueue < 0 {
+			return nil, fmt.Errorf("bedrock_concurrency: max_queue must not be negative")
+		}
+		maxQueue = *cfg.MaxQueue
+	}
+	maxWait := bedrockDefaultMaxQueueTime
+	if cfg.MaxQueueTimeStr != "" {
+		d, err := time.ParseDuration(cfg.MaxQueueTimeStr)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("bedrock_concurrency: invalid max_queue_time %q", cfg.MaxQueueTimeStr)
+		}
+		maxWait = d
+	}
+
+	limits := &bedrockLimits{
+		defaultLimiter: newBedrockLimiter(bedrockDefaultLimiter, maxConcurrent, maxQueue, maxWait),
+	}
+
+	patterns := make([]string, 0, len(cfg.Models))
+	for pattern := range cfg.Models {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		limit := cfg.Models[pattern]
+		if limit <= 0 {
+			return nil, fmt.Errorf("bedrock_concurrency: limit for %q must be positive", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("bedrock_concurrency: invalid model pattern %q", pattern)
+		}
+		limits.models = append(limits.models, bedrockModelLimiter{
+			pattern: pattern,
+			limiter: newBedrockLimiter(pattern, limit, maxQueue, maxWait),
+		})
+	}
+	return limits, nil
+}
+
+// forModel returns the limiter for modelID: the first matching per-model
+// pattern in sorted order (a TOML table has no order of its own), else the
+// default limiter.
+func (b *bedrockLimits) forModel(modelID string) *bedrockLimiter {
+	for _, m := range b.models {
+		if ok, _ := path.Match(m.pattern, modelID); ok {
+			return m.limiter
+		}
+	}
+	return b.defaultLimiter
+}
+
+// BedrockLimiterHealth reports one limiter's state on /health/bedrock.
+type BedrockLimiterHealth struct {
+	Name          string        `json:"name"`
+	MaxConcurrent int           `json:"max_concurrent"`
+	InFlight      int           `json:"in_flight"`
+	Queued        int64         `json:"queued"`
+	MaxQueue      int64         `json:"max_queue"`
+	Rejected      int64         `json:"rejected"`
+	TimedOut      int64         `json:"timed_out"`
+	Wait          WaitHistogram `json:"wait"`
+}
+
+// health returns the limiter's current state.
+func (l *bedrockLimiter) health() BedrockLimiterHealth {
+	return BedrockLimiterHealth{
+		Name:          l.name,
+		MaxConcurrent: cap(l.slots),
+		InFlight:      len(l.slots),
+		Queued:        atomic.LoadInt64(&l.queued),
+		MaxQueue:      l.maxQueue,
+		Rejected:      atomic.LoadInt64(&l.rejected),
+		TimedOut:      atomic.LoadInt64(&l.timedOut),
+		Wait:          l.waits.snapshot(),
+	}
+}
+
+// health returns the state of every limiter, default first.
+func (b *bedrockLimits) health() []BedrockLimiterHealth {
+	out := []BedrockLimiterHealth{b.defaultLimiter.health()}
+	for _, m := range b.models {
+		out = append(out, m.limiter.health())
+	}
+	return out
+}
+
+// writeBedrockThrottling writes a Bedrock-style ThrottlingException so SDK
+// clients recognize it and back off.
+func writeBedrockThrottling(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("x-amzn-ErrorType", "ThrottlingException")
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintf(w, `{"message":%q}`, err.Error())
+}
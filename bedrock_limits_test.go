@@ -0,0 +1,214 @@
+// bedrock_limits_test.go
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewBedrockLimits(t *testing.T) {
+	queue := func(n int) *int { return &n }
+	tests := []struct {
+		name    string
+		cfg     BedrockConcurrencyConfig
+		wantErr bool
+	}{
+		{"defaults", BedrockConcurrencyConfig{}, false},
+		{"per model", BedrockConcurrencyConfig{MaxConcurrent: 10, MaxQueue: queue(5), MaxQueueTimeStr: "2s", Models: map[string]int{"*.anthropic.claude-opus-*": 2}}, false},
+		{"no queue", BedrockConcurrencyConfig{MaxQueue: queue(0)}, false},
+		{"negative queue", BedrockConcurrencyConfig{MaxQueue: queue(-1)}, true},
+		{"bad queue time", BedrockConcurrencyConfig{MaxQueueTimeStr: "soon"}, true},
+		{"zero model limit", BedrockConcurrencyConfig{Models: map[string]int{"*": 0}}, true},
+		{"bad model pattern", BedrockConcurrencyConfig{Models: map[string]int{"[": 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newBedrockLimits(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newBedrockLimits() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	limits, _ := newBedrockLimits(BedrockConcurrencyConfig{})
+	if got := cap(limits.defaultLimiter.slots); got != bedrockDefaultMaxConcurrent {
+		t.Errorf("default max_concurrent = %d, want %d", got, bedrockDefaultMaxConcurrent)
+	}
+	if got := limits.defaultLimiter.maxQueue; got != bedrockDefaultMaxQueue {
+		t.Errorf("default max_queue = %d, want %d", got, bedrockDefaultMaxQueue)
+	}
+
+	// An explicit max_queue = 0 sheds requests as soon as every slot is busy
+	limits, _ = newBedrockLimits(BedrockConcurrencyConfig{MaxConcurrent: 1, MaxQueue: queue(0)})
+	release, err := limits.defaultLimiter.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	defer release()
+	if _, err := limits.defaultLimiter.acquire(context.Background()); !errors.Is(err, errBedrockQueueFull) {
+		t.Errorf("acquire with no queue: err = %v, want %v", err, errBedrockQueueFull)
+	}
+}
+
+func TestBedrockLimits_ForModel(t *testing.T) {
+	limits, err := newBedrockLimits(BedrockConcurrencyConfig{Models: map[string]int{
+		"*.anthropic.claude-opus-*":  2,
+		"anthropic.claude-3-haiku-*": 20,
+	}})
+	if err != nil {
+		t.Fatalf("newBedrockLimits: %v", err)
+	}
+
+	tests := []struct {
+		modelID string
+		want    string
+	}{
+		{"us.anthropic.claude-opus-4-1-20250805-v1:0", "*.anthropic.claude-opus-*"},
+		{"anthropic.claude-3-haiku-20240307-v1:0", "anthropic.claude-3-haiku-*"},
+		{"anthropic.claude-3-5-sonnet-20240620-v1:0", bedrockDefaultLimiter},
+	}
+	for _, tt := range tests {
+		if got := limits.forModel(tt.modelID).name; got != tt.want {
+			t.Errorf("forModel(%q) = %q, want %q", tt.modelID, got, tt.want)
+		}
+	}
+}
+
+func TestBedrockLimiter_QueueFullAndTimeout(t *testing.T) {
+	l := newBedrockLimiter("test", 1, 1, 50*time.Millisecond)
+
+	release, err := l.acquire(context.Background())
+	if err != nil {
+		t.Fatalf("first acquire: %v", err)
+	}
+
+	// Second request queues and times out; third finds the queue full.
+	timedOut := make(chan error, 1)
+	go func() {
+		_, err := l.acquire(context.Background())
+		timedOut <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for l.health().Queued != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := l.acquire(context.Background()); !errors.Is(err, errBedrockQueueFull) {
+		t.Errorf("third acquire error = %v, want queue full", err)
+	}
+	if err := <-timedOut; !errors.Is(err, errBedrockQueueTimeout) {
+		t.Errorf("queued acquire error = %v, want timeout", err)
+	}
+
+	// A queued request gets the slot when it is released.
+	acquired := make(chan error, 1)
+	go func() {
+		release, err := l.acquire(context.Background())
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("queued acquire after release: %v", err)
+	}
+
+	h := l.health()
+	if h.InFlight != 0 || h.Queued != 0 || h.Rejected != 1 || h.TimedOut != 1 || h.Wait.Count != 2 {
+		t.Errorf("health = %+v", h)
+	}
+	if last := h.Wait.Buckets[len(h.Wait.Buckets)-1]; last.LeMs != 0 || last.Count != h.Wait.Count {
+		t.Errorf("+Inf bucket = %+v, want cumulative count %d", last, h.Wait.Count)
+	}
+}
+
+func TestBedrockLimiter_ContextCancelled(t *testing.T) {
+	l := newBedrockLimiter("test", 1, 1, time.Minute)
+	release, _ := l.acquire(context.Background())
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("acquire error = %v, want context.Canceled", err)
+	}
+}
+
+func TestServeBedrock_QueueFullThrottles(t *testing.T) {
+	unblock := make(chan struct{})
+	proxy, mock := newTestBedrockProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		<-unblock
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn"}`))
+	})
+	defer mock.Close()
+	proxy.bedrock.client = &http.Client{
+		Transport: &rewriteTransport{target: strings.TrimPrefix(mock.URL, "http://"), inner: http.DefaultTransport},
+	}
+	queue := 1
+	proxy.bedrock.limits, _ = newBedrockLimits(BedrockConcurrencyConfig{
+		MaxQueue: &queue,
+		Models:   map[string]int{"anthropic.claude-3-haiku-*": 1},
+	})
+
+	const path = "/model/anthropic.claude-3-haiku-20240307-v1:0/invoke"
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w
+	}
+
+	// One request in flight, one queued; the third is shed.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer close(unblock)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			send()
+		}()
+	}
+	limiter := proxy.bedrock.limits.forModel("anthropic.claude-3-haiku-20240307-v1:0")
+	deadline := time.Now().Add(2 * time.Second)
+	for (len(limiter.slots) != 1 || limiter.health().Queued != 1) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	w := send()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429 (body %s)", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("x-amzn-ErrorType"); got != "ThrottlingException" {
+		t.Errorf("x-amzn-ErrorType = %q", got)
+	}
+	var body map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body["message"] == "" {
+		t.Errorf("body = %s, want JSON message", w.Body.String())
+	}
+
+	srv := &Server{proxy: proxy}
+	hw := httptest.NewRecorder()
+	srv.handleHealthBedrock(hw, httptest.NewRequest("GET", "/health/bedrock", nil))
+	var resp BedrockHealthResponse
+	if err := json.Unmarshal(hw.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(resp.Limits) != 2 {
+		t.Fatalf("limits = %+v", resp.Limits)
+	}
+	haiku := resp.Limits[1]
+	if haiku.Name != "anthropic.claude-3-haiku-*" || haiku.InFlight != 1 || haiku.Queued != 1 || haiku.Rejected != 1 || haiku.MaxConcurrent != 1 {
+		t.Errorf("haiku limiter = %+v", haiku)
+	}
+}
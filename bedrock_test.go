@@ -3,11 +3,13 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -15,75 +17,128 @@ import (
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 )
 
-// --- Step 3a: LimitedWriter and model ID validation ---
+// --- Step 3a: Incremental decoder and model ID validation ---
 
-func TestLimitedWriter_BasicWrite(t *testing.T) {
-	var buf bytes.Buffer
-	lw := &LimitedWriter{W: &buf, N: 100}
-
-	n, err := lw.Write([]byte("hello"))
-	if n != 5 || err != nil {
-		t.Errorf("Write() = (%d, %v), want (5, nil)", n, err)
+func TestBedrockStreamDecoder_SplitWrites(t *testing.T) {
+	data, err := os.ReadFile("testdata/bedrock-eventstream.bin")
+	if err != nil {
+		t.Fatalf("Failed to read test fixture: %v", err)
 	}
-	if buf.String() != "hello" {
-		t.Errorf("buffer = %q, want %q", buf.String(), "hello")
+	want, err := decodeBedrockEventstream(data)
+	if err != nil {
+		t.Fatalf("decodeBedrockEventstream() error = %v", err)
 	}
-	if lw.Overflow {
-		t.Error("Overflow should be false")
+
+	// Frames split across arbitrary write boundaries decode identically
+	for _, size := range []int{1, 7, 100, 4096} {
+		d := newBedrockStreamDecoder(time.Now())
+		for i := 0; i < len(data); i += size {
+			end := min(i+size, len(data))
+			if n, err := d.Write(data[i:end]); n != end-i || err != nil {
+				t.Fatalf("size %d: Write() = (%d, %v)", size, n, err)
+			}
+		}
+		got, err := d.Finish()
+		if err != nil {
+			t.Fatalf("size %d: Finish() error = %v", size, err)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("size %d: got %d chunks, want %d", size, len(got), len(want))
+		}
+		for i := range got {
+			if got[i].Raw != want[i].Raw {
+				t.Errorf("size %d: chunk[%d] = %q, want %q", size, i, got[i].Raw, want[i].Raw)
+			}
+		}
+		if len(d.pending) != 0 {
+			t.Errorf("size %d: %d bytes left pending", size, len(d.pending))
+		}
 	}
 }
 
-func TestLimitedWriter_OverflowDiscardsEntireChunk(t *testing.T) {
-	var buf bytes.Buffer
-	lw := &LimitedWriter{W: &buf, N: 10}
+func TestBedrockStreamDecoder_PerFrameTiming(t *testing.T) {
+	frames := encodeConverseStream(t, testConverseStreamEvents[:2])
+	start := time.Now()
+	d := newBedrockStreamDecoder(start)
 
-	// First write fits
-	n, err := lw.Write([]byte("12345"))
-	if n != 5 || err != nil {
-		t.Fatalf("first Write() = (%d, %v)", n, err)
-	}
+	// Deliver the two frames 20ms apart
+	first := int(binary.BigEndian.Uint32(frames[:4]))
+	time.Sleep(10 * time.Millisecond)
+	d.Write(frames[:first])
+	time.Sleep(20 * time.Millisecond)
+	d.Write(frames[first:])
 
-	// Second write exceeds limit — entire chunk discarded
-	n, err = lw.Write([]byte("1234567890"))
-	if n != 10 || err != nil {
-		t.Errorf("overflow Write() = (%d, %v), want (10, nil)", n, err)
+	chunks, err := d.Finish()
+	if err != nil || len(chunks) != 2 {
+		t.Fatalf("Finish() = (%d chunks, %v)", len(chunks), err)
 	}
-	if !lw.Overflow {
-		t.Error("Overflow should be true after exceeding limit")
+	if chunks[0].DeltaMs < 10 || chunks[1].DeltaMs-chunks[0].DeltaMs < 20 {
+		t.Errorf("DeltaMs = %d, %d; want per-frame arrival times", chunks[0].DeltaMs, chunks[1].DeltaMs)
 	}
-	// Buffer should only contain first write
-	if buf.String() != "12345" {
-		t.Errorf("buffer = %q, want %q", buf.String(), "12345")
+	if !chunks[1].Timestamp.After(chunks[0].Timestamp) {
+		t.Error("chunk timestamps should reflect arrival order")
+	}
+	if ttfb := d.TTFB(); ttfb < 10*time.Millisecond || ttfb > time.Duration(chunks[1].DeltaMs)*time.Millisecond {
+		t.Errorf("TTFB = %v, want time of first byte", ttfb)
 	}
 }
 
-func TestLimitedWriter_PostOverflowWritesDiscarded(t *testing.T) {
-	var buf bytes.Buffer
-	lw := &LimitedWriter{W: &buf, N: 5}
-
-	lw.Write([]byte("1234567890")) // triggers overflow
-	n, err := lw.Write([]byte("more data"))
-	if n != 9 || err != nil {
-		t.Errorf("post-overflow Write() = (%d, %v), want (9, nil)", n, err)
+func TestBedrockStreamDecoder_InvalidFrameNeverErrors(t *testing.T) {
+	// This is critical: io.TeeReader propagates Write errors to io.Copy.
+	// The decoder must NEVER return an error from Write.
+	d := newBedrockStreamDecoder(time.Now())
+	garbage := []byte{0xff, 0xff, 0xff, 0xff, 'x', 'y', 'z'}
+	if n, err := d.Write(garbage); n != len(garbage) || err != nil {
+		t.Errorf("Write() = (%d, %v), want (%d, nil)", n, err, len(garbage))
+	}
+	if n, err := d.Write([]byte("more data")); n != 9 || err != nil {
+		t.Errorf("post-failure Write() = (%d, %v), want (9, nil)", n, err)
 	}
-	// Buffer should be empty (first write was too big, discarded)
-	if buf.Len() != 0 {
-		t.Errorf("buffer len = %d, want 0", buf.Len())
+	if _, err := d.Finish(); err == nil {
+		t.Error("Finish() should report the invalid frame")
+	}
+	if len(d.pending) != 0 {
+		t.Errorf("failed decoder should not buffer, got %d bytes", len(d.pending))
 	}
 }
 
-func TestLimitedWriter_AlwaysReportsFullSuccess(t *testing.T) {
-	// This is critical: io.TeeReader propagates Write errors to io.Copy.
-	// LimitedWriter must NEVER return an error.
-	var buf bytes.Buffer
-	lw := &LimitedWriter{W: &buf, N: 0} // zero limit = immediate overflow
-
-	n, err := lw.Write([]byte("data"))
-	if n != 4 {
-		t.Errorf("n = %d, want 4", n)
-	}
+func TestBedrockStreamDecoder_MaxBuffer(t *testing.T) {
+	// Five 1 MB deltas: deltas stop being recorded past 4 MB, but every
+	// write still succeeds and the stop reason and usage are still recorded
+	delta := `{"contentBlockIndex":0,"delta":{"text":"` + strings.Repeat("x", 1<<20) + `"}}`
+	var events [][2]string
+	for i := 0; i < 5; i++ {
+		events = append(events, [2]string{"contentBlockDelta", delta})
+	}
+	events = append(events,
+		[2]string{"messageStop", `{"stopReason":"end_turn"}`},
+		[2]string{"metadata", `{"usage":{"inputTokens":10,"outputTokens":1300000}}`},
+	)
+	data := encodeConverseStream(t, events)
+
+	d := newBedrockStreamDecoder(time.Now())
+	if n, err := d.Write(data); n != len(data) || err != nil {
+		t.Fatalf("Write() = (%d, %v), want (%d, nil)", n, err, len(data))
+	}
+	chunks, err := d.Finish()
 	if err != nil {
-		t.Errorf("err = %v, want nil", err)
+		t.Fatalf("Finish() error = %v", err)
+	}
+	if !d.overflow {
+		t.Error("overflow should be true past bedrockMaxBuffer")
+	}
+	var deltas int
+	for _, c := range chunks {
+		if bedrockContentDelta(strings.TrimPrefix(c.Raw, "data: ")) {
+			deltas++
+		}
+	}
+	if deltas == 0 || deltas == 5 {
+		t.Errorf("recorded %d of 5 deltas, want those within %d bytes", deltas, bedrockMaxBuffer)
+	}
+	parsed := ParseStreamingResponse(chunks)
+	if parsed.StopReason != "end_turn" || parsed.Usage.OutputTokens != 1300000 {
+		t.Errorf("stop reason %q, usage %+v; want them recorded past the buffer", parsed.StopReason, parsed.Usage)
 	}
 }
 
@@ -97,6 +152,10 @@ func TestExtractModelID_Valid(t *testing.T) {
 		{"/model/anthropic.claude-3-haiku-20240307-v1:0/invoke", "anthropic.claude-3-haiku-20240307-v1:0", false},
 		{"/model/us.anthropic.claude-haiku-4-5-20251001-v1:0/invoke-with-response-stream", "us.anthropic.claude-haiku-4-5-20251001-v1:0", false},
 		{"/model/simple-model/invoke", "simple-model", false},
+		{"/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/converse", "us.anthropic.claude-sonnet-4-5-20250929-v1:0", false},
+		{"/model/arn%3Aaws%3Abedrock%3Aus-east-1%3A123456789012%3Ainference-profile%2Fus.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke", "arn:aws:bedrock:us-east-1:123456789012:inference-profile/us.anthropic.claude-sonnet-4-5-20250929-v1:0", false},
+		{"/model/arn%3Aaws%3Abedrock%3Aus-west-2%3A123456789012%3Aapplication-inference-profile%2Fa1b2c3d4e5f6/invoke", "arn:aws:bedrock:us-west-2:123456789012:application-inference-profile/a1b2c3d4e5f6", false},
+		{"/model/arn%3Aaws%3Abedrock%3Aus-east-1%3A%3Afoundation-model%2Fanthropic.claude-3-haiku-20240307-v1%3A0/invoke", "arn:aws:bedrock:us-east-1::foundation-model/anthropic.claude-3-haiku-20240307-v1:0", false},
 	}
 
 	for _, tt := range tests {
@@ -123,6 +182,11 @@ func TestExtractModelID_Invalid(t *testing.T) {
 		{"query string injection", "/model/foo?bar=baz/invoke"},
 		{"special chars", "/model/foo@bar/invoke"},
 		{"no suffix", "/model/"},
+		{"encoded slash outside ARN", "/model/foo%2F..%2Fbar/invoke"},
+		{"ARN wrong service", "/model/arn%3Aaws%3As3%3Aus-east-1%3A123456789012%3Ainference-profile%2Ffoo/invoke"},
+		{"ARN unknown resource type", "/model/arn%3Aaws%3Abedrock%3Aus-east-1%3A123456789012%3Aagent%2Ffoo/invoke"},
+		{"ARN path traversal", "/model/arn%3Aaws%3Abedrock%3Aus-east-1%3A123456789012%3Ainference-profile%2F..%2F..%2Fx/invoke"},
+		{"bad escape", "/model/foo%zz/invoke"},
 	}
 
 	for _, tt := range tests {
@@ -250,18 +314,28 @@ func newTestBedrockProxy(t *testing.T, mockHandler http.HandlerFunc) (*Proxy, *h
 		client:         createPassthroughClient(),
 		logger:         logger,
 		sessionManager: sm,
+		providers:      builtinRegistry,
 		bedrock: &bedrockState{
-			region:   "us-west-2",
-			credProv: staticCredentials{},
-			signer:   v4.NewSigner(),
+			region: "us-west-2",
+			regions: map[string]*bedrockRegion{
+				"us-west-2": {region: "us-west-2", signer: v4.NewSigner()},
+				"us-east-1": {region: "us-east-1", signer: v4.NewSigner()},
+			},
+			credentials: map[string]*bedrockCredential{
+				bedrockDefaultCredential: {name: bedrockDefaultCredential, provider: staticCredentials{}},
+			},
 			client: &http.Client{
 				Transport: &http.Transport{
 					DisableCompression: true,
 				},
 			},
-			semaphore: make(chan struct{}, bedrockMaxConcurrent),
 		},
 	}
+	limits, err := newBedrockLimits(BedrockConcurrencyConfig{})
+	if err != nil {
+		t.Fatalf("newBedrockLimits: %v", err)
+	}
+	proxy.bedrock.limits = limits
 
 	return proxy, mock
 }
@@ -318,6 +392,47 @@ func TestServeBedrock_StreamingRoundTrip(t *testing.T) {
 	}
 }
 
+func TestServeBedrock_StreamLargerThanFrameLimitDecoded(t *testing.T) {
+	// A long response (well past bedrockMaxFrame in total) must still be
+	// decoded, since only the current partial frame is buffered.
+	delta := strings.Repeat("x", 1024)
+	events := [][2]string{{"messageStart", `{"role":"assistant"}`}}
+	for i := 0; i < (bedrockMaxFrame/1024)+512; i++ {
+		events = append(events, [2]string{"contentBlockDelta", `{"contentBlockIndex":0,"delta":{"text":"` + delta + `"}}`})
+	}
+	events = append(events,
+		[2]string{"messageStop", `{"stopReason":"end_turn"}`},
+		[2]string{"metadata", `{"usage":{"inputTokens":10,"outputTokens":9000}}`},
+	)
+	frames := encodeConverseStream(t, events)
+
+	proxy, mock := newTestBedrockProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(frames)
+	})
+	defer mock.Close()
+	proxy.bedrock.client = &http.Client{
+		Transport: &rewriteTransport{target: strings.TrimPrefix(mock.URL, "http://"), inner: http.DefaultTransport},
+	}
+	emitter := &MockEventEmitter{}
+	proxy.eventEmitter = emitter
+
+	req := httptest.NewRequest("POST", "/model/us.anthropic.claude-sonnet-4-5-20250929-v1:0/converse-stream",
+		strings.NewReader(`{"messages":[{"role":"user","content":[{"text":"write a lot"}]}]}`))
+	w := httptest.NewRecorder()
+	proxy.serveBedrock(w, req)
+
+	if w.Body.Len() != len(frames) {
+		t.Errorf("client got %d bytes, want %d", w.Body.Len(), len(frames))
+	}
+	if n := atomic.LoadInt64(&proxy.bedrock.decodeErrors); n != 0 {
+		t.Errorf("decodeErrors = %d, want 0", n)
+	}
+	if len(emitter.TurnEndEvents) != 1 || emitter.TurnEndEvents[0].StopReason != "end_turn" {
+		t.Errorf("TurnEndEvents = %+v", emitter.TurnEndEvents)
+	}
+}
+
 func TestServeBedrock_InvalidModelID_Returns400(t *testing.T) {
 	proxy, mock := newTestBedrockProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		t.Error("mock should not be called for invalid model ID")
@@ -394,6 +509,74 @@ func TestServeBedrock_NonStreamingInvoke(t *testing.T) {
 	}
 }
 
+func TestServeBedrock_RegionSelectionAndARN(t *testing.T) {
+	var gotAuth, gotPath string
+	proxy, mock := newTestBedrockProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.EscapedPath()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn"}`))
+	})
+	defer mock.Close()
+	proxy.bedrock.client = &http.Client{
+		Transport: &rewriteTransport{target: strings.TrimPrefix(mock.URL, "http://"), inner: http.DefaultTransport},
+	}
+
+	arnPath := "/model/arn%3Aaws%3Abedrock%3Aus-east-1%3A123456789012%3Ainference-profile%2Fus.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke"
+	body := `{"anthropic_version":"bedrock-2023-05-31","max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+
+	tests := []struct {
+		name       string
+		path       string
+		header     string
+		wantStatus int
+		wantScope  string
+	}{
+		{"default region", "/model/anthropic.claude-3-haiku-20240307-v1:0/invoke", "", http.StatusOK, "/us-west-2/bedrock/"},
+		{"header selects region", arnPath, "us-east-1", http.StatusOK, "/us-east-1/bedrock/"},
+		{"path prefix selects region", "/bedrock/us-east-1" + arnPath, "", http.StatusOK, "/us-east-1/bedrock/"},
+		{"path prefix wins over header", "/bedrock/us-east-1" + arnPath, "us-west-2", http.StatusOK, "/us-east-1/bedrock/"},
+		{"unconfigured region", arnPath, "eu-west-1", http.StatusBadRequest, ""},
+		{"unconfigured prefix", "/bedrock/evil.com" + arnPath, "", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotAuth, gotPath = "", ""
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.header != "" {
+				req.Header.Set(bedrockRegionHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d (body %s)", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if gotAuth != "" {
+					t.Error("upstream should not be called")
+				}
+				return
+			}
+			if !strings.Contains(gotAuth, tt.wantScope) {
+				t.Errorf("Authorization = %q, want credential scope %s", gotAuth, tt.wantScope)
+			}
+			if strings.HasPrefix(gotPath, "/bedrock/") {
+				t.Errorf("region prefix forwarded upstream: %q", gotPath)
+			}
+		})
+	}
+
+	// ARNs are forwarded as a single encoded path segment
+	req := httptest.NewRequest("POST", arnPath, strings.NewReader(body))
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+	if gotPath != arnPath {
+		t.Errorf("upstream path = %q, want %q", gotPath, arnPath)
+	}
+}
+
 func TestServeBedrock_NotConfigured(t *testing.T) {
 	proxy := NewProxy()
 	// bedrock is nil
@@ -408,14 +591,14 @@ func TestServeBedrock_NotConfigured(t *testing.T) {
 }
 
 func TestServeBedrock_ConcurrencySemaphore(t *testing.T) {
-	// Create proxy with semaphore size 1
+	// Create proxy with a limit of 1
 	proxy, mock := newTestBedrockProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(50 * time.Millisecond)
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"id":"msg","type":"message","role":"assistant","content":[],"stop_reason":"end_turn","usage":{"input_tokens":1,"output_tokens":1}}`))
 	}))
 	defer mock.Close()
-	proxy.bedrock.semaphore = make(chan struct{}, 1)
+	proxy.bedrock.limits, _ = newBedrockLimits(BedrockConcurrencyConfig{MaxConcurrent: 1})
 
 	mockHost := strings.TrimPrefix(mock.URL, "http://")
 	proxy.bedrock.client = &http.Client{
@@ -547,10 +730,12 @@ func TestIsConversationEndpoint_BedrockPaths(t *testing.T) {
 		{"/model/us.anthropic.claude-sonnet-4-5-20250929-v2:0/invoke-with-response-stream", true},
 		{"/model/anthropic.claude-3-haiku-20240307-v1:0/invoke", true},
 		{"/model/simple/invoke", true},
+		{"/bedrock/us-east-1/model/simple/converse", true},
+		{"/bedrock/us-east-1/other", false},
 	}
 
 	for _, tt := range tests {
-		got := isConversationEndpoint(tt.path)
+		got := isConversationEndpoint(tt.path, builtinRegistry)
 		if got != tt.expected {
 			t.Errorf("isConversationEndpoint(%q) = %v, want %v", tt.path, got, tt.expected)
 		}
@@ -615,6 +800,18 @@ func (pc *providerCapture) LogResponse(sessionID, provider string, seq int, stat
 func (pc *providerCapture) LogFork(sessionID, provider string, fromSeq int, parentSession string) error {
 	return pc.inner.LogFork(sessionID, provider, fromSeq, parentSession)
 }
+func (pc *providerCapture) LogFailover(sessionID, provider string, seq int, failover FailoverRecord, requestID string) error {
+	return pc.inner.LogFailover(sessionID, provider, seq, failover, requestID)
+}
+func (pc *providerCapture) LogRewrite(sessionID, provider string, seq int, rewrite RewriteRecord, requestID string) error {
+	return pc.inner.LogRewrite(sessionID, provider, seq, rewrite, requestID)
+}
+func (pc *providerCapture) LogPolicyDenial(sessionID, provider string, seq int, denial PolicyDenial, requestID string) error {
+	return pc.inner.LogPolicyDenial(sessionID, provider, seq, denial, requestID)
+}
+func (pc *providerCapture) LogRealtimeEvent(sessionID, provider string, seq int, direction string, event []byte, requestID string) error {
+	return pc.inner.LogRealtimeEvent(sessionID, provider, seq, direction, event, requestID)
+}
 func (pc *providerCapture) Close() error {
 	return pc.inner.Close()
 }
@@ -0,0 +1,420 @@
+// budget.go
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Usage budgets ([budget] in the config file).
+//
+// Usage is tracked in sessions.db per session, per machine per UTC day and
+// per client API key per UTC month, in tokens (input, output and cache) and
+// USD (see pricing.go). Requests are checked before they are
+// forwarded: a request whose budget is used up is refused with a 429 that
+// tells clients not to retry, logged as a policy_denial. From warn_percent
+// on, responses carry an X-Proxy-Budget-Warning header. Usage is charged
+// when a response completes, so concurrent requests can overshoot a budget
+// by what they use. /budgets and /budgets/reset need the admin_token, so
+// clients can't read or reset their own budgets.
+
+// budgetWarningHeader warns clients that a budget is nearly used up.
+const budgetWarningHeader = "X-Proxy-Budget-Warning"
+
+// Budget scopes
+const (
+	budgetSession = "session" // a proxy session, for its lifetime
+	budgetMachine = "machine" // the proxy's machine ID, per UTC day
+	budgetKey     = "key"     // the API key a client sent, per UTC month
+)
+
+var budgetScopes = []string{budgetSession, budgetMachine, budgetKey}
+
+// budgetLimit caps one scope. Zero fields don't limit.
+type budgetLimit struct {
+	usd    float64
+	tokens int64
+}
+
+// used returns the fraction of the limit usage has used, the larger of its
+// USD and token fractions.
+func (l budgetLimit) used(u BudgetUsage) float64 {
+	var used float64
+	if l.usd > 0 {
+		used = u.CostUSD / l.usd
+	}
+	if l.tokens > 0 {
+		used = math.Max(used, float64(u.Tokens)/float64(l.tokens))
+	}
+	return used
+}
+
+// describe reports usage against the limit, e.g. "$4.12 of $5.00".
+func (l budgetLimit) describe(u BudgetUsage) string {
+	var parts []string
+	if l.usd > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f of $%.2f", u.CostUSD, l.usd))
+	}
+	if l.tokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d of %d tokens", u.Tokens, l.tokens))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String formats the limit, e.g. "$5.00/2000000 tokens".
+func (l budgetLimit) String() string {
+	var parts []string
+	if l.usd > 0 {
+		parts = append(parts, fmt.Sprintf("$%.2f", l.usd))
+	}
+	if l.tokens > 0 {
+		parts = append(parts, fmt.Sprintf("%d tokens", l.tokens))
+	}
+	return strings.Join(parts, "/")
+}
+
+// budgetLimits returns the limited scopes in cfg.
+func budgetLimits(cfg BudgetConfig) map[string]budgetLimit {
+	limits := make(map[string]budgetLimit)
+	for scope, l := range map[string]budgetLimit{
+		budgetSession: {cfg.SessionUSD, cfg.SessionTokens},
+		budgetMachine: {cfg.MachineDailyUSD, cfg.MachineDailyTokens},
+		budgetKey:     {cfg.KeyMonthlyUSD, cfg.KeyMonthlyTokens},
+	} {
+		if l.usd > 0 || l.tokens > 0 {
+			limits[scope] = l
+		}
+	}
+	return limits
+}
+
+// budgetPeriod returns the period a scope's usage at now is charged to.
+func budgetPeriod(scope string, now time.Time) string {
+	switch scope {
+	case budgetMachine:
+		return now.UTC().Format("2006-01-02")
+	case budgetKey:
+		return now.UTC().Format("2006-01")
+	}
+	return ""
+}
+
+// budgetName names a budget for messages.
+func budgetName(scope, subject, period string) string {
+	switch scope {
+	case budgetMachine:
+		return fmt.Sprintf("daily budget of %s (%s UTC)", subject, period)
+	case budgetKey:
+		return fmt.Sprintf("monthly budget of key %s (%s UTC)", subject, period)
+	}
+	return "session budget"
+}
+
+// budgetSubject is a subject and period a request's usage is charged to.
+type budgetSubject struct {
+	subject string
+	period  string
+}
+
+// budgetRequest is a request in flight and what its usage is charged to.
+type budgetRequest struct {
+	subjects map[string]budgetSubject // by scope
+}
+
+// budgetTracker checks requests against their budgets and charges their
+// responses' usage.
+type budgetTracker struct {
+	db         *SessionDB
+	limits     map[string]budgetLimit // limited scopes only
+	warnAt     float64                // fraction of a limit
+	machine    string
+	adminToken string // "" disables the HTTP endpoints
+
+	mu       sync.Mutex
+	requests map[string]*budgetRequest // by request ID
+}
+
+// newBudgetTracker validates cfg. Returns nil if no budget is configured.
+func newBudgetTracker(cfg BudgetConfig, db *SessionDB, machine string) (*budgetTracker, error) {
+	if cfg.SessionUSD < 0 || cfg.SessionTokens < 0 || cfg.MachineDailyUSD < 0 || cfg.MachineDailyTokens < 0 || cfg.KeyMonthlyUSD < 0 || cfg.KeyMonthlyTokens < 0 {
+		return nil, fmt.Errorf("budget: limits must not be negative")
+	}
+	if cfg.WarnPercent < 0 || cfg.WarnPercent > 100 {
+		return nil, fmt.Errorf("budget: warn_percent must be between 0 and 100")
+	}
+	if cfg.AdminToken != "" && len(cfg.AdminToken) < 16 {
+		return nil, fmt.Errorf("budget: admin_token is shorter than 16 characters")
+	}
+	limits := budgetLimits(cfg)
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	warnPercent := cfg.WarnPercent
+	if warnPercent == 0 {
+		warnPercent = 80
+	}
+	return &budgetTracker{
+		db:         db,
+		limits:     limits,
+		warnAt:     float64(warnPercent) / 100,
+		machine:    machine,
+		adminToken: cfg.AdminToken,
+		requests:   make(map[string]*budgetRequest),
+	}, nil
+}
+
+// authorizeAdmin reports whether r carries the admin token as
+// "Authorization: Bearer <admin_token>".
+func (bt *budgetTracker) authorizeAdmin(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && bt.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(bt.adminToken)) == 1
+}
+
+// describe lists the limits for the startup log.
+func (bt *budgetTracker) describe() string {
+	var parts []string
+	for _, scope := range budgetScopes {
+		if l, ok := bt.limits[scope]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%s", scope, l))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// begin checks a request against the budgets of its session, the machine
+// and key ("" if the client sent none). A request over budget is refused
+// with a denial; otherwise it is tracked until end, and warnings are
+// returned for budgets from warn_percent on. Usage that can't be read
+// doesn't refuse requests.
+func (bt *budgetTracker) begin(requestID, sessionID, key string, now time.Time) ([]string, *PolicyDenial) {
+	if bt == nil {
+		return nil, nil
+	}
+	subjects := map[string]string{budgetSession: sessionID, budgetMachine: bt.machine, budgetKey: key}
+
+	req := &budgetRequest{subjects: make(map[string]budgetSubject)}
+	var warnings []string
+	for _, scope := range budgetScopes {
+		limit, ok := bt.limits[scope]
+		if !ok || subjects[scope] == "" {
+			continue
+		}
+		s := budgetSubject{subject: subjects[scope], period: budgetPeriod(scope, now)}
+		usage, err := bt.db.GetBudgetUsage(scope, s.subject, s.period)
+		if err != nil {
+			log.Printf("WARNING: budget: reading %s usage: %v", scope, err)
+			continue
+		}
+		used := limit.used(usage)
+		if used >= 1 {
+			return nil, usedUpDenial(scope, s, limit, usage)
+		}
+		if used >= bt.warnAt {
+			name := budgetName(scope, s.subject, s.period)
+			warnings = append(warnings, fmt.Sprintf("%s %d%% used: %s", name, int(used*100), limit.describe(usage)))
+		}
+		req.subjects[scope] = s
+	}
+
+	bt.mu.Lock()
+	bt.requests[requestID] = req
+	bt.mu.Unlock()
+	return warnings, nil
+}
+
+// exceeded rechecks a tracked request's budgets, for long-lived requests
+// (Realtime WebSockets) charged while they run. Returns a denial if one is
+// used up.
+func (bt *budgetTracker) exceeded(requestID string) *PolicyDenial {
+	if bt == nil {
+		return nil
+	}
+	bt.mu.Lock()
+	req := bt.requests[requestID]
+	bt.mu.Unlock()
+	if req == nil {
+		return nil
+	}
+	for _, scope := range budgetScopes {
+		s, ok := req.subjects[scope]
+		if !ok {
+			continue
+		}
+		limit := bt.limits[scope]
+		usage, err := bt.db.GetBudgetUsage(scope, s.subject, s.period)
+		if err != nil {
+			log.Printf("WARNING: budget: reading %s usage: %v", scope, err)
+			continue
+		}
+		if limit.used(usage) >= 1 {
+			return usedUpDenial(scope, s, limit, usage)
+		}
+	}
+	return nil
+}
+
+// usedUpDenial refuses a request whose scope's budget is used up.
+func usedUpDenial(scope string, s budgetSubject, limit budgetLimit, usage BudgetUsage) *PolicyDenial {
+	return &PolicyDenial{
+		Rule:   "budget",
+		Reason: fmt.Sprintf("%s is used up: %s", budgetName(scope, s.subject, s.period), limit.describe(usage)),
+		Status: http.StatusTooManyRequests,
+	}
+}
+
+// end stops tracking a request.
+func (bt *budgetTracker) end(requestID string) {
+	if bt == nil {
+		return
+	}
+	bt.mu.Lock()
+	delete(bt.requests, requestID)
+	bt.mu.Unlock()
+}
+
+// charge adds usage, costing cost USD, to a tracked request's budgets.
+func (bt *budgetTracker) charge(requestID string, usage UsageInfo, cost float64) {
+	if bt == nil {
+		return
+	}
+	bt.mu.Lock()
+	req := bt.requests[requestID]
+	bt.mu.Unlock()
+	if req == nil {
+		return
+	}
+	tokens := int64(usage.InputTokens + usage.OutputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens)
+	if tokens == 0 {
+		return
+	}
+
+	for scope, s := range req.subjects {
+		if err := bt.db.AddBudgetUsage(scope, s.subject, s.period, tokens, cost); err != nil {
+			log.Printf("WARNING: budget: recording %s usage: %v", scope, err)
+		}
+	}
+}
+
+// budgetKeyID identifies the API key a client sent for spec's provider: its
+// obfuscated form and a short hash, to tell apart keys with the same ending.
+// Returns "" if the client sent none.
+func budgetKeyID(h http.Header, spec *providerSpec) string {
+	if spec == nil {
+		return ""
+	}
+	for _, name := range spec.AuthHeaders {
+		if key := strings.TrimPrefix(h.Get(name), "Bearer "); key != "" {
+			return ObfuscateAPIKey(key) + ":" + sha256Hex([]byte(key))[:8]
+		}
+	}
+	return ""
+}
+
+// checkBudgets refuses a logged request that is over budget and adds
+// warnings for budgets nearly used up. Returns false if it refused the
+// request. Callers defer p.budgets.end(requestID).
+func (p *Proxy) checkBudgets(w http.ResponseWriter, dialect, provider, sessionID string, seq int, requestID string, state *PatternState, key string) bool {
+	warnings, denial := p.budgets.begin(requestID, sessionID, key, time.Now())
+	if denial != nil {
+		p.deny(w, dialect, denial, provider, sessionID, seq, requestID, state)
+		return false
+	}
+	for _, warning := range warnings {
+		w.Header().Add(budgetWarningHeader, warning)
+	}
+	return true
+}
+
+// BudgetStatus is a budget subject's usage against its scope's limit.
+type BudgetStatus struct {
+	BudgetUsage
+	LimitUSD    float64 `json:"limit_usd,omitempty"`
+	LimitTokens int64   `json:"limit_tokens,omitempty"`
+	UsedPercent int     `json:"used_percent"`
+}
+
+// budgetStatuses reports usages against limits.
+func budgetStatuses(usages []BudgetUsage, limits map[string]budgetLimit) []BudgetStatus {
+	statuses := make([]BudgetStatus, 0, len(usages))
+	for _, u := range usages {
+		l := limits[u.Scope]
+		statuses = append(statuses, BudgetStatus{
+			BudgetUsage: u,
+			LimitUSD:    l.usd,
+			LimitTokens: l.tokens,
+			UsedPercent: int(l.used(u) * 100),
+		})
+	}
+	return statuses
+}
+
+// parseBudgetTarget parses a reset target: a scope, or scope:subject.
+func parseBudgetTarget(target string) (scope, subject string, err error) {
+	scope, subject, _ = strings.Cut(target, ":")
+	for _, s := range budgetScopes {
+		if scope == s {
+			return scope, subject, nil
+		}
+	}
+	return "", "", fmt.Errorf("unknown budget scope %q (expected session, machine or key)", scope)
+}
+
+// BudgetCommand is a --budgets or --budget-reset CLI operation.
+type BudgetCommand struct {
+	List  bool   // list usage
+	Reset string // scope or scope:subject to reset
+}
+
+// isSet reports whether any budget command was requested.
+func (c BudgetCommand) isSet() bool {
+	return c.List || c.Reset != ""
+}
+
+// runBudgetCommand performs cmd on the budget usage in the sessions.db at
+// dbPath, writing output to out.
+func runBudgetCommand(cmd BudgetCommand, cfg BudgetConfig, dbPath string, out io.Writer) error {
+	db, err := NewSessionDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if cmd.Reset != "" {
+		scope, subject, err := parseBudgetTarget(cmd.Reset)
+		if err != nil {
+			return err
+		}
+		n, err := db.ResetBudgetUsage(scope, subject)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Reset %d budget record(s)\n", n)
+		return nil
+	}
+
+	usages, err := db.ListBudgetUsage()
+	if err != nil {
+		return err
+	}
+	limits := budgetLimits(cfg)
+	for _, st := range budgetStatuses(usages, limits) {
+		usage := fmt.Sprintf("$%.2f, %d tokens", st.CostUSD, st.Tokens)
+		if l, ok := limits[st.Scope]; ok {
+			usage = fmt.Sprintf("%s (%d%%)", l.describe(st.BudgetUsage), st.UsedPercent)
+		}
+		period := st.Period
+		if period == "" {
+			period = "-"
+		}
+		fmt.Fprintf(out, "%-8s %-10s %-40s %s\n", st.Scope, period, st.Subject, usage)
+	}
+	return nil
+}
@@ -0,0 +1,288 @@
+// ca.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Local certificate authority for forward-proxy mode.
+//
+// The CA is generated once per user and lives in ca_dir (default
+// ~/.config/llm-proxy/ca): ca.pem is the certificate clients trust, ca-key.pem
+// its key (mode 0600), and ca-bundle.pem the system roots plus ca.pem, for
+// tools that only take a replacement bundle (SSL_CERT_FILE). Leaf
+// certificates for intercepted hosts are minted in memory as needed.
+//
+// The CA is valid for a year and name-constrained to the intercepted hosts,
+// so its key can't sign a certificate for any other host clients accept.
+// --setup-ca replaces it when it nears expiry or the hosts change.
+
+const (
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	caBundleFile = "ca-bundle.pem"
+
+	caValidity    = 365 * 24 * time.Hour
+	caRenewBefore = 30 * 24 * time.Hour
+	leafValidity  = 7 * 24 * time.Hour
+)
+
+// systemCABundles are the usual locations of the system root bundle.
+var systemCABundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt", // Debian, Ubuntu, Alpine
+	"/etc/pki/tls/certs/ca-bundle.crt",   // Fedora, RHEL
+	"/etc/ssl/ca-bundle.pem",             // openSUSE
+	"/etc/ssl/cert.pem",                  // macOS, BSD
+}
+
+// DefaultCADir returns ~/.config/llm-proxy/ca.
+func DefaultCADir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "llm-proxy", "ca")
+}
+
+// ensureLocalCA creates the CA in dir for hosts unless one exists that covers
+// them and isn't about to expire, and rewrites the bundle so it picks up
+// system root updates. Reports whether it created one.
+func ensureLocalCA(dir string, hosts []string) (bool, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, caCertFile))
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if err == nil {
+		if cert, err := parseCertPEM(certPEM); err == nil && checkCA(cert, hosts, time.Now().Add(caRenewBefore)) == nil {
+			return false, writeCABundle(dir, certPEM)
+		}
+	}
+	if certPEM, err = createLocalCA(dir, hosts); err != nil {
+		return false, err
+	}
+	return true, writeCABundle(dir, certPEM)
+}
+
+// parseCertPEM parses the first certificate in data.
+func parseCertPEM(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// checkCA returns an error unless cert is still valid at t and its name
+// constraints permit every host.
+func checkCA(cert *x509.Certificate, hosts []string, t time.Time) error {
+	if t.After(cert.NotAfter) {
+		return fmt.Errorf("CA expires %s", cert.NotAfter.Format(time.DateOnly))
+	}
+	var missing []string
+	for _, host := range hosts {
+		if !caPermits(cert, host) {
+			missing = append(missing, host)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("CA doesn't cover %s", strings.Join(missing, ","))
+	}
+	return nil
+}
+
+// caPermits returns true if cert's name constraints allow leaves for host.
+func caPermits(cert *x509.Certificate, host string) bool {
+	if ip := net.ParseIP(host); ip != nil {
+		for _, r := range cert.PermittedIPRanges {
+			if r.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, domain := range cert.PermittedDNSDomains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// createLocalCA writes a new CA certificate for hosts and its key to dir
+// and returns the certificate PEM.
+func createLocalCA(dir string, hosts []string) ([]byte, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	user := os.Getenv("USER")
+	host, _ := os.Hostname()
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("llm-proxy local CA (%s@%s)", user, host), Organization: []string{"llm-proxy"}},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+
+		PermittedDNSDomainsCritical: true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip == nil {
+			tmpl.PermittedDNSDomains = append(tmpl.PermittedDNSDomains, host)
+		} else if ip4 := ip.To4(); ip4 != nil {
+			tmpl.PermittedIPRanges = append(tmpl.PermittedIPRanges, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+		} else {
+			tmpl.PermittedIPRanges = append(tmpl.PermittedIPRanges, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+		}
+	}
+	if len(tmpl.PermittedIPRanges) == 0 {
+		// With no permitted ranges IP addresses would be unconstrained
+		tmpl.ExcludedIPRanges = []*net.IPNet{
+			{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)},
+			{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)},
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, caKeyFile), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, caCertFile), certPEM, 0644); err != nil {
+		return nil, err
+	}
+	return certPEM, nil
+}
+
+// writeCABundle writes the first system root bundle found plus certPEM to
+// ca-bundle.pem. Without a system bundle none is written, since one holding
+// only the local CA would break every host that isn't intercepted.
+func writeCABundle(dir string, certPEM []byte) error {
+	for _, path := range systemCABundles {
+		system, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		bundle := append(append(system, '\n'), certPEM...)
+		return os.WriteFile(filepath.Join(dir, caBundleFile), bundle, 0644)
+	}
+	return nil
+}
+
+// localCA mints leaf certificates signed by the local CA.
+type localCA struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	leafKey *ecdsa.PrivateKey // shared by all leaves
+
+	mu     sync.Mutex
+	leaves map[string]*tls.Certificate // by host
+}
+
+// loadLocalCA reads the CA in dir. The key must not be readable by group or
+// others.
+func loadLocalCA(dir string) (*localCA, error) {
+	keyPath := filepath.Join(dir, caKeyFile)
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("forward_proxy: no CA in %s; run llm-proxy --setup-ca", dir)
+		}
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("forward_proxy: CA key %s has mode %04o; run chmod 600 %s", keyPath, perm, keyPath)
+	}
+
+	pair, err := tls.LoadX509KeyPair(filepath.Join(dir, caCertFile), keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("forward_proxy: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("forward_proxy: CA key in %s is not ECDSA", keyPath)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("forward_proxy: %w", err)
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &localCA{cert: cert, key: key, leafKey: leafKey, leaves: make(map[string]*tls.Certificate)}, nil
+}
+
+// leaf returns a certificate for host (a name or IP), minting one if there
+// is no cached certificate with at least a day left.
+func (ca *localCA) leaf(host string, now time.Time) (*tls.Certificate, error) {
+	ca.mu.Lock()
+	defer ca.mu.Unlock()
+
+	if cert, ok := ca.leaves[host]; ok && cert.Leaf.NotAfter.After(now.Add(24*time.Hour)) {
+		return cert, nil
+	}
+
+	serial, err := randomSerial()
+	if err != nil {
+		return nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &ca.leafKey.PublicKey, ca.key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: ca.leafKey, Leaf: leaf}
+	ca.leaves[host] = cert
+	return cert, nil
+}
+
+// randomSerial returns a random 128-bit certificate serial number.
+func randomSerial() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
@@ -0,0 +1,115 @@
+// ca_test.go
+package main
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnsureLocalCA(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "ca")
+	hosts := []string{"api.anthropic.com", "api.openai.com"}
+
+	created, err := ensureLocalCA(dir, hosts)
+	if err != nil || !created {
+		t.Fatalf("ensureLocalCA() = (%v, %v), want created", created, err)
+	}
+	if info, _ := os.Stat(filepath.Join(dir, caKeyFile)); info.Mode().Perm() != 0600 {
+		t.Errorf("key mode = %04o, want 0600", info.Mode().Perm())
+	}
+	first, _ := os.ReadFile(filepath.Join(dir, caCertFile))
+
+	created, err = ensureLocalCA(dir, hosts[:1])
+	if err != nil || created {
+		t.Fatalf("second ensureLocalCA() = (%v, %v), want existing CA", created, err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, caCertFile)); string(again) != string(first) {
+		t.Error("second ensureLocalCA() replaced the CA")
+	}
+
+	cert, err := parseCertPEM(first)
+	if err != nil {
+		t.Fatalf("parseCertPEM: %v", err)
+	}
+	if cert.NotAfter.After(time.Now().Add(caValidity)) {
+		t.Errorf("CA valid until %v, want at most %v", cert.NotAfter, caValidity)
+	}
+	if err := checkCA(cert, hosts, cert.NotAfter.Add(time.Second)); err == nil || !strings.Contains(err.Error(), "expires") {
+		t.Errorf("checkCA after expiry: err = %v", err)
+	}
+
+	// A host the CA doesn't cover replaces it
+	created, err = ensureLocalCA(dir, append(hosts, "myresource.openai.azure.com"))
+	if err != nil || !created {
+		t.Fatalf("ensureLocalCA() with a new host = (%v, %v), want a new CA", created, err)
+	}
+	if again, _ := os.ReadFile(filepath.Join(dir, caCertFile)); string(again) == string(first) {
+		t.Error("ensureLocalCA() with a new host kept the old CA")
+	}
+}
+
+func TestLoadLocalCA(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := loadLocalCA(dir); err == nil || !strings.Contains(err.Error(), "--setup-ca") {
+		t.Errorf("missing CA: err = %v, want hint to run --setup-ca", err)
+	}
+
+	if _, err := ensureLocalCA(dir, []string{"api.anthropic.com"}); err != nil {
+		t.Fatalf("ensureLocalCA: %v", err)
+	}
+	os.Chmod(filepath.Join(dir, caKeyFile), 0644)
+	if _, err := loadLocalCA(dir); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Errorf("readable key: err = %v, want chmod hint", err)
+	}
+	os.Chmod(filepath.Join(dir, caKeyFile), 0600)
+	if _, err := loadLocalCA(dir); err != nil {
+		t.Errorf("loadLocalCA: %v", err)
+	}
+}
+
+func TestLocalCA_Leaf(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := ensureLocalCA(dir, []string{"api.anthropic.com", "127.0.0.1"}); err != nil {
+		t.Fatalf("ensureLocalCA: %v", err)
+	}
+	ca, err := loadLocalCA(dir)
+	if err != nil {
+		t.Fatalf("loadLocalCA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	now := time.Now()
+
+	for _, host := range []string{"api.anthropic.com", "127.0.0.1"} {
+		cert, err := ca.leaf(host, now)
+		if err != nil {
+			t.Fatalf("leaf(%s): %v", host, err)
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, CurrentTime: now}); err != nil {
+			t.Errorf("leaf(%s) does not verify: %v", host, err)
+		}
+	}
+
+	// Name constraints keep the key from vouching for any other host
+	for _, host := range []string{"github.com", "anthropic.com", "10.0.0.1"} {
+		cert, err := ca.leaf(host, now)
+		if err != nil {
+			t.Fatalf("leaf(%s): %v", host, err)
+		}
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, CurrentTime: now}); err == nil {
+			t.Errorf("leaf(%s) verifies outside the CA's hosts", host)
+		}
+	}
+
+	cached, _ := ca.leaf("api.anthropic.com", now)
+	if again, _ := ca.leaf("api.anthropic.com", now.Add(time.Hour)); again != cached {
+		t.Error("expected the cached leaf within its lifetime")
+	}
+	if renewed, _ := ca.leaf("api.anthropic.com", now.Add(leafValidity)); renewed == cached {
+		t.Error("expected a new leaf near expiry")
+	}
+}
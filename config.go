@@ -4,17 +4,17 @@ package main
 import (
 	"fmt"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 
 	toml "github.com/pelletier/go-toml/v2"
 )
 
-// validBedrockRegions lists AWS regions where Bedrock is available for Claude models.
-var validBedrockRegions = map[string]bool{
-	"us-east-1": true,
-	"us-east-2": true,
-	"us-west-2": true,
-}
+// validBedrockRegion matches AWS region names (us-west-2, eu-central-1,
+// us-gov-west-1, ...). Regions end up in the upstream hostname, so anything
+// else is rejected.
+var validBedrockRegion = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]$`)
 
 // LokiConfig holds configuration for Loki log export
 type LokiConfig struct {
@@ -28,10 +28,204 @@ type LokiConfig struct {
 	Environment  string `toml:"environment"`  // Environment label (development, staging, production)
 }
 
+// VertexConfig holds configuration for Anthropic-on-Vertex AI pass-through
+type VertexConfig struct {
+	Enabled         bool   `toml:"enabled"`
+	CredentialsFile string `toml:"credentials_file"` // Service-account JSON key or ADC file (empty = GOOGLE_APPLICATION_CREDENTIALS, then gcloud ADC)
+}
+
+// BedrockCredentialConfig is a named AWS credential source for Bedrock. A
+// request uses it when it names it in the X-Bedrock-Credential header (if
+// AllowHeader is set), is sent under PathPrefix, or its model ID matches one
+// of Models.
+type BedrockCredentialConfig struct {
+	Name        string   `toml:"name"`         // "default" replaces the default credential chain
+	Profile     string   `toml:"profile"`      // Named profile from the shared AWS config (empty = default chain)
+	RoleARN     string   `toml:"role_arn"`     // Role to assume via STS (optional)
+	ExternalID  string   `toml:"external_id"`  // External ID for AssumeRole (optional)
+	SessionName string   `toml:"session_name"` // AssumeRole session name (default: llm-proxy)
+	Models      []string `toml:"models"`       // Glob patterns matched against the model ID
+	PathPrefix  string   `toml:"path_prefix"`  // Single path segment, e.g. /team-a
+	AllowHeader bool     `toml:"allow_header"` // Clients may pick it with X-Bedrock-Credential
+}
+
+// BedrockConcurrencyConfig bounds concurrent Bedrock requests. Requests over
+// the limit wait in a queue; when the queue is full or the wait exceeds
+// MaxQueueTimeStr, they are rejected with a 429 ThrottlingException.
+type BedrockConcurrencyConfig struct {
+	MaxConcurrent   int            `toml:"max_concurrent"` // Shared limit for models without an override (default 5)
+	MaxQueue        *int           `toml:"max_queue"`      // Waiting requests per limiter (default 50, 0 rejects when all slots are busy)
+	MaxQueueTimeStr string         `toml:"max_queue_time"` // Duration string, max time a request waits (default 60s)
+	Models          map[string]int `toml:"models"`         // Per-model limits keyed by glob pattern on the model ID, tried in sorted order
+}
+
+// AnthropicFailoverConfig retries failed direct Anthropic /v1/messages calls
+// on Bedrock, before any bytes reach the client. Requires Bedrock.
+type AnthropicFailoverConfig struct {
+	Enabled  bool              `toml:"enabled"`
+	Statuses []int             `toml:"statuses"` // Anthropic statuses that trigger failover (default 500, 502, 503, 504, 529)
+	Region   string            `toml:"region"`   // Bedrock region (default: bedrock_region)
+	Models   map[string]string `toml:"models"`   // Anthropic model name or glob → Bedrock model ID or inference profile ARN
+}
+
+// RetryConfig retries connection errors and retryable statuses before any
+// response bytes reach the client. Disabled unless MaxRetries is set.
+type RetryConfig struct {
+	MaxRetries   int    `toml:"max_retries"` // Retries after the first attempt (default 0 = disabled)
+	Statuses     []int  `toml:"statuses"`    // Retried statuses (default 429, 503, 529)
+	BaseDelayStr string `toml:"base_delay"`  // Duration string, first backoff, doubled per retry (default 1s)
+	MaxDelayStr  string `toml:"max_delay"`   // Duration string, longest backoff (default 30s)
+	MaxTotalStr  string `toml:"max_total"`   // Duration string, cap on total time spent retrying (default 2m)
+}
+
+// KeyPoolConfig is a pool of upstream API keys for one provider. Requests to
+// the pool's upstream have the client's credentials replaced by a pool key.
+type KeyPoolConfig struct {
+	Provider string   `toml:"provider"` // Provider name, e.g. "anthropic"
+	Upstream string   `toml:"upstream"` // Upstream host the keys belong to (default: the provider's upstream)
+	Header   string   `toml:"header"`   // Header carrying the key (default: the provider's first auth header)
+	Keys     []string `toml:"keys"`     // Inline keys
+	KeyFile  string   `toml:"key_file"` // File with one key per line; blank lines and # comments are ignored
+}
+
+// VaultConfig enables credential vault mode: clients authenticate with
+// proxy-issued tokens and the proxy injects upstream keys from the vault file.
+type VaultConfig struct {
+	Enabled bool   `toml:"enabled"`
+	Path    string `toml:"path"` // Vault file (default ~/.config/llm-proxy/vault.json), must be mode 0600
+}
+
+// ProxyAuthConfig requires clients to authenticate to the proxy itself, so it
+// can listen on non-loopback addresses.
+type ProxyAuthConfig struct {
+	Enabled    bool              `toml:"enabled"`
+	MaxSkewStr string            `toml:"max_skew"` // Duration string, accepted signature clock skew (default 5m)
+	Clients    []ProxyAuthClient `toml:"clients"`
+}
+
+// ProxyAuthClient is one identity allowed to use the proxy, with a bearer
+// token, an HMAC secret, or both.
+type ProxyAuthClient struct {
+	Identity   string `toml:"identity"`    // Logged as _meta.identity and the Loki identity label
+	Token      string `toml:"token"`       // Sent as X-Proxy-Authorization: Bearer <token>
+	HMACSecret string `toml:"hmac_secret"` // Key for X-Proxy-Signature
+}
+
+// TLSConfig serves HTTPS instead of HTTP. With a client CA the proxy also
+// requires client certificates and uses their subject CN as the identity.
+type TLSConfig struct {
+	CertFile     string `toml:"cert_file"`      // PEM certificate chain (enables TLS with key_file)
+	KeyFile      string `toml:"key_file"`       // PEM private key
+	ClientCAFile string `toml:"client_ca_file"` // PEM CA bundle; set to require client certificates (optional)
+}
+
+// ForwardProxyConfig accepts CONNECT so tools that only honor HTTPS_PROXY are
+// logged too. Provider hosts are intercepted with certificates from a local
+// CA; other hosts are tunneled untouched.
+type ForwardProxyConfig struct {
+	Enabled bool              `toml:"enabled"`
+	CADir   string            `toml:"ca_dir"` // Local CA directory (default ~/.config/llm-proxy/ca)
+	Hosts   map[string]string `toml:"hosts"`  // Extra intercepted host[:port] → provider, e.g. Azure resources
+}
+
+// RewriteRuleConfig rewrites matching requests before they are forwarded.
+// Empty match fields match anything; matching rules apply in config order.
+type RewriteRuleConfig struct {
+	Name          string            `toml:"name"`           // Logged as _meta.rewritten_by and the Loki rewritten_by label
+	Provider      string            `toml:"provider"`       // Match: provider name
+	Path          string            `toml:"path"`           // Match: path pattern, "*" matches a segment, "**" anything
+	Model         string            `toml:"model"`          // Match: model glob (body model, or the Gemini path model)
+	Headers       map[string]string `toml:"headers"`        // Match: header name → value glob
+	ClientSession string            `toml:"client_session"` // Match: client session ID glob
+	SetModel      string            `toml:"set_model"`      // Rewrite: model name
+	MaxTokens     int               `toml:"max_tokens"`     // Rewrite: cap max_tokens, max_completion_tokens, max_output_tokens
+	RemoveBetas   []string          `toml:"remove_betas"`   // Rewrite: strip matching anthropic-beta / openai-beta values (globs)
+	AddBetas      []string          `toml:"add_betas"`      // Rewrite: add anthropic-beta / openai-beta values
+	UserID        string            `toml:"user_id"`        // Rewrite: set metadata.user_id when the client sends none
+}
+
+// PolicyRuleConfig refuses matching requests before they are forwarded.
+// Empty match fields match anything; the first rule that fires denies.
+type PolicyRuleConfig struct {
+	Name           string            `toml:"name"`             // Logged in the policy_denial entry and the client's error
+	Provider       string            `toml:"provider"`         // Match: provider name (Bedrock and Vertex requests are "anthropic")
+	Path           string            `toml:"path"`             // Match: path pattern, "*" matches a segment, "**" anything
+	Headers        map[string]string `toml:"headers"`          // Match: header name → value glob
+	Identity       string            `toml:"identity"`         // Match: proxy_auth identity or vault principal glob
+	DenyModels     []string          `toml:"deny_models"`      // Deny: model globs
+	DenyTools      []string          `toml:"deny_tools"`       // Deny: tool definition name globs
+	MaxInputTokens int               `toml:"max_input_tokens"` // Deny: more estimated input tokens than this
+	Message        string            `toml:"message"`          // Error message for the client (default: why the rule fired)
+}
+
+// BudgetConfig caps usage per session, per machine per UTC day and per
+// client API key per UTC month. Zero means no limit.
+type BudgetConfig struct {
+	SessionUSD         float64 `toml:"session_usd"`
+	SessionTokens      int64   `toml:"session_tokens"` // Input, output and cache tokens
+	MachineDailyUSD    float64 `toml:"machine_daily_usd"`
+	MachineDailyTokens int64   `toml:"machine_daily_tokens"`
+	KeyMonthlyUSD      float64 `toml:"key_monthly_usd"`
+	KeyMonthlyTokens   int64   `toml:"key_monthly_tokens"`
+	WarnPercent        int     `toml:"warn_percent"` // X-Proxy-Budget-Warning from this much used (default 80)
+	AdminToken         string  `toml:"admin_token"`  // Bearer token for /budgets and /budgets/reset (unset: disabled)
+}
+
+// PriceRates are token prices in USD per million tokens.
+type PriceRates struct {
+	Input        float64 `toml:"input"`
+	Output       float64 `toml:"output"`
+	CacheWrite   float64 `toml:"cache_write"`    // 5-minute cache writes
+	CacheWrite1h float64 `toml:"cache_write_1h"` // 1-hour cache writes (default: cache_write)
+	CacheRead    float64 `toml:"cache_read"`
+}
+
+// ModelPriceConfig prices the models matching a glob. Entries override the
+// built-in price table.
+type ModelPriceConfig struct {
+	Model string `toml:"model"` // Model glob, e.g. "*claude-sonnet-4-5*"
+	PriceRates
+	LongContextAbove int        `toml:"long_context_above"` // Prompt tokens past which long_context rates apply
+	LongContext      PriceRates `toml:"long_context"`
+}
+
+// ProviderConfig defines a /{provider}/{upstream}/{path} route. Built-in
+// providers use the same struct; a [[providers]] entry with a built-in name
+// replaces it.
+type ProviderConfig struct {
+	Name              string   `toml:"name"`               // First path segment, e.g. "openrouter"
+	Upstream          string   `toml:"upstream"`           // Default upstream host, used for /{provider}/{path} (optional)
+	Scheme            string   `toml:"scheme"`             // http or https (default: https, http for localhost)
+	Dialect           string   `toml:"dialect"`            // anthropic, openai-chat, openai-responses, gemini or ollama
+	ConversationPaths []string `toml:"conversation_paths"` // Logged paths; "*" matches a segment, "**" anything
+	SessionID         []string `toml:"session_id"`         // Rules: "header:X-Session-ID", "body:metadata.session_id", "path:/v1/threads/{id}/" (empty = dialect default)
+	AuthHeaders       []string `toml:"auth_headers"`       // Headers obfuscated in logs
+	JWTUpstream       string   `toml:"jwt_upstream"`       // Upstream for JWT bearer tokens sent to the default upstream (optional)
+	JWTPathPrefix     string   `toml:"jwt_path_prefix"`    // Path prefix added when rerouting JWT requests
+}
+
 type Config struct {
 	Port          int    `toml:"port"`
+	Bind          string `toml:"bind"`           // Listen address (default 127.0.0.1); non-loopback requires proxy_auth or mTLS
+	Socket        string `toml:"socket"`         // Unix socket path, mode 0600 (optional)
+	SocketOnly    bool   `toml:"socket_only"`    // Listen only on the socket, not TCP
 	LogDir        string `toml:"log_dir"`
 	BedrockRegion string `toml:"bedrock_region"` // AWS region for Bedrock (empty = disabled)
+	BedrockRegions []string `toml:"bedrock_regions"` // Additional regions requests may select
+	BedrockCredentials []BedrockCredentialConfig `toml:"bedrock_credentials"`
+	BedrockConcurrency BedrockConcurrencyConfig `toml:"bedrock_concurrency"`
+	AnthropicFailover AnthropicFailoverConfig `toml:"anthropic_failover"`
+	Retry         RetryConfig `toml:"retry"`
+	KeyPools      []KeyPoolConfig `toml:"key_pools"`
+	Vault         VaultConfig `toml:"vault"`
+	ProxyAuth     ProxyAuthConfig `toml:"proxy_auth"`
+	TLS           TLSConfig `toml:"tls"`
+	ForwardProxy  ForwardProxyConfig `toml:"forward_proxy"`
+	Providers     []ProviderConfig `toml:"providers"`
+	Rewrites      []RewriteRuleConfig `toml:"rewrite"`
+	Policies      []PolicyRuleConfig `toml:"policy"`
+	Budget        BudgetConfig `toml:"budget"`
+	Pricing       []ModelPriceConfig `toml:"pricing"`
 	ServiceMode   bool   `toml:"-"`              // CLI-only, not persisted in config file
 	SetupShell    bool   `toml:"-"`              // CLI-only, not persisted in config file
 	Env           bool   `toml:"-"`              // CLI-only, not persisted in config file
@@ -41,11 +235,13 @@ type Config struct {
 	Explore       bool   `toml:"-"`              // CLI-only, not persisted in config file
 	ExplorePort   int    `toml:"explore_port"`
 	Loki          LokiConfig `toml:"loki"`
+	Vertex        VertexConfig `toml:"vertex"`
 }
 
 func DefaultConfig() Config {
 	return Config{
 		Port:   8080,
+		Bind:   "127.0.0.1",
 		LogDir: "./logs",
 		Loki: LokiConfig{
 			Enabled:      false,
@@ -67,13 +263,13 @@ func LoadConfigFromTOML(data []byte) (Config, error) {
 }
 
 // ValidateBedrockRegion returns an error if the region is non-empty and not a
-// known Bedrock-supported region.
+// well-formed AWS region name.
 func ValidateBedrockRegion(region string) error {
 	if region == "" {
 		return nil
 	}
-	if !validBedrockRegions[region] {
-		return fmt.Errorf("unsupported Bedrock region %q (valid: us-east-1, us-east-2, us-west-2)", region)
+	if !validBedrockRegion.MatchString(region) {
+		return fmt.Errorf("invalid Bedrock region %q (expected e.g. us-west-2)", region)
 	}
 	return nil
 }
@@ -84,12 +280,97 @@ func LoadConfigFromEnv(cfg Config) Config {
 			cfg.Port = p
 		}
 	}
+	if bind := os.Getenv("LLM_PROXY_BIND"); bind != "" {
+		cfg.Bind = bind
+	}
+	if socket := os.Getenv("LLM_PROXY_SOCKET"); socket != "" {
+		cfg.Socket = socket
+	}
+	if socketOnly := os.Getenv("LLM_PROXY_SOCKET_ONLY"); socketOnly != "" {
+		cfg.SocketOnly = socketOnly == "true" || socketOnly == "1"
+	}
+	if enabled := os.Getenv("LLM_PROXY_AUTH_ENABLED"); enabled != "" {
+		cfg.ProxyAuth.Enabled = enabled == "true" || enabled == "1"
+	}
+	if enabled := os.Getenv("LLM_PROXY_FORWARD_PROXY_ENABLED"); enabled != "" {
+		cfg.ForwardProxy.Enabled = enabled == "true" || enabled == "1"
+	}
+	if caDir := os.Getenv("LLM_PROXY_FORWARD_PROXY_CA_DIR"); caDir != "" {
+		cfg.ForwardProxy.CADir = caDir
+	}
+	if certFile := os.Getenv("LLM_PROXY_TLS_CERT_FILE"); certFile != "" {
+		cfg.TLS.CertFile = certFile
+	}
+	if keyFile := os.Getenv("LLM_PROXY_TLS_KEY_FILE"); keyFile != "" {
+		cfg.TLS.KeyFile = keyFile
+	}
+	if caFile := os.Getenv("LLM_PROXY_TLS_CLIENT_CA_FILE"); caFile != "" {
+		cfg.TLS.ClientCAFile = caFile
+	}
 	if logDir := os.Getenv("LLM_PROXY_LOG_DIR"); logDir != "" {
 		cfg.LogDir = logDir
 	}
 	if region := os.Getenv("BEDROCK_REGION"); region != "" {
 		cfg.BedrockRegion = region
 	}
+	if regions := os.Getenv("BEDROCK_REGIONS"); regions != "" {
+		cfg.BedrockRegions = nil
+		for _, region := range strings.Split(regions, ",") {
+			if region = strings.TrimSpace(region); region != "" {
+				cfg.BedrockRegions = append(cfg.BedrockRegions, region)
+			}
+		}
+	}
+
+	if maxConcurrent := os.Getenv("BEDROCK_MAX_CONCURRENT"); maxConcurrent != "" {
+		if mc, err := strconv.Atoi(maxConcurrent); err == nil {
+			cfg.BedrockConcurrency.MaxConcurrent = mc
+		}
+	}
+	if maxQueue := os.Getenv("BEDROCK_MAX_QUEUE"); maxQueue != "" {
+		if mq, err := strconv.Atoi(maxQueue); err == nil {
+			cfg.BedrockConcurrency.MaxQueue = &mq
+		}
+	}
+	if maxQueueTime := os.Getenv("BEDROCK_MAX_QUEUE_TIME"); maxQueueTime != "" {
+		cfg.BedrockConcurrency.MaxQueueTimeStr = maxQueueTime
+	}
+
+	if enabled := os.Getenv("LLM_PROXY_ANTHROPIC_FAILOVER_ENABLED"); enabled != "" {
+		cfg.AnthropicFailover.Enabled = enabled == "true" || enabled == "1"
+	}
+	if statuses := os.Getenv("LLM_PROXY_ANTHROPIC_FAILOVER_STATUSES"); statuses != "" {
+		cfg.AnthropicFailover.Statuses = nil
+		for _, s := range strings.Split(statuses, ",") {
+			if status, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				cfg.AnthropicFailover.Statuses = append(cfg.AnthropicFailover.Statuses, status)
+			}
+		}
+	}
+
+	if maxRetries := os.Getenv("LLM_PROXY_RETRY_MAX_RETRIES"); maxRetries != "" {
+		if n, err := strconv.Atoi(maxRetries); err == nil {
+			cfg.Retry.MaxRetries = n
+		}
+	}
+	if maxTotal := os.Getenv("LLM_PROXY_RETRY_MAX_TOTAL"); maxTotal != "" {
+		cfg.Retry.MaxTotalStr = maxTotal
+	}
+
+	if enabled := os.Getenv("LLM_PROXY_VAULT_ENABLED"); enabled != "" {
+		cfg.Vault.Enabled = enabled == "true" || enabled == "1"
+	}
+	if path := os.Getenv("LLM_PROXY_VAULT_PATH"); path != "" {
+		cfg.Vault.Path = path
+	}
+
+	// Vertex AI configuration
+	if enabled := os.Getenv("LLM_PROXY_VERTEX_ENABLED"); enabled != "" {
+		cfg.Vertex.Enabled = enabled == "true" || enabled == "1"
+	}
+	if credFile := os.Getenv("LLM_PROXY_VERTEX_CREDENTIALS_FILE"); credFile != "" {
+		cfg.Vertex.CredentialsFile = credFile
+	}
 
 	// Loki configuration
 	if enabled := os.Getenv("LLM_PROXY_LOKI_ENABLED"); enabled != "" {
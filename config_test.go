@@ -135,18 +135,39 @@ func TestLoadConfigFromEnv_BedrockRegion(t *testing.T) {
 	}
 }
 
+func TestLoadConfigFromEnv_BedrockRegions(t *testing.T) {
+	t.Setenv("BEDROCK_REGIONS", "us-east-1, eu-west-1,")
+
+	cfg := LoadConfigFromEnv(DefaultConfig())
+
+	if len(cfg.BedrockRegions) != 2 || cfg.BedrockRegions[0] != "us-east-1" || cfg.BedrockRegions[1] != "eu-west-1" {
+		t.Errorf("BedrockRegions = %v, want [us-east-1 eu-west-1]", cfg.BedrockRegions)
+	}
+
+	cfg, err := LoadConfigFromTOML([]byte(`bedrock_region = "us-west-2"
+bedrock_regions = ["us-east-2"]`))
+	if err != nil {
+		t.Fatalf("LoadConfigFromTOML: %v", err)
+	}
+	if cfg.BedrockRegion != "us-west-2" || len(cfg.BedrockRegions) != 1 || cfg.BedrockRegions[0] != "us-east-2" {
+		t.Errorf("TOML config = %q %v", cfg.BedrockRegion, cfg.BedrockRegions)
+	}
+}
+
 func TestValidateBedrockRegion(t *testing.T) {
 	tests := []struct {
 		region  string
 		wantErr bool
 	}{
-		{"", false},           // empty = disabled, valid
-		{"us-west-2", false},  // supported
-		{"us-east-1", false},  // supported
-		{"us-east-2", false},  // supported
-		{"us-west-1", true},   // Bedrock not available
-		{"eu-west-1", true},   // not in our approved list
-		{"ap-southeast-1", true},
+		{"", false},               // empty = disabled, valid
+		{"us-west-2", false},      // well-formed
+		{"eu-west-1", false},      // well-formed
+		{"ap-southeast-1", false}, // well-formed
+		{"us-gov-west-1", false},  // well-formed
+		{"us-west", true},         // missing number
+		{"evil.com", true},        // would escape the upstream hostname
+		{"us-west-2.evil.com#", true},
+		{"US-WEST-2", true},
 	}
 
 	for _, tt := range tests {
@@ -203,3 +224,264 @@ environment = "production"
 		t.Errorf("expected Loki.Environment 'production', got %q", cfg.Loki.Environment)
 	}
 }
+
+func TestLoadConfig_VertexSection(t *testing.T) {
+	cfg, err := LoadConfigFromTOML([]byte(`
+[vertex]
+enabled = true
+credentials_file = "/etc/llm-proxy/sa.json"
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cfg.Vertex.Enabled || cfg.Vertex.CredentialsFile != "/etc/llm-proxy/sa.json" {
+		t.Errorf("unexpected Vertex config: %+v", cfg.Vertex)
+	}
+
+	os.Setenv("LLM_PROXY_VERTEX_CREDENTIALS_FILE", "/tmp/adc.json")
+	defer os.Unsetenv("LLM_PROXY_VERTEX_CREDENTIALS_FILE")
+
+	cfg = LoadConfigFromEnv(cfg)
+	if cfg.Vertex.CredentialsFile != "/tmp/adc.json" {
+		t.Errorf("expected env to override credentials_file, got %q", cfg.Vertex.CredentialsFile)
+	}
+}
+
+func TestLoadConfigFromTOML_Providers(t *testing.T) {
+	tomlContent := `
+[[providers]]
+name = "openrouter"
+upstream = "openrouter.ai"
+dialect = "openai-chat"
+conversation_paths = ["/api/v1/chat/completions"]
+session_id = ["header:X-Session-ID", "body:metadata.session_id"]
+auth_headers = ["authorization"]
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Providers) != 1 {
+		t.Fatalf("expected 1 provider, got %d", len(cfg.Providers))
+	}
+	p := cfg.Providers[0]
+	if p.Name != "openrouter" || p.Upstream != "openrouter.ai" || p.Dialect != "openai-chat" {
+		t.Errorf("unexpected provider: %+v", p)
+	}
+	if len(p.ConversationPaths) != 1 || len(p.SessionID) != 2 || len(p.AuthHeaders) != 1 {
+		t.Errorf("unexpected provider lists: %+v", p)
+	}
+	if _, err := newProviderSpec(p); err != nil {
+		t.Errorf("newProviderSpec: %v", err)
+	}
+}
+
+func TestLoadConfigFromTOML_AnthropicFailover(t *testing.T) {
+	tomlContent := `
+[anthropic_failover]
+enabled = true
+statuses = [529, 503]
+
+[anthropic_failover.models]
+"claude-sonnet-4-5*" = "us.anthropic.claude-sonnet-4-5-20250929-v1:0"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f := cfg.AnthropicFailover
+	if !f.Enabled || len(f.Statuses) != 2 || f.Models["claude-sonnet-4-5*"] != "us.anthropic.claude-sonnet-4-5-20250929-v1:0" {
+		t.Errorf("unexpected failover config: %+v", f)
+	}
+}
+
+func TestLoadConfigFromTOML_Retry(t *testing.T) {
+	tomlContent := `
+[retry]
+max_retries = 4
+statuses = [429, 529]
+max_total = "45s"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r := cfg.Retry
+	if r.MaxRetries != 4 || len(r.Statuses) != 2 || r.MaxTotalStr != "45s" || r.BaseDelayStr != "" {
+		t.Errorf("unexpected retry config: %+v", r)
+	}
+
+	t.Setenv("LLM_PROXY_RETRY_MAX_RETRIES", "1")
+	if cfg = LoadConfigFromEnv(cfg); cfg.Retry.MaxRetries != 1 {
+		t.Errorf("MaxRetries = %d, want env override 1", cfg.Retry.MaxRetries)
+	}
+}
+
+func TestLoadConfigFromTOML_KeyPools(t *testing.T) {
+	tomlContent := `
+[[key_pools]]
+provider = "anthropic"
+keys = ["sk-ant-one", "sk-ant-two"]
+
+[[key_pools]]
+provider = "openai"
+key_file = "/etc/llm-proxy/openai-keys"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.KeyPools) != 2 || len(cfg.KeyPools[0].Keys) != 2 || cfg.KeyPools[1].KeyFile != "/etc/llm-proxy/openai-keys" {
+		t.Errorf("unexpected key pools: %+v", cfg.KeyPools)
+	}
+}
+
+func TestLoadConfigFromTOML_ProxyAuth(t *testing.T) {
+	tomlContent := `
+bind = "0.0.0.0"
+
+[proxy_auth]
+enabled = true
+max_skew = "1m"
+
+[[proxy_auth.clients]]
+identity = "alice"
+token = "alice-token-0123456789"
+
+[[proxy_auth.clients]]
+identity = "ci"
+hmac_secret = "ci-secret-0123456789"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.Bind != "0.0.0.0" || !cfg.ProxyAuth.Enabled || cfg.ProxyAuth.MaxSkewStr != "1m" {
+		t.Errorf("unexpected config: bind %q, proxy_auth %+v", cfg.Bind, cfg.ProxyAuth)
+	}
+	if len(cfg.ProxyAuth.Clients) != 2 || cfg.ProxyAuth.Clients[1].HMACSecret != "ci-secret-0123456789" {
+		t.Errorf("unexpected clients: %+v", cfg.ProxyAuth.Clients)
+	}
+}
+
+func TestLoadConfigFromTOML_TLS(t *testing.T) {
+	tomlContent := `
+[tls]
+cert_file = "/etc/llm-proxy/server.pem"
+key_file = "/etc/llm-proxy/server-key.pem"
+client_ca_file = "/etc/llm-proxy/clients-ca.pem"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := TLSConfig{CertFile: "/etc/llm-proxy/server.pem", KeyFile: "/etc/llm-proxy/server-key.pem", ClientCAFile: "/etc/llm-proxy/clients-ca.pem"}
+	if cfg.TLS != want {
+		t.Errorf("TLS = %+v, want %+v", cfg.TLS, want)
+	}
+}
+
+func TestLoadConfigFromTOML_ForwardProxy(t *testing.T) {
+	tomlContent := `
+[forward_proxy]
+enabled = true
+ca_dir = "/etc/llm-proxy/ca"
+hosts = { "myresource.openai.azure.com" = "azure" }
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fp := cfg.ForwardProxy
+	if !fp.Enabled || fp.CADir != "/etc/llm-proxy/ca" || fp.Hosts["myresource.openai.azure.com"] != "azure" {
+		t.Errorf("unexpected forward_proxy: %+v", fp)
+	}
+}
+
+func TestLoadConfigFromTOML_Rewrite(t *testing.T) {
+	tomlContent := `
+[[rewrite]]
+name = "opus-upgrade"
+provider = "anthropic"
+model = "claude-3-opus*"
+set_model = "claude-opus-4-6"
+remove_betas = ["context-1m-*"]
+
+[[rewrite]]
+name = "ci-cap"
+headers = { "X-Team" = "ci-*" }
+max_tokens = 4096
+user_id = "ci"
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Rewrites) != 2 {
+		t.Fatalf("len(Rewrites) = %d, want 2", len(cfg.Rewrites))
+	}
+	first, second := cfg.Rewrites[0], cfg.Rewrites[1]
+	if first.SetModel != "claude-opus-4-6" || first.Model != "claude-3-opus*" || len(first.RemoveBetas) != 1 {
+		t.Errorf("unexpected first rule: %+v", first)
+	}
+	if second.Headers["X-Team"] != "ci-*" || second.MaxTokens != 4096 || second.UserID != "ci" {
+		t.Errorf("unexpected second rule: %+v", second)
+	}
+}
+
+func TestLoadConfigFromTOML_Policy(t *testing.T) {
+	tomlContent := `
+[[policy]]
+name = "no-opus-from-ci"
+identity = "ci-*"
+deny_models = ["*opus*"]
+message = "CI jobs use Sonnet"
+
+[[policy]]
+name = "guardrails"
+deny_tools = ["bash", "computer"]
+max_input_tokens = 150000
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(cfg.Policies) != 2 {
+		t.Fatalf("len(Policies) = %d, want 2", len(cfg.Policies))
+	}
+	first, second := cfg.Policies[0], cfg.Policies[1]
+	if first.Identity != "ci-*" || len(first.DenyModels) != 1 || first.Message != "CI jobs use Sonnet" {
+		t.Errorf("unexpected first rule: %+v", first)
+	}
+	if len(second.DenyTools) != 2 || second.MaxInputTokens != 150000 {
+		t.Errorf("unexpected second rule: %+v", second)
+	}
+}
+
+func TestLoadConfigFromTOML_Budget(t *testing.T) {
+	tomlContent := `
+[budget]
+session_usd = 5.0
+machine_daily_tokens = 2000000
+key_monthly_usd = 200.0
+warn_percent = 90
+
+[[pricing]]
+model = "*claude-sonnet-4*"
+input = 3.0
+output = 15.0
+cache_write = 3.75
+cache_read = 0.3
+`
+	cfg, err := LoadConfigFromTOML([]byte(tomlContent))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b := cfg.Budget
+	if b.SessionUSD != 5 || b.MachineDailyTokens != 2000000 || b.KeyMonthlyUSD != 200 || b.WarnPercent != 90 {
+		t.Errorf("unexpected budget: %+v", b)
+	}
+	if len(cfg.Pricing) != 1 || cfg.Pricing[0].Output != 15 || cfg.Pricing[0].CacheRead != 0.3 {
+		t.Errorf("unexpected pricing: %+v", cfg.Pricing)
+	}
+}
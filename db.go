@@ -22,6 +22,16 @@ type PatternState struct {
 	PendingToolIDs   map[string]string // tool_use_id -> tool_name for result matching
 }
 
+// BudgetUsage is the usage charged to one budget subject (a session, machine
+// or API key) in one period ("" for sessions, a UTC day or month).
+type BudgetUsage struct {
+	Scope   string  `json:"scope"`
+	Subject string  `json:"subject"`
+	Period  string  `json:"period,omitempty"`
+	Tokens  int64   `json:"tokens"`
+	CostUSD float64 `json:"cost_usd"`
+}
+
 type SessionDB struct {
 	db *sql.DB
 }
@@ -53,6 +63,16 @@ func NewSessionDB(path string) (*SessionDB, error) {
 		FOREIGN KEY (session_id) REFERENCES sessions(id)
 	);
 
+	CREATE TABLE IF NOT EXISTS budget_usage (
+		scope TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		period TEXT NOT NULL,
+		tokens INTEGER NOT NULL DEFAULT 0,
+		cost_usd REAL NOT NULL DEFAULT 0,
+		updated_at TEXT NOT NULL,
+		PRIMARY KEY (scope, subject, period)
+	);
+
 	CREATE INDEX IF NOT EXISTS idx_fingerprints_session ON fingerprints(session_id);
 	CREATE INDEX IF NOT EXISTS idx_sessions_provider ON sessions(provider);
 	CREATE INDEX IF NOT EXISTS idx_sessions_client_id ON sessions(client_session_id);
@@ -73,6 +93,11 @@ func NewSessionDB(path string) (*SessionDB, error) {
 		"ALTER TABLE sessions ADD COLUMN session_tool_count INTEGER NOT NULL DEFAULT 0",
 		"ALTER TABLE sessions ADD COLUMN last_was_error INTEGER NOT NULL DEFAULT 0",
 		"ALTER TABLE sessions ADD COLUMN pending_tool_ids TEXT NOT NULL DEFAULT '{}'",
+		"ALTER TABLE sessions ADD COLUMN input_tokens INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN output_tokens INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN cache_read_tokens INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN cache_creation_tokens INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE sessions ADD COLUMN cost_usd REAL NOT NULL DEFAULT 0",
 	}
 
 	for _, migration := range migrations {
@@ -289,3 +314,98 @@ func (s *SessionDB) ClearMatchedToolID(sessionID, toolUseID string) (string, err
 
 	return toolName, nil
 }
+
+// SessionUsage is a session's total token usage and cost.
+type SessionUsage struct {
+	UsageInfo
+	CostUSD float64
+}
+
+// AddSessionUsage adds a response's usage and cost to the session's totals.
+func (s *SessionDB) AddSessionUsage(sessionID string, usage UsageInfo, costUSD float64) error {
+	_, err := s.db.Exec(`
+		UPDATE sessions
+		SET input_tokens = input_tokens + ?, output_tokens = output_tokens + ?,
+			cache_read_tokens = cache_read_tokens + ?, cache_creation_tokens = cache_creation_tokens + ?,
+			cost_usd = cost_usd + ?
+		WHERE id = ?
+	`, usage.InputTokens, usage.OutputTokens, usage.CacheReadInputTokens, usage.CacheCreationInputTokens, costUSD, sessionID)
+	return err
+}
+
+// GetSessionUsage returns the session's totals, zero if it doesn't exist.
+func (s *SessionDB) GetSessionUsage(sessionID string) (SessionUsage, error) {
+	var u SessionUsage
+	row := s.db.QueryRow(`
+		SELECT input_tokens, output_tokens, cache_read_tokens, cache_creation_tokens, cost_usd
+		FROM sessions WHERE id = ?
+	`, sessionID)
+	err := row.Scan(&u.InputTokens, &u.OutputTokens, &u.CacheReadInputTokens, &u.CacheCreationInputTokens, &u.CostUSD)
+	if err == sql.ErrNoRows {
+		return u, nil
+	}
+	return u, err
+}
+
+// AddBudgetUsage adds tokens and cost to a budget subject's usage in period.
+func (s *SessionDB) AddBudgetUsage(scope, subject, period string, tokens int64, costUSD float64) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	_, err := s.db.Exec(`
+		INSERT INTO budget_usage (scope, subject, period, tokens, cost_usd, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (scope, subject, period) DO UPDATE
+		SET tokens = tokens + excluded.tokens, cost_usd = cost_usd + excluded.cost_usd, updated_at = excluded.updated_at
+	`, scope, subject, period, tokens, costUSD, now)
+	return err
+}
+
+// GetBudgetUsage returns a budget subject's usage in period, zero if none.
+func (s *SessionDB) GetBudgetUsage(scope, subject, period string) (BudgetUsage, error) {
+	usage := BudgetUsage{Scope: scope, Subject: subject, Period: period}
+	row := s.db.QueryRow(`
+		SELECT tokens, cost_usd FROM budget_usage
+		WHERE scope = ? AND subject = ? AND period = ?
+	`, scope, subject, period)
+	err := row.Scan(&usage.Tokens, &usage.CostUSD)
+	if err == sql.ErrNoRows {
+		return usage, nil
+	}
+	return usage, err
+}
+
+// ListBudgetUsage returns all recorded budget usage, by scope, subject and
+// period.
+func (s *SessionDB) ListBudgetUsage() ([]BudgetUsage, error) {
+	rows, err := s.db.Query(`
+		SELECT scope, subject, period, tokens, cost_usd FROM budget_usage
+		ORDER BY scope, subject, period
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []BudgetUsage
+	for rows.Next() {
+		var u BudgetUsage
+		if err := rows.Scan(&u.Scope, &u.Subject, &u.Period, &u.Tokens, &u.CostUSD); err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+	return usages, rows.Err()
+}
+
+// ResetBudgetUsage deletes the usage of a budget subject in every period, or
+// of every subject in scope if subject is "". Returns the rows deleted.
+func (s *SessionDB) ResetBudgetUsage(scope, subject string) (int64, error) {
+	res, err := s.db.Exec(`
+		DELETE FROM budget_usage
+		WHERE scope = ? AND (? = '' OR subject = ?)
+	`, scope, subject, subject)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
@@ -424,3 +424,69 @@ func TestDBMigrationOnExistingDB(t *testing.T) {
 		}
 	}
 }
+
+func TestDBBudgetUsage(t *testing.T) {
+	db, err := NewSessionDB(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+
+	usage, err := db.GetBudgetUsage("machine", "alice@laptop", "2026-10-16")
+	if err != nil || usage.Tokens != 0 || usage.CostUSD != 0 {
+		t.Fatalf("GetBudgetUsage before any usage = %+v, %v; want zero", usage, err)
+	}
+
+	db.AddBudgetUsage("machine", "alice@laptop", "2026-10-16", 1000, 0.25)
+	db.AddBudgetUsage("machine", "alice@laptop", "2026-10-16", 500, 0.5)
+	db.AddBudgetUsage("machine", "alice@laptop", "2026-10-17", 10, 0.01)
+	db.AddBudgetUsage("session", "session-1", "", 42, 0)
+
+	usage, err = db.GetBudgetUsage("machine", "alice@laptop", "2026-10-16")
+	if err != nil || usage.Tokens != 1500 || usage.CostUSD != 0.75 {
+		t.Errorf("GetBudgetUsage = %+v, %v; want 1500 tokens, $0.75", usage, err)
+	}
+
+	usages, err := db.ListBudgetUsage()
+	if err != nil || len(usages) != 3 {
+		t.Fatalf("ListBudgetUsage = %+v, %v; want 3 rows", usages, err)
+	}
+	if usages[0].Period != "2026-10-16" || usages[2].Scope != "session" {
+		t.Errorf("ListBudgetUsage order = %+v", usages)
+	}
+
+	if n, err := db.ResetBudgetUsage("machine", ""); err != nil || n != 2 {
+		t.Errorf("ResetBudgetUsage(machine) = %d, %v; want 2", n, err)
+	}
+	if n, err := db.ResetBudgetUsage("session", "session-2"); err != nil || n != 0 {
+		t.Errorf("ResetBudgetUsage(other session) = %d, %v; want 0", n, err)
+	}
+	if usages, _ := db.ListBudgetUsage(); len(usages) != 1 {
+		t.Errorf("after reset: %+v, want only the session", usages)
+	}
+}
+
+func TestDBSessionUsage(t *testing.T) {
+	db, err := NewSessionDB(filepath.Join(t.TempDir(), "sessions.db"))
+	if err != nil {
+		t.Fatalf("Failed to create DB: %v", err)
+	}
+	defer db.Close()
+
+	db.CreateSession("session-1", "anthropic", "api.anthropic.com", "session-1.jsonl")
+	db.AddSessionUsage("session-1", UsageInfo{InputTokens: 100, OutputTokens: 50, CacheReadInputTokens: 1000}, 0.25)
+	db.AddSessionUsage("session-1", UsageInfo{InputTokens: 10, CacheCreationInputTokens: 200}, 0.5)
+
+	usage, err := db.GetSessionUsage("session-1")
+	if err != nil {
+		t.Fatalf("GetSessionUsage: %v", err)
+	}
+	if usage.InputTokens != 110 || usage.OutputTokens != 50 || usage.CacheReadInputTokens != 1000 ||
+		usage.CacheCreationInputTokens != 200 || usage.CostUSD != 0.75 {
+		t.Errorf("GetSessionUsage = %+v", usage)
+	}
+
+	if usage, err := db.GetSessionUsage("session-2"); err != nil || usage.CostUSD != 0 {
+		t.Errorf("GetSessionUsage(unknown) = %+v, %v; want zero", usage, err)
+	}
+}
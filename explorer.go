@@ -35,6 +35,7 @@ type SessionInfo struct {
 	TimeRange    string
 	FirstTime    time.Time
 	LastTime     time.Time
+	CostUSD      float64
 }
 
 type LogEntry struct {
@@ -45,6 +46,7 @@ type LogEntry struct {
 	Status  int
 	Meta    EntryMeta
 	Chunks  []StreamChunk
+	CostUSD float64
 	Raw     string // Original JSON line
 }
 
@@ -77,6 +79,7 @@ type ParsedTurn struct {
 	RequestID       string
 	Request         *LogEntry
 	Response        *LogEntry
+	Retries         []*LogEntry // Earlier responses to the same request (retried attempts), oldest first
 	ReqParsed       ParsedRequest
 	RespParsed      ParsedResponse
 	LastUserMessage *ParsedMessage // Just the last user message (new content for this turn)
@@ -194,6 +197,7 @@ func (e *Explorer) parseSessionMetadata(session *SessionInfo) {
 	lines := strings.Split(string(data), "\n")
 	var firstTs, lastTs time.Time
 	msgCount := 0
+	var cost float64
 
 	for _, line := range lines {
 		if line == "" {
@@ -209,6 +213,9 @@ func (e *Explorer) parseSessionMetadata(session *SessionInfo) {
 		if entry["type"] == "request" {
 			msgCount++
 		}
+		if c, ok := entry["cost_usd"].(float64); ok {
+			cost += c
+		}
 
 		// Extract timestamp from _meta
 		if meta, ok := entry["_meta"].(map[string]interface{}); ok {
@@ -226,6 +233,7 @@ func (e *Explorer) parseSessionMetadata(session *SessionInfo) {
 	}
 
 	session.MessageCount = msgCount
+	session.CostUSD = cost
 	session.FirstTime = firstTs
 	session.LastTime = lastTs
 
@@ -265,12 +273,18 @@ func (e *Explorer) handleSession(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	var totalCost float64
+	for _, entry := range entries {
+		totalCost += entry.CostUSD
+	}
+
 	// Group and parse into conversation turns
 	turns := e.groupAndParseTurns(entries, host)
 
 	e.templates.ExecuteTemplate(w, "session.html", map[string]interface{}{
 		"SessionID": sessionID,
 		"Host":      host,
+		"TotalCost": totalCost,
 		"Turns":     turns,
 	})
 }
@@ -323,6 +337,9 @@ func (e *Explorer) parseSessionFile(path string) ([]LogEntry, error) {
 		if s, ok := raw["status"].(float64); ok {
 			entry.Status = int(s)
 		}
+		if c, ok := raw["cost_usd"].(float64); ok {
+			entry.CostUSD = c
+		}
 
 		// Parse streaming chunks
 		if chunks, ok := raw["chunks"].([]interface{}); ok {
@@ -446,6 +463,11 @@ func (e *Explorer) groupAndParseTurns(entries []LogEntry, host string) []ParsedT
 			}
 
 			if matchedTurn != nil {
+				// A request that was retried has one response per attempt;
+				// the last one is what the client received
+				if matchedTurn.Response != nil {
+					matchedTurn.Retries = append(matchedTurn.Retries, matchedTurn.Response)
+				}
 				matchedTurn.Response = entry
 				// Use streaming parser if we have chunks, otherwise parse body
 				if len(entry.Chunks) > 0 {
@@ -459,6 +481,7 @@ func (e *Explorer) groupAndParseTurns(entries []LogEntry, host string) []ParsedT
 					matchesSeq := turns[j].RequestID == "" && turns[j].Seq == entry.Seq
 					if matchesRequestID || matchesSeq {
 						turns[j].Response = entry
+						turns[j].Retries = matchedTurn.Retries
 						turns[j].RespParsed = matchedTurn.RespParsed
 						break
 					}
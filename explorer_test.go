@@ -84,6 +84,39 @@ func TestSessionInfoIncludesMessageCount(t *testing.T) {
 	}
 }
 
+func TestSessionShowsCost(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	sessionDir := filepath.Join(tmpDir, "api.anthropic.com", "2026-01-14")
+	os.MkdirAll(sessionDir, 0755)
+
+	content := `{"type":"session_start","_meta":{"ts":"2026-01-14T10:00:00Z","host":"api.anthropic.com","session":"cost123"}}
+{"type":"request","seq":1,"body":"{\"messages\":[{\"role\":\"user\",\"content\":\"Hello\"}]}","_meta":{"ts":"2026-01-14T10:00:01Z"}}
+{"type":"response","seq":1,"status":200,"cost_usd":0.0125,"body":"{\"content\":[{\"type\":\"text\",\"text\":\"Hi\"}],\"usage\":{\"input_tokens\":10,\"output_tokens\":5}}","_meta":{"ts":"2026-01-14T10:00:02Z"}}
+{"type":"request","seq":2,"body":"{\"messages\":[{\"role\":\"user\",\"content\":\"Again\"}]}","_meta":{"ts":"2026-01-14T10:01:01Z"}}
+{"type":"response","seq":2,"status":200,"cost_usd":0.25,"body":"{\"content\":[{\"type\":\"text\",\"text\":\"Hi\"}],\"usage\":{\"input_tokens\":10,\"output_tokens\":5}}","_meta":{"ts":"2026-01-14T10:01:02Z"}}
+`
+	os.WriteFile(filepath.Join(sessionDir, "cost123.jsonl"), []byte(content), 0644)
+
+	explorer := NewExplorer(tmpDir)
+
+	sessions := explorer.listSessions()
+	if len(sessions) != 1 || sessions[0].CostUSD != 0.2625 {
+		t.Fatalf("Expected session cost 0.2625, got %+v", sessions)
+	}
+
+	req := httptest.NewRequest("GET", "/session/cost123", nil)
+	w := httptest.NewRecorder()
+	explorer.ServeHTTP(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{"$0.0125", "$0.2500", "$0.2625"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected session page to show %s", want)
+		}
+	}
+}
+
 func TestSessionDetailShowsEntries(t *testing.T) {
 	tmpDir := t.TempDir()
 
@@ -435,3 +468,28 @@ func TestHomeFiltersByHost(t *testing.T) {
 		t.Error("Expected filtered results to exclude openai session")
 	}
 }
+
+func TestGroupAndParseTurns_Retries(t *testing.T) {
+	explorer := NewExplorer(t.TempDir())
+
+	meta := EntryMeta{RequestID: "req-1"}
+	entries := []LogEntry{
+		{Type: "request", Seq: 1, Meta: meta, Body: `{"model":"claude-3","messages":[{"role":"user","content":"Hello"}]}`},
+		{Type: "response", Seq: 1, Meta: meta, Status: 529, Body: `{"type":"error","error":{"type":"overloaded_error"}}`},
+		{Type: "response", Seq: 1, Meta: meta, Status: 429, Body: `{"type":"error","error":{"type":"rate_limit_error"}}`},
+		{Type: "response", Seq: 1, Meta: meta, Status: 200, Body: `{"content":[{"type":"text","text":"Hi there!"}]}`},
+	}
+
+	turns := explorer.groupAndParseTurns(entries, "api.anthropic.com")
+
+	if len(turns) != 1 {
+		t.Fatalf("Expected 1 turn, got %d", len(turns))
+	}
+	turn := turns[0]
+	if turn.Response == nil || turn.Response.Status != 200 {
+		t.Errorf("Expected the final response to be the 200, got %+v", turn.Response)
+	}
+	if len(turn.Retries) != 2 || turn.Retries[0].Status != 529 || turn.Retries[1].Status != 429 {
+		t.Errorf("Expected retried attempts 529, 429; got %+v", turn.Retries)
+	}
+}
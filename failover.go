@@ -0,0 +1,406 @@
+// failover.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Anthropic → Bedrock failover.
+//
+// When enabled, a direct Anthropic POST /v1/messages that fails with one of
+// the configured statuses is retried on Bedrock before anything is written to
+// the client. The request is translated to InvokeModel (model moved into the
+// URL through the model table, anthropic_version added) and the Bedrock
+// eventstream is re-encoded as Anthropic SSE, so the client sees an ordinary
+// Anthropic response. If Bedrock fails too, the original Anthropic error is
+// returned.
+
+// anthropicBedrockVersion is the anthropic_version InvokeModel requires.
+const anthropicBedrockVersion = "bedrock-2023-05-31"
+
+// bedrockInvocationMetrics is the Bedrock-only field added to message_stop
+// events; it is stripped so failed-over streams match Anthropic's.
+const bedrockInvocationMetrics = "amazon-bedrock-invocationMetrics"
+
+// defaultFailoverStatuses are the Anthropic statuses that trigger failover
+// when none are configured: server errors and 529 overloaded.
+var defaultFailoverStatuses = []int{500, 502, 503, 504, 529}
+
+// anthropicFailover holds the failover policy.
+type anthropicFailover struct {
+	statuses map[int]bool
+	models   map[string]string // Anthropic model name or glob → Bedrock model ID
+	patterns []string          // model table keys, longest first
+	region   *bedrockRegion
+}
+
+// newAnthropicFailover validates cfg against the configured Bedrock state.
+// Returns nil if failover is not enabled.
+func newAnthropicFailover(cfg AnthropicFailoverConfig, bedrock *bedrockState) (*anthropicFailover, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if bedrock == nil {
+		return nil, fmt.Errorf("anthropic_failover: requires bedrock_region")
+	}
+	if len(cfg.Models) == 0 {
+		return nil, fmt.Errorf("anthropic_failover: models must map at least one Anthropic model to a Bedrock model ID")
+	}
+
+	f := &anthropicFailover{
+		statuses: make(map[int]bool),
+		models:   cfg.Models,
+		region:   bedrock.regions[bedrock.region],
+	}
+
+	statuses := cfg.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultFailoverStatuses
+	}
+	for _, status := range statuses {
+		if status < 400 || status > 599 {
+			return nil, fmt.Errorf("anthropic_failover: status %d is not an error status", status)
+		}
+		f.statuses[status] = true
+	}
+
+	if cfg.Region != "" {
+		region, ok := bedrock.regions[cfg.Region]
+		if !ok {
+			return nil, fmt.Errorf("anthropic_failover: Bedrock region %q not configured", cfg.Region)
+		}
+		f.region = region
+	}
+
+	for pattern, modelID := range cfg.Models {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("anthropic_failover: invalid model pattern %q", pattern)
+		}
+		if !validModelID.MatchString(modelID) && !validModelARN.MatchString(modelID) {
+			return nil, fmt.Errorf("anthropic_failover: invalid Bedrock model ID %q for %q", modelID, pattern)
+		}
+		f.patterns = append(f.patterns, pattern)
+	}
+	sort.Slice(f.patterns, func(i, j int) bool {
+		if len(f.patterns[i]) != len(f.patterns[j]) {
+			return len(f.patterns[i]) > len(f.patterns[j])
+		}
+		return f.patterns[i] < f.patterns[j]
+	})
+
+	return f, nil
+}
+
+// modelFor maps an Anthropic model name to a Bedrock model ID: an exact
+// table entry, then the longest matching glob, so "claude-sonnet-4-5*" wins
+// over "claude-*". Returns "" if the model is not mapped.
+func (f *anthropicFailover) modelFor(model string) string {
+	if modelID, ok := f.models[model]; ok {
+		return modelID
+	}
+	for _, pattern := range f.patterns {
+		if ok, _ := path.Match(pattern, model); ok {
+			return f.models[pattern]
+		}
+	}
+	return ""
+}
+
+// bedrockModel returns the Bedrock model a request would fail over to, or ""
+// if it isn't eligible. Only POST /v1/messages calls to the anthropic
+// provider's default upstream, for a mapped model, fail over.
+func (f *anthropicFailover) bedrockModel(spec *providerSpec, upstream, method, path string, body []byte) string {
+	if f == nil || spec == nil || spec.Name != "anthropic" || method != http.MethodPost || path != "/v1/messages" {
+		return ""
+	}
+	if upstream != spec.Upstream {
+		return ""
+	}
+	var req struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.Model == "" {
+		return ""
+	}
+	return f.modelFor(req.Model)
+}
+
+// triggers reports whether an upstream result should fail over. Connection
+// errors count as 502 unless the client went away.
+func (f *anthropicFailover) triggers(r *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		return r.Context().Err() == nil && f.statuses[http.StatusBadGateway]
+	}
+	return f.statuses[resp.StatusCode]
+}
+
+// translateAnthropicToBedrock converts a Messages API body to an InvokeModel
+// body: the model moves to the URL, stream picks the operation, and
+// anthropic_version is required. Reports whether the client asked to stream.
+func translateAnthropicToBedrock(body []byte) ([]byte, bool, error) {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, false, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	var stream bool
+	if raw, ok := req["stream"]; ok {
+		json.Unmarshal(raw, &stream)
+	}
+	delete(req, "model")
+	delete(req, "stream")
+	req["anthropic_version"] = json.RawMessage(`"` + anthropicBedrockVersion + `"`)
+
+	out, err := json.Marshal(req)
+	return out, stream, err
+}
+
+// anthropicErrorType maps a Bedrock exception type to the Anthropic error
+// type clients retry on.
+func anthropicErrorType(exceptionType string) string {
+	switch exceptionType {
+	case "throttlingException":
+		return "rate_limit_error"
+	case "serviceUnavailableException", "modelNotReadyException":
+		return "overloaded_error"
+	case "validationException":
+		return "invalid_request_error"
+	default:
+		return "api_error"
+	}
+}
+
+// bedrockSSEWriter re-encodes a Bedrock InvokeModel eventstream as Anthropic
+// SSE. Like bedrockStreamDecoder, Write never fails; each event is written as
+// soon as its frame is complete, and mid-stream exceptions become Anthropic
+// error events.
+type bedrockSSEWriter struct {
+	decoder    *bedrockStreamDecoder
+	w          io.Writer
+	events     int // decoder chunks written
+	exceptions int // decoder exceptions written
+}
+
+func (s *bedrockSSEWriter) Write(p []byte) (int, error) {
+	s.decoder.Write(p)
+	for ; s.events < len(s.decoder.chunks); s.events++ {
+		s.writeEvent(strings.TrimPrefix(s.decoder.chunks[s.events].Raw, "data: "))
+	}
+	for ; s.exceptions < len(s.decoder.exceptions); s.exceptions++ {
+		ex := s.decoder.exceptions[s.exceptions]
+		data, _ := json.Marshal(map[string]interface{}{
+			"type": "error",
+			"error": map[string]interface{}{
+				"type":    anthropicErrorType(ex.Type),
+				"message": ex.Message,
+			},
+		})
+		s.writeEvent(string(data))
+	}
+	return len(p), nil
+}
+
+// writeEvent writes one event JSON as an SSE event named by its type.
+func (s *bedrockSSEWriter) writeEvent(data string) {
+	var event map[string]interface{}
+	if json.Unmarshal([]byte(data), &event) != nil {
+		return
+	}
+	if _, ok := event[bedrockInvocationMetrics]; ok {
+		delete(event, bedrockInvocationMetrics)
+		if stripped, err := json.Marshal(event); err == nil {
+			data = string(stripped)
+		}
+	}
+	eventType, _ := event["type"].(string)
+	fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", eventType, data)
+}
+
+// sendFailover sends a Messages API request to Bedrock. On success the caller
+// must close the response body and then call release. Non-200 responses are
+// returned as errors.
+func (p *Proxy) sendFailover(r *http.Request, reqBody []byte, modelID, requestID string) (resp *http.Response, stream bool, release func(), err error) {
+	body, stream, err := translateAnthropicToBedrock(reqBody)
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	operation, accept := "invoke", "application/json"
+	if stream {
+		operation, accept = "invoke-with-response-stream", "application/vnd.amazon.eventstream"
+	}
+
+	cred, err := p.bedrock.selectCredential(r, nil, modelID)
+	if err != nil {
+		return nil, false, nil, err
+	}
+	p.recordBedrockCredential(requestID, cred)
+	route := bedrockRoute{
+		region:     p.failover.region,
+		credential: cred,
+		modelID:    modelID,
+		operation:  operation,
+	}
+
+	release, err = p.bedrock.limits.forModel(modelID).acquire(r.Context())
+	if err != nil {
+		return nil, false, nil, err
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}, "Accept": {accept}}
+	req, err := p.bedrock.signedRequest(r.Context(), route, body, header)
+	if err != nil {
+		release()
+		return nil, false, nil, err
+	}
+
+	resp, err = p.bedrock.client.Do(req)
+	if err != nil {
+		release()
+		return nil, false, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errBody, _ := io.ReadAll(io.LimitReader(resp.Body, bedrockMaxErrorBody))
+		resp.Body.Close()
+		release()
+		return nil, false, nil, fmt.Errorf("Bedrock returned %d: %s", resp.StatusCode, strings.TrimSpace(string(errBody)))
+	}
+	return resp, stream, release, nil
+}
+
+// serveAnthropicFailover handles a direct Anthropic request that failed with a
+// failover status (resp) or connection error (upstreamErr). It retries on
+// Bedrock and writes the result as an Anthropic response, or writes the
+// original failure if Bedrock fails too. The turn's session, seq and pattern
+// state come from ServeHTTP; shouldLog is false for unlogged requests.
+func (p *Proxy) serveAnthropicFailover(w http.ResponseWriter, r *http.Request, reqBody []byte, resp *http.Response, upstreamErr error, modelID string, startTime time.Time, upstream, sessionID string, seq int, requestID string, patternState *PatternState, shouldLog bool) {
+	provider := "anthropic"
+
+	// Hold the original failure so it can be returned if Bedrock fails too
+	status := http.StatusBadGateway
+	header := http.Header{"Content-Type": {"text/plain; charset=utf-8"}}
+	var body []byte
+	if resp != nil {
+		status, header = resp.StatusCode, resp.Header
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, bedrockMaxErrorBody))
+		resp.Body.Close()
+	} else {
+		body = []byte("upstream request failed: " + upstreamErr.Error() + "\n")
+	}
+
+	record := FailoverRecord{
+		From:   upstream,
+		To:     bedrockHost(p.failover.region.region),
+		Status: status,
+		Model:  modelID,
+	}
+
+	bedrockResp, stream, release, err := p.sendFailover(r, reqBody, modelID, requestID)
+	if err != nil {
+		log.Printf("WARNING: Anthropic failover to Bedrock failed: %v (model=%s session=%s)", err, modelID, sessionID)
+		record.Error = err.Error()
+
+		if shouldLog {
+			p.logger.LogFailover(sessionID, provider, seq, record, requestID)
+			timing := ResponseTiming{
+				TTFBMs:  time.Since(startTime).Milliseconds(),
+				TotalMs: time.Since(startTime).Milliseconds(),
+			}
+			p.logger.LogResponse(sessionID, provider, seq, status, header, body, nil, timing, requestID)
+
+			if p.eventEmitter != nil && patternState != nil {
+				parsed := ParseResponseBody(string(body), upstream)
+				p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, status, string(body), requestID)
+			}
+		}
+
+		copyHeaders(w.Header(), header)
+		w.WriteHeader(status)
+		w.Write(body)
+		return
+	}
+	defer release()
+	defer bedrockResp.Body.Close()
+
+	log.Printf("Anthropic failover: %s returned %d, served by Bedrock (model=%s session=%s)", upstream, status, modelID, sessionID)
+	record.Succeeded = true
+
+	if shouldLog {
+		// Label the response with the transport and model actually used
+		if mw, ok := p.logger.(*MultiWriter); ok {
+			mw.SetFailoverContext(requestID, "bedrock", modelID, upstream)
+			defer mw.ClearTransportContext(requestID)
+		}
+		p.logger.LogFailover(sessionID, provider, seq, record, requestID)
+	}
+
+	if stream {
+		// Re-encode frames into a pipe so streamResponse forwards, logs and
+		// emits events exactly as for a direct Anthropic stream
+		pr, pw := io.Pipe()
+		defer pr.Close()
+		go func() {
+			decoder := newBedrockStreamDecoder(startTime)
+			_, copyErr := io.Copy(&bedrockSSEWriter{decoder: decoder, w: pw}, bedrockResp.Body)
+			if _, decodeErr := decoder.Finish(); decodeErr != nil {
+				log.Printf("WARNING: Bedrock decode error: %v (model=%s session=%s)", decodeErr, modelID, sessionID)
+				atomic.AddInt64(&p.bedrock.decodeErrors, 1)
+			}
+			pw.CloseWithError(copyErr)
+		}()
+
+		sseResp := &http.Response{
+			StatusCode: http.StatusOK,
+			Header: http.Header{
+				"Content-Type":  {"text/event-stream; charset=utf-8"},
+				"Cache-Control": {"no-cache"},
+			},
+			Body: pr,
+		}
+		var loggerForStream ProxyLogger
+		var smForStream *SessionManager
+		if shouldLog {
+			loggerForStream = p.logger
+			smForStream = p.sessionManager
+		}
+		chunks, _ := streamResponse(w, sseResp, loggerForStream, smForStream, sessionID, provider, dialectAnthropic, seq, startTime, reqBody, requestID, p.eventEmitter, p.machineID, patternState)
+		p.recordResponseUsage(requestID, sessionID, http.StatusOK, nil, chunks)
+		return
+	}
+
+	// InvokeModel returns the Messages API response body unchanged
+	respBody, err := io.ReadAll(io.LimitReader(bedrockResp.Body, bedrockMaxRequestBody))
+	if err != nil {
+		http.Error(w, "failed to read response body", http.StatusBadGateway)
+		return
+	}
+	respHeader := http.Header{"Content-Type": {"application/json"}}
+
+	if shouldLog {
+		totalTime := time.Since(startTime)
+		timing := ResponseTiming{
+			TTFBMs:  totalTime.Milliseconds(),
+			TotalMs: totalTime.Milliseconds(),
+		}
+		p.logger.LogResponse(sessionID, provider, seq, http.StatusOK, respHeader, respBody, nil, timing, requestID)
+
+		if p.eventEmitter != nil && patternState != nil {
+			parsed := ParseResponseBody(string(respBody), upstream)
+			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, http.StatusOK, string(respBody), requestID)
+		}
+		p.recordResponseUsage(requestID, sessionID, http.StatusOK, respBody, nil)
+	}
+
+	copyHeaders(w.Header(), respHeader)
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
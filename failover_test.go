@@ -0,0 +1,375 @@
+// failover_test.go
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws/protocol/eventstream"
+)
+
+const testFailoverModel = "us.anthropic.claude-sonnet-4-5-20250929-v1:0"
+
+// encodeInvokeStream encodes Anthropic events as InvokeModel eventstream
+// chunk frames, followed by an exception frame if exceptionType is set.
+func encodeInvokeStream(t *testing.T, events []string, exceptionType string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	enc := eventstream.NewEncoder()
+	for _, event := range events {
+		payload, _ := json.Marshal(map[string]string{"bytes": base64.StdEncoding.EncodeToString([]byte(event))})
+		msg := eventstream.Message{
+			Headers: eventstream.Headers{
+				{Name: ":message-type", Value: eventstream.StringValue("event")},
+				{Name: ":event-type", Value: eventstream.StringValue("chunk")},
+			},
+			Payload: payload,
+		}
+		if err := enc.Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	if exceptionType != "" {
+		msg := eventstream.Message{
+			Headers: eventstream.Headers{
+				{Name: ":message-type", Value: eventstream.StringValue("exception")},
+				{Name: ":exception-type", Value: eventstream.StringValue(exceptionType)},
+			},
+			Payload: []byte(`{"message":"Too many tokens, please wait before trying again."}`),
+		}
+		if err := enc.Encode(&buf, msg); err != nil {
+			t.Fatalf("Encode: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+var testInvokeStreamEvents = []string{
+	`{"type":"message_start","message":{"id":"msg_bdrk_1","type":"message","role":"assistant","model":"claude-sonnet-4-5-20250929","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+	`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+	`{"type":"content_block_stop","index":0}`,
+	`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`,
+	`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":12,"outputTokenCount":5}}`,
+}
+
+func TestNewAnthropicFailover(t *testing.T) {
+	bedrock := &bedrockState{
+		region:  "us-west-2",
+		regions: map[string]*bedrockRegion{"us-west-2": {region: "us-west-2"}},
+	}
+	valid := AnthropicFailoverConfig{
+		Enabled: true,
+		Models:  map[string]string{"claude-sonnet-4-5*": testFailoverModel},
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *AnthropicFailoverConfig)
+		bedrock *bedrockState
+		wantErr bool
+	}{
+		{"valid", func(c *AnthropicFailoverConfig) {}, bedrock, false},
+		{"bedrock not configured", func(c *AnthropicFailoverConfig) {}, nil, true},
+		{"no models", func(c *AnthropicFailoverConfig) { c.Models = nil }, bedrock, true},
+		{"invalid model ID", func(c *AnthropicFailoverConfig) { c.Models = map[string]string{"claude": "a/b"} }, bedrock, true},
+		{"invalid pattern", func(c *AnthropicFailoverConfig) { c.Models = map[string]string{"claude-[": testFailoverModel} }, bedrock, true},
+		{"success status", func(c *AnthropicFailoverConfig) { c.Statuses = []int{200} }, bedrock, true},
+		{"unknown region", func(c *AnthropicFailoverConfig) { c.Region = "eu-west-1" }, bedrock, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			_, err := newAnthropicFailover(cfg, tt.bedrock)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newAnthropicFailover() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if f, err := newAnthropicFailover(AnthropicFailoverConfig{}, bedrock); f != nil || err != nil {
+		t.Errorf("disabled failover = (%v, %v), want (nil, nil)", f, err)
+	}
+}
+
+func TestAnthropicFailover_ModelFor(t *testing.T) {
+	f := &anthropicFailover{
+		models: map[string]string{
+			"claude-sonnet-4-5":  "exact",
+			"claude-sonnet-4-5*": "dated",
+			"claude-*":           "fallback",
+		},
+	}
+	for pattern := range f.models {
+		f.patterns = append(f.patterns, pattern)
+	}
+	sort.Slice(f.patterns, func(i, j int) bool { return len(f.patterns[i]) > len(f.patterns[j]) })
+
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"claude-sonnet-4-5", "exact"},
+		{"claude-sonnet-4-5-20250929", "dated"}, // longest pattern wins
+		{"claude-opus-4-1", "fallback"},
+		{"gpt-4o", ""},
+	}
+	for _, tt := range tests {
+		if got := f.modelFor(tt.model); got != tt.want {
+			t.Errorf("modelFor(%q) = %q, want %q", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateAnthropicToBedrock(t *testing.T) {
+	body, stream, err := translateAnthropicToBedrock([]byte(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("translateAnthropicToBedrock: %v", err)
+	}
+	if !stream {
+		t.Error("stream = false, want true")
+	}
+
+	var got map[string]interface{}
+	json.Unmarshal(body, &got)
+	if _, ok := got["model"]; ok {
+		t.Error("model should move to the URL")
+	}
+	if _, ok := got["stream"]; ok {
+		t.Error("stream should be dropped")
+	}
+	if got["anthropic_version"] != anthropicBedrockVersion || got["max_tokens"] != float64(100) {
+		t.Errorf("body = %s", body)
+	}
+
+	if _, _, err := translateAnthropicToBedrock([]byte(`not json`)); err == nil {
+		t.Error("expected error for invalid JSON")
+	}
+}
+
+func TestBedrockSSEWriter_ReencodesEvents(t *testing.T) {
+	var out bytes.Buffer
+	sse := &bedrockSSEWriter{decoder: newBedrockStreamDecoder(time.Now()), w: &out}
+
+	// Split writes mid-frame: events are written once their frame completes
+	stream := encodeInvokeStream(t, testInvokeStreamEvents[:2], "throttlingException")
+	sse.Write(stream[:10])
+	if out.Len() != 0 {
+		t.Fatalf("wrote %q before a frame completed", out.String())
+	}
+	sse.Write(stream[10:])
+
+	want := "event: message_start\ndata: " + testInvokeStreamEvents[0] + "\n\n" +
+		"event: content_block_start\ndata: " + testInvokeStreamEvents[1] + "\n\n" +
+		"event: error\ndata: {\"error\":{\"message\":\"Too many tokens, please wait before trying again.\",\"type\":\"rate_limit_error\"},\"type\":\"error\"}\n\n"
+	if out.String() != want {
+		t.Errorf("SSE =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+// newTestFailoverProxy returns a proxy whose anthropic provider points at
+// anthropicHandler and whose Bedrock client points at bedrockHandler, with
+// failover enabled for claude-sonnet-4-5*.
+func newTestFailoverProxy(t *testing.T, anthropicHandler, bedrockHandler http.HandlerFunc) (*Proxy, *mockFileLogger, *mockLokiExporter) {
+	t.Helper()
+
+	anthropic := httptest.NewServer(anthropicHandler)
+	t.Cleanup(anthropic.Close)
+	providers := newTestProviders(t, ProviderConfig{
+		Name:              "anthropic",
+		Upstream:          strings.TrimPrefix(anthropic.URL, "http://"),
+		Dialect:           dialectAnthropic,
+		ConversationPaths: []string{"/v1/messages"},
+	})
+
+	proxy, bedrock := newTestBedrockProxy(t, bedrockHandler)
+	proxy.providers = providers
+	t.Cleanup(bedrock.Close)
+	proxy.bedrock.client = &http.Client{
+		Transport: &rewriteTransport{target: strings.TrimPrefix(bedrock.URL, "http://"), inner: http.DefaultTransport},
+	}
+
+	failover, err := newAnthropicFailover(AnthropicFailoverConfig{
+		Enabled: true,
+		Models:  map[string]string{"claude-sonnet-4-5*": testFailoverModel},
+	}, proxy.bedrock)
+	if err != nil {
+		t.Fatalf("newAnthropicFailover: %v", err)
+	}
+	proxy.failover = failover
+
+	fileLogger := newMockFileLogger()
+	loki := newMockLokiExporter(nil)
+	proxy.logger = NewMultiWriter(fileLogger, loki)
+	return proxy, fileLogger, loki
+}
+
+func overloaded(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(529)
+	w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+}
+
+func TestProxy_AnthropicFailoverStreaming(t *testing.T) {
+	var bedrockPath string
+	var bedrockBody map[string]interface{}
+	proxy, fileLogger, loki := newTestFailoverProxy(t, overloaded, func(w http.ResponseWriter, r *http.Request) {
+		bedrockPath = r.URL.EscapedPath()
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &bedrockBody)
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.Write(encodeInvokeStream(t, testInvokeStreamEvents, ""))
+	})
+
+	req := httptest.NewRequest("POST", "/anthropic/v1/messages?beta=true", strings.NewReader(`{"model":"claude-sonnet-4-5-20250929","stream":true,"max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	req.Header.Set("x-api-key", "sk-ant-test")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		t.Fatalf("status = %d, Content-Type = %q, body = %s", w.Code, w.Header().Get("Content-Type"), w.Body.String())
+	}
+	if bedrockPath != "/model/us.anthropic.claude-sonnet-4-5-20250929-v1%3A0/invoke-with-response-stream" {
+		t.Errorf("Bedrock path = %q", bedrockPath)
+	}
+	if bedrockBody["anthropic_version"] != anthropicBedrockVersion || bedrockBody["model"] != nil {
+		t.Errorf("Bedrock body = %v", bedrockBody)
+	}
+
+	body := w.Body.String()
+	if !strings.Contains(body, "event: content_block_delta\ndata: "+testInvokeStreamEvents[2]+"\n\n") {
+		t.Errorf("response is not Anthropic SSE:\n%s", body)
+	}
+	if strings.Contains(body, "amazon-bedrock") {
+		t.Errorf("response leaks Bedrock fields:\n%s", body)
+	}
+
+	if len(fileLogger.failoverCalls) != 1 {
+		t.Fatalf("failoverCalls = %+v", fileLogger.failoverCalls)
+	}
+	record := fileLogger.failoverCalls[0]
+	if !record.Succeeded || record.Status != 529 || record.Model != testFailoverModel || record.To != "bedrock-runtime.us-west-2.amazonaws.com" {
+		t.Errorf("failover record = %+v", record)
+	}
+	if len(fileLogger.responseCalls) != 1 || fileLogger.responseCalls[0].status != http.StatusOK {
+		t.Fatalf("responseCalls = %+v", fileLogger.responseCalls)
+	}
+	parsed := ParseStreamingResponse(fileLogger.responseCalls[0].chunks)
+	if parsed.StopReason != "end_turn" || parsed.Usage.OutputTokens != 5 {
+		t.Errorf("logged stream parsed as %+v", parsed)
+	}
+
+	// Loki: the failover and response entries carry the Bedrock transport
+	var sawFailover bool
+	for _, call := range loki.pushCalls {
+		meta, _ := call.entry["_meta"].(map[string]interface{})
+		switch call.entry["type"] {
+		case "failover":
+			sawFailover = true
+			fallthrough
+		case "response":
+			if meta["transport"] != "bedrock" || meta["model_override"] != testFailoverModel || meta["failover_from"] == nil {
+				t.Errorf("%s entry _meta = %v", call.entry["type"], meta)
+			}
+		}
+	}
+	if !sawFailover {
+		t.Error("no failover entry pushed to Loki")
+	}
+}
+
+func TestProxy_AnthropicFailoverNonStreaming(t *testing.T) {
+	const message = `{"id":"msg_bdrk_1","type":"message","role":"assistant","content":[{"type":"text","text":"Hello"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":5}}`
+	var bedrockPath string
+	proxy, _, _ := newTestFailoverProxy(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}, func(w http.ResponseWriter, r *http.Request) {
+		bedrockPath = r.URL.EscapedPath()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(message))
+	})
+
+	req := httptest.NewRequest("POST", "/anthropic/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || w.Body.String() != message {
+		t.Errorf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if !strings.HasSuffix(bedrockPath, "/invoke") {
+		t.Errorf("Bedrock path = %q, want /invoke", bedrockPath)
+	}
+}
+
+func TestProxy_AnthropicFailoverSkipped(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		model     string
+		path      string
+		wantCalls bool
+	}{
+		{"status not configured", http.StatusBadRequest, "claude-sonnet-4-5", "/anthropic/v1/messages", false},
+		{"model not mapped", 529, "claude-opus-4-1", "/anthropic/v1/messages", false},
+		{"not messages", 529, "claude-sonnet-4-5", "/anthropic/v1/messages/count_tokens", false},
+		{"failover", 529, "claude-sonnet-4-5", "/anthropic/v1/messages", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bedrockCalled bool
+			proxy, _, _ := newTestFailoverProxy(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}, func(w http.ResponseWriter, r *http.Request) {
+				bedrockCalled = true
+				w.Write([]byte(`{"type":"message","content":[]}`))
+			})
+
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(`{"model":"`+tt.model+`","max_tokens":10,"messages":[]}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if bedrockCalled != tt.wantCalls {
+				t.Errorf("Bedrock called = %v, want %v", bedrockCalled, tt.wantCalls)
+			}
+			if !tt.wantCalls && w.Code != tt.status {
+				t.Errorf("status = %d, want upstream status %d", w.Code, tt.status)
+			}
+		})
+	}
+}
+
+func TestProxy_AnthropicFailoverBedrockFailsReturnsOriginal(t *testing.T) {
+	proxy, fileLogger, _ := newTestFailoverProxy(t, overloaded, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-amzn-ErrorType", "ThrottlingException")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"message":"Too many requests"}`))
+	})
+
+	req := httptest.NewRequest("POST", "/anthropic/v1/messages", strings.NewReader(`{"model":"claude-sonnet-4-5","stream":true,"max_tokens":10,"messages":[]}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != 529 || !strings.Contains(w.Body.String(), "overloaded_error") {
+		t.Errorf("status = %d, body = %s; want the original Anthropic error", w.Code, w.Body.String())
+	}
+	if len(fileLogger.failoverCalls) != 1 {
+		t.Fatalf("failoverCalls = %+v", fileLogger.failoverCalls)
+	}
+	if record := fileLogger.failoverCalls[0]; record.Succeeded || !strings.Contains(record.Error, "429") {
+		t.Errorf("failover record = %+v", record)
+	}
+	if len(fileLogger.responseCalls) != 1 || fileLogger.responseCalls[0].status != 529 {
+		t.Errorf("responseCalls = %+v", fileLogger.responseCalls)
+	}
+}
@@ -87,14 +87,21 @@ func canonicalizeSlice(s []interface{}) []interface{} {
 	return result
 }
 
-// ExtractMessages extracts the messages array from a request body
-func ExtractMessages(body []byte, provider string) ([]map[string]interface{}, error) {
+// ExtractMessages extracts the messages array from a request body in the given dialect
+func ExtractMessages(body []byte, dialect string) ([]map[string]interface{}, error) {
 	var request map[string]interface{}
 	if err := json.Unmarshal(body, &request); err != nil {
 		return nil, err
 	}
 
 	messagesKey := "messages" // Same for both Anthropic and OpenAI
+	if dialect == dialectGemini {
+		request = unwrapGeminiPayload(request, "request")
+		messagesKey = "contents"
+	} else if dialect == dialectOpenAIResponses || (dialect == dialectOpenAIChat && isResponsesAPIRequest(request)) {
+		// Responses API (Codex): conversation items live under "input"
+		messagesKey = "input"
+	}
 
 	messagesRaw, ok := request[messagesKey]
 	if !ok {
@@ -119,8 +126,8 @@ func ExtractMessages(body []byte, provider string) ([]map[string]interface{}, er
 }
 
 // ExtractPriorMessages extracts all but the last message (for fingerprinting conversation state)
-func ExtractPriorMessages(body []byte, provider string) ([]map[string]interface{}, error) {
-	messages, err := ExtractMessages(body, provider)
+func ExtractPriorMessages(body []byte, dialect string) ([]map[string]interface{}, error) {
+	messages, err := ExtractMessages(body, dialect)
 	if err != nil {
 		return nil, err
 	}
@@ -133,8 +140,8 @@ func ExtractPriorMessages(body []byte, provider string) ([]map[string]interface{
 }
 
 // ComputePriorFingerprint computes fingerprint of conversation state before current message
-func ComputePriorFingerprint(body []byte, provider string) (string, error) {
-	prior, err := ExtractPriorMessages(body, provider)
+func ComputePriorFingerprint(body []byte, dialect string) (string, error) {
+	prior, err := ExtractPriorMessages(body, dialect)
 	if err != nil {
 		return "", err
 	}
@@ -153,22 +160,31 @@ func ComputePriorFingerprint(body []byte, provider string) (string, error) {
 
 // ExtractClientSessionID extracts a client-provided session ID from the request.
 // path is the URL path, used for OpenAI Threads API thread ID extraction.
-// For Anthropic, this is found in metadata.user_id with format:
+// For the Anthropic dialect, this is found in metadata.user_id with format:
 //
 //	user_<hash>_account_<uuid>_session_<session-uuid>
 //
-// For OpenAI, priority order:
+// For the OpenAI dialects (OpenAI, Azure OpenAI), priority order:
 //  1. URL path thread ID (Threads API)
 //  2. conversation (Responses API)
 //  3. previous_response_id (Responses API chaining)
 //  4. metadata.session_id
 //  5. X-Session-ID header
-//  6. X-Client-Request-Id header
-//  7. user field
+//  6. session_id header (Codex CLI)
+//  7. X-Client-Request-Id header
+//  8. user field
+//
+// For Gemini: session_id (Code Assist request.session_id), then X-Session-ID header.
+//
+// For Ollama: X-Session-ID header.
 //
 // Returns empty string if no session ID is found.
-func ExtractClientSessionID(body []byte, provider string, headers http.Header, path string) string {
-	if provider == "openai" {
+func ExtractClientSessionID(body []byte, dialect string, headers http.Header, path string) string {
+	if dialect == dialectOllama {
+		return extractOllamaSessionID(headers)
+	}
+
+	if isOpenAIDialect(dialect) {
 		// Check URL path first for thread ID (highest priority)
 		if threadID := ExtractThreadIDFromPath(path); threadID != "" {
 			return threadID
@@ -180,14 +196,18 @@ func ExtractClientSessionID(body []byte, provider string, headers http.Header, p
 		return ""
 	}
 
-	if provider == "anthropic" {
+	if dialect == dialectAnthropic {
 		return extractAnthropicSessionID(request)
 	}
 
-	if provider == "openai" {
+	if isOpenAIDialect(dialect) {
 		return extractOpenAISessionID(request, headers)
 	}
 
+	if dialect == dialectGemini {
+		return extractGeminiSessionID(request, headers)
+	}
+
 	return ""
 }
 
@@ -224,7 +244,7 @@ func extractAnthropicSessionID(request map[string]interface{}) string {
 }
 
 // extractOpenAISessionID extracts session ID from OpenAI request fields and headers
-// Priority: conversation > previous_response_id > metadata.session_id > X-Session-ID > X-Client-Request-Id > user
+// Priority: conversation > previous_response_id > metadata.session_id > X-Session-ID > Session_id > X-Client-Request-Id > user
 func extractOpenAISessionID(request map[string]interface{}, headers http.Header) string {
 	// 1. conversation (Responses API)
 	if conv, ok := request["conversation"].(string); ok && conv != "" {
@@ -257,7 +277,14 @@ func extractOpenAISessionID(request map[string]interface{}, headers http.Header)
 			}
 		}
 
-		// 5. X-Client-Request-Id header
+		// 5. session_id header (Codex CLI)
+		if sessID := headers.Get("Session_id"); sessID != "" {
+			if isValidSessionID(sessID) {
+				return sessID
+			}
+		}
+
+		// 6. X-Client-Request-Id header
 		if clientReq := headers.Get("X-Client-Request-Id"); clientReq != "" {
 			if isValidSessionID(clientReq) {
 				return clientReq
@@ -265,7 +292,7 @@ func extractOpenAISessionID(request map[string]interface{}, headers http.Header)
 		}
 	}
 
-	// 6. user field
+	// 7. user field
 	if user, ok := request["user"].(string); ok && user != "" {
 		if isValidSessionID(user) {
 			return user
@@ -328,13 +355,16 @@ func ExtractThreadIDFromPath(path string) string {
 
 // ExtractAssistantMessage extracts the assistant's response from API response body
 // Preserves the original content structure (array for Anthropic) to match follow-up requests
-func ExtractAssistantMessage(responseBody []byte, provider string) (map[string]interface{}, error) {
+func ExtractAssistantMessage(responseBody []byte, dialect string) (map[string]interface{}, error) {
 	var resp map[string]interface{}
 	if err := json.Unmarshal(responseBody, &resp); err != nil {
 		return nil, fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	if provider == "anthropic" {
+	if dialect == dialectAnthropic && isConverseResponse(resp) {
+		// Bedrock Converse: {"output": {"message": {"role": "assistant", "content": [...]}}}
+		return resp["output"].(map[string]interface{})["message"].(map[string]interface{}), nil
+	} else if dialect == dialectAnthropic {
 		// Anthropic response: {"role": "assistant", "content": [{"type": "text", "text": "..."}], ...}
 		// Preserve content as array to match how Claude Code sends it in follow-up requests
 		content, ok := resp["content"].([]interface{})
@@ -345,8 +375,8 @@ func ExtractAssistantMessage(responseBody []byte, provider string) (map[string]i
 			"role":    "assistant",
 			"content": content,
 		}, nil
-	} else if provider == "openai" {
-		// OpenAI: {"choices": [{"message": {"role": "assistant", "content": "..."}}]}
+	} else if isOpenAIDialect(dialect) {
+		// OpenAI / Azure OpenAI: {"choices": [{"message": {"role": "assistant", "content": "..."}}]}
 		choices, ok := resp["choices"].([]interface{})
 		if !ok || len(choices) == 0 {
 			return nil, fmt.Errorf("missing or empty choices in response")
@@ -360,7 +390,34 @@ func ExtractAssistantMessage(responseBody []byte, provider string) (map[string]i
 			return nil, fmt.Errorf("missing message in choice")
 		}
 		return message, nil
+	} else if dialect == dialectOllama {
+		// Ollama /api/chat: {"message": {"role": "assistant", "content": "...", "tool_calls": [...]}}
+		// /api/generate: {"response": "..."}
+		if message, ok := resp["message"].(map[string]interface{}); ok {
+			return message, nil
+		}
+		text, ok := resp["response"].(string)
+		if !ok {
+			return nil, fmt.Errorf("missing message or response in Ollama response")
+		}
+		return map[string]interface{}{"role": "assistant", "content": text}, nil
+	} else if dialect == dialectGemini {
+		// Gemini: {"candidates": [{"content": {"role": "model", "parts": [...]}}]}
+		inner := unwrapGeminiPayload(resp, "response")
+		candidates, ok := inner["candidates"].([]interface{})
+		if !ok || len(candidates) == 0 {
+			return nil, fmt.Errorf("missing or empty candidates in response")
+		}
+		candidate, ok := candidates[0].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid candidate format")
+		}
+		content, ok := candidate["content"].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("missing content in candidate")
+		}
+		return content, nil
 	}
 
-	return nil, fmt.Errorf("unsupported provider: %s", provider)
+	return nil, fmt.Errorf("unsupported dialect: %s", dialect)
 }
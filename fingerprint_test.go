@@ -122,7 +122,7 @@ func TestExtractAssistantMessageAnthropic(t *testing.T) {
 func TestExtractAssistantMessageOpenAI(t *testing.T) {
 	response := `{"choices":[{"message":{"role":"assistant","content":"Hi!"}}]}`
 
-	msg, err := ExtractAssistantMessage([]byte(response), "openai")
+	msg, err := ExtractAssistantMessage([]byte(response), dialectOpenAIChat)
 	if err != nil {
 		t.Fatalf("Failed to extract assistant message: %v", err)
 	}
@@ -202,7 +202,7 @@ func TestExtractClientSessionIDOpenAIChatCompletionsUser(t *testing.T) {
 		"user": "user-12345"
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/chat/completions")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/chat/completions")
 	if sessionID != "user-12345" {
 		t.Errorf("Expected session ID 'user-12345', got '%s'", sessionID)
 	}
@@ -217,7 +217,7 @@ func TestExtractClientSessionIDOpenAIMetadata(t *testing.T) {
 		"metadata": {"session_id": "sess-abc-789"}
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/chat/completions")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/chat/completions")
 	if sessionID != "sess-abc-789" {
 		t.Errorf("Expected session ID 'sess-abc-789', got '%s'", sessionID)
 	}
@@ -232,7 +232,7 @@ func TestExtractClientSessionIDOpenAIResponsesConversation(t *testing.T) {
 		"metadata": {"session_id": "other-session"}
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/responses")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/responses")
 	if sessionID != "conv_abc123" {
 		t.Errorf("Expected session ID 'conv_abc123', got '%s'", sessionID)
 	}
@@ -246,7 +246,7 @@ func TestExtractClientSessionIDOpenAIPreviousResponseID(t *testing.T) {
 		"previous_response_id": "resp_xyz789"
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/responses")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/responses")
 	if sessionID != "resp_xyz789" {
 		t.Errorf("Expected session ID 'resp_xyz789', got '%s'", sessionID)
 	}
@@ -259,7 +259,7 @@ func TestExtractClientSessionIDOpenAINoSession(t *testing.T) {
 		"messages": [{"role": "user", "content": "hello"}]
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/chat/completions")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/chat/completions")
 	if sessionID != "" {
 		t.Errorf("Expected empty session ID, got '%s'", sessionID)
 	}
@@ -331,7 +331,7 @@ func TestExtractClientSessionIDOpenAIHeaders(t *testing.T) {
 	headers := http.Header{}
 	headers.Set("X-Session-ID", "header-session-123")
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", headers, "/v1/chat/completions")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, headers, "/v1/chat/completions")
 	if sessionID != "header-session-123" {
 		t.Errorf("Expected session ID 'header-session-123', got '%s'", sessionID)
 	}
@@ -347,12 +347,28 @@ func TestExtractClientSessionIDOpenAIClientRequestId(t *testing.T) {
 	headers := http.Header{}
 	headers.Set("X-Client-Request-Id", "client-req-456")
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", headers, "/v1/chat/completions")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, headers, "/v1/chat/completions")
 	if sessionID != "client-req-456" {
 		t.Errorf("Expected session ID 'client-req-456', got '%s'", sessionID)
 	}
 }
 
+func TestExtractClientSessionIDCodexSessionHeader(t *testing.T) {
+	// Codex CLI sends a session_id header on Responses API requests
+	request := `{
+		"model": "gpt-5-codex",
+		"input": [{"type": "message", "role": "user", "content": "hello"}]
+	}`
+
+	headers := http.Header{}
+	headers.Set("session_id", "codex-session-789")
+
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, headers, "/backend-api/codex/responses")
+	if sessionID != "codex-session-789" {
+		t.Errorf("Expected session ID 'codex-session-789', got '%s'", sessionID)
+	}
+}
+
 func TestExtractClientSessionIDOpenAIBodyOverHeader(t *testing.T) {
 	// Body session_id takes priority over header
 	request := `{
@@ -364,7 +380,7 @@ func TestExtractClientSessionIDOpenAIBodyOverHeader(t *testing.T) {
 	headers := http.Header{}
 	headers.Set("X-Session-ID", "header-session")
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", headers, "/v1/chat/completions")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, headers, "/v1/chat/completions")
 	if sessionID != "body-session" {
 		t.Errorf("Expected session ID 'body-session' (body priority), got '%s'", sessionID)
 	}
@@ -414,7 +430,7 @@ func TestExtractClientSessionIDOpenAIThreadPath(t *testing.T) {
 		"model": "gpt-4o"
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/threads/thread_abc123/messages")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/threads/thread_abc123/messages")
 	if sessionID != "thread_abc123" {
 		t.Errorf("Expected session ID 'thread_abc123', got '%s'", sessionID)
 	}
@@ -427,7 +443,7 @@ func TestExtractClientSessionIDOpenAIThreadPathPriority(t *testing.T) {
 		"user": "other-user"
 	}`
 
-	sessionID := ExtractClientSessionID([]byte(request), "openai", nil, "/v1/threads/thread_xyz/runs")
+	sessionID := ExtractClientSessionID([]byte(request), dialectOpenAIChat, nil, "/v1/threads/thread_xyz/runs")
 	if sessionID != "thread_xyz" {
 		t.Errorf("Expected session ID 'thread_xyz' (path priority), got '%s'", sessionID)
 	}
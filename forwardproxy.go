@@ -0,0 +1,263 @@
+// forwardproxy.go
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Forward-proxy mode.
+//
+// For tools that only honor HTTPS_PROXY, the proxy accepts CONNECT. A tunnel
+// to a provider's host (its default or JWT upstream, or one listed in
+// forward_proxy.hosts) is terminated with a leaf certificate from the local
+// CA, and each request inside it is served as /{provider}/{host}/{path}, so
+// it is logged and forwarded like any other. Tunnels to every other host are
+// spliced to the host untouched.
+
+// forwardProxy handles CONNECT requests.
+type forwardProxy struct {
+	ca        *localCA
+	hosts     map[string]string // extra intercepted host[:port] → provider
+	handler   http.Handler      // serves intercepted requests
+	providers *providerRegistry // default upstreams are intercepted
+	dialer    net.Dialer
+}
+
+// tunnel is an intercepted CONNECT.
+type tunnel struct {
+	provider string
+	upstream string // host, or host:port when not 443
+	identity string // from proxy auth on the CONNECT request
+}
+
+// newForwardProxy loads the local CA and checks cfg's extra hosts. Returns
+// nil if forward-proxy mode is disabled.
+func newForwardProxy(cfg ForwardProxyConfig, handler http.Handler, providers *providerRegistry) (*forwardProxy, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	dir := cfg.CADir
+	if dir == "" {
+		dir = DefaultCADir()
+	}
+	ca, err := loadLocalCA(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]string)
+	for host, provider := range cfg.Hosts {
+		if providers.lookup(provider) == nil {
+			return nil, fmt.Errorf("forward_proxy: host %s: unknown provider %q", host, provider)
+		}
+		hosts[strings.TrimSuffix(strings.ToLower(host), ":443")] = provider
+	}
+	if err := checkCA(ca.cert, interceptedHosts(providers, cfg.Hosts), time.Now()); err != nil {
+		return nil, fmt.Errorf("forward_proxy: %v in %s; run llm-proxy --setup-ca", err, dir)
+	}
+	return &forwardProxy{ca: ca, hosts: hosts, handler: handler, providers: providers}, nil
+}
+
+// interceptedHosts returns the names, without ports, of every host
+// forward-proxy mode may intercept: the providers' default and JWT upstreams
+// plus extra. The local CA is limited to these.
+func interceptedHosts(providers *providerRegistry, extra map[string]string) []string {
+	seen := make(map[string]bool)
+	add := func(host string) {
+		if host == "" {
+			return
+		}
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		seen[strings.ToLower(host)] = true
+	}
+	for _, name := range providers.names() {
+		spec := providers.lookup(name)
+		add(spec.Upstream)
+		add(spec.JWTUpstream)
+	}
+	for host := range extra {
+		add(host)
+	}
+	hosts := make([]string, 0, len(seen))
+	for host := range seen {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// providerFor returns the provider whose requests go to upstream, or "" if
+// the tunnel should not be intercepted.
+func (f *forwardProxy) providerFor(upstream string) string {
+	if provider, ok := f.hosts[upstream]; ok {
+		return provider
+	}
+	return f.providers.forHost(upstream)
+}
+
+// describe summarizes the intercepted hosts for the startup log.
+func (f *forwardProxy) describe() string {
+	var hosts []string
+	for _, name := range f.providers.names() {
+		spec := f.providers.lookup(name)
+		for _, h := range []string{spec.Upstream, spec.JWTUpstream} {
+			if h != "" && !isLocalhost(h) {
+				hosts = append(hosts, h)
+			}
+		}
+	}
+	for h := range f.hosts {
+		hosts = append(hosts, h)
+	}
+	sort.Strings(hosts)
+	return strings.Join(hosts, ",")
+}
+
+// ServeHTTP handles a CONNECT request.
+func (f *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		http.Error(w, "forward proxy: CONNECT target must be host:port", http.StatusBadRequest)
+		return
+	}
+	host = strings.ToLower(host)
+	upstream := net.JoinHostPort(host, port)
+	if port == "443" {
+		upstream = host
+	}
+	provider := f.providerFor(upstream)
+
+	// Dial a pass-through tunnel before answering, so a bad host gets a 502
+	var upstreamConn net.Conn
+	if provider == "" {
+		upstreamConn, err = f.dialer.DialContext(r.Context(), "tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			http.Error(w, "forward proxy: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		if upstreamConn != nil {
+			upstreamConn.Close()
+		}
+		http.Error(w, "forward proxy: connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hj.Hijack()
+	if err != nil {
+		if upstreamConn != nil {
+			upstreamConn.Close()
+		}
+		return
+	}
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		if upstreamConn != nil {
+			upstreamConn.Close()
+		}
+		return
+	}
+	clientConn := net.Conn(conn)
+	if buf.Reader.Buffered() > 0 {
+		clientConn = &bufferedConn{Conn: conn, r: buf.Reader}
+	}
+
+	if provider == "" {
+		splice(clientConn, upstreamConn)
+		return
+	}
+	f.intercept(clientConn, host, tunnel{provider: provider, upstream: upstream, identity: proxyIdentity(r.Context())})
+}
+
+// intercept terminates TLS on conn with a certificate for host and serves
+// the requests inside it in the background.
+func (f *forwardProxy) intercept(conn net.Conn, host string, t tunnel) {
+	tlsConn := tls.Server(conn, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return f.ca.leaf(host, time.Now())
+		},
+	})
+	srv := &http.Server{
+		Handler:           http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { f.serveTunneled(w, r, t) }),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          log.New(io.Discard, "", 0), // handshake failures from clients without the CA
+	}
+	srv.Serve(&oneConnListener{conn: tlsConn, addr: conn.LocalAddr()})
+}
+
+// serveTunneled serves one intercepted request through the proxy pipeline.
+func (f *forwardProxy) serveTunneled(w http.ResponseWriter, r *http.Request, t tunnel) {
+	prefix := "/" + t.provider + "/" + t.upstream
+	r.URL.Path = prefix + r.URL.Path
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = prefix + r.URL.RawPath
+	}
+	if t.identity != "" {
+		r = r.WithContext(withProxyIdentity(r.Context(), t.identity))
+	}
+	f.handler.ServeHTTP(w, r)
+}
+
+// splice copies between a and b until either side closes.
+func splice(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	copyConn := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copyConn(a, b)
+	go copyConn(b, a)
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// bufferedConn is a hijacked connection whose first bytes were already read
+// into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// oneConnListener hands a single connection to an http.Server.
+type oneConnListener struct {
+	mu   sync.Mutex
+	conn net.Conn
+	addr net.Addr
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conn == nil {
+		return nil, io.EOF
+	}
+	conn := l.conn
+	l.conn = nil
+	return conn, nil
+}
+
+func (l *oneConnListener) Close() error { return nil }
+
+func (l *oneConnListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,347 @@
+// gemini.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Gemini (Google Generative Language API) support.
+//
+// Gemini CLI talks to generativelanguage.googleapis.com with paths like
+// /v1beta/models/{model}:generateContent and :streamGenerateContent?alt=sse.
+// Code Assist (cloudcode-pa.googleapis.com) uses /v1internal:generateContent
+// and wraps the same payloads in {"request": {...}} / {"response": {...}}.
+
+// isGeminiConversationPath returns true for Gemini generateContent endpoints.
+func isGeminiConversationPath(path string) bool {
+	return strings.HasSuffix(path, ":generateContent") || strings.HasSuffix(path, ":streamGenerateContent")
+}
+
+// extractGeminiModel extracts the model name from a Gemini URL path.
+// Path format: /v1beta/models/{model}:generateContent
+// Returns empty string for paths without a model segment (e.g., Code Assist).
+func extractGeminiModel(path string) string {
+	idx := strings.Index(path, "/models/")
+	if idx == -1 {
+		return ""
+	}
+	model := path[idx+len("/models/"):]
+	if colon := strings.Index(model, ":"); colon != -1 {
+		model = model[:colon]
+	}
+	if strings.Contains(model, "/") {
+		return ""
+	}
+	return model
+}
+
+// unwrapGeminiPayload returns the inner payload for Code Assist requests and
+// responses, which wrap the standard Gemini body under the given key.
+func unwrapGeminiPayload(raw map[string]interface{}, key string) map[string]interface{} {
+	if inner, ok := raw[key].(map[string]interface{}); ok {
+		return inner
+	}
+	return raw
+}
+
+// isGeminiRequest returns true if the decoded request body uses Gemini's
+// contents/parts shape rather than Anthropic/OpenAI messages.
+func isGeminiRequest(raw map[string]interface{}) bool {
+	_, ok := unwrapGeminiPayload(raw, "request")["contents"].([]interface{})
+	return ok
+}
+
+// isGeminiResponse returns true if the decoded response body uses Gemini's
+// candidates/usageMetadata shape.
+func isGeminiResponse(raw map[string]interface{}) bool {
+	inner := unwrapGeminiPayload(raw, "response")
+	if _, ok := inner["candidates"]; ok {
+		return true
+	}
+	_, ok := inner["usageMetadata"]
+	return ok
+}
+
+// parseGeminiRequest populates a ParsedRequest from a Gemini request body.
+func parseGeminiRequest(raw map[string]interface{}, parsed *ParsedRequest) {
+	if model, ok := raw["model"].(string); ok {
+		parsed.Model = model
+	}
+	req := unwrapGeminiPayload(raw, "request")
+
+	if cfg, ok := req["generationConfig"].(map[string]interface{}); ok {
+		if maxTokens, ok := cfg["maxOutputTokens"].(float64); ok {
+			parsed.MaxTokens = int(maxTokens)
+		}
+	}
+
+	if sys, ok := req["systemInstruction"].(map[string]interface{}); ok {
+		var systemParts []string
+		for _, block := range parseGeminiParts(sys["parts"]) {
+			if block.Type == "text" {
+				systemParts = append(systemParts, block.Text)
+			}
+		}
+		parsed.System = strings.Join(systemParts, "\n\n")
+	}
+
+	contents, _ := req["contents"].([]interface{})
+	for _, c := range contents {
+		content, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		pm := ParsedMessage{Raw: content}
+		if role, ok := content["role"].(string); ok {
+			pm.Role = normalizeGeminiRole(role)
+		}
+		pm.Content = parseGeminiParts(content["parts"])
+		for _, cb := range pm.Content {
+			if cb.Type == "text" && pm.TextContent == "" {
+				pm.TextContent = cb.Text
+			}
+		}
+		parsed.Messages = append(parsed.Messages, pm)
+	}
+}
+
+// normalizeGeminiRole maps Gemini's "model" role to "assistant" so the explorer
+// and session tooling can treat all providers alike.
+func normalizeGeminiRole(role string) string {
+	if role == "model" {
+		return "assistant"
+	}
+	return role
+}
+
+// parseGeminiParts converts Gemini parts into ContentBlocks.
+// functionCall → tool_use, functionResponse → tool_result, thought text → thinking.
+func parseGeminiParts(v interface{}) []ContentBlock {
+	parts, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var blocks []ContentBlock
+	var calls, responses int
+	for _, p := range parts {
+		part, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cb := ContentBlock{Raw: part}
+
+		if fc, ok := part["functionCall"].(map[string]interface{}); ok {
+			cb.Type = "tool_use"
+			cb.ToolName, _ = fc["name"].(string)
+			cb.ToolID = geminiToolID(fc, calls)
+			calls++
+			if args, ok := fc["args"].(map[string]interface{}); ok {
+				cb.ToolInput = args
+			}
+		} else if fr, ok := part["functionResponse"].(map[string]interface{}); ok {
+			cb.Type = "tool_result"
+			cb.ToolName, _ = fr["name"].(string)
+			cb.ToolID = geminiToolID(fr, responses)
+			responses++
+			if resp, ok := fr["response"].(map[string]interface{}); ok {
+				if _, hasErr := resp["error"]; hasErr {
+					cb.IsError = true
+				}
+				if data, err := json.Marshal(resp); err == nil {
+					cb.Text = string(data)
+				}
+			}
+		} else if text, ok := part["text"].(string); ok {
+			if thought, _ := part["thought"].(bool); thought {
+				cb.Type = "thinking"
+				cb.Thinking = text
+			} else {
+				cb.Type = "text"
+				cb.Text = text
+			}
+		} else {
+			continue
+		}
+
+		blocks = append(blocks, cb)
+	}
+	return blocks
+}
+
+// geminiToolID returns the call ID for the index'th functionCall (or
+// functionResponse) part of a message. Older Gemini models don't send IDs,
+// so fall back to the function name, which is what the matching
+// functionResponse carries, and the part's position.
+func geminiToolID(part map[string]interface{}, index int) string {
+	if id, ok := part["id"].(string); ok && id != "" {
+		return id
+	}
+	name, _ := part["name"].(string)
+	return fallbackToolID(name, index)
+}
+
+// fallbackToolID identifies a tool call sent without an ID by its function
+// name and position among the message's calls, so parallel calls to one
+// function stay distinct. Results come back in call order, so the result at
+// the same position gets the same ID.
+func fallbackToolID(name string, index int) string {
+	return name + "#" + strconv.Itoa(index)
+}
+
+// parseGeminiResponse populates a ParsedResponse from a Gemini response body.
+func parseGeminiResponse(raw map[string]interface{}, parsed *ParsedResponse) {
+	resp := unwrapGeminiPayload(raw, "response")
+
+	if candidates, ok := resp["candidates"].([]interface{}); ok && len(candidates) > 0 {
+		if candidate, ok := candidates[0].(map[string]interface{}); ok {
+			if content, ok := candidate["content"].(map[string]interface{}); ok {
+				parsed.Content = parseGeminiParts(content["parts"])
+			}
+			if finish, ok := candidate["finishReason"].(string); ok {
+				parsed.StopReason = finish
+			}
+		}
+	}
+
+	if usage, ok := resp["usageMetadata"].(map[string]interface{}); ok {
+		parsed.Usage = parseGeminiUsage(usage)
+	}
+}
+
+// parseGeminiUsage maps usageMetadata onto UsageInfo. Gemini's promptTokenCount
+// includes cached tokens, so they are subtracted to match Anthropic semantics
+// where input_tokens excludes cache reads. Thinking tokens count as output.
+func parseGeminiUsage(usage map[string]interface{}) UsageInfo {
+	var info UsageInfo
+	prompt, _ := usage["promptTokenCount"].(float64)
+	cached, _ := usage["cachedContentTokenCount"].(float64)
+	candidates, _ := usage["candidatesTokenCount"].(float64)
+	thoughts, _ := usage["thoughtsTokenCount"].(float64)
+
+	info.InputTokens = int(prompt - cached)
+	info.CacheReadInputTokens = int(cached)
+	info.OutputTokens = int(candidates + thoughts)
+	return info
+}
+
+// parseGeminiStreamingResponse reconstructs a ParsedResponse from Gemini SSE chunks.
+func parseGeminiStreamingResponse(chunks []StreamChunk) ParsedResponse {
+	var events []map[string]interface{}
+	for _, chunk := range chunks {
+		if data := decodeSSEData(chunk.Raw); data != nil {
+			events = append(events, data)
+		}
+	}
+	return mergeGeminiResponses(events)
+}
+
+// mergeGeminiResponses folds a sequence of partial GenerateContentResponses
+// (SSE events, or the JSON array returned without alt=sse) into one
+// ParsedResponse. Text parts are appended, functionCall parts arrive whole,
+// and usageMetadata in later events supersedes earlier ones.
+func mergeGeminiResponses(events []map[string]interface{}) ParsedResponse {
+	parsed := ParsedResponse{}
+	var calls int
+
+	for _, data := range events {
+		var partial ParsedResponse
+		parseGeminiResponse(data, &partial)
+
+		for _, block := range partial.Content {
+			if block.Type == "tool_use" {
+				// Number calls across the stream, not per event
+				fc, _ := block.Raw["functionCall"].(map[string]interface{})
+				block.ToolID = geminiToolID(fc, calls)
+				calls++
+			}
+			last := len(parsed.Content) - 1
+			if last >= 0 && block.Type == parsed.Content[last].Type {
+				switch block.Type {
+				case "text":
+					parsed.Content[last].Text += block.Text
+					continue
+				case "thinking":
+					parsed.Content[last].Thinking += block.Thinking
+					continue
+				}
+			}
+			parsed.Content = append(parsed.Content, block)
+		}
+
+		if partial.StopReason != "" {
+			parsed.StopReason = partial.StopReason
+		}
+		if _, ok := unwrapGeminiPayload(data, "response")["usageMetadata"]; ok {
+			parsed.Usage = partial.Usage
+		}
+	}
+
+	return parsed
+}
+
+// parseGeminiResponseArray handles non-SSE :streamGenerateContent responses,
+// which arrive as a JSON array of partial responses.
+func parseGeminiResponseArray(body string) (ParsedResponse, bool) {
+	var events []map[string]interface{}
+	if json.Unmarshal([]byte(body), &events) != nil || len(events) == 0 {
+		return ParsedResponse{}, false
+	}
+	if !isGeminiResponse(events[0]) {
+		return ParsedResponse{}, false
+	}
+	return mergeGeminiResponses(events), true
+}
+
+// decodeSSEData parses the JSON payload of an SSE "data: " line.
+// Returns nil for non-data lines, [DONE] markers, and invalid JSON.
+func decodeSSEData(raw string) map[string]interface{} {
+	if !strings.HasPrefix(raw, "data: ") {
+		return nil
+	}
+	dataStr := strings.TrimSpace(strings.TrimPrefix(raw, "data: "))
+	if dataStr == "" || dataStr == "[DONE]" {
+		return nil
+	}
+	var data map[string]interface{}
+	if json.Unmarshal([]byte(dataStr), &data) != nil {
+		return nil
+	}
+	return data
+}
+
+// extractGeminiDeltaText returns the non-thought text from a Gemini SSE event.
+func extractGeminiDeltaText(event map[string]interface{}) string {
+	var parsed ParsedResponse
+	parseGeminiResponse(event, &parsed)
+
+	var text strings.Builder
+	for _, block := range parsed.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return text.String()
+}
+
+// extractGeminiSessionID extracts a session ID from a Gemini request.
+// Priority: session_id (Code Assist: request.session_id) > X-Session-ID header.
+func extractGeminiSessionID(request map[string]interface{}, headers http.Header) string {
+	inner := unwrapGeminiPayload(request, "request")
+	for _, body := range []map[string]interface{}{inner, request} {
+		if sessID, ok := body["session_id"].(string); ok && isValidSessionID(sessID) {
+			return sessID
+		}
+	}
+
+	if headers != nil {
+		if sessID := headers.Get("X-Session-ID"); isValidSessionID(sessID) {
+			return sessID
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,324 @@
+// gemini_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExtractGeminiModel(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/v1beta/models/gemini-2.5-pro:generateContent", "gemini-2.5-pro"},
+		{"/v1beta/models/gemini-2.5-flash:streamGenerateContent", "gemini-2.5-flash"},
+		{"/v1internal:generateContent", ""},
+		{"/v1beta/models", ""},
+	}
+
+	for _, tt := range tests {
+		if got := extractGeminiModel(tt.path); got != tt.want {
+			t.Errorf("extractGeminiModel(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseGeminiRequest(t *testing.T) {
+	body := `{
+		"systemInstruction": {"parts": [{"text": "You are helpful."}]},
+		"generationConfig": {"maxOutputTokens": 2048},
+		"contents": [
+			{"role": "user", "parts": [{"text": "List files"}]},
+			{"role": "model", "parts": [{"functionCall": {"id": "call_1", "name": "list_directory", "args": {"path": "."}}}]},
+			{"role": "user", "parts": [{"functionResponse": {"id": "call_1", "name": "list_directory", "response": {"error": "permission denied"}}}]}
+		]
+	}`
+
+	parsed := ParseRequestBody(body, "generativelanguage.googleapis.com")
+
+	if parsed.System != "You are helpful." {
+		t.Errorf("System = %q", parsed.System)
+	}
+	if parsed.MaxTokens != 2048 {
+		t.Errorf("MaxTokens = %d, want 2048", parsed.MaxTokens)
+	}
+	if len(parsed.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(parsed.Messages))
+	}
+	if parsed.Messages[0].TextContent != "List files" {
+		t.Errorf("TextContent = %q", parsed.Messages[0].TextContent)
+	}
+	if parsed.Messages[1].Role != "assistant" {
+		t.Errorf("model role should normalize to assistant, got %q", parsed.Messages[1].Role)
+	}
+
+	call := parsed.Messages[1].Content[0]
+	if call.Type != "tool_use" || call.ToolName != "list_directory" || call.ToolID != "call_1" {
+		t.Errorf("functionCall parsed as %+v", call)
+	}
+	if call.ToolInput["path"] != "." {
+		t.Errorf("ToolInput = %v", call.ToolInput)
+	}
+
+	result := parsed.Messages[2].Content[0]
+	if result.Type != "tool_result" || result.ToolID != "call_1" || !result.IsError {
+		t.Errorf("functionResponse parsed as %+v", result)
+	}
+
+	// extractToolResults should see Gemini functionResponses
+	results := extractToolResults([]byte(body))
+	if len(results) != 1 || results[0].ToolUseID != "call_1" || !results[0].IsError {
+		t.Errorf("extractToolResults = %+v", results)
+	}
+}
+
+func TestParseGeminiRequest_ParallelCallsWithoutIDs(t *testing.T) {
+	body := `{"contents": [
+		{"role": "user", "parts": [{"text": "Read both files"}]},
+		{"role": "model", "parts": [
+			{"functionCall": {"name": "read_file", "args": {"path": "a.go"}}},
+			{"functionCall": {"name": "read_file", "args": {"path": "b.go"}}}
+		]},
+		{"role": "user", "parts": [
+			{"functionResponse": {"name": "read_file", "response": {"output": "package a"}}},
+			{"functionResponse": {"name": "read_file", "response": {"output": "package b"}}}
+		]}
+	]}`
+
+	parsed := ParseRequestBody(body, "generativelanguage.googleapis.com")
+	calls, results := parsed.Messages[1].Content, parsed.Messages[2].Content
+	if len(calls) != 2 || len(results) != 2 {
+		t.Fatalf("calls = %+v, results = %+v", calls, results)
+	}
+	if calls[0].ToolID == calls[1].ToolID {
+		t.Errorf("parallel calls share ID %q", calls[0].ToolID)
+	}
+	for i := range calls {
+		if results[i].ToolID != calls[i].ToolID {
+			t.Errorf("result %d ID = %q, want %q", i, results[i].ToolID, calls[i].ToolID)
+		}
+	}
+
+	// Calls in separate stream events are numbered across the stream
+	stream := ParseStreamingResponse([]StreamChunk{
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"a.go"}}}]}}]}`},
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"b.go"}}}]}}]}`},
+	})
+	if len(stream.Content) != 2 || stream.Content[0].ToolID != calls[0].ToolID || stream.Content[1].ToolID != calls[1].ToolID {
+		t.Errorf("streamed calls = %+v", stream.Content)
+	}
+}
+
+func TestParseGeminiRequest_CodeAssistWrapper(t *testing.T) {
+	body := `{
+		"model": "gemini-2.5-pro",
+		"project": "my-project",
+		"request": {
+			"session_id": "gemini-cli-session-1",
+			"contents": [{"role": "user", "parts": [{"text": "hi"}]}]
+		}
+	}`
+
+	parsed := ParseRequestBody(body, "cloudcode-pa.googleapis.com")
+	if parsed.Model != "gemini-2.5-pro" {
+		t.Errorf("Model = %q", parsed.Model)
+	}
+	if len(parsed.Messages) != 1 || parsed.Messages[0].TextContent != "hi" {
+		t.Errorf("Messages = %+v", parsed.Messages)
+	}
+
+	if got := ExtractClientSessionID([]byte(body), "gemini", nil, "/v1internal:generateContent"); got != "gemini-cli-session-1" {
+		t.Errorf("ExtractClientSessionID = %q, want gemini-cli-session-1", got)
+	}
+}
+
+func TestExtractGeminiSessionID_Header(t *testing.T) {
+	headers := http.Header{}
+	headers.Set("X-Session-ID", "hdr-session")
+
+	body := `{"contents": [{"role": "user", "parts": [{"text": "hi"}]}]}`
+	if got := ExtractClientSessionID([]byte(body), "gemini", headers, "/v1beta/models/gemini-2.5-pro:generateContent"); got != "hdr-session" {
+		t.Errorf("ExtractClientSessionID = %q, want hdr-session", got)
+	}
+}
+
+func TestParseGeminiResponse(t *testing.T) {
+	body := `{
+		"candidates": [{
+			"content": {"role": "model", "parts": [
+				{"text": "Thinking it over", "thought": true},
+				{"text": "Reading the file."},
+				{"functionCall": {"name": "read_file", "args": {"path": "main.go"}}}
+			]},
+			"finishReason": "STOP"
+		}],
+		"usageMetadata": {"promptTokenCount": 120, "cachedContentTokenCount": 100, "candidatesTokenCount": 15, "thoughtsTokenCount": 5}
+	}`
+
+	parsed := ParseResponseBody(body, "generativelanguage.googleapis.com")
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Thinking it over" {
+		t.Errorf("thought part parsed as %+v", parsed.Content[0])
+	}
+	if parsed.Content[2].Type != "tool_use" || parsed.Content[2].ToolID != "read_file#0" {
+		t.Errorf("functionCall without id should fall back to name and position, got %+v", parsed.Content[2])
+	}
+	if parsed.StopReason != "STOP" {
+		t.Errorf("StopReason = %q, want STOP", parsed.StopReason)
+	}
+	want := UsageInfo{InputTokens: 20, OutputTokens: 20, CacheReadInputTokens: 100}
+	if parsed.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", parsed.Usage, want)
+	}
+}
+
+func TestParseGeminiResponse_JSONArray(t *testing.T) {
+	body := `[
+		{"candidates": [{"content": {"role": "model", "parts": [{"text": "Hel"}]}}]},
+		{"candidates": [{"content": {"role": "model", "parts": [{"text": "lo"}]}, "finishReason": "STOP"}],
+		 "usageMetadata": {"promptTokenCount": 4, "candidatesTokenCount": 2}}
+	]`
+
+	parsed := ParseResponseBody(body, "generativelanguage.googleapis.com")
+	if len(parsed.Content) != 1 || parsed.Content[0].Text != "Hello" {
+		t.Errorf("Content = %+v", parsed.Content)
+	}
+	if parsed.Usage.InputTokens != 4 || parsed.Usage.OutputTokens != 2 {
+		t.Errorf("Usage = %+v", parsed.Usage)
+	}
+}
+
+func TestParseStreamingResponse_Gemini(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"Let me "}]}}]}` + "\n"},
+		{Raw: "\n"},
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"text":"check."}]}}]}` + "\n"},
+		{Raw: `data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"id":"c1","name":"run_shell_command","args":{"command":"ls"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":50,"candidatesTokenCount":12}}` + "\n"},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got %d: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Text != "Let me check." {
+		t.Errorf("Text = %q", parsed.Content[0].Text)
+	}
+	if parsed.Content[1].ToolName != "run_shell_command" || parsed.Content[1].ToolID != "c1" {
+		t.Errorf("tool block = %+v", parsed.Content[1])
+	}
+	if parsed.StopReason != "STOP" {
+		t.Errorf("StopReason = %q", parsed.StopReason)
+	}
+	if parsed.Usage.InputTokens != 50 || parsed.Usage.OutputTokens != 12 {
+		t.Errorf("Usage = %+v", parsed.Usage)
+	}
+}
+
+func TestExtractDeltaTextGemini(t *testing.T) {
+	data := []byte(`data: {"candidates":[{"content":{"parts":[{"text":"skip","thought":true},{"text":"Hello"}]}}]}` + "\n")
+	if got := extractDeltaText(data, "gemini"); got != "Hello" {
+		t.Errorf("extractDeltaText = %q, want Hello", got)
+	}
+}
+
+func TestGeminiLokiLabels(t *testing.T) {
+	entry := map[string]interface{}{
+		"type": "request",
+		"path": "/v1beta/models/gemini-2.5-pro:streamGenerateContent",
+		"body": `{"contents":[{"role":"user","parts":[{"text":"hi"}]}],"tools":[{"functionDeclarations":[]}]}`,
+	}
+	model, _, stream, hasTools, _, _ := extractExtendedLabels(entry, "request")
+	if model != "gemini-2.5-pro" {
+		t.Errorf("model = %q", model)
+	}
+	if stream != "true" {
+		t.Errorf("stream = %q", stream)
+	}
+	if hasTools != "true" {
+		t.Errorf("hasTools = %q", hasTools)
+	}
+
+	resp := map[string]interface{}{
+		"type":   "response",
+		"status": 200,
+		"chunks": []StreamChunk{
+			{Raw: `data: {"candidates":[{"content":{"parts":[{"text":"hi"}]},"finishReason":"MAX_TOKENS"}]}`},
+		},
+	}
+	if got := extractStopReason(resp); got != "MAX_TOKENS" {
+		t.Errorf("stop reason = %q, want MAX_TOKENS", got)
+	}
+}
+
+func TestGeminiStreamingEventEmission(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	var gotQuery, gotPath string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotQuery = r.URL.RawQuery
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(200)
+		w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"a.txt"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":30,"candidatesTokenCount":7}}` + "\n\n"))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	body := `{"contents":[{"role":"user","parts":[{"text":"read a.txt"}]}]}`
+	req := httptest.NewRequest("POST", "/gemini/"+upstreamHost+"/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Session-ID", "gemini-e2e")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if gotPath != "/v1beta/models/gemini-2.5-pro:streamGenerateContent" || gotQuery != "alt=sse" {
+		t.Errorf("upstream got %s?%s", gotPath, gotQuery)
+	}
+	if len(emitter.TurnStartEvents) != 1 {
+		t.Fatalf("Expected 1 turn_start event, got %d", len(emitter.TurnStartEvents))
+	}
+	if emitter.TurnStartEvents[0].Provider != "gemini" {
+		t.Errorf("provider = %q, want gemini", emitter.TurnStartEvents[0].Provider)
+	}
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "read_file" {
+		t.Errorf("ToolCallEvents = %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("Expected 1 turn_end event, got %d", len(emitter.TurnEndEvents))
+	}
+	end := emitter.TurnEndEvents[0]
+	if end.StopReason != "STOP" || end.Tokens.InputTokens != 30 || end.Tokens.OutputTokens != 7 {
+		t.Errorf("turn_end = %+v", end)
+	}
+
+	// Follow-up with the functionResponse continues the same session and
+	// resolves the pending tool call by name.
+	body2 := `{"contents":[{"role":"user","parts":[{"text":"read a.txt"}]},{"role":"model","parts":[{"functionCall":{"name":"read_file","args":{"path":"a.txt"}}}]},{"role":"user","parts":[{"functionResponse":{"name":"read_file","response":{"output":"ok"}}}]}]}`
+	req2 := httptest.NewRequest("POST", "/gemini/"+upstreamHost+"/v1beta/models/gemini-2.5-pro:streamGenerateContent?alt=sse", strings.NewReader(body2))
+	req2.Header.Set("X-Session-ID", "gemini-e2e")
+	proxy.ServeHTTP(httptest.NewRecorder(), req2)
+
+	if len(emitter.ToolResultEvents) != 1 || emitter.ToolResultEvents[0].ToolName != "read_file" {
+		t.Errorf("ToolResultEvents = %+v", emitter.ToolResultEvents)
+	}
+	if emitter.TurnStartEvents[1].SessionID != emitter.TurnStartEvents[0].SessionID {
+		t.Error("follow-up request should continue the same session")
+	}
+}
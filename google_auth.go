@@ -0,0 +1,237 @@
+// google_auth.go
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// googleTokenURL is the default OAuth2 token endpoint for Google credentials.
+const googleTokenURL = "https://oauth2.googleapis.com/token"
+
+// googleCloudPlatformScope is the OAuth scope required for Vertex AI.
+const googleCloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// googleTokenRefreshMargin refreshes cached tokens this long before expiry so
+// a token never expires mid-request.
+const googleTokenRefreshMargin = 5 * time.Minute
+
+// googleCredentials is the union of the fields used by service-account keys
+// ("type": "service_account") and gcloud ADC files ("type": "authorized_user").
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// googleTokenSource mints and caches OAuth2 access tokens from Google
+// credentials. Service accounts use a self-signed RS256 JWT assertion;
+// authorized_user credentials use the refresh_token grant.
+type googleTokenSource struct {
+	creds  googleCredentials
+	key    *rsa.PrivateKey
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// defaultGoogleCredentialsPath returns GOOGLE_APPLICATION_CREDENTIALS, or the
+// gcloud application-default credentials file if that is unset.
+func defaultGoogleCredentialsPath() string {
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "gcloud", "application_default_credentials.json")
+}
+
+// loadGoogleTokenSource reads a service-account key or ADC file.
+// An empty path falls back to defaultGoogleCredentialsPath.
+func loadGoogleTokenSource(path string) (*googleTokenSource, error) {
+	if path == "" {
+		path = defaultGoogleCredentialsPath()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read Google credentials: %w", err)
+	}
+	return newGoogleTokenSource(data)
+}
+
+// newGoogleTokenSource parses Google credentials JSON and validates that it
+// has the fields required for its type.
+func newGoogleTokenSource(data []byte) (*googleTokenSource, error) {
+	var creds googleCredentials
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, fmt.Errorf("parse Google credentials: %w", err)
+	}
+	if creds.TokenURI == "" {
+		creds.TokenURI = googleTokenURL
+	}
+
+	ts := &googleTokenSource{
+		creds:  creds,
+		client: &http.Client{Timeout: 30 * time.Second},
+		now:    time.Now,
+	}
+
+	switch creds.Type {
+	case "service_account":
+		if creds.ClientEmail == "" || creds.PrivateKey == "" {
+			return nil, errors.New("service account credentials missing client_email or private_key")
+		}
+		key, err := parseRSAPrivateKey(creds.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		ts.key = key
+	case "authorized_user":
+		if creds.ClientID == "" || creds.ClientSecret == "" || creds.RefreshToken == "" {
+			return nil, errors.New("authorized_user credentials missing client_id, client_secret or refresh_token")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported Google credentials type %q (want service_account or authorized_user)", creds.Type)
+	}
+
+	return ts, nil
+}
+
+// parseRSAPrivateKey decodes a PEM-encoded PKCS#8 or PKCS#1 RSA private key.
+func parseRSAPrivateKey(pemKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemKey))
+	if block == nil {
+		return nil, errors.New("private_key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		rsaKey, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private_key is not an RSA key")
+		}
+		return rsaKey, nil
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse private_key: %w", err)
+	}
+	return key, nil
+}
+
+// Token returns a valid access token, refreshing it if it is missing or
+// within googleTokenRefreshMargin of expiry.
+func (ts *googleTokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.token != "" && ts.now().Add(googleTokenRefreshMargin).Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	token, expiresIn, err := ts.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	ts.token = token
+	ts.expiry = ts.now().Add(expiresIn)
+	return ts.token, nil
+}
+
+// fetch exchanges the credentials for a new access token.
+func (ts *googleTokenSource) fetch(ctx context.Context) (string, time.Duration, error) {
+	form := url.Values{}
+	if ts.key != nil {
+		assertion, err := ts.signJWT(ts.now())
+		if err != nil {
+			return "", 0, err
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	} else {
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", ts.creds.ClientID)
+		form.Set("client_secret", ts.creds.ClientSecret)
+		form.Set("refresh_token", ts.creds.RefreshToken)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ts.creds.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return "", 0, fmt.Errorf("parse token response: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", 0, errors.New("token response missing access_token")
+	}
+	return tok.AccessToken, time.Duration(tok.ExpiresIn) * time.Second, nil
+}
+
+// signJWT builds the RS256 JWT assertion for the service-account grant.
+func (ts *googleTokenSource) signJWT(now time.Time) (string, error) {
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if ts.creds.PrivateKeyID != "" {
+		header["kid"] = ts.creds.PrivateKeyID
+	}
+	claims := map[string]interface{}{
+		"iss":   ts.creds.ClientEmail,
+		"scope": googleCloudPlatformScope,
+		"aud":   ts.creds.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}
+
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("sign JWT: %w", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
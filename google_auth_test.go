@@ -0,0 +1,170 @@
+// google_auth_test.go
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFakeTokenServer returns a token endpoint that verifies service-account
+// JWT assertions against pub, checks refresh tokens, and counts requests.
+func newFakeTokenServer(t *testing.T, pub *rsa.PublicKey, calls *int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad form", http.StatusBadRequest)
+			return
+		}
+
+		switch r.Form.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:jwt-bearer":
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			if len(parts) != 3 {
+				http.Error(w, "malformed assertion", http.StatusBadRequest)
+				return
+			}
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+				http.Error(w, "bad signature", http.StatusUnauthorized)
+				return
+			}
+			claimsJSON, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			var claims map[string]interface{}
+			json.Unmarshal(claimsJSON, &claims)
+			if claims["scope"] != googleCloudPlatformScope || claims["iss"] != "proxy@test-project.iam.gserviceaccount.com" {
+				http.Error(w, "bad claims", http.StatusUnauthorized)
+				return
+			}
+		case "refresh_token":
+			if r.Form.Get("refresh_token") != "1//refresh" {
+				http.Error(w, "bad refresh token", http.StatusUnauthorized)
+				return
+			}
+		default:
+			http.Error(w, "unsupported grant", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"ya29.test-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testServiceAccountJSON builds a service-account key file for key pointing at tokenURI.
+func testServiceAccountJSON(t *testing.T, key *rsa.PrivateKey, tokenURI string) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKCS8PrivateKey: %v", err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	data, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "proxy@test-project.iam.gserviceaccount.com",
+		"private_key":    string(pemKey),
+		"private_key_id": "key-1",
+		"token_uri":      tokenURI,
+	})
+	return data
+}
+
+func TestGoogleTokenSource_ServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	var calls int32
+	srv := newFakeTokenServer(t, &key.PublicKey, &calls)
+
+	ts, err := newGoogleTokenSource(testServiceAccountJSON(t, key, srv.URL))
+	if err != nil {
+		t.Fatalf("newGoogleTokenSource: %v", err)
+	}
+
+	token, err := ts.Token(context.Background())
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if token != "ya29.test-token" {
+		t.Errorf("token = %q", token)
+	}
+
+	// Cached until close to expiry
+	ts.Token(context.Background())
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("token endpoint called %d times, want 1 (cached)", calls)
+	}
+
+	// Refreshed once inside the refresh margin
+	ts.now = func() time.Time { return time.Now().Add(56 * time.Minute) }
+	ts.Token(context.Background())
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("token endpoint called %d times, want 2 (refreshed)", calls)
+	}
+}
+
+func TestGoogleTokenSource_AuthorizedUser(t *testing.T) {
+	var calls int32
+	srv := newFakeTokenServer(t, nil, &calls)
+
+	data := []byte(`{"type":"authorized_user","client_id":"cid","client_secret":"secret","refresh_token":"1//refresh","token_uri":"` + srv.URL + `"}`)
+	ts, err := newGoogleTokenSource(data)
+	if err != nil {
+		t.Fatalf("newGoogleTokenSource: %v", err)
+	}
+	token, err := ts.Token(context.Background())
+	if err != nil || token != "ya29.test-token" {
+		t.Errorf("Token() = (%q, %v)", token, err)
+	}
+}
+
+func TestGoogleTokenSource_TokenEndpointError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	data := []byte(`{"type":"authorized_user","client_id":"cid","client_secret":"secret","refresh_token":"revoked","token_uri":"` + srv.URL + `"}`)
+	ts, _ := newGoogleTokenSource(data)
+	if _, err := ts.Token(context.Background()); err == nil || !strings.Contains(err.Error(), "invalid_grant") {
+		t.Errorf("expected invalid_grant error, got %v", err)
+	}
+}
+
+func TestNewGoogleTokenSource_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"not json", `nope`},
+		{"unknown type", `{"type":"external_account"}`},
+		{"service account without key", `{"type":"service_account","client_email":"a@b"}`},
+		{"service account bad pem", `{"type":"service_account","client_email":"a@b","private_key":"garbage"}`},
+		{"authorized user without refresh token", `{"type":"authorized_user","client_id":"c","client_secret":"s"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := newGoogleTokenSource([]byte(tt.data)); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
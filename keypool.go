@@ -0,0 +1,317 @@
+// keypool.go
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Upstream API key pools.
+//
+// A pool holds several keys for one provider's upstream. Each attempt sent
+// there has the client's credentials removed and a pool key set instead. The
+// key with the most remaining quota is chosen, from the rate limit headers
+// of its last response (anthropic-ratelimit-* or x-ratelimit-*); ties rotate
+// round-robin. A key that gets a 429 is parked until its limit resets.
+
+// keyPoolDefaultPark is how long a key is parked after a 429 that says
+// nothing about when its limit resets.
+const keyPoolDefaultPark = 30 * time.Second
+
+// rateLimit is one upstream rate limit as last reported for a key.
+type rateLimit struct {
+	remaining int64
+	limit     int64
+	reset     time.Time
+}
+
+// poolKey is a pool key and what is known about its quota.
+type poolKey struct {
+	value string
+	label string // obfuscated, for logs
+
+	mu          sync.Mutex
+	limits      map[string]rateLimit // by limit name, e.g. "requests", "input-tokens"
+	parkedUntil time.Time
+}
+
+// quota returns the fraction of the key's tightest limit still remaining,
+// ignoring limits that have reset since they were reported. A key with no
+// known limits has full quota.
+func (k *poolKey) quota(now time.Time) float64 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	quota := 1.0
+	for _, rl := range k.limits {
+		if rl.limit <= 0 || !rl.reset.After(now) {
+			continue
+		}
+		if q := float64(rl.remaining) / float64(rl.limit); q < quota {
+			quota = q
+		}
+	}
+	return quota
+}
+
+// parked reports whether the key is parked at now, and until when.
+func (k *poolKey) parked(now time.Time) (time.Time, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.parkedUntil, k.parkedUntil.After(now)
+}
+
+// observe records the rate limits reported in a response to a request made
+// with the key, and parks the key if the response is a 429.
+func (k *poolKey) observe(status int, header http.Header, now time.Time) {
+	limits := parseRateLimits(header, now)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for name, rl := range limits {
+		k.limits[name] = rl
+	}
+	if status != http.StatusTooManyRequests {
+		return
+	}
+
+	// Park until the server's hint, or the latest reset of an exhausted limit
+	until := now.Add(keyPoolDefaultPark)
+	if d, ok := serverRetryDelay(header, now); ok {
+		until = now.Add(d)
+	} else {
+		var latest time.Time
+		for _, rl := range limits {
+			if rl.remaining == 0 && rl.reset.After(latest) {
+				latest = rl.reset
+			}
+		}
+		if !latest.IsZero() {
+			until = latest
+		}
+	}
+	if until.After(k.parkedUntil) {
+		k.parkedUntil = until
+	}
+	log.Printf("Key pool: parked %s until %s after 429", k.label, until.Format(time.RFC3339))
+}
+
+// parseRateLimits reads Anthropic (anthropic-ratelimit-{name}-remaining,
+// -limit, -reset as RFC 3339) and OpenAI (x-ratelimit-remaining-{name},
+// limit-, reset- as a duration like "6m0s") rate limit headers.
+func parseRateLimits(h http.Header, now time.Time) map[string]rateLimit {
+	limits := make(map[string]rateLimit)
+	for key := range h {
+		var name, limitKey, resetKey string
+		switch {
+		case strings.HasPrefix(key, "Anthropic-Ratelimit-") && strings.HasSuffix(key, "-Remaining"):
+			base := strings.TrimSuffix(key, "-Remaining")
+			name = strings.ToLower(strings.TrimPrefix(base, "Anthropic-Ratelimit-"))
+			limitKey, resetKey = base+"-Limit", base+"-Reset"
+		case strings.HasPrefix(key, "X-Ratelimit-Remaining-"):
+			suffix := strings.TrimPrefix(key, "X-Ratelimit-Remaining-")
+			name = strings.ToLower(suffix)
+			limitKey, resetKey = "X-Ratelimit-Limit-"+suffix, "X-Ratelimit-Reset-"+suffix
+		default:
+			continue
+		}
+
+		remaining, err := strconv.ParseInt(h.Get(key), 10, 64)
+		if err != nil {
+			continue
+		}
+		limit, _ := strconv.ParseInt(h.Get(limitKey), 10, 64)
+		rl := rateLimit{remaining: remaining, limit: limit}
+		reset := h.Get(resetKey)
+		if t, err := time.Parse(time.RFC3339, reset); err == nil {
+			rl.reset = t
+		} else if d, err := time.ParseDuration(reset); err == nil {
+			rl.reset = now.Add(d)
+		}
+		limits[name] = rl
+	}
+	return limits
+}
+
+// keyPool is the set of keys for one provider upstream.
+type keyPool struct {
+	provider    string
+	upstream    string
+	header      string   // canonical header the key is sent in
+	bearer      bool     // send as "Bearer <key>"
+	authHeaders []string // client credential headers removed before sending
+	keys        []*poolKey
+
+	mu   sync.Mutex
+	next int // round-robin start for the next selection
+}
+
+// pick returns the unparked key with the most remaining quota, starting the
+// scan at a rotating offset so equal keys are used in turn. If every key is
+// parked it returns the one that unparks first.
+func (kp *keyPool) pick(now time.Time) *poolKey {
+	kp.mu.Lock()
+	start := kp.next
+	kp.next = (kp.next + 1) % len(kp.keys)
+	kp.mu.Unlock()
+
+	var best, soonest *poolKey
+	var bestQuota float64
+	var soonestUntil time.Time
+	for i := range kp.keys {
+		k := kp.keys[(start+i)%len(kp.keys)]
+		if until, parked := k.parked(now); parked {
+			if soonest == nil || until.Before(soonestUntil) {
+				soonest, soonestUntil = k, until
+			}
+			continue
+		}
+		if q := k.quota(now); best == nil || q > bestQuota {
+			best, bestQuota = k, q
+		}
+	}
+	if best == nil {
+		return soonest
+	}
+	return best
+}
+
+// send sends req with a pool key in place of the client's credentials and
+// records the response's rate limits against that key. onKey is called with
+// the key's obfuscated label before the request is sent.
+func (kp *keyPool) send(client *http.Client, req *http.Request, onKey func(label string)) (*http.Response, error) {
+	key := kp.pick(time.Now())
+	for _, h := range kp.authHeaders {
+		req.Header.Del(h)
+	}
+	value := key.value
+	if kp.bearer {
+		value = "Bearer " + value
+	}
+	req.Header.Set(kp.header, value)
+	if onKey != nil {
+		onKey(key.label)
+	}
+
+	resp, err := client.Do(req)
+	if err == nil {
+		key.observe(resp.StatusCode, resp.Header, time.Now())
+	}
+	return resp, err
+}
+
+// keyPools maps provider and upstream to a pool.
+type keyPools map[string]*keyPool
+
+func keyPoolID(provider, upstream string) string {
+	return provider + "/" + upstream
+}
+
+// forUpstream returns the pool for requests to upstream via provider, or nil.
+func (kps keyPools) forUpstream(provider, upstream string) *keyPool {
+	return kps[keyPoolID(provider, upstream)]
+}
+
+// newKeyPools validates cfgs against the providers registry and loads key
+// files. Returns nil if no pools are configured.
+func newKeyPools(cfgs []KeyPoolConfig, providers *providerRegistry) (keyPools, error) {
+	if len(cfgs) == 0 {
+		return nil, nil
+	}
+
+	pools := make(keyPools)
+	for _, cfg := range cfgs {
+		spec := providers.lookup(cfg.Provider)
+		if spec == nil {
+			return nil, fmt.Errorf("key_pools: unknown provider %q", cfg.Provider)
+		}
+		upstream := cfg.Upstream
+		if upstream == "" {
+			upstream = spec.Upstream
+		}
+		if upstream == "" {
+			return nil, fmt.Errorf("key_pools: provider %q has no default upstream; set upstream", cfg.Provider)
+		}
+		id := keyPoolID(cfg.Provider, upstream)
+		if _, ok := pools[id]; ok {
+			return nil, fmt.Errorf("key_pools: duplicate pool for %s", id)
+		}
+
+		header := cfg.Header
+		if header == "" {
+			if len(spec.AuthHeaders) == 0 {
+				return nil, fmt.Errorf("key_pools: provider %q has no auth headers; set header", cfg.Provider)
+			}
+			header = spec.AuthHeaders[0]
+		}
+		header = http.CanonicalHeaderKey(header)
+
+		values := append([]string{}, cfg.Keys...)
+		if cfg.KeyFile != "" {
+			fileKeys, err := readKeyFile(cfg.KeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("key_pools: %s: %w", id, err)
+			}
+			values = append(values, fileKeys...)
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("key_pools: %s has no keys", id)
+		}
+
+		pool := &keyPool{
+			provider:    cfg.Provider,
+			upstream:    upstream,
+			header:      header,
+			bearer:      header == "Authorization",
+			authHeaders: append([]string{header}, spec.AuthHeaders...),
+		}
+		for _, v := range values {
+			v = strings.TrimSpace(v)
+			if v == "" {
+				return nil, fmt.Errorf("key_pools: %s has an empty key", id)
+			}
+			pool.keys = append(pool.keys, &poolKey{
+				value:  v,
+				label:  ObfuscateAPIKey(v),
+				limits: make(map[string]rateLimit),
+			})
+		}
+		pools[id] = pool
+	}
+	return pools, nil
+}
+
+// readKeyFile reads one key per line, skipping blank lines and # comments.
+func readKeyFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+// describe summarizes the pools for the startup log, without key material.
+func (kps keyPools) describe() string {
+	var parts []string
+	for id, pool := range kps {
+		parts = append(parts, fmt.Sprintf("%s=%d", id, len(pool.keys)))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,300 @@
+// keypool_test.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimits(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	h := http.Header{}
+	h.Set("anthropic-ratelimit-requests-limit", "50")
+	h.Set("anthropic-ratelimit-requests-remaining", "10")
+	h.Set("anthropic-ratelimit-requests-reset", now.Add(20*time.Second).Format(time.RFC3339))
+	h.Set("x-ratelimit-limit-tokens", "30000")
+	h.Set("x-ratelimit-remaining-tokens", "0")
+	h.Set("x-ratelimit-reset-tokens", "6m0s")
+	h.Set("anthropic-ratelimit-unified-status", "allowed") // not a limit
+
+	limits := parseRateLimits(h, now)
+	if len(limits) != 2 {
+		t.Fatalf("limits = %+v, want requests and tokens", limits)
+	}
+	if rl := limits["requests"]; rl.remaining != 10 || rl.limit != 50 || !rl.reset.Equal(now.Add(20*time.Second)) {
+		t.Errorf("requests = %+v", rl)
+	}
+	if rl := limits["tokens"]; rl.remaining != 0 || rl.limit != 30000 || !rl.reset.Equal(now.Add(6*time.Minute)) {
+		t.Errorf("tokens = %+v", rl)
+	}
+}
+
+// newTestKeyPool returns a pool of the given keys sent in x-api-key.
+func newTestKeyPool(values ...string) *keyPool {
+	pool := &keyPool{header: "X-Api-Key", authHeaders: []string{"X-Api-Key", "Authorization"}}
+	for _, v := range values {
+		pool.keys = append(pool.keys, &poolKey{value: v, label: ObfuscateAPIKey(v), limits: make(map[string]rateLimit)})
+	}
+	return pool
+}
+
+func TestKeyPool_PickRoundRobinsUntilQuotaKnown(t *testing.T) {
+	pool := newTestKeyPool("key-a", "key-b", "key-c")
+	now := time.Now()
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		got = append(got, pool.pick(now).value)
+	}
+	if strings.Join(got, ",") != "key-a,key-b,key-c" {
+		t.Errorf("picks = %v, want round-robin", got)
+	}
+
+	// key-a reports 10% left, key-b 90%; key-c is unknown and assumed full
+	limits := func(remaining string) http.Header {
+		h := http.Header{}
+		h.Set("anthropic-ratelimit-requests-limit", "100")
+		h.Set("anthropic-ratelimit-requests-remaining", remaining)
+		h.Set("anthropic-ratelimit-requests-reset", now.Add(time.Minute).Format(time.RFC3339))
+		return h
+	}
+	pool.keys[0].observe(http.StatusOK, limits("10"), now)
+	pool.keys[1].observe(http.StatusOK, limits("90"), now)
+	pool.keys[2].observe(http.StatusOK, limits("50"), now)
+	for i := 0; i < 3; i++ {
+		if k := pool.pick(now); k.value != "key-b" {
+			t.Errorf("pick = %s, want key-b (most quota)", k.value)
+		}
+	}
+
+	// Once the limits reset, quotas are full again and picks rotate
+	later := now.Add(2 * time.Minute)
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[pool.pick(later).value] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("picks after reset = %v, want all keys", seen)
+	}
+}
+
+func TestKeyPool_ParksAfter429(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"retry-after", http.Header{"Retry-After": {"40"}}, 40 * time.Second},
+		{"exhausted openai limit", http.Header{
+			"X-Ratelimit-Limit-Requests":     {"500"},
+			"X-Ratelimit-Remaining-Requests": {"0"},
+			"X-Ratelimit-Reset-Requests":     {"12s"},
+		}, 12 * time.Second},
+		{"no hint", http.Header{}, keyPoolDefaultPark},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestKeyPool("key-a", "key-b")
+			pool.keys[0].observe(http.StatusTooManyRequests, tt.header, now)
+
+			if until, parked := pool.keys[0].parked(now); !parked || !until.Equal(now.Add(tt.want)) {
+				t.Errorf("parked until %v (%v), want %v", until, parked, now.Add(tt.want))
+			}
+			for i := 0; i < 2; i++ {
+				if k := pool.pick(now); k.value != "key-b" {
+					t.Errorf("pick = %s while key-a is parked", k.value)
+				}
+			}
+
+			// With every key parked, the one unparking first is used
+			pool.keys[1].observe(http.StatusTooManyRequests, http.Header{"Retry-After": {"3600"}}, now)
+			if k := pool.pick(now); k.value != "key-a" {
+				t.Errorf("pick = %s, want key-a (unparks first)", k.value)
+			}
+		})
+	}
+}
+
+func TestNewKeyPools(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "keys")
+	os.WriteFile(keyFile, []byte("# CI keys\nsk-ant-api03-file1\n\n  sk-ant-api03-file2  \n"), 0600)
+
+	tests := []struct {
+		name     string
+		cfg      KeyPoolConfig
+		wantKeys int
+		wantErr  bool
+	}{
+		{"inline and file", KeyPoolConfig{Provider: "anthropic", Keys: []string{"sk-ant-inline"}, KeyFile: keyFile}, 3, false},
+		{"unknown provider", KeyPoolConfig{Provider: "nope", Keys: []string{"k"}}, 0, true},
+		{"no keys", KeyPoolConfig{Provider: "anthropic"}, 0, true},
+		{"missing key file", KeyPoolConfig{Provider: "anthropic", KeyFile: filepath.Join(dir, "missing")}, 0, true},
+		{"no default upstream", KeyPoolConfig{Provider: "azure", Keys: []string{"k"}}, 0, true},
+		{"explicit upstream", KeyPoolConfig{Provider: "azure", Upstream: "res.openai.azure.com", Keys: []string{"k"}}, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pools, err := newKeyPools([]KeyPoolConfig{tt.cfg}, builtinRegistry)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newKeyPools() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil {
+				for _, pool := range pools {
+					if len(pool.keys) != tt.wantKeys {
+						t.Errorf("keys = %d, want %d", len(pool.keys), tt.wantKeys)
+					}
+				}
+			}
+		})
+	}
+
+	pools, _ := newKeyPools([]KeyPoolConfig{
+		{Provider: "anthropic", Keys: []string{"a"}},
+		{Provider: "openai", Keys: []string{"b"}},
+	}, builtinRegistry)
+	if pool := pools.forUpstream("anthropic", "api.anthropic.com"); pool == nil || pool.header != "X-Api-Key" || pool.bearer {
+		t.Errorf("anthropic pool = %+v", pool)
+	}
+	if pool := pools.forUpstream("openai", "api.openai.com"); pool == nil || pool.header != "Authorization" || !pool.bearer {
+		t.Errorf("openai pool = %+v", pool)
+	}
+	if pools.forUpstream("anthropic", "evil.example.com") != nil {
+		t.Error("pool keys must not be sent to other upstreams")
+	}
+
+	if _, err := newKeyPools([]KeyPoolConfig{
+		{Provider: "anthropic", Keys: []string{"a"}},
+		{Provider: "anthropic", Upstream: "api.anthropic.com", Keys: []string{"b"}},
+	}, builtinRegistry); err == nil {
+		t.Error("expected error for duplicate pool")
+	}
+}
+
+func TestProxy_KeyPoolReplacesClientKey(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("x-api-key")+"|"+r.Header.Get("Authorization"))
+		first := len(seen) == 1
+		mu.Unlock()
+
+		if first {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"Hi"}]}`))
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	providers := newTestProviders(t, ProviderConfig{
+		Name:              "anthropic",
+		Upstream:          host,
+		Dialect:           dialectAnthropic,
+		ConversationPaths: []string{"/v1/messages"},
+		AuthHeaders:       []string{"x-api-key", "authorization"},
+	})
+	pools, err := newKeyPools([]KeyPoolConfig{{Provider: "anthropic", Keys: []string{"sk-ant-api03-pool-key-one", "sk-ant-api03-pool-key-two"}}}, providers)
+	if err != nil {
+		t.Fatalf("newKeyPools: %v", err)
+	}
+
+	dir := t.TempDir()
+	fileLogger, _ := NewLogger(dir)
+	defer fileLogger.Close()
+	loki := newMockLokiExporter(nil)
+	proxy := NewProxyWithSessionManagerAndLogger(NewMultiWriter(fileLogger, loki), nil)
+	proxy.keyPools = pools
+	proxy.providers = providers
+	proxy.retry, _ = newRetryPolicy(RetryConfig{MaxRetries: 1, BaseDelayStr: "1ms", MaxDelayStr: "1ms"})
+
+	// The first request's 429 parks key one; the retry and the next request
+	// (whose round-robin turn would be key one) both use key two
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/anthropic/v1/messages", strings.NewReader(`{"model":"claude-3","messages":[{"role":"user","content":"Hello"}]}`))
+		req.Header.Set("x-api-key", "sk-ant-client-key")
+		req.Header.Set("Authorization", "Bearer client-oauth")
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, w.Code)
+		}
+	}
+	want := []string{"sk-ant-api03-pool-key-one|", "sk-ant-api03-pool-key-two|", "sk-ant-api03-pool-key-two|"}
+	if strings.Join(seen, ",") != strings.Join(want, ",") {
+		t.Errorf("upstream saw %v, want %v", seen, want)
+	}
+
+	// Each response entry names the key that served it, obfuscated
+	wantLabels := []string{"sk-ant-...-one", "sk-ant-...-two", "sk-ant-...-two"}
+	var lokiLabels []string
+	for _, call := range loki.pushCalls {
+		if call.entry["type"] == "response" {
+			meta, _ := call.entry["_meta"].(map[string]interface{})
+			label, _ := meta["api_key"].(string)
+			lokiLabels = append(lokiLabels, label)
+		}
+	}
+	if strings.Join(lokiLabels, ",") != strings.Join(wantLabels, ",") {
+		t.Errorf("Loki api_key = %v, want %v", lokiLabels, wantLabels)
+	}
+
+	logs, _ := filepath.Glob(filepath.Join(dir, host, "*", "*.jsonl"))
+	var fileLabels []string
+	for _, logPath := range logs {
+		fileLabels = append(fileLabels, readLoggedKeys(t, logPath)...)
+	}
+	if len(fileLabels) != len(wantLabels) {
+		t.Errorf("file api_key = %v, want %v", fileLabels, wantLabels)
+	}
+	for _, label := range fileLabels {
+		if !strings.HasPrefix(label, "sk-ant-...-") {
+			t.Errorf("file api_key = %q", label)
+		}
+	}
+}
+
+// readLoggedKeys returns the api_key of each response entry in a session
+// log, failing if any entry contains pool key material.
+func readLoggedKeys(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	var labels []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry struct {
+			Type string `json:"type"`
+			Meta struct {
+				APIKey string `json:"api_key"`
+			} `json:"_meta"`
+		}
+		json.Unmarshal(scanner.Bytes(), &entry)
+		if entry.Type == "response" {
+			labels = append(labels, entry.Meta.APIKey)
+		}
+		if strings.Contains(scanner.Text(), "pool-key") {
+			t.Errorf("log entry leaks a pool key: %s", scanner.Text())
+		}
+	}
+	return labels
+}
@@ -4,6 +4,7 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"net/http"
 	"os"
 	"os/user"
@@ -17,18 +18,59 @@ type ResponseTiming struct {
 	TotalMs int64 `json:"total_ms"`
 }
 
+// FailoverRecord describes a request retried on another upstream after the
+// original upstream failed.
+type FailoverRecord struct {
+	From      string `json:"from"`            // upstream host that failed
+	To        string `json:"to"`              // upstream host the request was retried on
+	Status    int    `json:"status"`          // status that triggered failover (502 for connection errors)
+	Model     string `json:"model"`           // model ID on the failover upstream
+	Succeeded bool   `json:"succeeded"`       // false if the failover upstream failed too
+	Error     string `json:"error,omitempty"` // why the failover attempt failed
+}
+
+// RewriteRecord describes rewrite rules applied to a request before it was
+// forwarded. The request entry with the same seq holds what was sent.
+type RewriteRecord struct {
+	Rules           []string    `json:"rules"`                      // rules that changed the request, in order
+	OriginalPath    string      `json:"original_path,omitempty"`    // set if the path changed (Gemini model)
+	OriginalHeaders http.Header `json:"original_headers,omitempty"` // set if headers changed, obfuscated
+	OriginalBody    string      `json:"original_body"`
+}
+
+// PolicyDenial describes a request refused by a policy rule instead of being
+// forwarded. The request entry with the same seq holds what was refused.
+type PolicyDenial struct {
+	Rule    string `json:"rule"`
+	Reason  string `json:"reason"`            // which condition fired, e.g. "model claude-opus-4-6 is not allowed"
+	Message string `json:"message,omitempty"` // the rule's message for the client, if set
+	Status  int    `json:"status"`            // status returned to the client
+}
+
 type StreamChunk struct {
 	Timestamp time.Time `json:"ts"`
 	DeltaMs   int64     `json:"delta_ms"`
 	Raw       string    `json:"raw"`
 }
 
+// requestMetaRecorder is implemented by loggers that add per-request fields,
+// such as the authenticated principal or the pool key used, to the _meta of
+// a request's entries (Logger, MultiWriter).
+type requestMetaRecorder interface {
+	SetRequestMeta(requestID, field, value string)
+	ClearRequestMeta(requestID string)
+}
+
 type Logger struct {
 	baseDir   string
 	machineID string // user@hostname for log aggregation
 	mu        sync.Mutex
 	files     map[string]*os.File
-	upstreams map[string]string // sessionID -> upstream
+	upstreams map[string]string            // sessionID -> upstream
+	reqMeta   map[string]map[string]string // requestID -> extra _meta fields
+	reqModels map[string]string            // requestID -> model, for pricing responses
+	prices    modelPrices
+	providers *providerRegistry // credential headers to obfuscate (nil: baseline only)
 }
 
 func getMachineID() string {
@@ -55,9 +97,25 @@ func NewLogger(baseDir string) (*Logger, error) {
 		machineID: getMachineID(),
 		files:     make(map[string]*os.File),
 		upstreams: make(map[string]string),
+		reqMeta:   make(map[string]map[string]string),
+		reqModels: make(map[string]string),
+		prices:    defaultModelPrices,
 	}, nil
 }
 
+// SetProviders sets the provider registry whose credential headers are
+// obfuscated in logged requests. Call before serving.
+func (l *Logger) SetProviders(providers *providerRegistry) {
+	l.providers = providers
+}
+
+// SetModelPrices replaces the built-in price table used for cost_usd.
+func (l *Logger) SetModelPrices(prices modelPrices) {
+	l.mu.Lock()
+	l.prices = prices
+	l.mu.Unlock()
+}
+
 func (l *Logger) Close() error {
 	l.mu.Lock()
 	defer l.mu.Unlock()
@@ -67,6 +125,8 @@ func (l *Logger) Close() error {
 	}
 	l.files = nil
 	l.upstreams = nil
+	l.reqMeta = nil
+	l.reqModels = nil
 	return nil
 }
 
@@ -142,40 +202,106 @@ func (l *Logger) LogSessionStart(sessionID, provider, upstream string) error {
 		"type":     "session_start",
 		"provider": provider,
 		"upstream": upstream,
-		"_meta": map[string]interface{}{
-			"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-			"machine": l.machineID,
-			"host":    upstream,
-			"session": sessionID,
-		},
+		"_meta":    l.entryMeta(sessionID, ""),
 	}
 	return l.writeEntry(sessionID, entry)
 }
 
 func (l *Logger) LogRequest(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string) error {
-	upstream := l.upstreams[sessionID]
+	meta := l.entryMeta(sessionID, requestID)
 
 	entry := map[string]interface{}{
 		"type":    "request",
 		"seq":     seq,
 		"method":  method,
 		"path":    path,
-		"headers": ObfuscateHeaders(headers),
+		"headers": ObfuscateHeaders(headers, l.providers),
 		"body":    string(body),
 		"size":    len(body),
-		"_meta": map[string]interface{}{
-			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    l.machineID,
-			"host":       upstream,
-			"session":    sessionID,
-			"request_id": requestID,
-		},
+		"_meta":   meta,
+	}
+	if model := modelOfRequest(path, body); model != "" && requestID != "" {
+		l.mu.Lock()
+		if l.reqModels != nil {
+			l.reqModels[requestID] = model
+		}
+		l.mu.Unlock()
 	}
 	return l.writeEntry(sessionID, entry)
 }
 
+// SetRequestMeta adds field to the _meta of the request's entries logged
+// from now on (e.g. principal, api_key), until ClearRequestMeta. An empty
+// value removes the field.
+func (l *Logger) SetRequestMeta(requestID, field, value string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.reqMeta == nil {
+		return
+	}
+	fields := l.reqMeta[requestID]
+	if fields == nil {
+		fields = make(map[string]string)
+		l.reqMeta[requestID] = fields
+	}
+	if value == "" {
+		delete(fields, field)
+	} else {
+		fields[field] = value
+	}
+}
+
+// ClearRequestMeta removes the extra _meta fields and model of a completed
+// request.
+func (l *Logger) ClearRequestMeta(requestID string) {
+	l.mu.Lock()
+	delete(l.reqMeta, requestID)
+	delete(l.reqModels, requestID)
+	l.mu.Unlock()
+}
+
+// usageCost prices usage at the rates of the model the request named, to the
+// millionth of a dollar. Returns false if the model has no price.
+func (l *Logger) usageCost(requestID string, usage UsageInfo) (float64, bool) {
+	l.mu.Lock()
+	model, prices := l.reqModels[requestID], l.prices
+	l.mu.Unlock()
+	price, ok := prices.lookup(model)
+	if !ok {
+		return 0, false
+	}
+	return math.Round(price.cost(usage)*1e6) / 1e6, true
+}
+
+// baseMeta returns the _meta fields every log entry carries. requestID is
+// left out of entries not tied to a request (session start, fork).
+func baseMeta(machineID, sessionID, requestID string) map[string]interface{} {
+	meta := map[string]interface{}{
+		"ts":      time.Now().UTC().Format(time.RFC3339Nano),
+		"machine": machineID,
+		"session": sessionID,
+	}
+	if requestID != "" {
+		meta["request_id"] = requestID
+	}
+	return meta
+}
+
+// entryMeta returns the _meta of an entry for the session: the base fields,
+// the session's upstream host and the request's extra fields.
+func (l *Logger) entryMeta(sessionID, requestID string) map[string]interface{} {
+	meta := baseMeta(l.machineID, sessionID, requestID)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	meta["host"] = l.upstreams[sessionID]
+	for field, value := range l.reqMeta[requestID] {
+		meta[field] = value
+	}
+	return meta
+}
+
 func (l *Logger) LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error {
-	upstream := l.upstreams[sessionID]
+	meta := l.entryMeta(sessionID, requestID)
 
 	entry := map[string]interface{}{
 		"type":    "response",
@@ -184,13 +310,7 @@ func (l *Logger) LogResponse(sessionID, provider string, seq int, status int, he
 		"headers": headers,
 		"timing":  timing,
 		"size":    len(body),
-		"_meta": map[string]interface{}{
-			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    l.machineID,
-			"host":       upstream,
-			"session":    sessionID,
-			"request_id": requestID,
-		},
+		"_meta":   meta,
 	}
 
 	if chunks != nil {
@@ -198,25 +318,78 @@ func (l *Logger) LogResponse(sessionID, provider string, seq int, status int, he
 	} else {
 		entry["body"] = string(body)
 	}
+	if cost, ok := responseCost(l, requestID, status, body, chunks); ok {
+		entry["cost_usd"] = cost
+	}
+
+	return l.writeEntry(sessionID, entry)
+}
+
+// LogFailover records that a request was retried on another upstream. The
+// response entry with the same seq holds what the client received.
+func (l *Logger) LogFailover(sessionID, provider string, seq int, failover FailoverRecord, requestID string) error {
+	meta := l.entryMeta(sessionID, requestID)
+
+	entry := map[string]interface{}{
+		"type":     "failover",
+		"seq":      seq,
+		"failover": failover,
+		"_meta":    meta,
+	}
+	return l.writeEntry(sessionID, entry)
+}
+
+// LogRewrite records the original of a request changed by rewrite rules.
+func (l *Logger) LogRewrite(sessionID, provider string, seq int, rewrite RewriteRecord, requestID string) error {
+	meta := l.entryMeta(sessionID, requestID)
+
+	entry := map[string]interface{}{
+		"type":    "rewrite",
+		"seq":     seq,
+		"rewrite": rewrite,
+		"_meta":   meta,
+	}
+	return l.writeEntry(sessionID, entry)
+}
 
+// LogPolicyDenial records a policy rule refusing a request. It stands in for
+// the request's response.
+func (l *Logger) LogPolicyDenial(sessionID, provider string, seq int, denial PolicyDenial, requestID string) error {
+	meta := l.entryMeta(sessionID, requestID)
+
+	entry := map[string]interface{}{
+		"type":   "policy_denial",
+		"seq":    seq,
+		"policy": denial,
+		"_meta":  meta,
+	}
+	return l.writeEntry(sessionID, entry)
+}
+
+// LogRealtimeEvent records a JSON event sent over a WebSocket connection by
+// direction ("client" or "server"). seq is the handshake request's.
+func (l *Logger) LogRealtimeEvent(sessionID, provider string, seq int, direction string, event []byte, requestID string) error {
+	meta := l.entryMeta(sessionID, requestID)
+
+	entry := map[string]interface{}{
+		"type":      "realtime_event",
+		"seq":       seq,
+		"direction": direction,
+		"body":      string(event),
+		"size":      len(event),
+		"_meta":     meta,
+	}
 	return l.writeEntry(sessionID, entry)
 }
 
 // LogFork records a fork event when conversation history diverges
 func (l *Logger) LogFork(sessionID, provider string, fromSeq int, parentSession string) error {
-	upstream := l.upstreams[sessionID]
-
 	entry := map[string]interface{}{
 		"type":           "fork",
 		"from_seq":       fromSeq,
 		"parent_session": parentSession,
 		"reason":         "message_history_diverged",
-		"_meta": map[string]interface{}{
-			"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-			"machine": l.machineID,
-			"host":    upstream,
-			"session": sessionID,
-		},
+		"_meta":          l.entryMeta(sessionID, ""),
 	}
 	return l.writeEntry(sessionID, entry)
 }
@@ -190,3 +190,39 @@ func TestLogEntryHasMeta(t *testing.T) {
 		t.Errorf("Expected machine format user@host, got %s", machine)
 	}
 }
+
+func TestLoggerResponseCost(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	logger, err := NewLogger(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	defer logger.Close()
+
+	sessionID := "test-session-cost"
+	upstream := "api.anthropic.com"
+	body := `{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":1000,"output_tokens":2000,"cache_read_input_tokens":10000}}`
+
+	logger.LogSessionStart(sessionID, "anthropic", upstream)
+	logger.LogRequest(sessionID, "anthropic", 1, "POST", "/v1/messages", nil, []byte(`{"model":"claude-sonnet-4-5"}`), "req-1")
+	logger.LogResponse(sessionID, "anthropic", 1, 200, http.Header{}, []byte(body), nil, ResponseTiming{}, "req-1")
+	logger.LogRequest(sessionID, "anthropic", 2, "POST", "/v1/messages", nil, []byte(`{"model":"unknown-model"}`), "req-2")
+	logger.LogResponse(sessionID, "anthropic", 2, 200, http.Header{}, []byte(body), nil, ResponseTiming{}, "req-2")
+
+	today := time.Now().Format("2006-01-02")
+	data, _ := os.ReadFile(filepath.Join(tmpDir, upstream, today, sessionID+".jsonl"))
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	var priced, unpriced map[string]interface{}
+	json.Unmarshal([]byte(lines[2]), &priced)
+	json.Unmarshal([]byte(lines[4]), &unpriced)
+
+	// 1000*$3 + 2000*$15 + 10000*$0.30 per million
+	if cost, _ := priced["cost_usd"].(float64); cost != 0.036 {
+		t.Errorf("cost_usd = %v, want 0.036", priced["cost_usd"])
+	}
+	if _, ok := unpriced["cost_usd"]; ok {
+		t.Errorf("unpriced model has cost_usd %v", unpriced["cost_usd"])
+	}
+}
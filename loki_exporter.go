@@ -32,10 +32,11 @@ type PatternData struct {
 
 // TokenData holds token usage metrics for JSON body (not labels)
 type TokenData struct {
-	InputTokens              int `json:"input_tokens"`
-	OutputTokens             int `json:"output_tokens"`
-	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
-	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	InputTokens              int     `json:"input_tokens"`
+	OutputTokens             int     `json:"output_tokens"`
+	CacheReadInputTokens     int     `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int     `json:"cache_creation_input_tokens"`
+	CostUSD                  float64 `json:"cost_usd"` // 0 if the model has no price
 }
 
 // LokiExporterConfig holds configuration for the Loki exporter
@@ -96,8 +97,14 @@ type lokiEntry struct {
 	requestSHA string // SHA256 of raw request body for deterministic replay
 
 	// Transport label distinguishes Bedrock vs direct API traffic
-	transport     string // "direct" or "bedrock"
+	transport     string // "direct", "bedrock" or "vertex"
 	modelOverride string // Caller-injected model ID (Bedrock: from URL path, not body)
+
+	// Client identity authenticated by proxy_auth
+	identity string
+
+	// Rewrite rules that changed the request, comma-separated
+	rewrittenBy string
 }
 
 // LokiExporter handles async batching and pushing logs to Loki
@@ -193,6 +200,27 @@ func extractExtendedLabels(entry map[string]interface{}, logType string) (model,
 			}
 		}
 
+		// Gemini carries the model and streaming mode in the URL path
+		// (/v1beta/models/{model}:streamGenerateContent) rather than the body.
+		if path, ok := entry["path"].(string); ok && isGeminiConversationPath(path) {
+			if model == "" {
+				model = extractGeminiModel(path)
+			}
+			if stream == "" {
+				if strings.HasSuffix(path, ":streamGenerateContent") {
+					stream = "true"
+				} else {
+					stream = "false"
+				}
+			}
+		}
+
+		// Azure deployment paths omit model from the body; the deployment
+		// name is what the client selected.
+		if path, ok := entry["path"].(string); ok && model == "" {
+			model = extractAzureDeployment(path)
+		}
+
 	case "response":
 		// Extract status bucket from HTTP status code
 		if status, ok := entry["status"].(float64); ok {
@@ -264,6 +292,71 @@ func extractStopReasonFromBody(body interface{}) string {
 	if sr, ok := parsed["stop_reason"].(string); ok {
 		return sr
 	}
+	if sr := geminiFinishReason(parsed); sr != "" {
+		return sr
+	}
+	if sr := openAIFinishReason(parsed); sr != "" {
+		return sr
+	}
+	if sr := responsesStopReason(parsed); sr != "" {
+		return sr
+	}
+	if sr := converseStopReason(parsed); sr != "" {
+		return sr
+	}
+	if sr := ollamaStopReason(parsed); sr != "" {
+		return sr
+	}
+	return ""
+}
+
+// geminiFinishReason returns candidates[0].finishReason from a Gemini response event.
+func geminiFinishReason(event map[string]interface{}) string {
+	if !isGeminiResponse(event) {
+		return ""
+	}
+	var parsed ParsedResponse
+	parseGeminiResponse(event, &parsed)
+	return parsed.StopReason
+}
+
+// openAIFinishReason returns choices[0].finish_reason from a Chat Completions
+// response or stream chunk.
+func openAIFinishReason(event map[string]interface{}) string {
+	if choice := firstOpenAIChoice(event); choice != nil {
+		if sr, ok := choice["finish_reason"].(string); ok {
+			return sr
+		}
+	}
+	return ""
+}
+
+// responsesStopReason returns the final status of a Responses API body or
+// terminal stream event (response.completed / incomplete / failed).
+func responsesStopReason(event map[string]interface{}) string {
+	if isResponsesAPIResponse(event) {
+		return responsesStatus(event)
+	}
+	switch event["type"] {
+	case "response.completed", "response.incomplete", "response.failed":
+		if resp, ok := event["response"].(map[string]interface{}); ok {
+			return responsesStatus(resp)
+		}
+	}
+	return ""
+}
+
+// converseStopReason returns stopReason from a Bedrock Converse response body
+// or a normalized messageStop stream event.
+func converseStopReason(event map[string]interface{}) string {
+	if stop, ok := event["messageStop"].(map[string]interface{}); ok {
+		sr, _ := stop["stopReason"].(string)
+		return sr
+	}
+	if isConverseResponse(event) {
+		sr, _ := event["stopReason"].(string)
+		return sr
+	}
 	return ""
 }
 
@@ -300,8 +393,8 @@ func extractChunkRawData(chunks interface{}) []string {
 	return nil
 }
 
-// findStopReasonInChunks searches chunks (from end) for a message_delta event with stop_reason.
-// Chunks may have an SSE "data: " prefix which must be stripped before JSON parsing.
+// findStopReasonInChunks searches chunks (from end) for a message_delta event with stop_reason
+// (or a Gemini finishReason / OpenAI finish_reason / Responses status / Converse stopReason). Chunks may have an SSE "data: " prefix which must be stripped before JSON parsing.
 func findStopReasonInChunks(chunkData []string) string {
 	for i := len(chunkData) - 1; i >= 0; i-- {
 		raw := chunkData[i]
@@ -315,6 +408,21 @@ func findStopReasonInChunks(chunkData []string) string {
 		if err := json.Unmarshal([]byte(raw), &event); err != nil {
 			continue
 		}
+		if sr := geminiFinishReason(event); sr != "" {
+			return sr
+		}
+		if sr := openAIFinishReason(event); sr != "" {
+			return sr
+		}
+		if sr := responsesStopReason(event); sr != "" {
+			return sr
+		}
+		if sr := converseStopReason(event); sr != "" {
+			return sr
+		}
+		if sr := ollamaStopReason(event); sr != "" {
+			return sr
+		}
 		if event["type"] != "message_delta" {
 			continue
 		}
@@ -361,7 +469,7 @@ func (e *LokiExporter) Push(entry map[string]interface{}, provider string) {
 
 	// Extract transport and modelOverride from _meta
 	transport := "direct"
-	var modelOverride string
+	var modelOverride, identity, rewrittenBy string
 	if meta, ok := entry["_meta"].(map[string]interface{}); ok {
 		if t, ok := meta["transport"].(string); ok && t != "" {
 			transport = t
@@ -369,6 +477,12 @@ func (e *LokiExporter) Push(entry map[string]interface{}, provider string) {
 		if mo, ok := meta["model_override"].(string); ok && mo != "" {
 			modelOverride = mo
 		}
+		if id, ok := meta["identity"].(string); ok {
+			identity = id
+		}
+		if rules, ok := meta["rewritten_by"].(string); ok {
+			rewrittenBy = rules
+		}
 	}
 
 	// modelOverride takes precedence over body-parsed model
@@ -399,6 +513,8 @@ func (e *LokiExporter) Push(entry map[string]interface{}, provider string) {
 		requestSHA:      requestSHA,
 		transport:       transport,
 		modelOverride:   modelOverride,
+		identity:        identity,
+		rewrittenBy:     rewrittenBy,
 	}
 
 	// Non-blocking send with drop if full
@@ -510,9 +626,15 @@ func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 		if entry.transport != "" {
 			labels["transport"] = entry.transport
 		}
+		if entry.identity != "" {
+			labels["identity"] = entry.identity
+		}
+		if entry.rewrittenBy != "" {
+			labels["rewritten_by"] = entry.rewrittenBy
+		}
 
 		// Create label key for grouping (include all labels for proper stream separation)
-		labelKey := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s",
+		labelKey := fmt.Sprintf("%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s|%s",
 			labels["app"],
 			labels["provider"],
 			labels["environment"],
@@ -528,6 +650,8 @@ func (e *LokiExporter) sendBatch(entries []lokiEntry) {
 			entry.isRetry,
 			entry.errorType,
 			entry.transport,
+			entry.identity,
+			entry.rewrittenBy,
 		)
 
 		// Get or create stream for this label set
@@ -801,6 +925,7 @@ func (e *LokiExporter) EmitTurnEnd(sessionID, provider, machine, stopReason stri
 		"output_tokens":                tokens.OutputTokens,
 		"cache_read_input_tokens":      tokens.CacheReadInputTokens,
 		"cache_creation_input_tokens":  tokens.CacheCreationInputTokens,
+		"cost_usd":                     tokens.CostUSD,
 	}
 
 	e.emitEvent(sessionID, provider, machine, LogTypeTurnEnd, labels, body)
@@ -2099,3 +2099,50 @@ func TestLokiExporter_DifferentTransportsSeparateStreams(t *testing.T) {
 		t.Errorf("expected 2 streams (different transports), got %d", len(receivedPayload.Streams))
 	}
 }
+
+func TestLokiExporter_IdentityLabel(t *testing.T) {
+	var receivedPayload LokiPushRequest
+	var mu sync.Mutex
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := io.ReadAll(r.Body)
+		json.Unmarshal(body, &receivedPayload)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	exporter, err := NewLokiExporter(LokiExporterConfig{
+		URL:       server.URL,
+		BatchSize: 1,
+		BatchWait: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewLokiExporter: %v", err)
+	}
+
+	entry := map[string]interface{}{
+		"type": "request",
+		"body": `{"model":"claude-sonnet-4-20250514","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`,
+		"_meta": map[string]interface{}{
+			"ts":       time.Now().Format(time.RFC3339Nano),
+			"machine":  "test-machine",
+			"identity": "ci-runner",
+		},
+	}
+	exporter.Push(entry, "anthropic")
+
+	time.Sleep(200 * time.Millisecond)
+	exporter.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(receivedPayload.Streams) == 0 {
+		t.Fatal("expected at least one stream")
+	}
+	if got := receivedPayload.Streams[0].Stream["identity"]; got != "ci-runner" {
+		t.Errorf("identity = %q, want 'ci-runner'", got)
+	}
+}
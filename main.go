@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
@@ -13,12 +14,17 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
 type CLIFlags struct {
 	Port        int
+	Bind        string
+	Socket      string
+	SocketOnly  bool
 	LogDir      string
 	ConfigPath  string
 	ServiceMode bool
@@ -29,6 +35,9 @@ type CLIFlags struct {
 	Status      bool
 	Explore     bool
 	ExplorePort int
+	SetupCA     bool
+	Vault       VaultCommand
+	Budget      BudgetCommand
 }
 
 func ParseCLIFlags(args []string) (CLIFlags, error) {
@@ -36,6 +45,9 @@ func ParseCLIFlags(args []string) (CLIFlags, error) {
 
 	var flags CLIFlags
 	fs.IntVar(&flags.Port, "port", 0, "Port to listen on")
+	fs.StringVar(&flags.Bind, "bind", "", "Address to listen on (default 127.0.0.1; other addresses require proxy_auth or client certificates)")
+	fs.StringVar(&flags.Socket, "socket", "", "Also listen on a Unix socket at this path (mode 0600)")
+	fs.BoolVar(&flags.SocketOnly, "socket-only", false, "Listen only on the Unix socket (default path "+DefaultSocketPath()+")")
 	fs.StringVar(&flags.LogDir, "log-dir", "", "Directory for log files")
 	fs.StringVar(&flags.ConfigPath, "config", "", "Path to config file")
 	fs.BoolVar(&flags.ServiceMode, "service", false, "Run as background service (dynamic port, write portfile)")
@@ -44,8 +56,18 @@ func ParseCLIFlags(args []string) (CLIFlags, error) {
 	fs.BoolVar(&flags.Setup, "setup", false, "Full setup: install systemd service, enable, start, and configure shell")
 	fs.BoolVar(&flags.Uninstall, "uninstall", false, "Uninstall: stop service, remove service file, remove shell patches, remove portfile")
 	fs.BoolVar(&flags.Status, "status", false, "Show proxy status and exit")
+	fs.BoolVar(&flags.SetupCA, "setup-ca", false, "Create the local CA for forward-proxy mode and exit")
 	fs.BoolVar(&flags.Explore, "explore", false, "Start log explorer web UI")
 	fs.IntVar(&flags.ExplorePort, "explore-port", 8080, "Port for explorer web UI")
+	fs.StringVar(&flags.Vault.Issue, "vault-issue", "", "Issue a proxy token for a principal (user:NAME or team:NAME), print it and exit")
+	fs.StringVar(&flags.Vault.Providers, "vault-providers", "", "Comma-separated providers an issued token may use (default all)")
+	fs.DurationVar(&flags.Vault.TTL, "vault-ttl", vaultDefaultTTL, "Lifetime of an issued token")
+	fs.StringVar(&flags.Vault.Revoke, "vault-revoke", "", "Revoke a token by ID, or all tokens of a principal, and exit")
+	fs.BoolVar(&flags.Vault.List, "vault-list", false, "List vault tokens and credentials and exit")
+	fs.StringVar(&flags.Vault.SetKey, "vault-set-key", "", "Store the upstream key read from stdin for a provider and exit")
+	fs.StringVar(&flags.Vault.Upstream, "vault-upstream", "", "Upstream host for --vault-set-key (default: the provider's)")
+	fs.BoolVar(&flags.Budget.List, "budgets", false, "Show budget usage and exit")
+	fs.StringVar(&flags.Budget.Reset, "budget-reset", "", "Reset budget usage for a scope (session, machine or key), optionally scope:subject, and exit")
 
 	if err := fs.Parse(args); err != nil {
 		return CLIFlags{}, err
@@ -58,6 +80,15 @@ func MergeConfig(cfg Config, flags CLIFlags) Config {
 	if flags.Port != 0 {
 		cfg.Port = flags.Port
 	}
+	if flags.Bind != "" {
+		cfg.Bind = flags.Bind
+	}
+	if flags.Socket != "" {
+		cfg.Socket = flags.Socket
+	}
+	if flags.SocketOnly {
+		cfg.SocketOnly = true
+	}
 	if flags.LogDir != "" {
 		cfg.LogDir = flags.LogDir
 	}
@@ -124,6 +155,59 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Handle --vault-*: manage the credential vault and exit
+	if flags.Vault.isSet() {
+		providers, err := newProviderRegistry(cfg.Providers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := runVaultCommand(flags.Vault, cfg.Vault.Path, providers, os.Stdin, os.Stdout, time.Now()); err != nil {
+			fmt.Fprintf(os.Stderr, "Vault: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle --budgets / --budget-reset: show or reset budget usage and exit
+	if flags.Budget.isSet() {
+		logDir := cfg.LogDir
+		// Same log directory as the service unless --log-dir is set
+		if flags.LogDir == "" {
+			home, _ := os.UserHomeDir()
+			logDir = filepath.Join(home, ".llm-provider-logs")
+		}
+		if err := runBudgetCommand(flags.Budget, cfg.Budget, filepath.Join(logDir, "sessions.db"), os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "Budgets: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Handle --setup-ca: create the forward-proxy CA and exit
+	if flags.SetupCA {
+		dir := cfg.ForwardProxy.CADir
+		if dir == "" {
+			dir = DefaultCADir()
+		}
+		providers, err := newProviderRegistry(cfg.Providers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		created, err := ensureLocalCA(dir, interceptedHosts(providers, cfg.ForwardProxy.Hosts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating CA: %v\n", err)
+			os.Exit(1)
+		}
+		if created {
+			fmt.Printf("Created local CA in %s\n", dir)
+		} else {
+			fmt.Printf("Local CA already exists in %s\n", dir)
+		}
+		os.Exit(0)
+	}
+
 	// Handle --status: show proxy status and exit
 	if cfg.Status {
 		Status()
@@ -133,26 +217,40 @@ func main() {
 	// Handle --env: output environment variables for shell eval and exit
 	if cfg.Env {
 		// Read portfile
-		port, err := ReadPortfile(DefaultPortfilePath())
+		pf, err := ReadPortfile(DefaultPortfilePath())
 		if err != nil {
 			// Proxy not configured, output nothing
 			os.Exit(0)
 		}
 
 		// Health check
-		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/health", port))
-		if err != nil || resp.StatusCode != 200 {
+		if !pf.Healthy() {
 			// Proxy not running, output nothing
-			if resp != nil {
-				resp.Body.Close()
-			}
 			os.Exit(0)
 		}
-		resp.Body.Close()
 
-		// Output exports
-		fmt.Printf("export ANTHROPIC_BASE_URL=\"http://localhost:%d/anthropic/api.anthropic.com\"\n", port)
-		fmt.Printf("export OPENAI_BASE_URL=\"http://localhost:%d/openai/api.openai.com\"\n", port)
+		// Output exports. HTTP base URLs need the TCP port; socket-aware
+		// clients can use LLM_PROXY_SOCKET instead.
+		if pf.Socket != "" {
+			fmt.Printf("export LLM_PROXY_SOCKET=\"%s\"\n", pf.Socket)
+		}
+		if pf.Port != 0 {
+			fmt.Printf("export ANTHROPIC_BASE_URL=\"%s://localhost:%d/anthropic/api.anthropic.com\"\n", pf.Scheme(), pf.Port)
+			fmt.Printf("export OPENAI_BASE_URL=\"%s://localhost:%d/openai/api.openai.com\"\n", pf.Scheme(), pf.Port)
+			fmt.Printf("export GOOGLE_GEMINI_BASE_URL=\"%s://localhost:%d/gemini/generativelanguage.googleapis.com\"\n", pf.Scheme(), pf.Port)
+		}
+		// Forward-proxy mode: tools that ignore base URLs go through
+		// HTTPS_PROXY and trust the local CA
+		if pf.CADir != "" && pf.Port != 0 {
+			fmt.Printf("export HTTPS_PROXY=\"%s://localhost:%d\"\n", pf.Scheme(), pf.Port)
+			fmt.Printf("export NO_PROXY=\"localhost,127.0.0.1,::1\"\n")
+			fmt.Printf("export NODE_EXTRA_CA_CERTS=\"%s\"\n", filepath.Join(pf.CADir, caCertFile))
+			bundle := filepath.Join(pf.CADir, caBundleFile)
+			if _, err := os.Stat(bundle); err == nil {
+				fmt.Printf("export SSL_CERT_FILE=\"%s\"\n", bundle)
+				fmt.Printf("export CURL_CA_BUNDLE=\"%s\"\n", bundle)
+			}
+		}
 		os.Exit(0)
 	}
 
@@ -167,7 +265,11 @@ func main() {
 
 	// Handle --setup: full Linux installation
 	if cfg.Setup {
-		if err := FullSetup(); err != nil {
+		providers, err := newProviderRegistry(cfg.Providers)
+		if err != nil {
+			log.Fatalf("Setup failed: %v", err)
+		}
+		if err := FullSetup(interceptedHosts(providers, cfg.ForwardProxy.Hosts)); err != nil {
 			log.Fatalf("Setup failed: %v", err)
 		}
 		fmt.Println("LLM Proxy installed and started.")
@@ -220,34 +322,83 @@ func main() {
 		}
 	}
 
-	srv, err := NewServer(cfg)
+	if cfg.SocketOnly && cfg.Socket == "" {
+		cfg.Socket = DefaultSocketPath()
+	}
+	if err := checkBindAuth(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	tlsCerts, err := newTLSReloader(cfg.TLS)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating server: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Bind to localhost only — the proxy has no authentication, so binding to
-	// all interfaces would allow unauthenticated access if security groups are
-	// misconfigured. In ECS awsvpc mode, localhost is shared between containers
-	// in the same task, so the PA container can still reach the proxy.
-	addr := fmt.Sprintf("127.0.0.1:%d", cfg.Port)
-	listener, err := net.Listen("tcp", addr)
+	srv, err := NewServer(cfg)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error binding to %s: %v\n", addr, err)
+		fmt.Fprintf(os.Stderr, "Error creating server: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Get actual port (important for service mode with port 0)
-	actualPort := listener.Addr().(*net.TCPAddr).Port
+	// Bind to localhost by default. Other addresses are only allowed with
+	// proxy_auth or client certificates (checked above), since without them
+	// anyone who can reach the port can use the proxy. In ECS awsvpc mode,
+	// localhost is shared between containers in the same task, so the PA
+	// container can still reach it.
+	var listeners []net.Listener
+	var listening []string
+	var portfile Portfile
+	if !cfg.SocketOnly {
+		addr := net.JoinHostPort(cfg.Bind, strconv.Itoa(cfg.Port))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error binding to %s: %v\n", addr, err)
+			os.Exit(1)
+		}
+
+		// Get actual port (important for service mode with port 0)
+		portfile.Port = listener.Addr().(*net.TCPAddr).Port
+
+		// Serve HTTPS if configured
+		scheme := "http"
+		if tlsCerts != nil {
+			scheme = "https"
+			portfile.TLS = true
+			listener = tls.NewListener(listener, tlsCerts.config())
+		}
+		listeners = append(listeners, listener)
+		listening = append(listening, scheme+"://"+addr)
+	}
+
+	// A Unix socket is only reachable by the user running the proxy, unlike
+	// a localhost port, which every local user can connect to
+	if cfg.Socket != "" {
+		listener, err := listenUnix(cfg.Socket)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error listening on socket %s: %v\n", cfg.Socket, err)
+			os.Exit(1)
+		}
+		portfile.Socket = cfg.Socket
+		listeners = append(listeners, listener)
+		listening = append(listening, "unix:"+cfg.Socket)
+	}
 
 	// In service mode, write portfile
+	if cfg.ForwardProxy.Enabled && portfile.Port != 0 {
+		portfile.CADir = cfg.ForwardProxy.CADir
+		if portfile.CADir == "" {
+			portfile.CADir = DefaultCADir()
+		}
+	}
 	if cfg.ServiceMode {
 		portfilePath := DefaultPortfilePath()
-		if err := WritePortfile(portfilePath, actualPort); err != nil {
+		if err := WritePortfile(portfilePath, portfile); err != nil {
 			fmt.Fprintf(os.Stderr, "Error writing portfile: %v\n", err)
 			os.Exit(1)
 		}
-		log.Printf("Wrote port %d to %s", actualPort, portfilePath)
+		log.Printf("Wrote %s to %s", strings.Join(listening, ", "), portfilePath)
 	}
 
 	httpSrv := &http.Server{
@@ -259,6 +410,21 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// SIGHUP reloads the certificate files
+	if tlsCerts != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := tlsCerts.reload(); err != nil {
+					log.Printf("TLS reload failed, keeping previous certificate: %v", err)
+				} else {
+					log.Printf("TLS: reloaded %s", cfg.TLS.CertFile)
+				}
+			}
+		}()
+	}
+
 	go func() {
 		<-ctx.Done()
 		log.Println("Shutting down gracefully...")
@@ -272,7 +438,10 @@ func main() {
 		srv.Close()
 	}()
 
-	log.Printf("Starting llm-proxy on %s", addr)
+	log.Printf("Starting llm-proxy on %s", strings.Join(listening, " and "))
+	if cfg.TLS.ClientCAFile != "" {
+		log.Printf("TLS: client certificates required (%s)", cfg.TLS.ClientCAFile)
+	}
 	log.Printf("Log directory: %s", cfg.LogDir)
 	if cfg.Loki.Enabled {
 		log.Printf("Loki export: enabled (%s)", cfg.Loki.URL)
@@ -280,7 +449,11 @@ func main() {
 		log.Printf("Loki export: disabled")
 	}
 
-	if err := httpSrv.Serve(listener); err != nil && err != http.ErrServerClosed {
+	serveErr := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func() { serveErr <- httpSrv.Serve(listener) }()
+	}
+	if err := <-serveErr; err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server error: %v", err)
 	}
 }
@@ -72,3 +72,15 @@ func TestParseCLIFlagsUninstall(t *testing.T) {
 		t.Error("expected Uninstall flag to be true")
 	}
 }
+
+func TestParseCLIFlagsSocket(t *testing.T) {
+	flags, err := ParseCLIFlags([]string{"--socket", "/run/user/1000/llm-proxy.sock", "--socket-only"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg := MergeConfig(DefaultConfig(), flags)
+	if cfg.Socket != "/run/user/1000/llm-proxy.sock" || !cfg.SocketOnly {
+		t.Errorf("expected socket-only on /run/user/1000/llm-proxy.sock, got socket %q only %v", cfg.Socket, cfg.SocketOnly)
+	}
+}
@@ -8,9 +8,7 @@ import (
 	"net/http"
 	"os"
 	"os/user"
-	"strings"
 	"sync"
-	"time"
 )
 
 // LokiPusher is the interface for pushing entries to Loki.
@@ -29,10 +27,12 @@ type AgentEventEmitter interface {
 	EmitToolResult(sessionID, provider, machine, toolName, toolUseID string, isError bool)
 }
 
-// bedrockContext holds per-request Bedrock metadata for Loki labels.
+// bedrockContext holds per-request cloud transport metadata (Bedrock, Vertex)
+// for Loki labels.
 type bedrockContext struct {
-	transport string
-	modelID   string
+	transport    string
+	modelID      string
+	failoverFrom string // original upstream when the request failed over
 }
 
 // MultiWriter fans out log entries to both a file logger (primary) and a Loki
@@ -46,6 +46,13 @@ type MultiWriter struct {
 	// bedrockContexts stores per-request Bedrock metadata keyed by requestID.
 	// Set by serveBedrock before logging; consumed by LogRequest/LogResponse.
 	bedrockContexts sync.Map
+
+	// requestMeta stores extra _meta fields (principal, api_key) keyed by
+	// requestID, as an immutable map[string]string replaced on each set.
+	requestMeta sync.Map
+
+	// providers lists the credential headers to obfuscate (nil: baseline only)
+	providers *providerRegistry
 }
 
 // NewMultiWriter creates a new MultiWriter that writes to both the file logger
@@ -68,20 +75,102 @@ func NewMultiWriterWithCloseOrder(file ProxyLogger, loki LokiPusher, closeOrder
 	}
 }
 
+// SetProviders sets the provider registry whose credential headers are
+// obfuscated in the Loki request entries. Call before serving.
+func (m *MultiWriter) SetProviders(providers *providerRegistry) {
+	m.providers = providers
+}
+
 // SetBedrockContext stores Bedrock metadata for a request, so LogRequest
 // and LogResponse can add transport and model_override to _meta.
 func (m *MultiWriter) SetBedrockContext(requestID, modelID string) {
+	m.SetTransportContext(requestID, "bedrock", modelID)
+}
+
+// ClearBedrockContext removes Bedrock metadata for a completed request.
+func (m *MultiWriter) ClearBedrockContext(requestID string) {
+	m.ClearTransportContext(requestID)
+}
+
+// SetTransportContext stores the transport label (e.g., "vertex") and model
+// for a signed cloud pass-through request.
+func (m *MultiWriter) SetTransportContext(requestID, transport, modelID string) {
 	m.bedrockContexts.Store(requestID, bedrockContext{
-		transport: "bedrock",
+		transport: transport,
 		modelID:   modelID,
 	})
 }
 
-// ClearBedrockContext removes Bedrock metadata for a completed request.
-func (m *MultiWriter) ClearBedrockContext(requestID string) {
+// SetFailoverContext marks a request as failed over from the original
+// upstream to transport, so its response entry is labeled with the
+// transport and model actually used.
+func (m *MultiWriter) SetFailoverContext(requestID, transport, modelID, from string) {
+	m.bedrockContexts.Store(requestID, bedrockContext{
+		transport:    transport,
+		modelID:      modelID,
+		failoverFrom: from,
+	})
+}
+
+// ClearTransportContext removes transport metadata for a completed request.
+func (m *MultiWriter) ClearTransportContext(requestID string) {
 	m.bedrockContexts.Delete(requestID)
 }
 
+// SetRequestMeta adds field to the _meta of the request's entries in both
+// destinations until ClearRequestMeta. An empty value removes the field.
+func (m *MultiWriter) SetRequestMeta(requestID, field, value string) {
+	fields := make(map[string]string)
+	if prev, ok := m.requestMeta.Load(requestID); ok {
+		for k, v := range prev.(map[string]string) {
+			fields[k] = v
+		}
+	}
+	if value == "" {
+		delete(fields, field)
+	} else {
+		fields[field] = value
+	}
+	m.requestMeta.Store(requestID, fields)
+
+	if rec, ok := m.file.(requestMetaRecorder); ok {
+		rec.SetRequestMeta(requestID, field, value)
+	}
+}
+
+// ClearRequestMeta removes the extra _meta fields of a completed request.
+func (m *MultiWriter) ClearRequestMeta(requestID string) {
+	m.requestMeta.Delete(requestID)
+	if rec, ok := m.file.(requestMetaRecorder); ok {
+		rec.ClearRequestMeta(requestID)
+	}
+}
+
+// usageCost prices usage with the file logger, which records request models.
+func (m *MultiWriter) usageCost(requestID string, usage UsageInfo) (float64, bool) {
+	if coster, ok := m.file.(usageCoster); ok {
+		return coster.usageCost(requestID, usage)
+	}
+	return 0, false
+}
+
+// entryMeta returns the _meta of a Loki entry: the base fields and the
+// request's extra fields.
+func (m *MultiWriter) entryMeta(sessionID, requestID string) map[string]interface{} {
+	meta := baseMeta(m.machineID, sessionID, requestID)
+	m.addRequestMeta(meta, requestID)
+	return meta
+}
+
+// addRequestMeta copies the request's extra fields into meta.
+func (m *MultiWriter) addRequestMeta(meta map[string]interface{}, requestID string) {
+	if fields, ok := m.requestMeta.Load(requestID); ok {
+		for k, v := range fields.(map[string]string) {
+			meta[k] = v
+		}
+	}
+}
+
 // addBedrockMeta adds transport and model_override to the _meta map if
 // Bedrock context exists for this request, or detects Bedrock from path.
 func (m *MultiWriter) addBedrockMetaByRequestID(meta map[string]interface{}, requestID string) {
@@ -91,16 +180,25 @@ func (m *MultiWriter) addBedrockMetaByRequestID(meta map[string]interface{}, req
 		if bc.modelID != "" {
 			meta["model_override"] = bc.modelID
 		}
+		if bc.failoverFrom != "" {
+			meta["failover_from"] = bc.failoverFrom
+		}
 	}
 }
 
 // addBedrockMeta adds transport and model_override to _meta based on path.
 // Used by LogRequest which has the request path available.
 func addBedrockMeta(meta map[string]interface{}, path string) {
-	if !strings.HasPrefix(path, "/model/") {
+	if route, err := parseVertexPath(path); err == nil {
+		meta["transport"] = "vertex"
+		meta["model_override"] = route.model
+		return
+	}
+	if !isBedrockPath(path) {
 		return
 	}
 	meta["transport"] = "bedrock"
+	_, path = splitBedrockRegionPrefix(path)
 	modelID, err := extractModelID(path)
 	if err == nil && modelID != "" {
 		meta["model_override"] = modelID
@@ -134,16 +232,13 @@ func (m *MultiWriter) LogSessionStart(sessionID, provider, upstream string) erro
 	err := m.file.LogSessionStart(sessionID, provider, upstream)
 
 	if m.loki != nil {
+		meta := m.entryMeta(sessionID, "")
+		meta["host"] = upstream
 		entry := map[string]interface{}{
 			"type":     "session_start",
 			"provider": provider,
 			"upstream": upstream,
-			"_meta": map[string]interface{}{
-				"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-				"machine": m.machineID,
-				"host":    upstream,
-				"session": sessionID,
-			},
+			"_meta":    meta,
 		}
 		m.loki.Push(entry, provider)
 	}
@@ -161,20 +256,18 @@ func (m *MultiWriter) LogRequest(sessionID, provider string, seq int, method, pa
 		bodyHash := sha256.Sum256(body)
 		bodySHA := hex.EncodeToString(bodyHash[:])
 
-		meta := map[string]interface{}{
-			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    m.machineID,
-			"session":    sessionID,
-			"request_id": requestID,
-		}
+		meta := m.entryMeta(sessionID, requestID)
 		addBedrockMeta(meta, path)
+		// Per-request context wins: it holds the decoded model ID even when
+		// the logged path can't be re-parsed (e.g. inference profile ARNs)
+		m.addBedrockMetaByRequestID(meta, requestID)
 
 		entry := map[string]interface{}{
 			"type":        "request",
 			"seq":         seq,
 			"method":      method,
 			"path":        path,
-			"headers":     ObfuscateHeaders(headers),
+			"headers":     ObfuscateHeaders(headers, m.providers),
 			"body":        string(body),
 			"size":        len(body),
 			"request_sha": bodySHA,
@@ -192,12 +285,7 @@ func (m *MultiWriter) LogResponse(sessionID, provider string, seq int, status in
 	err := m.file.LogResponse(sessionID, provider, seq, status, headers, body, chunks, timing, requestID)
 
 	if m.loki != nil {
-		meta := map[string]interface{}{
-			"ts":         time.Now().UTC().Format(time.RFC3339Nano),
-			"machine":    m.machineID,
-			"session":    sessionID,
-			"request_id": requestID,
-		}
+		meta := m.entryMeta(sessionID, requestID)
 		// Add Bedrock metadata if this request was a Bedrock pass-through
 		m.addBedrockMetaByRequestID(meta, requestID)
 
@@ -216,6 +304,9 @@ func (m *MultiWriter) LogResponse(sessionID, provider string, seq int, status in
 		} else {
 			entry["body"] = string(body)
 		}
+		if cost, ok := responseCost(m, requestID, status, body, chunks); ok {
+			entry["cost_usd"] = cost
+		}
 
 		m.loki.Push(entry, provider)
 	}
@@ -234,11 +325,85 @@ func (m *MultiWriter) LogFork(sessionID, provider string, fromSeq int, parentSes
 			"from_seq":       fromSeq,
 			"parent_session": parentSession,
 			"reason":         "message_history_diverged",
-			"_meta": map[string]interface{}{
-				"ts":      time.Now().UTC().Format(time.RFC3339Nano),
-				"machine": m.machineID,
-				"session": sessionID,
-			},
+			"_meta":          m.entryMeta(sessionID, ""),
+		}
+		m.loki.Push(entry, provider)
+	}
+
+	return err
+}
+
+// LogFailover logs a failover to both destinations. In Loki the entry is
+// labeled with the failover transport and model.
+// File errors are returned; Loki errors are logged but don't fail.
+func (m *MultiWriter) LogFailover(sessionID, provider string, seq int, failover FailoverRecord, requestID string) error {
+	err := m.file.LogFailover(sessionID, provider, seq, failover, requestID)
+
+	if m.loki != nil {
+		meta := m.entryMeta(sessionID, requestID)
+		m.addBedrockMetaByRequestID(meta, requestID)
+
+		entry := map[string]interface{}{
+			"type":     "failover",
+			"seq":      seq,
+			"failover": failover,
+			"_meta":    meta,
+		}
+		m.loki.Push(entry, provider)
+	}
+
+	return err
+}
+
+// LogRewrite logs a request's rewrite to both destinations.
+// File errors are returned; Loki errors are logged but don't fail.
+func (m *MultiWriter) LogRewrite(sessionID, provider string, seq int, rewrite RewriteRecord, requestID string) error {
+	err := m.file.LogRewrite(sessionID, provider, seq, rewrite, requestID)
+
+	if m.loki != nil {
+		entry := map[string]interface{}{
+			"type":    "rewrite",
+			"seq":     seq,
+			"rewrite": rewrite,
+			"_meta":   m.entryMeta(sessionID, requestID),
+		}
+		m.loki.Push(entry, provider)
+	}
+
+	return err
+}
+
+// LogPolicyDenial logs a policy denial to both destinations.
+// File errors are returned; Loki errors are logged but don't fail.
+func (m *MultiWriter) LogPolicyDenial(sessionID, provider string, seq int, denial PolicyDenial, requestID string) error {
+	err := m.file.LogPolicyDenial(sessionID, provider, seq, denial, requestID)
+
+	if m.loki != nil {
+		entry := map[string]interface{}{
+			"type":   "policy_denial",
+			"seq":    seq,
+			"policy": denial,
+			"_meta":  m.entryMeta(sessionID, requestID),
+		}
+		m.loki.Push(entry, provider)
+	}
+
+	return err
+}
+
+// LogRealtimeEvent logs a WebSocket event to both destinations.
+// File errors are returned; Loki errors are logged but don't fail.
+func (m *MultiWriter) LogRealtimeEvent(sessionID, provider string, seq int, direction string, event []byte, requestID string) error {
+	err := m.file.LogRealtimeEvent(sessionID, provider, seq, direction, event, requestID)
+
+	if m.loki != nil {
+		entry := map[string]interface{}{
+			"type":      "realtime_event",
+			"seq":       seq,
+			"direction": direction,
+			"body":      string(event),
+			"size":      len(event),
+			"_meta":     m.entryMeta(sessionID, requestID),
 		}
 		m.loki.Push(entry, provider)
 	}
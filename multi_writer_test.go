@@ -19,6 +19,10 @@ type mockFileLogger struct {
 	requestCalls          []requestCall
 	responseCalls         []responseCall
 	forkCalls             []forkCall
+	failoverCalls         []FailoverRecord
+	realtimeCalls         []realtimeCall
+	rewriteCalls          []RewriteRecord
+	denialCalls           []PolicyDenial
 	closeCalls            int
 	closeError            error
 
@@ -70,6 +74,13 @@ type forkCall struct {
 	parentSession string
 }
 
+type realtimeCall struct {
+	sessionID string
+	direction string
+	event     string
+	requestID string
+}
+
 func newMockFileLogger() *mockFileLogger {
 	return &mockFileLogger{}
 }
@@ -108,6 +119,34 @@ func (m *mockFileLogger) LogFork(sessionID, provider string, fromSeq int, parent
 	return m.forkError
 }
 
+func (m *mockFileLogger) LogFailover(sessionID, provider string, seq int, failover FailoverRecord, requestID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failoverCalls = append(m.failoverCalls, failover)
+	return nil
+}
+
+func (m *mockFileLogger) LogRewrite(sessionID, provider string, seq int, rewrite RewriteRecord, requestID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rewriteCalls = append(m.rewriteCalls, rewrite)
+	return nil
+}
+
+func (m *mockFileLogger) LogPolicyDenial(sessionID, provider string, seq int, denial PolicyDenial, requestID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.denialCalls = append(m.denialCalls, denial)
+	return nil
+}
+
+func (m *mockFileLogger) LogRealtimeEvent(sessionID, provider string, seq int, direction string, event []byte, requestID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.realtimeCalls = append(m.realtimeCalls, realtimeCall{sessionID, direction, string(event), requestID})
+	return nil
+}
+
 func (m *mockFileLogger) Close() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -301,6 +340,58 @@ func TestMultiWriter_LogResponse_BothCalled(t *testing.T) {
 	}
 }
 
+func TestMultiWriter_LogRealtimeEvent_BothCalled(t *testing.T) {
+	fileLogger := newMockFileLogger()
+	closeOrder := []string{}
+	lokiExporter := newMockLokiExporter(&closeOrder)
+
+	mw := NewMultiWriter(fileLogger, lokiExporter)
+	mw.SetRequestMeta("req-1", "principal", "user:alice")
+
+	event := `{"type":"response.done"}`
+	if err := mw.LogRealtimeEvent("test-session-123", "openai", 1, "server", []byte(event), "req-1"); err != nil {
+		t.Fatalf("LogRealtimeEvent returned error: %v", err)
+	}
+
+	if len(fileLogger.realtimeCalls) != 1 || fileLogger.realtimeCalls[0].event != event || fileLogger.realtimeCalls[0].direction != "server" {
+		t.Errorf("file logger calls = %+v, want the server event", fileLogger.realtimeCalls)
+	}
+	if len(lokiExporter.pushCalls) != 1 {
+		t.Fatalf("Expected 1 push call to Loki exporter, got %d", len(lokiExporter.pushCalls))
+	}
+	entry := lokiExporter.pushCalls[0].entry
+	meta, _ := entry["_meta"].(map[string]interface{})
+	if entry["type"] != "realtime_event" || entry["direction"] != "server" || entry["body"] != event || meta["principal"] != "user:alice" {
+		t.Errorf("Loki entry = %v, want a realtime_event with the principal", entry)
+	}
+}
+
+func TestMultiWriter_LogPolicyDenial_BothCalled(t *testing.T) {
+	fileLogger := newMockFileLogger()
+	closeOrder := []string{}
+	lokiExporter := newMockLokiExporter(&closeOrder)
+
+	mw := NewMultiWriter(fileLogger, lokiExporter)
+	mw.SetRequestMeta("req-1", "identity", "ci")
+
+	denial := PolicyDenial{Rule: "no-opus-from-ci", Reason: "model claude-opus-4-6 is not allowed", Status: 403}
+	if err := mw.LogPolicyDenial("test-session-123", "anthropic", 1, denial, "req-1"); err != nil {
+		t.Fatalf("LogPolicyDenial returned error: %v", err)
+	}
+
+	if len(fileLogger.denialCalls) != 1 || fileLogger.denialCalls[0] != denial {
+		t.Errorf("file logger calls = %+v, want the denial", fileLogger.denialCalls)
+	}
+	if len(lokiExporter.pushCalls) != 1 {
+		t.Fatalf("Expected 1 push call to Loki exporter, got %d", len(lokiExporter.pushCalls))
+	}
+	entry := lokiExporter.pushCalls[0].entry
+	meta, _ := entry["_meta"].(map[string]interface{})
+	if entry["type"] != "policy_denial" || entry["policy"] != denial || meta["identity"] != "ci" {
+		t.Errorf("Loki entry = %v, want a policy_denial with the identity", entry)
+	}
+}
+
 func TestMultiWriter_LogFork_BothCalled(t *testing.T) {
 	fileLogger := newMockFileLogger()
 	closeOrder := []string{}
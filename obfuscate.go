@@ -52,14 +52,15 @@ func extractPrefix(key string) string {
 	return ""
 }
 
-// ObfuscateHeaders returns a copy of headers with API keys obfuscated
-func ObfuscateHeaders(headers http.Header) http.Header {
+// ObfuscateHeaders returns a copy of headers with API keys obfuscated. A nil
+// providers registry obfuscates only the baseline credential headers.
+func ObfuscateHeaders(headers http.Header, providers *providerRegistry) http.Header {
 	result := make(http.Header)
 
 	for key, values := range headers {
 		newValues := make([]string, len(values))
 		for i, v := range values {
-			if isAPIKeyHeader(key) {
+			if isAPIKeyHeader(key, providers) {
 				newValues[i] = obfuscateHeaderValue(v)
 			} else {
 				newValues[i] = v
@@ -71,9 +72,13 @@ func ObfuscateHeaders(headers http.Header) http.Header {
 	return result
 }
 
-func isAPIKeyHeader(name string) bool {
-	lower := strings.ToLower(name)
-	return lower == "x-api-key" || lower == "authorization"
+// isAPIKeyHeader returns true for the baseline credential headers and headers
+// any registered provider uses for credentials.
+func isAPIKeyHeader(name string, providers *providerRegistry) bool {
+	if baselineAuthHeaders[strings.ToLower(name)] {
+		return true
+	}
+	return providers != nil && providers.isAuthHeader(name)
 }
 
 func obfuscateHeaderValue(value string) string {
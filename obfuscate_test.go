@@ -50,9 +50,11 @@ func TestObfuscateHeaders(t *testing.T) {
 		"Authorization":     []string{"Bearer sk-proj-anothersecret999"},
 		"Content-Type":      []string{"application/json"},
 		"Anthropic-Version": []string{"2023-06-01"},
+		"X-Goog-Api-Key":    []string{"AIzaSyExampleGeminiKey1234"},
+		"Api-Key":           []string{"0123456789abcdef0123456789abcdef"},
 	}
 
-	result := ObfuscateHeaders(headers)
+	result := ObfuscateHeaders(headers, builtinRegistry)
 
 	if result.Get("X-Api-Key") != "sk-ant-...5678" {
 		t.Errorf("X-Api-Key not obfuscated correctly: %s", result.Get("X-Api-Key"))
@@ -60,7 +62,55 @@ func TestObfuscateHeaders(t *testing.T) {
 	if result.Get("Authorization") != "Bearer sk-proj-...t999" {
 		t.Errorf("Authorization not obfuscated correctly: %s", result.Get("Authorization"))
 	}
+	if result.Get("X-Goog-Api-Key") != "...1234" {
+		t.Errorf("X-Goog-Api-Key not obfuscated correctly: %s", result.Get("X-Goog-Api-Key"))
+	}
+	if result.Get("Api-Key") != "...cdef" {
+		t.Errorf("Api-Key not obfuscated correctly: %s", result.Get("Api-Key"))
+	}
 	if result.Get("Content-Type") != "application/json" {
 		t.Error("Content-Type should not be modified")
 	}
 }
+
+func TestObfuscateHeaders_BaselineAlwaysApplies(t *testing.T) {
+	// Overriding the built-ins without their credential headers must not
+	// leak the keys clients still send in them
+	override, err := newProviderRegistry([]ProviderConfig{
+		{Name: "anthropic", Upstream: "gateway.internal", Dialect: dialectAnthropic, ConversationPaths: []string{"/v1/messages"}},
+		{Name: "gemini", Upstream: "gateway.internal", Dialect: dialectGemini, ConversationPaths: []string{"/**:generateContent"}},
+		{Name: "router", Upstream: "router.internal", Dialect: dialectOpenAIChat, ConversationPaths: []string{"/v1/chat/completions"}, AuthHeaders: []string{"X-Router-Key"}},
+	})
+	if err != nil {
+		t.Fatalf("newProviderRegistry: %v", err)
+	}
+	headers := http.Header{
+		"X-Api-Key":      []string{"sk-ant-REDACTED"},
+		"X-Goog-Api-Key": []string{"AIzaSyExampleGeminiKey1234"},
+		"X-Router-Key":   []string{"or-0123456789abcdef"},
+	}
+
+	tests := []struct {
+		name       string
+		providers  *providerRegistry
+		wantRouter string
+	}{
+		{"override registry", override, "or-...cdef"},
+		{"built-in registry", builtinRegistry, "or-0123456789abcdef"},
+		{"no registry", nil, "or-0123456789abcdef"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := ObfuscateHeaders(headers, tt.providers)
+			if got := result.Get("X-Api-Key"); got != "sk-ant-...5678" {
+				t.Errorf("X-Api-Key = %q", got)
+			}
+			if got := result.Get("X-Goog-Api-Key"); got != "...1234" {
+				t.Errorf("X-Goog-Api-Key = %q", got)
+			}
+			if got := result.Get("X-Router-Key"); got != tt.wantRouter {
+				t.Errorf("X-Router-Key = %q, want %q", got, tt.wantRouter)
+			}
+		})
+	}
+}
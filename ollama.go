@@ -0,0 +1,234 @@
+// ollama.go
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Ollama support.
+//
+// Ollama's native API (/api/chat, /api/generate) is served over plain HTTP
+// and streams newline-delimited JSON (application/x-ndjson) rather than SSE.
+// Every line is a full object: /api/chat lines carry a partial
+// message {role, content, thinking, tool_calls}, /api/generate lines a partial
+// "response" string. The final line has done=true, done_reason and the token
+// counts prompt_eval_count / eval_count. llama.cpp's server speaks the OpenAI
+// dialect and needs no special handling beyond an http upstream.
+
+// isNDJSONResponse returns true for newline-delimited JSON streams.
+func isNDJSONResponse(resp *http.Response) bool {
+	return strings.HasPrefix(resp.Header.Get("Content-Type"), "application/x-ndjson")
+}
+
+// isOllamaResponse returns true if the decoded body or NDJSON line is an
+// Ollama /api/chat or /api/generate response.
+func isOllamaResponse(raw map[string]interface{}) bool {
+	if _, ok := raw["done"].(bool); !ok {
+		return false
+	}
+	if _, ok := raw["message"].(map[string]interface{}); ok {
+		return true
+	}
+	_, ok := raw["response"].(string)
+	return ok
+}
+
+// decodeNDJSONLine parses one NDJSON line. Returns nil for blank lines and
+// invalid JSON.
+func decodeNDJSONLine(raw string) map[string]interface{} {
+	line := strings.TrimSpace(raw)
+	if line == "" {
+		return nil
+	}
+	var data map[string]interface{}
+	if json.Unmarshal([]byte(line), &data) != nil {
+		return nil
+	}
+	return data
+}
+
+// firstNDJSONEvent returns the first decodable NDJSON line, or nil.
+func firstNDJSONEvent(chunks []StreamChunk) map[string]interface{} {
+	for _, chunk := range chunks {
+		if data := decodeNDJSONLine(chunk.Raw); data != nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// parseOllamaToolCalls converts Ollama tool_calls into tool_use blocks.
+// Arguments are a JSON object, not a string.
+func parseOllamaToolCalls(toolCalls []interface{}) []ContentBlock {
+	var blocks []ContentBlock
+	for _, tc := range toolCalls {
+		call, ok := tc.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		cb := ContentBlock{Type: "tool_use", Raw: call}
+		if fn, ok := call["function"].(map[string]interface{}); ok {
+			cb.ToolName, _ = fn["name"].(string)
+			switch args := fn["arguments"].(type) {
+			case map[string]interface{}:
+				cb.ToolInput = args
+			case string:
+				cb.ToolInput = parseToolArguments(args)
+			}
+		}
+		cb.ToolID = ollamaToolID(call, cb.ToolName, len(blocks))
+		blocks = append(blocks, cb)
+	}
+	return blocks
+}
+
+// ollamaToolID returns the ID of the index'th tool call of a message. Ollama
+// doesn't always send call IDs, so fall back to the function name, which is
+// what the matching role=tool message carries as tool_name, and the call's
+// position.
+func ollamaToolID(call map[string]interface{}, name string, index int) string {
+	if id, ok := call["id"].(string); ok && id != "" {
+		return id
+	}
+	return fallbackToolID(name, index)
+}
+
+// isOllamaToolCalls returns true if tool calls carry object arguments, as
+// Ollama sends them, rather than OpenAI's JSON strings.
+func isOllamaToolCalls(toolCalls []interface{}) bool {
+	if len(toolCalls) == 0 {
+		return false
+	}
+	call, _ := toolCalls[0].(map[string]interface{})
+	fn, _ := call["function"].(map[string]interface{})
+	_, ok := fn["arguments"].(map[string]interface{})
+	return ok
+}
+
+// parseOllamaRequest adds the /api/generate prompt as a user message and
+// options.num_predict as MaxTokens. /api/chat messages share the OpenAI shape.
+func parseOllamaRequest(raw map[string]interface{}, parsed *ParsedRequest) {
+	if options, ok := raw["options"].(map[string]interface{}); ok {
+		if numPredict, ok := options["num_predict"].(float64); ok && numPredict > 0 {
+			parsed.MaxTokens = int(numPredict)
+		}
+	}
+	if prompt, ok := raw["prompt"].(string); ok && prompt != "" {
+		parsed.Messages = append(parsed.Messages, ParsedMessage{Role: "user", TextContent: prompt})
+	}
+}
+
+// parseOllamaResponse populates a ParsedResponse from an Ollama response body
+// or a single NDJSON line.
+func parseOllamaResponse(raw map[string]interface{}, parsed *ParsedResponse) {
+	var thinking, text string
+	var toolCalls []interface{}
+	if message, ok := raw["message"].(map[string]interface{}); ok {
+		thinking, _ = message["thinking"].(string)
+		text, _ = message["content"].(string)
+		toolCalls, _ = message["tool_calls"].([]interface{})
+	} else {
+		thinking, _ = raw["thinking"].(string)
+		text, _ = raw["response"].(string)
+	}
+
+	if thinking != "" {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "thinking", Thinking: thinking})
+	}
+	if text != "" {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "text", Text: text})
+	}
+	parsed.Content = append(parsed.Content, parseOllamaToolCalls(toolCalls)...)
+
+	if reason, ok := raw["done_reason"].(string); ok {
+		parsed.StopReason = reason
+	}
+	parsed.Usage = parseOllamaUsage(raw)
+}
+
+// parseOllamaUsage maps prompt_eval_count / eval_count onto UsageInfo.
+func parseOllamaUsage(raw map[string]interface{}) UsageInfo {
+	prompt, _ := raw["prompt_eval_count"].(float64)
+	eval, _ := raw["eval_count"].(float64)
+	return UsageInfo{InputTokens: int(prompt), OutputTokens: int(eval)}
+}
+
+// parseOllamaStreamingResponse reconstructs a ParsedResponse from NDJSON
+// chunks. Text and thinking are appended across lines, tool calls arrive
+// whole, and the done=true line carries done_reason and the token counts.
+func parseOllamaStreamingResponse(chunks []StreamChunk) ParsedResponse {
+	parsed := ParsedResponse{}
+	var thinking, text strings.Builder
+	var tools []ContentBlock
+
+	for _, chunk := range chunks {
+		data := decodeNDJSONLine(chunk.Raw)
+		if data == nil {
+			continue
+		}
+		var partial ParsedResponse
+		parseOllamaResponse(data, &partial)
+		for _, block := range partial.Content {
+			switch block.Type {
+			case "thinking":
+				thinking.WriteString(block.Thinking)
+			case "text":
+				text.WriteString(block.Text)
+			default:
+				// Number calls across the stream, not per line
+				block.ToolID = ollamaToolID(block.Raw, block.ToolName, len(tools))
+				tools = append(tools, block)
+			}
+		}
+		if done, _ := data["done"].(bool); done {
+			parsed.StopReason = partial.StopReason
+			parsed.Usage = partial.Usage
+		}
+	}
+
+	if thinking.Len() > 0 {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "thinking", Thinking: thinking.String()})
+	}
+	if text.Len() > 0 {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "text", Text: text.String()})
+	}
+	parsed.Content = append(parsed.Content, tools...)
+	return parsed
+}
+
+// extractOllamaDeltaText returns the text delta from an NDJSON line.
+func extractOllamaDeltaText(line []byte) string {
+	data := decodeNDJSONLine(string(line))
+	if data == nil {
+		return ""
+	}
+	if message, ok := data["message"].(map[string]interface{}); ok {
+		text, _ := message["content"].(string)
+		return text
+	}
+	text, _ := data["response"].(string)
+	return text
+}
+
+// ollamaStopReason returns done_reason from a final Ollama response or NDJSON line.
+func ollamaStopReason(event map[string]interface{}) string {
+	if !isOllamaResponse(event) {
+		return ""
+	}
+	reason, _ := event["done_reason"].(string)
+	return reason
+}
+
+// extractOllamaSessionID returns the X-Session-ID header; Ollama requests
+// carry no session fields, so other sessions are tracked by fingerprint.
+func extractOllamaSessionID(headers http.Header) string {
+	if headers == nil {
+		return ""
+	}
+	if sessID := headers.Get("X-Session-ID"); isValidSessionID(sessID) {
+		return sessID
+	}
+	return ""
+}
@@ -0,0 +1,214 @@
+// ollama_test.go
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testOllamaChatStream = []string{
+	`{"model":"qwen3","created_at":"2025-06-01T00:00:00Z","message":{"role":"assistant","content":"","thinking":"Need the file."},"done":false}`,
+	`{"model":"qwen3","created_at":"2025-06-01T00:00:00Z","message":{"role":"assistant","content":"Let me "},"done":false}`,
+	`{"model":"qwen3","created_at":"2025-06-01T00:00:00Z","message":{"role":"assistant","content":"check."},"done":false}`,
+	`{"model":"qwen3","created_at":"2025-06-01T00:00:00Z","message":{"role":"assistant","content":"","tool_calls":[{"function":{"name":"Read","arguments":{"path":"main.go"}}}]},"done":false}`,
+	`{"model":"qwen3","created_at":"2025-06-01T00:00:01Z","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","total_duration":1200000000,"prompt_eval_count":42,"eval_count":17}`,
+}
+
+func TestParseStreamingResponse_OllamaNDJSON(t *testing.T) {
+	var chunks []StreamChunk
+	for _, line := range testOllamaChatStream {
+		chunks = append(chunks, StreamChunk{Raw: line + "\n"})
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("got %d content blocks, want 3: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Need the file." {
+		t.Errorf("block 0 = %+v", parsed.Content[0])
+	}
+	if parsed.Content[1].Type != "text" || parsed.Content[1].Text != "Let me check." {
+		t.Errorf("block 1 = %+v", parsed.Content[1])
+	}
+	tool := parsed.Content[2]
+	if tool.Type != "tool_use" || tool.ToolName != "Read" || tool.ToolID != "Read#0" || tool.ToolInput["path"] != "main.go" {
+		t.Errorf("block 2 = %+v", tool)
+	}
+	if parsed.StopReason != "stop" {
+		t.Errorf("StopReason = %q", parsed.StopReason)
+	}
+	if parsed.Usage != (UsageInfo{InputTokens: 42, OutputTokens: 17}) {
+		t.Errorf("Usage = %+v", parsed.Usage)
+	}
+	if sr := findStopReasonInChunks(testOllamaChatStream); sr != "stop" {
+		t.Errorf("findStopReasonInChunks = %q", sr)
+	}
+}
+
+func TestParseResponseBody_Ollama(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		wantText string
+		wantStop string
+		wantOut  int
+	}{
+		{"chat", `{"model":"llama3.2","message":{"role":"assistant","content":"Hi there"},"done":true,"done_reason":"stop","prompt_eval_count":10,"eval_count":3}`, "Hi there", "stop", 3},
+		{"generate", `{"model":"llama3.2","response":"The sky is blue","done":true,"done_reason":"length","prompt_eval_count":8,"eval_count":5}`, "The sky is blue", "length", 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := ParseResponseBody(tt.body, "localhost:11434")
+			if len(parsed.Content) != 1 || parsed.Content[0].Text != tt.wantText {
+				t.Errorf("Content = %+v", parsed.Content)
+			}
+			if parsed.StopReason != tt.wantStop || parsed.Usage.OutputTokens != tt.wantOut {
+				t.Errorf("StopReason = %q, Usage = %+v", parsed.StopReason, parsed.Usage)
+			}
+			if sr := extractStopReasonFromBody(tt.body); sr != tt.wantStop {
+				t.Errorf("extractStopReasonFromBody = %q", sr)
+			}
+			msg, err := ExtractAssistantMessage([]byte(tt.body), "ollama")
+			if err != nil || msg["content"] != tt.wantText {
+				t.Errorf("ExtractAssistantMessage = (%v, %v)", msg, err)
+			}
+		})
+	}
+}
+
+func TestParseRequestBody_Ollama(t *testing.T) {
+	chat := ParseRequestBody(`{"model":"qwen3","options":{"num_predict":256},"messages":[
+		{"role":"user","content":"Read main.go"},
+		{"role":"assistant","content":"","tool_calls":[{"function":{"name":"Read","arguments":{"path":"main.go"}}}]},
+		{"role":"tool","content":"package main","tool_name":"Read"}
+	]}`, "localhost:11434")
+
+	if chat.MaxTokens != 256 || len(chat.Messages) != 3 {
+		t.Fatalf("MaxTokens = %d, Messages = %+v", chat.MaxTokens, chat.Messages)
+	}
+	use := chat.Messages[1].Content
+	if len(use) != 1 || use[0].Type != "tool_use" || use[0].ToolID != "Read#0" || use[0].ToolInput["path"] != "main.go" {
+		t.Errorf("tool_use = %+v", use)
+	}
+	result := chat.Messages[2].Content
+	if len(result) != 1 || result[0].Type != "tool_result" || result[0].ToolID != "Read#0" || result[0].Text != "package main" {
+		t.Errorf("tool_result = %+v", result)
+	}
+
+	// Parallel calls to one tool get distinct IDs, matched by result order
+	parallel := ParseRequestBody(`{"model":"qwen3","messages":[
+		{"role":"assistant","content":"","tool_calls":[
+			{"function":{"name":"Read","arguments":{"path":"a.go"}}},
+			{"function":{"name":"Read","arguments":{"path":"b.go"}}}
+		]},
+		{"role":"tool","content":"package a","tool_name":"Read"},
+		{"role":"tool","content":"package b","tool_name":"Read"}
+	]}`, "localhost:11434")
+	calls := parallel.Messages[0].Content
+	if len(calls) != 2 || calls[0].ToolID == calls[1].ToolID {
+		t.Fatalf("parallel tool_use = %+v", calls)
+	}
+	for i, msg := range parallel.Messages[1:] {
+		if len(msg.Content) != 1 || msg.Content[0].ToolID != calls[i].ToolID {
+			t.Errorf("tool_result %d = %+v, want ID %q", i, msg.Content, calls[i].ToolID)
+		}
+	}
+
+	gen := ParseRequestBody(`{"model":"llama3.2","system":"Be brief.","prompt":"Why is the sky blue?"}`, "localhost:11434")
+	if gen.System != "Be brief." || len(gen.Messages) != 1 || gen.Messages[0].TextContent != "Why is the sky blue?" {
+		t.Errorf("generate = %+v", gen)
+	}
+}
+
+func TestOllamaProvider_PlainHTTP(t *testing.T) {
+	spec := builtinRegistry.lookup("ollama")
+	if spec == nil {
+		t.Fatal("ollama provider not registered")
+	}
+	for _, host := range []string{"localhost:11434", "gpu-box.lan:11434", "10.0.0.5:11434"} {
+		if got := spec.scheme(host); got != "http" {
+			t.Errorf("scheme(%q) = %q, want http", host, got)
+		}
+	}
+	if !spec.isConversation("/api/chat") || !spec.isConversation("/api/generate") || spec.isConversation("/api/tags") {
+		t.Error("ollama conversation paths should be /api/chat and /api/generate only")
+	}
+}
+
+func TestProxy_OllamaStreamsNDJSONIncrementally(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		flusher := w.(http.Flusher)
+		for i, line := range testOllamaChatStream {
+			w.Write([]byte(line + "\n"))
+			flusher.Flush()
+			if i == 0 {
+				<-release
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+	emitter := &MockEventEmitter{}
+	proxy := httptest.NewServer(NewProxyWithEventEmitter(logger, sm, emitter, "test-machine"))
+	defer proxy.Close()
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	resp, err := http.Post(proxy.URL+"/ollama/"+upstreamHost+"/api/chat", "application/json",
+		strings.NewReader(`{"model":"qwen3","messages":[{"role":"user","content":"Read main.go"}]}`))
+	if err != nil {
+		t.Fatalf("POST: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The first line must arrive while upstream is still holding the rest.
+	reader := bufio.NewReader(resp.Body)
+	firstLine := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		firstLine <- line
+	}()
+	select {
+	case line := <-firstLine:
+		if strings.TrimSpace(line) != testOllamaChatStream[0] {
+			t.Errorf("first line = %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("first NDJSON line was buffered instead of streamed")
+	}
+	close(release)
+
+	var rest int
+	for {
+		if _, err := reader.ReadString('\n'); err != nil {
+			break
+		}
+		rest++
+	}
+	if rest != len(testOllamaChatStream)-1 {
+		t.Errorf("got %d more lines, want %d", rest, len(testOllamaChatStream)-1)
+	}
+
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "Read" {
+		t.Errorf("ToolCallEvents = %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("TurnEndEvents = %+v", emitter.TurnEndEvents)
+	}
+	end := emitter.TurnEndEvents[0]
+	if end.Provider != "ollama" || end.StopReason != "stop" || end.Tokens.InputTokens != 42 || end.Tokens.OutputTokens != 17 {
+		t.Errorf("TurnEnd = %+v", end)
+	}
+}
@@ -0,0 +1,253 @@
+// openai.go
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// OpenAI Chat Completions support.
+//
+// Responses look like {"choices":[{"message":{...},"finish_reason":"..."}],"usage":{...}}
+// and streams are "chat.completion.chunk" events whose choices[].delta carry
+// content and incremental tool_calls fragments keyed by index.
+
+// isOpenAIChatResponse returns true if the decoded body or SSE event uses the
+// Chat Completions choices shape (either a full response or a stream chunk).
+func isOpenAIChatResponse(raw map[string]interface{}) bool {
+	if object, ok := raw["object"].(string); ok {
+		return object == "chat.completion" || object == "chat.completion.chunk"
+	}
+	_, ok := raw["choices"].([]interface{})
+	return ok
+}
+
+// parseOpenAIChatResponse populates a ParsedResponse from a non-streaming
+// Chat Completions response body.
+func parseOpenAIChatResponse(raw map[string]interface{}, parsed *ParsedResponse) {
+	if choice := firstOpenAIChoice(raw); choice != nil {
+		if message, ok := choice["message"].(map[string]interface{}); ok {
+			parsed.Content = parseOpenAIMessageContent(message)
+		}
+		if finish, ok := choice["finish_reason"].(string); ok {
+			parsed.StopReason = finish
+		}
+	}
+
+	if usage, ok := raw["usage"].(map[string]interface{}); ok {
+		parsed.Usage = parseOpenAIChatUsage(usage)
+	}
+}
+
+// firstOpenAIChoice returns choices[0] or nil if absent.
+func firstOpenAIChoice(raw map[string]interface{}) map[string]interface{} {
+	choices, ok := raw["choices"].([]interface{})
+	if !ok || len(choices) == 0 {
+		return nil
+	}
+	choice, _ := choices[0].(map[string]interface{})
+	return choice
+}
+
+// parseOpenAIMessageContent converts an OpenAI chat message into ContentBlocks.
+// Handles string or array content, reasoning_content, and tool_calls.
+func parseOpenAIMessageContent(message map[string]interface{}) []ContentBlock {
+	var blocks []ContentBlock
+
+	if reasoning, ok := message["reasoning_content"].(string); ok && reasoning != "" {
+		blocks = append(blocks, ContentBlock{Type: "thinking", Thinking: reasoning})
+	}
+
+	switch content := message["content"].(type) {
+	case string:
+		if content != "" {
+			blocks = append(blocks, ContentBlock{Type: "text", Text: content})
+		}
+	case []interface{}:
+		for _, c := range content {
+			if part, ok := c.(map[string]interface{}); ok {
+				blocks = append(blocks, parseContentBlock(part))
+			}
+		}
+	}
+
+	if toolCalls, ok := message["tool_calls"].([]interface{}); ok {
+		for _, tc := range toolCalls {
+			call, ok := tc.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			cb := ContentBlock{Type: "tool_use", Raw: call}
+			cb.ToolID, _ = call["id"].(string)
+			if fn, ok := call["function"].(map[string]interface{}); ok {
+				cb.ToolName, _ = fn["name"].(string)
+				if args, ok := fn["arguments"].(string); ok {
+					cb.ToolInput = parseToolArguments(args)
+				}
+			}
+			blocks = append(blocks, cb)
+		}
+	}
+
+	return blocks
+}
+
+// parseToolArguments decodes an OpenAI function arguments JSON string.
+// Returns nil if the arguments are empty or not a JSON object.
+func parseToolArguments(args string) map[string]interface{} {
+	if args == "" {
+		return nil
+	}
+	var input map[string]interface{}
+	if json.Unmarshal([]byte(args), &input) != nil {
+		return nil
+	}
+	return input
+}
+
+// parseOpenAIChatUsage maps Chat Completions usage onto UsageInfo.
+// prompt_tokens includes cached tokens, so they are subtracted to match
+// Anthropic semantics where input_tokens excludes cache reads.
+func parseOpenAIChatUsage(usage map[string]interface{}) UsageInfo {
+	var info UsageInfo
+	prompt, _ := usage["prompt_tokens"].(float64)
+	completion, _ := usage["completion_tokens"].(float64)
+
+	var cached float64
+	if details, ok := usage["prompt_tokens_details"].(map[string]interface{}); ok {
+		cached, _ = details["cached_tokens"].(float64)
+	}
+
+	info.InputTokens = int(prompt - cached)
+	info.OutputTokens = int(completion)
+	info.CacheReadInputTokens = int(cached)
+	return info
+}
+
+// openAIToolCallBuilder accumulates a streamed tool call across delta fragments.
+type openAIToolCallBuilder struct {
+	id        string
+	name      string
+	arguments string
+}
+
+// parseOpenAIChatStreamingResponse reconstructs a ParsedResponse from Chat
+// Completions SSE chunks. Tool call arguments arrive as string fragments under
+// delta.tool_calls[].index and are reassembled before JSON decoding. Usage is
+// only present when the client sets stream_options.include_usage, in a final
+// chunk with empty choices.
+func parseOpenAIChatStreamingResponse(chunks []StreamChunk) ParsedResponse {
+	parsed := ParsedResponse{}
+
+	var text, reasoning string
+	toolCalls := make(map[int]*openAIToolCallBuilder)
+
+	for _, chunk := range chunks {
+		data := decodeSSEData(chunk.Raw)
+		if data == nil {
+			continue
+		}
+
+		if usage, ok := data["usage"].(map[string]interface{}); ok {
+			parsed.Usage = parseOpenAIChatUsage(usage)
+		}
+
+		choice := firstOpenAIChoice(data)
+		if choice == nil {
+			continue
+		}
+		if finish, ok := choice["finish_reason"].(string); ok {
+			parsed.StopReason = finish
+		}
+
+		delta, ok := choice["delta"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if content, ok := delta["content"].(string); ok {
+			text += content
+		}
+		if r, ok := delta["reasoning_content"].(string); ok {
+			reasoning += r
+		}
+
+		fragments, _ := delta["tool_calls"].([]interface{})
+		for _, f := range fragments {
+			frag, ok := f.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			idx := 0
+			if i, ok := frag["index"].(float64); ok {
+				idx = int(i)
+			}
+			builder, ok := toolCalls[idx]
+			if !ok {
+				builder = &openAIToolCallBuilder{}
+				toolCalls[idx] = builder
+			}
+			if id, ok := frag["id"].(string); ok && id != "" {
+				builder.id = id
+			}
+			if fn, ok := frag["function"].(map[string]interface{}); ok {
+				if name, ok := fn["name"].(string); ok && name != "" {
+					builder.name = name
+				}
+				if args, ok := fn["arguments"].(string); ok {
+					builder.arguments += args
+				}
+			}
+		}
+	}
+
+	if reasoning != "" {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "thinking", Thinking: reasoning})
+	}
+	if text != "" {
+		parsed.Content = append(parsed.Content, ContentBlock{Type: "text", Text: text})
+	}
+
+	indices := make([]int, 0, len(toolCalls))
+	for idx := range toolCalls {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+	for _, idx := range indices {
+		builder := toolCalls[idx]
+		parsed.Content = append(parsed.Content, ContentBlock{
+			Type:      "tool_use",
+			ToolID:    builder.id,
+			ToolName:  builder.name,
+			ToolInput: parseToolArguments(builder.arguments),
+		})
+	}
+
+	return parsed
+}
+
+// parseOpenAIRequestMessage adds tool_use blocks for assistant tool_calls and a
+// tool_result block for role=tool messages, so tool events and the explorer
+// work for Chat Completions conversations. toolIndex is the position of a
+// role=tool message among those answering the last assistant message.
+func parseOpenAIRequestMessage(msg map[string]interface{}, pm *ParsedMessage, toolIndex int) {
+	if pm.Role == "assistant" {
+		if toolCalls, ok := msg["tool_calls"].([]interface{}); ok && isOllamaToolCalls(toolCalls) {
+			pm.Content = append(pm.Content, parseOllamaToolCalls(toolCalls)...)
+		} else if toolCalls, ok := msg["tool_calls"]; ok {
+			pm.Content = append(pm.Content, parseOpenAIMessageContent(map[string]interface{}{"tool_calls": toolCalls})...)
+		}
+		return
+	}
+
+	if pm.Role == "tool" {
+		cb := ContentBlock{Type: "tool_result", Raw: msg}
+		cb.ToolID, _ = msg["tool_call_id"].(string)
+		if cb.ToolID == "" {
+			// Ollama identifies the call by tool_name, answering calls in order
+			cb.ToolName, _ = msg["tool_name"].(string)
+			cb.ToolID = fallbackToolID(cb.ToolName, toolIndex)
+		}
+		cb.Text = pm.TextContent
+		pm.Content = append(pm.Content, cb)
+	}
+}
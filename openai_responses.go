@@ -0,0 +1,343 @@
+// openai_responses.go
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// OpenAI Responses API support (/v1/responses, Codex /backend-api/codex/responses).
+//
+// Requests carry an "input" array of items (message, function_call,
+// function_call_output, reasoning) instead of "messages". Streams are typed SSE
+// events: response.output_item.added opens an item at output_index, delta
+// events (output_text, reasoning_summary_text, function_call_arguments) fill
+// it in, response.output_item.done delivers the final item, and
+// response.completed carries status and usage.
+
+// isResponsesAPIRequest returns true if the request body uses the Responses API
+// input shape rather than Chat Completions messages.
+func isResponsesAPIRequest(raw map[string]interface{}) bool {
+	if _, ok := raw["messages"]; ok {
+		return false
+	}
+	_, ok := raw["input"]
+	return ok
+}
+
+// isResponsesAPIResponse returns true for a non-streaming Responses API body.
+func isResponsesAPIResponse(raw map[string]interface{}) bool {
+	object, _ := raw["object"].(string)
+	return object == "response"
+}
+
+// isResponsesAPIEvent returns true for a Responses API SSE event.
+func isResponsesAPIEvent(event map[string]interface{}) bool {
+	eventType, _ := event["type"].(string)
+	return strings.HasPrefix(eventType, "response.")
+}
+
+// parseResponsesAPIRequest populates a ParsedRequest from a Responses API request.
+// Consecutive items from the same role are grouped into one ParsedMessage, so a
+// function_call following assistant text shows up as a single assistant turn.
+func parseResponsesAPIRequest(raw map[string]interface{}, parsed *ParsedRequest) {
+	if model, ok := raw["model"].(string); ok {
+		parsed.Model = model
+	}
+	if maxTokens, ok := raw["max_output_tokens"].(float64); ok {
+		parsed.MaxTokens = int(maxTokens)
+	}
+	if instructions, ok := raw["instructions"].(string); ok {
+		parsed.System = instructions
+	}
+
+	switch input := raw["input"].(type) {
+	case string:
+		parsed.Messages = append(parsed.Messages, ParsedMessage{
+			Role:        "user",
+			TextContent: input,
+			Content:     []ContentBlock{{Type: "text", Text: input}},
+		})
+	case []interface{}:
+		for _, i := range input {
+			item, ok := i.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			block, ok := parseResponsesItem(item)
+			if !ok {
+				continue
+			}
+			role := responsesItemRole(item)
+
+			last := len(parsed.Messages) - 1
+			if last < 0 || parsed.Messages[last].Role != role {
+				parsed.Messages = append(parsed.Messages, ParsedMessage{Role: role, Raw: item})
+				last++
+			}
+			pm := &parsed.Messages[last]
+			pm.Content = append(pm.Content, block)
+			if block.Type == "text" && pm.TextContent == "" {
+				pm.TextContent = block.Text
+			}
+		}
+	}
+}
+
+// responsesItemRole returns the conversational role for a Responses API item.
+// Tool outputs are attributed to the user, matching Anthropic's tool_result placement.
+func responsesItemRole(item map[string]interface{}) string {
+	if role, ok := item["role"].(string); ok && role != "" {
+		return role
+	}
+	itemType, _ := item["type"].(string)
+	if strings.HasSuffix(itemType, "_output") {
+		return "user"
+	}
+	return "assistant"
+}
+
+// parseResponsesItem converts a Responses API output/input item into a ContentBlock.
+// Returns false for item types that carry no conversational content (e.g.,
+// server-side web_search_call), which must not create pending tool IDs.
+func parseResponsesItem(item map[string]interface{}) (ContentBlock, bool) {
+	cb := ContentBlock{Raw: item}
+	itemType, _ := item["type"].(string)
+
+	// Messages may omit type in request input: {"role":"user","content":"..."}
+	if itemType == "" {
+		if _, ok := item["role"]; ok {
+			itemType = "message"
+		}
+	}
+
+	switch itemType {
+	case "message":
+		cb.Type = "text"
+		switch content := item["content"].(type) {
+		case string:
+			cb.Text = content
+		case []interface{}:
+			cb.Text = joinResponsesText(content, "text", "refusal")
+		}
+	case "reasoning":
+		cb.Type = "thinking"
+		summary, _ := item["summary"].([]interface{})
+		cb.Thinking = joinResponsesText(summary, "text")
+		if cb.Thinking == "" {
+			content, _ := item["content"].([]interface{})
+			cb.Thinking = joinResponsesText(content, "text")
+		}
+	case "function_call":
+		cb.Type = "tool_use"
+		cb.ToolID, _ = item["call_id"].(string)
+		cb.ToolName, _ = item["name"].(string)
+		if args, ok := item["arguments"].(string); ok {
+			cb.ToolInput = parseToolArguments(args)
+		}
+	case "custom_tool_call":
+		cb.Type = "tool_use"
+		cb.ToolID, _ = item["call_id"].(string)
+		cb.ToolName, _ = item["name"].(string)
+		if input, ok := item["input"].(string); ok {
+			cb.ToolInput = map[string]interface{}{"input": input}
+		}
+	case "local_shell_call":
+		cb.Type = "tool_use"
+		cb.ToolID, _ = item["call_id"].(string)
+		cb.ToolName = "local_shell"
+		if action, ok := item["action"].(map[string]interface{}); ok {
+			cb.ToolInput = action
+		}
+	case "function_call_output", "custom_tool_call_output", "local_shell_call_output":
+		cb.Type = "tool_result"
+		cb.ToolID, _ = item["call_id"].(string)
+		switch output := item["output"].(type) {
+		case string:
+			cb.Text = output
+		case []interface{}:
+			cb.Text = joinResponsesText(output, "text")
+		}
+	default:
+		return cb, false
+	}
+
+	return cb, true
+}
+
+// joinResponsesText concatenates the given string fields from a list of
+// content parts (e.g., output_text, summary_text, input_text).
+func joinResponsesText(parts []interface{}, fields ...string) string {
+	var text strings.Builder
+	for _, p := range parts {
+		part, ok := p.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for _, field := range fields {
+			if s, ok := part[field].(string); ok {
+				text.WriteString(s)
+				break
+			}
+		}
+	}
+	return text.String()
+}
+
+// parseResponsesAPIResponse populates a ParsedResponse from a Responses API
+// response object (non-streaming body, or the "response" of response.completed).
+func parseResponsesAPIResponse(resp map[string]interface{}, parsed *ParsedResponse) {
+	if output, ok := resp["output"].([]interface{}); ok {
+		parsed.Content = nil
+		for _, o := range output {
+			if item, ok := o.(map[string]interface{}); ok {
+				if block, ok := parseResponsesItem(item); ok {
+					parsed.Content = append(parsed.Content, block)
+				}
+			}
+		}
+	}
+	if usage, ok := resp["usage"].(map[string]interface{}); ok {
+		parsed.Usage = parseResponsesUsage(usage)
+	}
+	parsed.StopReason = responsesStatus(resp)
+}
+
+// responsesStatus maps a Responses API status onto a stop reason. Incomplete
+// responses report the incomplete_details.reason (e.g., max_output_tokens).
+func responsesStatus(resp map[string]interface{}) string {
+	status, _ := resp["status"].(string)
+	if status == "incomplete" {
+		if details, ok := resp["incomplete_details"].(map[string]interface{}); ok {
+			if reason, ok := details["reason"].(string); ok && reason != "" {
+				return reason
+			}
+		}
+	}
+	return status
+}
+
+// parseResponsesUsage maps Responses API usage onto UsageInfo. input_tokens
+// includes cached tokens, which are split out into CacheReadInputTokens. The
+// Realtime API spells the details field input_token_details.
+func parseResponsesUsage(usage map[string]interface{}) UsageInfo {
+	var info UsageInfo
+	input, _ := usage["input_tokens"].(float64)
+	output, _ := usage["output_tokens"].(float64)
+
+	var cached float64
+	details, ok := usage["input_tokens_details"].(map[string]interface{})
+	if !ok {
+		details, ok = usage["input_token_details"].(map[string]interface{})
+	}
+	if ok {
+		cached, _ = details["cached_tokens"].(float64)
+	}
+
+	info.InputTokens = int(input - cached)
+	info.OutputTokens = int(output)
+	info.CacheReadInputTokens = int(cached)
+	return info
+}
+
+// responsesItemBuilder accumulates a streamed output item.
+type responsesItemBuilder struct {
+	block     ContentBlock
+	arguments string
+	done      bool
+}
+
+// parseResponsesStreamingResponse reconstructs a ParsedResponse from Responses
+// API SSE chunks. Items are keyed by output_index; output_item.done replaces the
+// accumulated deltas with the final item, so a truncated stream still yields
+// whatever content arrived.
+func parseResponsesStreamingResponse(chunks []StreamChunk) ParsedResponse {
+	parsed := ParsedResponse{}
+	items := make(map[int]*responsesItemBuilder)
+
+	builderAt := func(event map[string]interface{}) *responsesItemBuilder {
+		idx := 0
+		if i, ok := event["output_index"].(float64); ok {
+			idx = int(i)
+		}
+		b, ok := items[idx]
+		if !ok {
+			b = &responsesItemBuilder{}
+			items[idx] = b
+		}
+		return b
+	}
+
+	for _, chunk := range chunks {
+		data := decodeSSEData(chunk.Raw)
+		if data == nil {
+			continue
+		}
+		eventType, _ := data["type"].(string)
+		delta, _ := data["delta"].(string)
+
+		switch eventType {
+		case "response.output_item.added", "response.output_item.done":
+			item, ok := data["item"].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			block, ok := parseResponsesItem(item)
+			if !ok {
+				continue
+			}
+			b := builderAt(data)
+			b.block = block
+			b.done = eventType == "response.output_item.done"
+
+		case "response.output_text.delta", "response.refusal.delta":
+			b := builderAt(data)
+			if !b.done {
+				b.block.Text += delta
+			}
+
+		case "response.reasoning_summary_text.delta", "response.reasoning_text.delta":
+			b := builderAt(data)
+			if !b.done {
+				b.block.Thinking += delta
+			}
+
+		case "response.function_call_arguments.delta", "response.custom_tool_call_input.delta":
+			b := builderAt(data)
+			if !b.done {
+				b.arguments += delta
+			}
+
+		case "response.completed", "response.incomplete", "response.failed":
+			if resp, ok := data["response"].(map[string]interface{}); ok {
+				if usage, ok := resp["usage"].(map[string]interface{}); ok {
+					parsed.Usage = parseResponsesUsage(usage)
+				}
+				parsed.StopReason = responsesStatus(resp)
+			}
+		}
+	}
+
+	indices := make([]int, 0, len(items))
+	for idx := range items {
+		indices = append(indices, idx)
+	}
+	sort.Ints(indices)
+
+	for _, idx := range indices {
+		b := items[idx]
+		if b.block.Type == "" {
+			continue
+		}
+		if !b.done && b.block.Type == "tool_use" && b.arguments != "" {
+			if itemType, _ := b.block.Raw["type"].(string); itemType == "custom_tool_call" {
+				b.block.ToolInput = map[string]interface{}{"input": b.arguments}
+			} else {
+				b.block.ToolInput = parseToolArguments(b.arguments)
+			}
+		}
+		parsed.Content = append(parsed.Content, b.block)
+	}
+
+	return parsed
+}
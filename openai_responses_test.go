@@ -0,0 +1,204 @@
+// openai_responses_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseResponsesAPIRequest(t *testing.T) {
+	body := `{
+		"model": "gpt-5-codex",
+		"instructions": "You are Codex.",
+		"input": [
+			{"type": "message", "role": "user", "content": [{"type": "input_text", "text": "Fix the build"}]},
+			{"type": "reasoning", "summary": [{"type": "summary_text", "text": "Look at go.mod"}]},
+			{"type": "function_call", "call_id": "call_1", "name": "shell", "arguments": "{\"command\":[\"go\",\"build\"]}"},
+			{"type": "function_call_output", "call_id": "call_1", "output": "ok"}
+		]
+	}`
+
+	parsed := ParseRequestBody(body, "chatgpt.com")
+
+	if parsed.Model != "gpt-5-codex" || parsed.System != "You are Codex." {
+		t.Errorf("Model/System = %q/%q", parsed.Model, parsed.System)
+	}
+	if len(parsed.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d: %+v", len(parsed.Messages), parsed.Messages)
+	}
+	if parsed.Messages[0].Role != "user" || parsed.Messages[0].TextContent != "Fix the build" {
+		t.Errorf("user message = %+v", parsed.Messages[0])
+	}
+
+	assistant := parsed.Messages[1]
+	if assistant.Role != "assistant" || len(assistant.Content) != 2 {
+		t.Fatalf("assistant message = %+v", assistant)
+	}
+	if assistant.Content[0].Type != "thinking" || assistant.Content[0].Thinking != "Look at go.mod" {
+		t.Errorf("reasoning block = %+v", assistant.Content[0])
+	}
+	call := assistant.Content[1]
+	if call.Type != "tool_use" || call.ToolID != "call_1" || call.ToolName != "shell" || call.ToolInput["command"] == nil {
+		t.Errorf("function_call block = %+v", call)
+	}
+
+	result := parsed.Messages[2]
+	if result.Role != "user" || result.Content[0].Type != "tool_result" || result.Content[0].ToolID != "call_1" || result.Content[0].Text != "ok" {
+		t.Errorf("function_call_output message = %+v", result)
+	}
+}
+
+func TestParseResponsesAPIResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStop   string
+		wantBlocks int
+	}{
+		{
+			name:       "completed",
+			body:       `{"object":"response","status":"completed","output":[{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Done."}]}],"usage":{"input_tokens":50,"output_tokens":5}}`,
+			wantStop:   "completed",
+			wantBlocks: 1,
+		},
+		{
+			name:       "incomplete reports reason",
+			body:       `{"object":"response","status":"incomplete","incomplete_details":{"reason":"max_output_tokens"},"output":[]}`,
+			wantStop:   "max_output_tokens",
+			wantBlocks: 0,
+		},
+		{
+			name:       "server-side tool calls are skipped",
+			body:       `{"object":"response","status":"completed","output":[{"type":"web_search_call","id":"ws_1","status":"completed"},{"type":"message","role":"assistant","content":[{"type":"output_text","text":"Found it."}]}]}`,
+			wantStop:   "completed",
+			wantBlocks: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed := ParseResponseBody(tt.body, "api.openai.com")
+			if parsed.StopReason != tt.wantStop {
+				t.Errorf("StopReason = %q, want %q", parsed.StopReason, tt.wantStop)
+			}
+			if len(parsed.Content) != tt.wantBlocks {
+				t.Errorf("Expected %d content blocks, got %d", tt.wantBlocks, len(parsed.Content))
+			}
+			if got := extractStopReasonFromBody(tt.body); got != tt.wantStop {
+				t.Errorf("extractStopReasonFromBody = %q, want %q", got, tt.wantStop)
+			}
+		})
+	}
+}
+
+func TestParseStreamingResponse_Responses(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: "event: response.created\n"},
+		{Raw: `data: {"type":"response.created","response":{"id":"resp_1","object":"response","status":"in_progress"}}` + "\n"},
+		{Raw: "\n"},
+		{Raw: `data: {"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1","summary":[]}}` + "\n"},
+		{Raw: `data: {"type":"response.reasoning_summary_text.delta","output_index":0,"summary_index":0,"delta":"Need to "}` + "\n"},
+		{Raw: `data: {"type":"response.reasoning_summary_text.delta","output_index":0,"summary_index":0,"delta":"check files"}` + "\n"},
+		{Raw: `data: {"type":"response.output_item.added","output_index":1,"item":{"type":"message","id":"msg_1","role":"assistant","content":[]}}` + "\n"},
+		{Raw: `data: {"type":"response.output_text.delta","output_index":1,"content_index":0,"delta":"Listing"}` + "\n"},
+		{Raw: `data: {"type":"response.output_text.delta","output_index":1,"content_index":0,"delta":" files."}` + "\n"},
+		{Raw: `data: {"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","id":"fc_1","call_id":"call_ls","name":"shell","arguments":""}}` + "\n"},
+		{Raw: `data: {"type":"response.function_call_arguments.delta","output_index":2,"item_id":"fc_1","delta":"{\"command\":"}` + "\n"},
+		{Raw: `data: {"type":"response.function_call_arguments.delta","output_index":2,"item_id":"fc_1","delta":"[\"ls\"]}"}` + "\n"},
+		{Raw: `data: {"type":"response.completed","response":{"id":"resp_1","object":"response","status":"completed","usage":{"input_tokens":900,"input_tokens_details":{"cached_tokens":800},"output_tokens":42,"output_tokens_details":{"reasoning_tokens":20}}}}` + "\n"},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %d: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Type != "thinking" || parsed.Content[0].Thinking != "Need to check files" {
+		t.Errorf("reasoning block = %+v", parsed.Content[0])
+	}
+	if parsed.Content[1].Type != "text" || parsed.Content[1].Text != "Listing files." {
+		t.Errorf("message block = %+v", parsed.Content[1])
+	}
+	call := parsed.Content[2]
+	if call.Type != "tool_use" || call.ToolID != "call_ls" || call.ToolName != "shell" {
+		t.Errorf("function_call block = %+v", call)
+	}
+	if cmd, ok := call.ToolInput["command"].([]interface{}); !ok || len(cmd) != 1 || cmd[0] != "ls" {
+		t.Errorf("ToolInput = %v", call.ToolInput)
+	}
+	if parsed.StopReason != "completed" {
+		t.Errorf("StopReason = %q, want completed", parsed.StopReason)
+	}
+	want := UsageInfo{InputTokens: 100, OutputTokens: 42, CacheReadInputTokens: 800}
+	if parsed.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", parsed.Usage, want)
+	}
+
+	if got := extractStopReason(map[string]interface{}{"chunks": chunks}); got != "completed" {
+		t.Errorf("extractStopReason = %q, want completed", got)
+	}
+}
+
+func TestParseStreamingResponse_ResponsesOutputItemDone(t *testing.T) {
+	// output_item.done carries the final item and wins over accumulated deltas.
+	chunks := []StreamChunk{
+		{Raw: `data: {"type":"response.output_item.added","output_index":0,"item":{"type":"custom_tool_call","call_id":"call_p","name":"apply_patch","input":""}}` + "\n"},
+		{Raw: `data: {"type":"response.custom_tool_call_input.delta","output_index":0,"delta":"*** Begin"}` + "\n"},
+		{Raw: `data: {"type":"response.output_item.done","output_index":0,"item":{"type":"custom_tool_call","call_id":"call_p","name":"apply_patch","input":"*** Begin Patch\n*** End Patch"}}` + "\n"},
+		{Raw: `data: {"type":"response.incomplete","response":{"status":"incomplete","incomplete_details":{"reason":"max_output_tokens"}}}` + "\n"},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 1 {
+		t.Fatalf("Expected 1 content block, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].ToolInput["input"] != "*** Begin Patch\n*** End Patch" {
+		t.Errorf("ToolInput = %v", parsed.Content[0].ToolInput)
+	}
+	if parsed.StopReason != "max_output_tokens" {
+		t.Errorf("StopReason = %q, want max_output_tokens", parsed.StopReason)
+	}
+}
+
+func TestResponsesEventEmission(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("event: response.output_item.added\n" +
+			`data: {"type":"response.output_item.added","output_index":0,"item":{"type":"function_call","call_id":"call_7","name":"shell","arguments":""}}` + "\n\n" +
+			`data: {"type":"response.function_call_arguments.delta","output_index":0,"delta":"{\"command\":[\"pwd\"]}"}` + "\n\n" +
+			`data: {"type":"response.completed","response":{"status":"completed","usage":{"input_tokens":30,"output_tokens":8}}}` + "\n\n"))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	body := `{"model":"gpt-5-codex","stream":true,"input":[{"type":"message","role":"user","content":[{"type":"input_text","text":"where am I"}]}]}`
+	req := httptest.NewRequest("POST", "/openai/"+upstreamHost+"/v1/responses", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Session_id", "codex-session-1")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "shell" || emitter.ToolCallEvents[0].ToolUseID != "call_7" {
+		t.Errorf("ToolCallEvents = %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("Expected 1 turn_end event, got %d", len(emitter.TurnEndEvents))
+	}
+	end := emitter.TurnEndEvents[0]
+	if end.StopReason != "completed" || end.Tokens.InputTokens != 30 || end.Tokens.OutputTokens != 8 {
+		t.Errorf("turn_end = %+v", end)
+	}
+}
@@ -0,0 +1,155 @@
+// openai_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseOpenAIChatResponse(t *testing.T) {
+	body := `{
+		"id": "chatcmpl-123",
+		"object": "chat.completion",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"content": "Let me look.",
+				"tool_calls": [
+					{"id": "call_abc", "type": "function", "function": {"name": "read_file", "arguments": "{\"path\":\"go.mod\"}"}}
+				]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {"prompt_tokens": 120, "completion_tokens": 30, "prompt_tokens_details": {"cached_tokens": 100}}
+	}`
+
+	parsed := ParseResponseBody(body, "api.openai.com")
+
+	if len(parsed.Content) != 2 {
+		t.Fatalf("Expected 2 content blocks, got %d", len(parsed.Content))
+	}
+	if parsed.Content[0].Type != "text" || parsed.Content[0].Text != "Let me look." {
+		t.Errorf("text block = %+v", parsed.Content[0])
+	}
+	tool := parsed.Content[1]
+	if tool.Type != "tool_use" || tool.ToolID != "call_abc" || tool.ToolName != "read_file" {
+		t.Errorf("tool block = %+v", tool)
+	}
+	if tool.ToolInput["path"] != "go.mod" {
+		t.Errorf("ToolInput = %v", tool.ToolInput)
+	}
+	if parsed.StopReason != "tool_calls" {
+		t.Errorf("StopReason = %q, want tool_calls", parsed.StopReason)
+	}
+	want := UsageInfo{InputTokens: 20, OutputTokens: 30, CacheReadInputTokens: 100}
+	if parsed.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", parsed.Usage, want)
+	}
+}
+
+func TestParseOpenAIChatRequest_ToolMessages(t *testing.T) {
+	body := `{
+		"model": "gpt-4.1",
+		"messages": [
+			{"role": "user", "content": "Read go.mod"},
+			{"role": "assistant", "content": null, "tool_calls": [{"id": "call_abc", "type": "function", "function": {"name": "read_file", "arguments": "{}"}}]},
+			{"role": "tool", "tool_call_id": "call_abc", "content": "module example"}
+		]
+	}`
+
+	parsed := ParseRequestBody(body, "api.openai.com")
+	if len(parsed.Messages) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(parsed.Messages))
+	}
+	if len(parsed.Messages[1].Content) != 1 || parsed.Messages[1].Content[0].Type != "tool_use" {
+		t.Errorf("assistant tool_calls not parsed: %+v", parsed.Messages[1].Content)
+	}
+
+	results := extractToolResults([]byte(body))
+	if len(results) != 1 || results[0].ToolUseID != "call_abc" {
+		t.Errorf("extractToolResults = %+v", results)
+	}
+}
+
+func TestParseStreamingResponse_OpenAIChat(t *testing.T) {
+	chunks := []StreamChunk{
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","content":"Checking"},"finish_reason":null}]}` + "\n"},
+		{Raw: "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"..."},"finish_reason":null}]}` + "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"grep","arguments":""}}]},"finish_reason":null}]}` + "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"pattern\":"}}]},"finish_reason":null}]}` + "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"ls","arguments":"{}"}}]},"finish_reason":null}]}` + "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"TODO\"}"}}]},"finish_reason":null}]}` + "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}` + "\n"},
+		{Raw: `data: {"id":"c1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":80,"completion_tokens":25,"prompt_tokens_details":{"cached_tokens":64}}}` + "\n"},
+		{Raw: "data: [DONE]\n"},
+	}
+
+	parsed := ParseStreamingResponse(chunks)
+
+	if len(parsed.Content) != 3 {
+		t.Fatalf("Expected 3 content blocks, got %d: %+v", len(parsed.Content), parsed.Content)
+	}
+	if parsed.Content[0].Text != "Checking..." {
+		t.Errorf("Text = %q", parsed.Content[0].Text)
+	}
+	grep := parsed.Content[1]
+	if grep.ToolID != "call_1" || grep.ToolName != "grep" || grep.ToolInput["pattern"] != "TODO" {
+		t.Errorf("reassembled tool call = %+v", grep)
+	}
+	if parsed.Content[2].ToolID != "call_2" {
+		t.Errorf("second tool call = %+v", parsed.Content[2])
+	}
+	if parsed.StopReason != "tool_calls" {
+		t.Errorf("StopReason = %q", parsed.StopReason)
+	}
+	want := UsageInfo{InputTokens: 16, OutputTokens: 25, CacheReadInputTokens: 64}
+	if parsed.Usage != want {
+		t.Errorf("Usage = %+v, want %+v", parsed.Usage, want)
+	}
+
+	// Loki stop_reason label should come from the finish_reason chunk,
+	// skipping the trailing usage-only chunk.
+	if got := extractStopReason(map[string]interface{}{"chunks": chunks}); got != "tool_calls" {
+		t.Errorf("extractStopReason = %q, want tool_calls", got)
+	}
+}
+
+func TestOpenAIChatEventEmission(t *testing.T) {
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+
+	emitter := &MockEventEmitter{}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_9","type":"function","function":{"name":"shell","arguments":"{\"cmd\":\"ls\"}"}}]},"finish_reason":"tool_calls"}],"usage":{"prompt_tokens":40,"completion_tokens":10}}`))
+	}))
+	defer upstream.Close()
+
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	body := `{"model":"gpt-4.1","messages":[{"role":"user","content":"list"}],"metadata":{"session_id":"openai-chat-1"}}`
+	req := httptest.NewRequest("POST", "/openai/"+upstreamHost+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "shell" || emitter.ToolCallEvents[0].ToolUseID != "call_9" {
+		t.Errorf("ToolCallEvents = %+v", emitter.ToolCallEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("Expected 1 turn_end event, got %d", len(emitter.TurnEndEvents))
+	}
+	end := emitter.TurnEndEvents[0]
+	if end.StopReason != "tool_calls" || end.Tokens.InputTokens != 40 || end.Tokens.OutputTokens != 10 {
+		t.Errorf("turn_end = %+v", end)
+	}
+}
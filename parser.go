@@ -39,10 +39,11 @@ type ContentBlock struct {
 }
 
 type UsageInfo struct {
-	InputTokens              int
-	OutputTokens             int
-	CacheReadInputTokens     int
-	CacheCreationInputTokens int
+	InputTokens                int
+	OutputTokens               int
+	CacheReadInputTokens       int
+	CacheCreationInputTokens   int
+	CacheCreation1hInputTokens int // Part of CacheCreationInputTokens written to the 1-hour cache
 }
 
 func ParseRequestBody(body string, host string) ParsedRequest {
@@ -53,12 +54,28 @@ func ParseRequestBody(body string, host string) ParsedRequest {
 
 	parsed := ParsedRequest{Raw: raw}
 
+	if isGeminiRequest(raw) {
+		parseGeminiRequest(raw, &parsed)
+		return parsed
+	}
+
+	if isResponsesAPIRequest(raw) {
+		parseResponsesAPIRequest(raw, &parsed)
+		return parsed
+	}
+
 	if model, ok := raw["model"].(string); ok {
 		parsed.Model = model
 	}
 	if maxTokens, ok := raw["max_tokens"].(float64); ok {
 		parsed.MaxTokens = int(maxTokens)
 	}
+	// Bedrock Converse: inferenceConfig.maxTokens
+	if ic, ok := raw["inferenceConfig"].(map[string]interface{}); ok {
+		if maxTokens, ok := ic["maxTokens"].(float64); ok {
+			parsed.MaxTokens = int(maxTokens)
+		}
+	}
 	// Handle system as string
 	if system, ok := raw["system"].(string); ok {
 		parsed.System = system
@@ -77,6 +94,7 @@ func ParseRequestBody(body string, host string) ParsedRequest {
 	}
 
 	if messages, ok := raw["messages"].([]interface{}); ok {
+		var toolIndex int
 		for _, m := range messages {
 			if msg, ok := m.(map[string]interface{}); ok {
 				pm := ParsedMessage{Raw: msg}
@@ -105,22 +123,61 @@ func ParseRequestBody(body string, host string) ParsedRequest {
 					}
 				}
 
+				// OpenAI Chat Completions tool_calls / role=tool messages
+				parseOpenAIRequestMessage(msg, &pm, toolIndex)
+				if pm.Role == "tool" {
+					toolIndex++
+				} else {
+					toolIndex = 0
+				}
+
 				parsed.Messages = append(parsed.Messages, pm)
 			}
 		}
 	}
 
+	// Ollama /api/generate prompt and options
+	parseOllamaRequest(raw, &parsed)
+
 	return parsed
 }
 
 func ParseResponseBody(body string, host string) ParsedResponse {
 	var raw map[string]interface{}
 	if json.Unmarshal([]byte(body), &raw) != nil {
+		if parsed, ok := parseGeminiResponseArray(body); ok {
+			return parsed
+		}
 		return ParsedResponse{Raw: raw}
 	}
 
 	parsed := ParsedResponse{Raw: raw}
 
+	if isGeminiResponse(raw) {
+		parseGeminiResponse(raw, &parsed)
+		return parsed
+	}
+
+	if isOpenAIChatResponse(raw) {
+		parseOpenAIChatResponse(raw, &parsed)
+		return parsed
+	}
+
+	if isResponsesAPIResponse(raw) {
+		parseResponsesAPIResponse(raw, &parsed)
+		return parsed
+	}
+
+	if isConverseResponse(raw) {
+		parseConverseResponse(raw, &parsed)
+		return parsed
+	}
+
+	if isOllamaResponse(raw) {
+		parseOllamaResponse(raw, &parsed)
+		return parsed
+	}
+
 	if content, ok := raw["content"].([]interface{}); ok {
 		for _, c := range content {
 			if block, ok := c.(map[string]interface{}); ok {
@@ -142,6 +199,7 @@ func ParseResponseBody(body string, host string) ParsedResponse {
 		if cacheCreate, ok := usage["cache_creation_input_tokens"].(float64); ok {
 			parsed.Usage.CacheCreationInputTokens = int(cacheCreate)
 		}
+		parsed.Usage.CacheCreation1hInputTokens = cacheCreation1h(usage)
 	}
 
 	if stop, ok := raw["stop_reason"].(string); ok {
@@ -151,8 +209,52 @@ func ParseResponseBody(body string, host string) ParsedResponse {
 	return parsed
 }
 
-// ParseStreamingResponse reconstructs a ParsedResponse from SSE chunks
+// cacheCreation1h returns the cache writes of an Anthropic usage object that
+// went to the 1-hour cache.
+func cacheCreation1h(usage map[string]interface{}) int {
+	breakdown, _ := usage["cache_creation"].(map[string]interface{})
+	tokens, _ := breakdown["ephemeral_1h_input_tokens"].(float64)
+	return int(tokens)
+}
+
+// ParseStreamingResponse reconstructs a ParsedResponse from SSE chunks.
+// The wire format (Anthropic, OpenAI Chat Completions, OpenAI Responses,
+// Gemini, Bedrock Converse) is detected from the first decodable event.
+// Ollama NDJSON lines are detected when no SSE event is present.
 func ParseStreamingResponse(chunks []StreamChunk) ParsedResponse {
+	if first := firstSSEEvent(chunks); first == nil {
+		if first := firstNDJSONEvent(chunks); first != nil && isOllamaResponse(first) {
+			return parseOllamaStreamingResponse(chunks)
+		}
+	} else {
+		switch {
+		case isResponsesAPIEvent(first):
+			return parseResponsesStreamingResponse(chunks)
+		case isGeminiResponse(first):
+			return parseGeminiStreamingResponse(chunks)
+		case isOpenAIChatResponse(first):
+			return parseOpenAIChatStreamingResponse(chunks)
+		case isConverseStreamEvent(first):
+			return parseConverseStreamingResponse(chunks)
+		}
+	}
+
+	return parseAnthropicStreamingResponse(chunks)
+}
+
+// firstSSEEvent returns the first decodable SSE data event, or nil.
+func firstSSEEvent(chunks []StreamChunk) map[string]interface{} {
+	for _, chunk := range chunks {
+		if data := decodeSSEData(chunk.Raw); data != nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// parseAnthropicStreamingResponse reconstructs a ParsedResponse from Anthropic
+// Messages SSE events (message_start, content_block_*, message_delta).
+func parseAnthropicStreamingResponse(chunks []StreamChunk) ParsedResponse {
 	parsed := ParsedResponse{}
 
 	// Track content blocks being built
@@ -189,6 +291,7 @@ func ParseStreamingResponse(chunks []StreamChunk) ParsedResponse {
 						if cacheCreate, ok := usage["cache_creation_input_tokens"].(float64); ok {
 							parsed.Usage.CacheCreationInputTokens = int(cacheCreate)
 						}
+						parsed.Usage.CacheCreation1hInputTokens = cacheCreation1h(usage)
 					}
 				}
 
@@ -281,6 +384,9 @@ func parseContentBlock(block map[string]interface{}) ContentBlock {
 
 	if t, ok := block["type"].(string); ok {
 		cb.Type = t
+	} else if converse, ok := parseConverseContentBlock(block); ok {
+		// Bedrock Converse blocks are keyed by kind instead of "type"
+		return converse
 	}
 
 	switch cb.Type {
@@ -215,6 +215,28 @@ func TestParseCacheTokens(t *testing.T) {
 	}
 }
 
+func TestParseCacheTokens1h(t *testing.T) {
+	body := `{
+		"content": [{"type": "text", "text": "Hello"}],
+		"usage": {
+			"input_tokens": 100,
+			"output_tokens": 50,
+			"cache_creation_input_tokens": 30,
+			"cache_creation": {"ephemeral_5m_input_tokens": 10, "ephemeral_1h_input_tokens": 20}
+		}
+	}`
+
+	parsed := ParseResponseBody(body, "api.anthropic.com")
+
+	if parsed.Usage.CacheCreationInputTokens != 30 {
+		t.Errorf("Expected cache_creation_input_tokens 30, got %d", parsed.Usage.CacheCreationInputTokens)
+	}
+
+	if parsed.Usage.CacheCreation1hInputTokens != 20 {
+		t.Errorf("Expected ephemeral_1h_input_tokens 20, got %d", parsed.Usage.CacheCreation1hInputTokens)
+	}
+}
+
 func TestParseCacheTokensMissing(t *testing.T) {
 	// Response without cache token fields (should default to 0)
 	body := `{
@@ -0,0 +1,325 @@
+// policy.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Policy rules ([[policy]] in the config file).
+//
+// A rule matches on provider, path, headers and the caller's identity; empty
+// match fields match anything. A matching rule fires if the request names a
+// denied model, defines a denied tool or is estimated to exceed its input
+// token limit. The first rule that fires refuses the request with an error
+// in the provider's format, logged as a policy_denial entry in place of the
+// response. Rules see requests as they would be forwarded, after rewrites.
+
+// dialectBedrock selects AWS's error format in writePolicyDenial. It is not
+// a provider dialect.
+const dialectBedrock = "bedrock"
+
+// Keys whose string values are base64 payloads (images, documents, thinking
+// signatures) rather than text, and don't count towards input tokens.
+var policyBinaryKeys = map[string]bool{"data": true, "bytes": true, "signature": true}
+
+// policyRule is a validated PolicyRuleConfig.
+type policyRule struct {
+	PolicyRuleConfig
+	path *regexp.Regexp // nil matches any path
+}
+
+// policyRules are evaluated in config order.
+type policyRules []*policyRule
+
+// newPolicyRules validates cfgs against the providers registry. Returns nil
+// if no rules are configured.
+func newPolicyRules(cfgs []PolicyRuleConfig, providers *providerRegistry) (policyRules, error) {
+	var rules policyRules
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("policy: every rule needs a name")
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("policy: duplicate rule name %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		if cfg.Provider != "" && providers.lookup(cfg.Provider) == nil {
+			return nil, fmt.Errorf("policy %q: unknown provider %q", cfg.Name, cfg.Provider)
+		}
+		rule := &policyRule{PolicyRuleConfig: cfg}
+		if cfg.Path != "" {
+			re, err := compilePathPattern(cfg.Path)
+			if err != nil {
+				return nil, fmt.Errorf("policy %q: %w", cfg.Name, err)
+			}
+			rule.path = re
+		}
+		globs := append(append([]string{cfg.Identity}, cfg.DenyModels...), cfg.DenyTools...)
+		for _, value := range cfg.Headers {
+			globs = append(globs, value)
+		}
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("policy %q: invalid pattern %q", cfg.Name, glob)
+			}
+		}
+		if cfg.MaxInputTokens < 0 {
+			return nil, fmt.Errorf("policy %q: max_input_tokens must not be negative", cfg.Name)
+		}
+		if len(cfg.DenyModels) == 0 && len(cfg.DenyTools) == 0 && cfg.MaxInputTokens == 0 {
+			return nil, fmt.Errorf("policy %q: nothing to deny (deny_models, deny_tools or max_input_tokens)", cfg.Name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// names lists the rules for the startup log.
+func (pr policyRules) names() string {
+	var names []string
+	for _, rule := range pr {
+		names = append(names, rule.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// check evaluates the rules against a request and returns the first denial,
+// or nil. model overrides the body's model (Bedrock and Vertex carry it in
+// the path).
+func (pr policyRules) check(provider, reqPath string, header http.Header, identity, model string, body []byte) *PolicyDenial {
+	if len(pr) == 0 {
+		return nil
+	}
+	parsed := ParseRequestBody(string(body), "")
+	if model == "" {
+		model = parsed.Model
+	}
+	if model == "" && isGeminiConversationPath(reqPath) {
+		model = extractGeminiModel(reqPath)
+	}
+	// Azure bodies usually name no model; the deployment stands in for it
+	if model == "" {
+		model = extractAzureDeployment(reqPath)
+	}
+
+	for _, rule := range pr {
+		if !rule.matches(provider, reqPath, header, identity) {
+			continue
+		}
+		if reason, status := rule.violation(model, parsed); reason != "" {
+			return &PolicyDenial{Rule: rule.Name, Reason: reason, Message: rule.Message, Status: status}
+		}
+	}
+	return nil
+}
+
+// matches reports whether every match field set on the rule matches.
+func (rule *policyRule) matches(provider, reqPath string, header http.Header, identity string) bool {
+	if rule.Provider != "" && rule.Provider != provider {
+		return false
+	}
+	if rule.path != nil && !rule.path.MatchString(reqPath) {
+		return false
+	}
+	if rule.Identity != "" && !globMatch(rule.Identity, identity) {
+		return false
+	}
+	for name, glob := range rule.Headers {
+		if !globMatch(glob, header.Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// violation returns why the rule denies a request and the status to answer
+// with, or "" if it doesn't.
+func (rule *policyRule) violation(model string, parsed ParsedRequest) (string, int) {
+	for _, glob := range rule.DenyModels {
+		if globMatch(glob, model) {
+			return fmt.Sprintf("model %s is not allowed", model), http.StatusForbidden
+		}
+	}
+	if len(rule.DenyTools) > 0 {
+		for _, name := range requestToolNames(parsed.Raw) {
+			for _, glob := range rule.DenyTools {
+				if globMatch(glob, name) {
+					return fmt.Sprintf("tool %s is not allowed", name), http.StatusForbidden
+				}
+			}
+		}
+	}
+	if rule.MaxInputTokens > 0 {
+		if n := estimateInputTokens(parsed.Raw); n > rule.MaxInputTokens {
+			// "too long" classifies the turn's error as context_length
+			return fmt.Sprintf("prompt is too long: about %d input tokens, over the limit of %d", n, rule.MaxInputTokens), http.StatusBadRequest
+		}
+	}
+	return "", 0
+}
+
+// requestToolNames returns the names of the tools a request defines, in any
+// dialect. Built-in tools without a name (Responses API web_search, Gemini
+// googleSearch) are named by their type.
+func requestToolNames(raw map[string]interface{}) []string {
+	tools, _ := raw["tools"].([]interface{})
+	if toolConfig, ok := raw["toolConfig"].(map[string]interface{}); ok { // Bedrock Converse
+		converseTools, _ := toolConfig["tools"].([]interface{})
+		tools = append(tools, converseTools...)
+	}
+
+	var names []string
+	for _, t := range tools {
+		tool, _ := t.(map[string]interface{})
+		if fn, ok := tool["function"].(map[string]interface{}); ok { // OpenAI Chat
+			tool = fn
+		}
+		if spec, ok := tool["toolSpec"].(map[string]interface{}); ok { // Bedrock Converse
+			tool = spec
+		}
+		if decls, ok := tool["functionDeclarations"].([]interface{}); ok { // Gemini
+			for _, d := range decls {
+				decl, _ := d.(map[string]interface{})
+				if name, _ := decl["name"].(string); name != "" {
+					names = append(names, name)
+				}
+			}
+			continue
+		}
+		if name, _ := tool["name"].(string); name != "" {
+			names = append(names, name)
+		} else if typ, _ := tool["type"].(string); typ != "" {
+			names = append(names, typ)
+		} else {
+			for key := range tool {
+				names = append(names, key)
+			}
+		}
+	}
+	return names
+}
+
+// estimateInputTokens approximates a request's input tokens at four bytes of
+// text per token. Base64 payloads aren't counted; images cost far fewer
+// tokens than their encoding suggests.
+func estimateInputTokens(raw map[string]interface{}) int {
+	return (textLength(raw) + 3) / 4
+}
+
+// textLength sums the lengths of the strings in a decoded JSON value.
+func textLength(v interface{}) int {
+	n := 0
+	switch v := v.(type) {
+	case string:
+		if !strings.HasPrefix(v, "data:") {
+			n = len(v)
+		}
+	case map[string]interface{}:
+		for key, value := range v {
+			if !policyBinaryKeys[key] {
+				n += textLength(value)
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			n += textLength(value)
+		}
+	}
+	return n
+}
+
+// clientMessage is the error message a denied client sees.
+func (d *PolicyDenial) clientMessage() string {
+	message := d.Message
+	if message == "" {
+		message = d.Reason
+	}
+	return fmt.Sprintf("%s (llm-proxy policy %q)", message, d.Rule)
+}
+
+// writePolicyDenial answers a denied request with an error in the format of
+// the dialect's API, so clients report it like any upstream error. Returns
+// the body written. A 429 (budget used up) tells clients not to retry.
+func writePolicyDenial(w http.ResponseWriter, dialect string, d *PolicyDenial) []byte {
+	message := d.clientMessage()
+	forbidden := d.Status == http.StatusForbidden
+	exhausted := d.Status == http.StatusTooManyRequests
+	if exhausted {
+		w.Header().Set("x-should-retry", "false")
+	}
+
+	var body interface{}
+	switch dialect {
+	case dialectOpenAIChat, dialectOpenAIResponses:
+		errType, code := "invalid_request_error", "policy_violation"
+		if forbidden {
+			errType = "permission_error"
+		}
+		if exhausted {
+			errType, code = "insufficient_quota", "insufficient_quota"
+		}
+		body = map[string]interface{}{"error": map[string]interface{}{
+			"message": message, "type": errType, "param": nil, "code": code,
+		}}
+	case dialectGemini:
+		status := "INVALID_ARGUMENT"
+		if forbidden {
+			status = "PERMISSION_DENIED"
+		}
+		if exhausted {
+			status = "RESOURCE_EXHAUSTED"
+		}
+		body = map[string]interface{}{"error": map[string]interface{}{
+			"code": d.Status, "message": message, "status": status,
+		}}
+	case dialectOllama:
+		body = map[string]interface{}{"error": message}
+	case dialectBedrock:
+		errType := "ValidationException"
+		if forbidden {
+			errType = "AccessDeniedException"
+		}
+		if exhausted {
+			errType = "ServiceQuotaExceededException"
+		}
+		w.Header().Set("x-amzn-ErrorType", errType)
+		body = map[string]interface{}{"message": message}
+	default:
+		errType := "invalid_request_error"
+		if forbidden {
+			errType = "permission_error"
+		}
+		if exhausted {
+			errType = "rate_limit_error"
+		}
+		body = map[string]interface{}{"type": "error", "error": map[string]interface{}{
+			"type": errType, "message": message,
+		}}
+	}
+
+	data, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(d.Status)
+	w.Write(data)
+	return data
+}
+
+// deny answers a request refused by a policy rule. A logged request
+// (requestID set) gets a policy_denial entry, and its turn ends with the
+// denial as the error.
+func (p *Proxy) deny(w http.ResponseWriter, dialect string, d *PolicyDenial, provider, sessionID string, seq int, requestID string, state *PatternState) {
+	if requestID != "" {
+		p.logger.LogPolicyDenial(sessionID, provider, seq, *d, requestID)
+	}
+	body := writePolicyDenial(w, dialect, d)
+	if requestID != "" && p.eventEmitter != nil && state != nil && p.sessionManager != nil {
+		emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, state, nil, UsageInfo{}, 0, "", d.Status, string(body))
+	}
+}
@@ -0,0 +1,409 @@
+// policy_test.go
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewPolicyRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfgs    []PolicyRuleConfig
+		wantErr string
+	}{
+		{"none", nil, ""},
+		{"valid", []PolicyRuleConfig{{Name: "no-opus", Provider: "anthropic", Identity: "ci", DenyModels: []string{"*opus*"}}}, ""},
+		{"missing name", []PolicyRuleConfig{{DenyModels: []string{"*"}}}, "needs a name"},
+		{"duplicate name", []PolicyRuleConfig{{Name: "a", DenyModels: []string{"*"}}, {Name: "a", MaxInputTokens: 10}}, "duplicate"},
+		{"unknown provider", []PolicyRuleConfig{{Name: "a", Provider: "nope", DenyModels: []string{"*"}}}, "unknown provider"},
+		{"relative path", []PolicyRuleConfig{{Name: "a", Path: "v1/messages", DenyModels: []string{"*"}}}, "must start with /"},
+		{"bad model glob", []PolicyRuleConfig{{Name: "a", DenyModels: []string{"claude-["}}}, "invalid pattern"},
+		{"bad tool glob", []PolicyRuleConfig{{Name: "a", DenyTools: []string{"["}}}, "invalid pattern"},
+		{"negative max_input_tokens", []PolicyRuleConfig{{Name: "a", MaxInputTokens: -1}}, "negative"},
+		{"nothing to deny", []PolicyRuleConfig{{Name: "a", Identity: "ci"}}, "nothing to deny"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newPolicyRules(tt.cfgs, builtinRegistry)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPolicyRules_Check(t *testing.T) {
+	image := `{"type":"image","source":{"type":"base64","media_type":"image/png","data":"` + strings.Repeat("A", 8000) + `"}}`
+
+	tests := []struct {
+		name       string
+		rules      []PolicyRuleConfig
+		provider   string
+		path       string
+		header     map[string]string
+		identity   string
+		model      string
+		body       string
+		wantRule   string
+		wantReason string
+		wantStatus int
+	}{
+		{
+			name:       "denied model",
+			rules:      []PolicyRuleConfig{{Name: "no-opus", DenyModels: []string{"claude-opus-*"}}},
+			provider:   "anthropic",
+			path:       "/v1/messages",
+			body:       `{"model":"claude-opus-4-6","messages":[]}`,
+			wantRule:   "no-opus",
+			wantReason: "model claude-opus-4-6 is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:     "allowed model",
+			rules:    []PolicyRuleConfig{{Name: "no-opus", DenyModels: []string{"claude-opus-*"}}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			body:     `{"model":"claude-sonnet-4-5","messages":[]}`,
+		},
+		{
+			name:       "gemini path model",
+			rules:      []PolicyRuleConfig{{Name: "no-pro", DenyModels: []string{"gemini-*-pro"}}},
+			provider:   "gemini",
+			path:       "/v1beta/models/gemini-2.5-pro:streamGenerateContent",
+			body:       `{"contents":[]}`,
+			wantRule:   "no-pro",
+			wantReason: "model gemini-2.5-pro is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "azure deployment as the model",
+			rules:      []PolicyRuleConfig{{Name: "no-gpt5", DenyModels: []string{"gpt-5*"}}},
+			provider:   "azure",
+			path:       "/openai/deployments/gpt-5-prod/chat/completions",
+			body:       `{"messages":[]}`,
+			wantRule:   "no-gpt5",
+			wantReason: "model gpt-5-prod is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "bedrock model from the path",
+			rules:      []PolicyRuleConfig{{Name: "no-opus", DenyModels: []string{"*opus*"}}},
+			provider:   "anthropic",
+			path:       "/model/us.anthropic.claude-opus-4-6-v1:0/invoke",
+			model:      "us.anthropic.claude-opus-4-6-v1:0",
+			body:       `{"anthropic_version":"bedrock-2023-05-31","messages":[]}`,
+			wantRule:   "no-opus",
+			wantReason: "model us.anthropic.claude-opus-4-6-v1:0 is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:     "identity must match",
+			rules:    []PolicyRuleConfig{{Name: "ci", Identity: "ci-*", DenyModels: []string{"*"}}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			identity: "alice",
+			body:     `{"model":"claude-opus-4-6"}`,
+		},
+		{
+			name:       "identity matches",
+			rules:      []PolicyRuleConfig{{Name: "ci", Identity: "ci-*", DenyModels: []string{"*"}, Message: "CI may not call models directly"}},
+			provider:   "anthropic",
+			path:       "/v1/messages",
+			identity:   "ci-runner",
+			body:       `{"model":"claude-opus-4-6"}`,
+			wantRule:   "ci",
+			wantReason: "model claude-opus-4-6 is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:     "header and provider must match",
+			rules:    []PolicyRuleConfig{{Name: "ci", Provider: "openai", Headers: map[string]string{"X-Team": "ci"}, DenyModels: []string{"*"}}},
+			provider: "openai",
+			path:     "/v1/chat/completions",
+			header:   map[string]string{"X-Team": "web"},
+			body:     `{"model":"gpt-5"}`,
+		},
+		{
+			name:       "anthropic tool",
+			rules:      []PolicyRuleConfig{{Name: "no-shell", DenyTools: []string{"bash*"}}},
+			provider:   "anthropic",
+			path:       "/v1/messages",
+			body:       `{"model":"m","tools":[{"name":"read_file"},{"type":"bash_20250124","name":"bash"}]}`,
+			wantRule:   "no-shell",
+			wantReason: "tool bash is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "openai chat tool",
+			rules:      []PolicyRuleConfig{{Name: "no-shell", DenyTools: []string{"shell"}}},
+			provider:   "openai",
+			path:       "/v1/chat/completions",
+			body:       `{"model":"gpt-5","tools":[{"type":"function","function":{"name":"shell"}}]}`,
+			wantRule:   "no-shell",
+			wantReason: "tool shell is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "responses built-in tool",
+			rules:      []PolicyRuleConfig{{Name: "no-web", DenyTools: []string{"web_search*"}}},
+			provider:   "openai",
+			path:       "/v1/responses",
+			body:       `{"model":"gpt-5","input":"hi","tools":[{"type":"web_search_preview"}]}`,
+			wantRule:   "no-web",
+			wantReason: "tool web_search_preview is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "gemini function declaration",
+			rules:      []PolicyRuleConfig{{Name: "no-shell", DenyTools: []string{"run_shell_command"}}},
+			provider:   "gemini",
+			path:       "/v1beta/models/gemini-2.5-flash:generateContent",
+			body:       `{"contents":[],"tools":[{"functionDeclarations":[{"name":"read_file"},{"name":"run_shell_command"}]}]}`,
+			wantRule:   "no-shell",
+			wantReason: "tool run_shell_command is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "converse tool",
+			rules:      []PolicyRuleConfig{{Name: "no-shell", DenyTools: []string{"bash"}}},
+			provider:   "anthropic",
+			path:       "/model/m/converse",
+			model:      "m",
+			body:       `{"messages":[],"toolConfig":{"tools":[{"toolSpec":{"name":"bash"}}]}}`,
+			wantRule:   "no-shell",
+			wantReason: "tool bash is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "too many input tokens",
+			rules:      []PolicyRuleConfig{{Name: "small", MaxInputTokens: 100}},
+			provider:   "anthropic",
+			path:       "/v1/messages",
+			body:       `{"model":"m","messages":[{"role":"user","content":"` + strings.Repeat("word ", 200) + `"}]}`,
+			wantRule:   "small",
+			wantReason: "prompt is too long: about 252 input tokens, over the limit of 100",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:     "images don't count towards input tokens",
+			rules:    []PolicyRuleConfig{{Name: "small", MaxInputTokens: 100}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			body:     `{"model":"m","messages":[{"role":"user","content":[` + image + `]}]}`,
+		},
+		{
+			name:       "first rule that fires wins",
+			rules:      []PolicyRuleConfig{{Name: "tokens", MaxInputTokens: 1000}, {Name: "models", DenyModels: []string{"m"}}, {Name: "all", DenyModels: []string{"*"}}},
+			provider:   "anthropic",
+			path:       "/v1/messages",
+			body:       `{"model":"m"}`,
+			wantRule:   "models",
+			wantReason: "model m is not allowed",
+			wantStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newPolicyRules(tt.rules, builtinRegistry)
+			if err != nil {
+				t.Fatalf("newPolicyRules: %v", err)
+			}
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+
+			d := rules.check(tt.provider, tt.path, header, tt.identity, tt.model, []byte(tt.body))
+			if tt.wantRule == "" {
+				if d != nil {
+					t.Errorf("denial = %+v, want none", d)
+				}
+				return
+			}
+			if d == nil {
+				t.Fatalf("no denial, want rule %q", tt.wantRule)
+			}
+			if d.Rule != tt.wantRule || d.Reason != tt.wantReason || d.Status != tt.wantStatus {
+				t.Errorf("denial = %+v, want rule %q reason %q status %d", d, tt.wantRule, tt.wantReason, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestWritePolicyDenial(t *testing.T) {
+	denied := &PolicyDenial{Rule: "no-opus", Reason: "model claude-opus-4-6 is not allowed", Status: http.StatusForbidden}
+	tooLong := &PolicyDenial{Rule: "small", Reason: "prompt is too long", Message: "Ask for a bigger budget", Status: http.StatusBadRequest}
+	overBudget := &PolicyDenial{Rule: "budget", Reason: "session budget is used up", Status: http.StatusTooManyRequests}
+
+	tests := []struct {
+		dialect  string
+		denial   *PolicyDenial
+		wantBody string
+		wantAWS  string
+	}{
+		{dialectAnthropic, denied, `{"error":{"message":"model claude-opus-4-6 is not allowed (llm-proxy policy \"no-opus\")","type":"permission_error"},"type":"error"}`, ""},
+		{dialectAnthropic, tooLong, `{"error":{"message":"Ask for a bigger budget (llm-proxy policy \"small\")","type":"invalid_request_error"},"type":"error"}`, ""},
+		{dialectOpenAIChat, denied, `{"error":{"code":"policy_violation","message":"model claude-opus-4-6 is not allowed (llm-proxy policy \"no-opus\")","param":null,"type":"permission_error"}}`, ""},
+		{dialectOpenAIResponses, tooLong, `{"error":{"code":"policy_violation","message":"Ask for a bigger budget (llm-proxy policy \"small\")","param":null,"type":"invalid_request_error"}}`, ""},
+		{dialectGemini, denied, `{"error":{"code":403,"message":"model claude-opus-4-6 is not allowed (llm-proxy policy \"no-opus\")","status":"PERMISSION_DENIED"}}`, ""},
+		{dialectOllama, tooLong, `{"error":"Ask for a bigger budget (llm-proxy policy \"small\")"}`, ""},
+		{dialectBedrock, denied, `{"message":"model claude-opus-4-6 is not allowed (llm-proxy policy \"no-opus\")"}`, "AccessDeniedException"},
+		{dialectBedrock, tooLong, `{"message":"Ask for a bigger budget (llm-proxy policy \"small\")"}`, "ValidationException"},
+		{dialectAnthropic, overBudget, `{"error":{"message":"session budget is used up (llm-proxy policy \"budget\")","type":"rate_limit_error"},"type":"error"}`, ""},
+		{dialectOpenAIChat, overBudget, `{"error":{"code":"insufficient_quota","message":"session budget is used up (llm-proxy policy \"budget\")","param":null,"type":"insufficient_quota"}}`, ""},
+		{dialectGemini, overBudget, `{"error":{"code":429,"message":"session budget is used up (llm-proxy policy \"budget\")","status":"RESOURCE_EXHAUSTED"}}`, ""},
+		{dialectBedrock, overBudget, `{"message":"session budget is used up (llm-proxy policy \"budget\")"}`, "ServiceQuotaExceededException"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dialect+"/"+tt.denial.Rule, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			body := writePolicyDenial(w, tt.dialect, tt.denial)
+
+			if w.Code != tt.denial.Status {
+				t.Errorf("status = %d, want %d", w.Code, tt.denial.Status)
+			}
+			if w.Body.String() != tt.wantBody || string(body) != tt.wantBody {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.wantBody)
+			}
+			if got := w.Header().Get("x-amzn-ErrorType"); got != tt.wantAWS {
+				t.Errorf("x-amzn-ErrorType = %q, want %q", got, tt.wantAWS)
+			}
+			if retry := w.Header().Get("x-should-retry"); (retry == "false") != (tt.denial.Status == http.StatusTooManyRequests) {
+				t.Errorf("x-should-retry = %q", retry)
+			}
+		})
+	}
+}
+
+func TestServer_PolicyDenial(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("denied request reached upstream")
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	srv, err := NewServer(Config{
+		Port:     8080,
+		LogDir:   tmpDir,
+		Policies: []PolicyRuleConfig{{Name: "no-opus-from-ci", Identity: "ci", DenyModels: []string{"claude-opus-*"}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages",
+		strings.NewReader(`{"model":"claude-opus-4-6","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	req = req.WithContext(context.WithValue(req.Context(), proxyIdentityKey{}, "ci"))
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", w.Code)
+	}
+	var errBody struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &errBody); err != nil || errBody.Type != "error" || errBody.Error.Type != "permission_error" {
+		t.Errorf("body = %s, want an Anthropic permission_error", w.Body.String())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	logFiles, _ := filepath.Glob(filepath.Join(tmpDir, upstreamHost, time.Now().Format("2006-01-02"), "*.jsonl"))
+	if len(logFiles) != 1 {
+		t.Fatalf("log files = %v, want one", logFiles)
+	}
+	f, err := os.Open(logFiles[0])
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	var types []string
+	var denial map[string]interface{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &entry)
+		types = append(types, entry["type"].(string))
+		if entry["type"] == "policy_denial" {
+			denial, _ = entry["policy"].(map[string]interface{})
+		}
+	}
+	if strings.Join(types, ",") != "session_start,request,policy_denial" {
+		t.Errorf("entry types = %v, want session_start,request,policy_denial", types)
+	}
+	if denial["rule"] != "no-opus-from-ci" || denial["status"] != float64(403) {
+		t.Errorf("policy = %v, want the rule and status", denial)
+	}
+}
+
+func TestServer_PolicyDenialRealtime(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("denied handshake reached upstream")
+	}))
+	defer upstream.Close()
+
+	srv, err := NewServer(Config{
+		Port:     8080,
+		LogDir:   t.TempDir(),
+		Policies: []PolicyRuleConfig{{Name: "no-realtime", DenyModels: []string{"gpt-realtime*"}}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	req := httptest.NewRequest("GET", "/openai/"+strings.TrimPrefix(upstream.URL, "http://")+"/v1/realtime?model=gpt-realtime-2025-08-28", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "model gpt-realtime-2025-08-28 is not allowed") {
+		t.Errorf("response = %d %s, want a 403 naming the model", w.Code, w.Body.String())
+	}
+}
+
+func TestServeBedrock_PolicyDenial(t *testing.T) {
+	proxy, mock := newTestBedrockProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("denied request reached Bedrock")
+	}))
+	defer mock.Close()
+	rules, err := newPolicyRules([]PolicyRuleConfig{{Name: "no-opus", DenyModels: []string{"*opus*"}}}, builtinRegistry)
+	if err != nil {
+		t.Fatalf("newPolicyRules: %v", err)
+	}
+	proxy.policies = rules
+
+	req := httptest.NewRequest("POST", "/model/anthropic.claude-opus-4-6-v1:0/invoke",
+		strings.NewReader(`{"anthropic_version":"bedrock-2023-05-31","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`))
+	w := httptest.NewRecorder()
+	proxy.serveBedrock(w, req)
+
+	if w.Code != http.StatusForbidden || w.Header().Get("x-amzn-ErrorType") != "AccessDeniedException" {
+		t.Errorf("status = %d, error type %q, want 403 AccessDeniedException", w.Code, w.Header().Get("x-amzn-ErrorType"))
+	}
+}
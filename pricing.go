@@ -0,0 +1,187 @@
+// pricing.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Model prices, in USD per million tokens.
+//
+// A model takes the first entry whose glob matches: [[pricing]] entries from
+// the config file first, then the built-in table. Bedrock model IDs carry
+// region and vendor prefixes (us.anthropic.claude-...), so globs usually
+// start with *. Prices are list prices for the provider's standard tier;
+// batch, priority and regional-endpoint premiums aren't modeled.
+
+// pricingVersion dates the built-in table. Prices changed since then can be
+// overridden with [[pricing]].
+const pricingVersion = "2026-10-01"
+
+// Anthropic long-context pricing applies to the whole request once the
+// prompt (input plus cache tokens) exceeds 200K tokens.
+const anthropicLongContext = 200000
+
+// defaultModelPrices is the built-in table. Specific globs go before general
+// ones.
+var defaultModelPrices = modelPrices{
+	// Anthropic (also on Bedrock and Vertex)
+	{Model: "*claude-opus-4-5*", PriceRates: PriceRates{Input: 5, Output: 25, CacheWrite: 6.25, CacheWrite1h: 10, CacheRead: 0.5}},
+	{Model: "*claude-opus-4*", PriceRates: PriceRates{Input: 15, Output: 75, CacheWrite: 18.75, CacheWrite1h: 30, CacheRead: 1.5}},
+	{Model: "*claude-sonnet-4*", PriceRates: PriceRates{Input: 3, Output: 15, CacheWrite: 3.75, CacheWrite1h: 6, CacheRead: 0.3},
+		LongContextAbove: anthropicLongContext, LongContext: PriceRates{Input: 6, Output: 22.5, CacheWrite: 7.5, CacheWrite1h: 12, CacheRead: 0.6}},
+	{Model: "*claude-haiku-4-5*", PriceRates: PriceRates{Input: 1, Output: 5, CacheWrite: 1.25, CacheWrite1h: 2, CacheRead: 0.1}},
+	{Model: "*claude-3-7-sonnet*", PriceRates: PriceRates{Input: 3, Output: 15, CacheWrite: 3.75, CacheWrite1h: 6, CacheRead: 0.3}},
+	{Model: "*claude-3-5-sonnet*", PriceRates: PriceRates{Input: 3, Output: 15, CacheWrite: 3.75, CacheWrite1h: 6, CacheRead: 0.3}},
+	{Model: "*claude-3-5-haiku*", PriceRates: PriceRates{Input: 0.8, Output: 4, CacheWrite: 1, CacheWrite1h: 1.6, CacheRead: 0.08}},
+	{Model: "*claude-3-opus*", PriceRates: PriceRates{Input: 15, Output: 75, CacheWrite: 18.75, CacheWrite1h: 30, CacheRead: 1.5}},
+	{Model: "*claude-3-haiku*", PriceRates: PriceRates{Input: 0.25, Output: 1.25, CacheWrite: 0.3, CacheWrite1h: 0.5, CacheRead: 0.03}},
+
+	// OpenAI (cache writes are free)
+	{Model: "*gpt-5-mini*", PriceRates: PriceRates{Input: 0.25, Output: 2, CacheRead: 0.025}},
+	{Model: "*gpt-5-nano*", PriceRates: PriceRates{Input: 0.05, Output: 0.4, CacheRead: 0.005}},
+	{Model: "*gpt-5*", PriceRates: PriceRates{Input: 1.25, Output: 10, CacheRead: 0.125}},
+	{Model: "*gpt-4.1-mini*", PriceRates: PriceRates{Input: 0.4, Output: 1.6, CacheRead: 0.1}},
+	{Model: "*gpt-4.1-nano*", PriceRates: PriceRates{Input: 0.1, Output: 0.4, CacheRead: 0.025}},
+	{Model: "*gpt-4.1*", PriceRates: PriceRates{Input: 2, Output: 8, CacheRead: 0.5}},
+	{Model: "*gpt-4o-mini*", PriceRates: PriceRates{Input: 0.15, Output: 0.6, CacheRead: 0.075}},
+	{Model: "*gpt-4o*", PriceRates: PriceRates{Input: 2.5, Output: 10, CacheRead: 1.25}},
+	{Model: "o4-mini*", PriceRates: PriceRates{Input: 1.1, Output: 4.4, CacheRead: 0.275}},
+	{Model: "o3-mini*", PriceRates: PriceRates{Input: 1.1, Output: 4.4, CacheRead: 0.55}},
+	{Model: "o3*", PriceRates: PriceRates{Input: 2, Output: 8, CacheRead: 0.5}},
+	{Model: "o1-mini*", PriceRates: PriceRates{Input: 1.1, Output: 4.4, CacheRead: 0.55}},
+	{Model: "o1*", PriceRates: PriceRates{Input: 15, Output: 60, CacheRead: 7.5}},
+
+	// Bedrock (Claude is priced above)
+	{Model: "*gpt-oss-120b*", PriceRates: PriceRates{Input: 0.15, Output: 0.6}},
+	{Model: "*gpt-oss-20b*", PriceRates: PriceRates{Input: 0.07, Output: 0.3}},
+	{Model: "*nova-premier*", PriceRates: PriceRates{Input: 2.5, Output: 12.5, CacheRead: 0.625}},
+	{Model: "*nova-pro*", PriceRates: PriceRates{Input: 0.8, Output: 3.2, CacheRead: 0.2}},
+	{Model: "*nova-lite*", PriceRates: PriceRates{Input: 0.06, Output: 0.24, CacheRead: 0.015}},
+	{Model: "*nova-micro*", PriceRates: PriceRates{Input: 0.035, Output: 0.14, CacheRead: 0.00875}},
+	{Model: "*llama4-maverick*", PriceRates: PriceRates{Input: 0.24, Output: 0.97}},
+	{Model: "*llama4-scout*", PriceRates: PriceRates{Input: 0.17, Output: 0.66}},
+}
+
+// modelPrices are looked up in order.
+type modelPrices []ModelPriceConfig
+
+// newModelPrices validates cfgs and puts them ahead of the built-in table.
+func newModelPrices(cfgs []ModelPriceConfig) (modelPrices, error) {
+	for _, cfg := range cfgs {
+		if cfg.Model == "" {
+			return nil, fmt.Errorf("pricing: every entry needs a model")
+		}
+		if _, err := path.Match(cfg.Model, ""); err != nil {
+			return nil, fmt.Errorf("pricing: invalid model pattern %q", cfg.Model)
+		}
+		if cfg.PriceRates.negative() || cfg.LongContext.negative() || cfg.LongContextAbove < 0 {
+			return nil, fmt.Errorf("pricing %q: prices must not be negative", cfg.Model)
+		}
+		if cfg.LongContext != (PriceRates{}) && cfg.LongContextAbove == 0 {
+			return nil, fmt.Errorf("pricing %q: long_context rates need long_context_above", cfg.Model)
+		}
+	}
+	return append(modelPrices(cfgs), defaultModelPrices...), nil
+}
+
+// describe summarizes the table for the startup log.
+func (mp modelPrices) describe() string {
+	overrides := len(mp) - len(defaultModelPrices)
+	if overrides == 0 {
+		return "built-in " + pricingVersion
+	}
+	var models []string
+	for _, price := range mp[:overrides] {
+		models = append(models, price.Model)
+	}
+	return fmt.Sprintf("built-in %s, overridden for %s", pricingVersion, strings.Join(models, ","))
+}
+
+// lookup returns the price of model.
+func (mp modelPrices) lookup(model string) (ModelPriceConfig, bool) {
+	if model == "" {
+		return ModelPriceConfig{}, false
+	}
+	for _, price := range mp {
+		if globMatch(price.Model, model) {
+			return price, true
+		}
+	}
+	return ModelPriceConfig{}, false
+}
+
+// negative reports whether any rate is negative.
+func (r PriceRates) negative() bool {
+	return r.Input < 0 || r.Output < 0 || r.CacheWrite < 0 || r.CacheWrite1h < 0 || r.CacheRead < 0
+}
+
+// cost returns what usage costs at price, in USD. Past long_context_above
+// prompt tokens, every token is billed at the long-context rates.
+func (price ModelPriceConfig) cost(u UsageInfo) float64 {
+	rates := price.PriceRates
+	prompt := u.InputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens
+	if price.LongContextAbove > 0 && prompt > price.LongContextAbove {
+		rates = price.LongContext
+	}
+	write1h := rates.CacheWrite1h
+	if write1h == 0 {
+		write1h = rates.CacheWrite
+	}
+	return (float64(u.InputTokens)*rates.Input +
+		float64(u.OutputTokens)*rates.Output +
+		float64(u.CacheCreationInputTokens-u.CacheCreation1hInputTokens)*rates.CacheWrite +
+		float64(u.CacheCreation1hInputTokens)*write1h +
+		float64(u.CacheReadInputTokens)*rates.CacheRead) / 1e6
+}
+
+// modelOfRequest returns the model a request names: in the body, or in the
+// path for Gemini, Bedrock and Vertex. For Azure it falls back to the
+// deployment, which prices it when the deployment is named after its model.
+func modelOfRequest(reqPath string, body []byte) string {
+	var fields map[string]json.RawMessage
+	json.Unmarshal(body, &fields)
+	if model := requestModel(fields, reqPath); model != "" {
+		return model
+	}
+	if route, err := parseVertexPath(reqPath); err == nil {
+		return route.model
+	}
+	// Bedrock paths may have a region or credential prefix
+	if i := strings.Index(reqPath, "/model/"); i >= 0 {
+		modelID, _ := extractModelID(reqPath[i:])
+		return modelID
+	}
+	return extractAzureDeployment(reqPath)
+}
+
+// usageCoster is implemented by loggers that price a logged request's usage
+// by the model of its request (Logger, MultiWriter).
+type usageCoster interface {
+	usageCost(requestID string, usage UsageInfo) (float64, bool)
+}
+
+// usageCostOf prices usage of a request logged by logger, 0 if it can't.
+func usageCostOf(logger ProxyLogger, requestID string, usage UsageInfo) float64 {
+	if coster, ok := logger.(usageCoster); ok {
+		cost, _ := coster.usageCost(requestID, usage)
+		return cost
+	}
+	return 0
+}
+
+// responseCost prices a successful response from its body or stream chunks.
+func responseCost(coster usageCoster, requestID string, status int, body []byte, chunks []StreamChunk) (float64, bool) {
+	if status != 200 {
+		return 0, false
+	}
+	var parsed ParsedResponse
+	if chunks != nil {
+		parsed = ParseStreamingResponse(chunks)
+	} else {
+		parsed = ParseResponseBody(string(body), "")
+	}
+	return coster.usageCost(requestID, parsed.Usage)
+}
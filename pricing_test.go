@@ -0,0 +1,91 @@
+// pricing_test.go
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestNewModelPrices(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfgs    []ModelPriceConfig
+		wantErr bool
+	}{
+		{"none", nil, false},
+		{"valid", []ModelPriceConfig{{Model: "*claude-sonnet-4*", PriceRates: PriceRates{Input: 3, Output: 15}}}, false},
+		{"no model", []ModelPriceConfig{{PriceRates: PriceRates{Input: 3}}}, true},
+		{"bad glob", []ModelPriceConfig{{Model: "[claude", PriceRates: PriceRates{Input: 3}}}, true},
+		{"negative", []ModelPriceConfig{{Model: "gpt-4o", PriceRates: PriceRates{Output: -1}}}, true},
+		{"long context without threshold", []ModelPriceConfig{{Model: "gpt-4o", LongContext: PriceRates{Input: 5}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newModelPrices(tt.cfgs)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestModelOfRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+		want string
+	}{
+		{"body", "/v1/messages", `{"model":"claude-haiku-4-5"}`, "claude-haiku-4-5"},
+		{"gemini path", "/v1beta/models/gemini-2.5-pro:generateContent", `{"contents":[]}`, "gemini-2.5-pro"},
+		{"vertex path", "/v1/projects/my-project-123/locations/us-east5/publishers/anthropic/models/claude-sonnet-4-5@20250929:rawPredict", `{}`, "claude-sonnet-4-5@20250929"},
+		{"bedrock path", "/us-west-2/model/anthropic.claude-3-haiku-20240307-v1:0/invoke", `{}`, "anthropic.claude-3-haiku-20240307-v1:0"},
+		{"azure deployment", "/openai/deployments/gpt-4o/chat/completions", `{"messages":[]}`, "gpt-4o"},
+		{"azure body model", "/openai/deployments/prod/chat/completions", `{"model":"gpt-4o-mini"}`, "gpt-4o-mini"},
+		{"none", "/v1/models", ``, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := modelOfRequest(tt.path, []byte(tt.body)); got != tt.want {
+				t.Errorf("modelOfRequest = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestModelPrices_Cost(t *testing.T) {
+	prices, err := newModelPrices([]ModelPriceConfig{
+		{Model: "*claude-haiku-4-5*", PriceRates: PriceRates{Input: 2, Output: 10, CacheWrite: 2.5, CacheRead: 0.2}},
+	})
+	if err != nil {
+		t.Fatalf("newModelPrices: %v", err)
+	}
+	usage := UsageInfo{InputTokens: 1000, OutputTokens: 2000, CacheCreationInputTokens: 4000, CacheReadInputTokens: 10000}
+
+	tests := []struct {
+		model string
+		want  float64
+		found bool
+	}{
+		{"claude-haiku-4-5-20251001", 0.002 + 0.02 + 0.01 + 0.002, true},
+		{"us.anthropic.claude-sonnet-4-5-20250929-v1:0", 0.003 + 0.03 + 0.015 + 0.003, true},
+		{"gpt-4o-2024-08-06", 0.0025 + 0.02 + 0.0125, true},
+		{"gpt-4o-mini", 0.00015 + 0.0012 + 0.00075, true},
+		{"mistral-large", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			price, found := prices.lookup(tt.model)
+			if found != tt.found {
+				t.Fatalf("found = %v, want %v", found, tt.found)
+			}
+			if got := price.cost(usage); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("cost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,418 @@
+// providers.go
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Provider registry.
+//
+// Every /{provider}/{upstream}/{path} route is described by a ProviderConfig:
+// its default upstream, which paths are conversations, the wire dialect its
+// bodies use, how to find a client session ID and which headers carry
+// credentials. The built-in providers are registered the same way as
+// [[providers]] entries from the config file, and a config entry with a
+// built-in name replaces it. Each Server builds its own registry and hands
+// it to the Proxy; code below that works with a spec or its dialect.
+
+// Wire dialects a provider can speak.
+const (
+	dialectAnthropic       = "anthropic"
+	dialectOpenAIChat      = "openai-chat"
+	dialectOpenAIResponses = "openai-responses"
+	dialectGemini          = "gemini"
+	dialectOllama          = "ollama"
+)
+
+var validDialects = map[string]bool{
+	dialectAnthropic:       true,
+	dialectOpenAIChat:      true,
+	dialectOpenAIResponses: true,
+	dialectGemini:          true,
+	dialectOllama:          true,
+}
+
+// validProviderName restricts provider names to lowercase URL- and label-safe characters.
+var validProviderName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// reservedProviderNames are first path segments routed before the registry.
+var reservedProviderNames = map[string]bool{
+	"bedrock": true,
+	"model":   true,
+	"health":  true,
+	"v1":      true,
+}
+
+// builtinProviders are registered before any [[providers]] entries.
+var builtinProviders = []ProviderConfig{
+	{
+		Name:              "anthropic",
+		Upstream:          "api.anthropic.com",
+		Dialect:           dialectAnthropic,
+		ConversationPaths: []string{"/v1/messages"},
+		AuthHeaders:       []string{"x-api-key", "authorization"},
+	},
+	{
+		Name:     "openai",
+		Upstream: "api.openai.com",
+		Dialect:  dialectOpenAIChat,
+		ConversationPaths: []string{
+			"/v1/chat/completions",
+			"/v1/completions",
+			"/v1/responses",
+			// Threads API: /v1/threads/{id}/messages or /v1/threads/{id}/runs[/...]
+			"/v1/threads/*/messages",
+			"/v1/threads/*/messages/**",
+			"/v1/threads/*/runs",
+			"/v1/threads/*/runs/**",
+			// ChatGPT backend API (used with OAuth authentication)
+			"/backend-api/**/responses",
+			// Realtime API (WebSocket)
+			"/v1/realtime",
+		},
+		AuthHeaders: []string{"authorization"},
+		// JWT tokens (ChatGPT OAuth) go to chatgpt.com/backend-api/codex;
+		// API keys (sk-...) stay on api.openai.com
+		JWTUpstream:   "chatgpt.com",
+		JWTPathPrefix: "/backend-api/codex",
+	},
+	{
+		Name:              "gemini",
+		Upstream:          "generativelanguage.googleapis.com",
+		Dialect:           dialectGemini,
+		ConversationPaths: []string{"/**:generateContent", "/**:streamGenerateContent"},
+		AuthHeaders:       []string{"x-goog-api-key", "authorization"},
+	},
+	{
+		Name:    "azure",
+		Dialect: dialectOpenAIChat,
+		ConversationPaths: []string{
+			"/openai/deployments/*/chat/completions",
+			"/openai/deployments/*/completions",
+			"/openai/deployments/*/responses",
+			"/openai/v1/chat/completions",
+			"/openai/v1/completions",
+			"/openai/v1/responses",
+			"/openai/responses",
+			"/openai/realtime",
+			"/openai/v1/realtime",
+		},
+		AuthHeaders: []string{"api-key", "authorization"},
+	},
+	{
+		// Ollama serves plain HTTP on any host, not just localhost
+		Name:              "ollama",
+		Upstream:          "localhost:11434",
+		Scheme:            "http",
+		Dialect:           dialectOllama,
+		ConversationPaths: []string{"/api/chat", "/api/generate"},
+		AuthHeaders:       []string{"authorization"},
+	},
+}
+
+// providerSpec is a validated ProviderConfig with compiled path patterns.
+type providerSpec struct {
+	ProviderConfig
+	conversation []*regexp.Regexp
+}
+
+// baselineAuthHeaders always carry credentials, whatever the registry says,
+// so overriding a built-in provider can't leak its keys into the logs.
+var baselineAuthHeaders = map[string]bool{
+	"authorization":  true,
+	"x-api-key":      true,
+	"api-key":        true,
+	"x-goog-api-key": true,
+}
+
+// providerRegistry maps provider names to their specs.
+type providerRegistry struct {
+	specs       map[string]*providerSpec
+	authHeaders map[string]bool // lowercase, across all providers
+}
+
+// builtinRegistry has only the built-in providers, for code that runs
+// without a Server's registry (CLI commands, tests). It is never modified.
+var builtinRegistry = mustProviderRegistry(nil)
+
+func mustProviderRegistry(configs []ProviderConfig) *providerRegistry {
+	reg, err := newProviderRegistry(configs)
+	if err != nil {
+		panic(err)
+	}
+	return reg
+}
+
+// newProviderRegistry builds a registry from the built-in providers plus
+// configs.
+func newProviderRegistry(configs []ProviderConfig) (*providerRegistry, error) {
+	reg := &providerRegistry{
+		specs:       make(map[string]*providerSpec),
+		authHeaders: make(map[string]bool),
+	}
+	for h := range baselineAuthHeaders {
+		reg.authHeaders[h] = true
+	}
+	for _, cfg := range append(append([]ProviderConfig{}, builtinProviders...), configs...) {
+		spec, err := newProviderSpec(cfg)
+		if err != nil {
+			return nil, err
+		}
+		// Later entries replace earlier ones, so config overrides built-ins
+		reg.specs[cfg.Name] = spec
+	}
+	for _, spec := range reg.specs {
+		for _, h := range spec.AuthHeaders {
+			reg.authHeaders[strings.ToLower(h)] = true
+		}
+	}
+	return reg, nil
+}
+
+// newProviderSpec validates cfg and compiles its conversation path patterns.
+func newProviderSpec(cfg ProviderConfig) (*providerSpec, error) {
+	if !validProviderName.MatchString(cfg.Name) || reservedProviderNames[cfg.Name] {
+		return nil, fmt.Errorf("provider: invalid name %q", cfg.Name)
+	}
+	if !validDialects[cfg.Dialect] {
+		return nil, fmt.Errorf("provider %q: unknown dialect %q (expected anthropic, openai-chat, openai-responses, gemini or ollama)", cfg.Name, cfg.Dialect)
+	}
+	if cfg.Scheme != "" && cfg.Scheme != "http" && cfg.Scheme != "https" {
+		return nil, fmt.Errorf("provider %q: scheme must be http or https, got %q", cfg.Name, cfg.Scheme)
+	}
+	if strings.ContainsAny(cfg.Upstream, "/?#") || strings.ContainsAny(cfg.JWTUpstream, "/?#") {
+		return nil, fmt.Errorf("provider %q: upstream must be a host[:port]", cfg.Name)
+	}
+	if cfg.JWTPathPrefix != "" && cfg.JWTUpstream == "" {
+		return nil, fmt.Errorf("provider %q: jwt_path_prefix requires jwt_upstream", cfg.Name)
+	}
+	if cfg.JWTUpstream != "" && cfg.Upstream == "" {
+		return nil, fmt.Errorf("provider %q: jwt_upstream requires upstream", cfg.Name)
+	}
+	if len(cfg.ConversationPaths) == 0 {
+		return nil, fmt.Errorf("provider %q: at least one conversation_paths pattern is required", cfg.Name)
+	}
+	for _, rule := range cfg.SessionID {
+		if err := validateSessionIDRule(rule); err != nil {
+			return nil, fmt.Errorf("provider %q: %w", cfg.Name, err)
+		}
+	}
+
+	spec := &providerSpec{ProviderConfig: cfg}
+	for _, pattern := range cfg.ConversationPaths {
+		re, err := compilePathPattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("provider %q: %w", cfg.Name, err)
+		}
+		spec.conversation = append(spec.conversation, re)
+	}
+	return spec, nil
+}
+
+// compilePathPattern compiles a conversation path pattern. "*" matches one
+// non-empty path segment, "**" matches anything (including "/"), and "/**/"
+// also matches a single "/".
+func compilePathPattern(pattern string) (*regexp.Regexp, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, fmt.Errorf("conversation path %q must start with /", pattern)
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); {
+		switch {
+		case strings.HasPrefix(pattern[i:], "/**/"):
+			b.WriteString("/(?:.*/)?")
+			i += 4
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i += 2
+		case pattern[i] == '*':
+			b.WriteString("[^/]+")
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			i++
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// lookup returns the spec for a provider name, or nil if unknown.
+func (reg *providerRegistry) lookup(name string) *providerSpec {
+	return reg.specs[name]
+}
+
+// names returns the registered provider names, sorted.
+func (reg *providerRegistry) names() []string {
+	names := make([]string, 0, len(reg.specs))
+	for name := range reg.specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forHost returns the provider whose default or JWT upstream is host, or ""
+// if there is none.
+func (reg *providerRegistry) forHost(host string) string {
+	if host == "" {
+		return ""
+	}
+	for _, name := range reg.names() {
+		spec := reg.specs[name]
+		if host == spec.Upstream || host == spec.JWTUpstream {
+			return name
+		}
+	}
+	return ""
+}
+
+// dialect returns the wire dialect for a provider name, or "" if unknown.
+func (reg *providerRegistry) dialect(provider string) string {
+	if spec := reg.lookup(provider); spec != nil {
+		return spec.Dialect
+	}
+	return ""
+}
+
+// isAuthHeader returns true for the baseline credential headers and any
+// header a provider declares as one.
+func (reg *providerRegistry) isAuthHeader(name string) bool {
+	return reg.authHeaders[strings.ToLower(name)]
+}
+
+// isOpenAIDialect returns true for dialects of the OpenAI wire format.
+func isOpenAIDialect(dialect string) bool {
+	return dialect == dialectOpenAIChat || dialect == dialectOpenAIResponses
+}
+
+// isConversation returns true if path matches one of the provider's conversation patterns.
+func (s *providerSpec) isConversation(path string) bool {
+	for _, re := range s.conversation {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// isConversation returns true if path is a conversation for any provider.
+func (reg *providerRegistry) isConversation(path string) bool {
+	for _, spec := range reg.specs {
+		if spec.isConversation(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheme returns the URL scheme for upstream: the configured scheme, else
+// http for localhost (tests) and https otherwise.
+func (s *providerSpec) scheme(upstream string) string {
+	if s.Scheme != "" {
+		return s.Scheme
+	}
+	if isLocalhost(upstream) {
+		return "http"
+	}
+	return "https"
+}
+
+// clientSessionID extracts a client-provided session ID from the request.
+// Providers with session_id rules use only those; otherwise the provider's
+// dialect decides (see ExtractClientSessionID). A nil spec finds nothing.
+func (s *providerSpec) clientSessionID(body []byte, headers http.Header, path string) string {
+	if s == nil {
+		return ""
+	}
+	if len(s.SessionID) > 0 {
+		return extractSessionIDByRules(s.SessionID, body, headers, path)
+	}
+	return ExtractClientSessionID(body, s.Dialect, headers, path)
+}
+
+// validateSessionIDRule checks a session_id rule of the form "header:<Name>",
+// "body:<dotted.field>" or "path:<prefix>{id}<suffix>".
+func validateSessionIDRule(rule string) error {
+	kind, arg, ok := strings.Cut(rule, ":")
+	if !ok || arg == "" {
+		return fmt.Errorf("invalid session_id rule %q", rule)
+	}
+	switch kind {
+	case "header", "body":
+		return nil
+	case "path":
+		if strings.Count(arg, "{id}") != 1 {
+			return fmt.Errorf("session_id rule %q must contain {id} once", rule)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid session_id rule %q (expected header:, body: or path:)", rule)
+}
+
+// extractSessionIDByRules returns the first valid session ID produced by rules.
+func extractSessionIDByRules(rules []string, body []byte, headers http.Header, path string) string {
+	var request map[string]interface{}
+	for _, rule := range rules {
+		kind, arg, _ := strings.Cut(rule, ":")
+		var id string
+		switch kind {
+		case "header":
+			if headers != nil {
+				id = headers.Get(arg)
+			}
+		case "body":
+			if request == nil {
+				if err := json.Unmarshal(body, &request); err != nil {
+					request = map[string]interface{}{}
+				}
+			}
+			id = lookupJSONString(request, arg)
+		case "path":
+			id = extractPathID(arg, path)
+		}
+		if isValidSessionID(id) {
+			return id
+		}
+	}
+	return ""
+}
+
+// lookupJSONString returns the string at a dotted field path, or "".
+func lookupJSONString(obj map[string]interface{}, field string) string {
+	keys := strings.Split(field, ".")
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		obj = next
+	}
+	s, _ := obj[keys[len(keys)-1]].(string)
+	return s
+}
+
+// extractPathID matches path against a "{prefix}{id}{suffix}" template and
+// returns the segment in place of {id}. The suffix only has to prefix the
+// rest of the path, so "/v1/threads/{id}/" matches any thread sub-resource.
+func extractPathID(template, path string) string {
+	prefix, suffix, _ := strings.Cut(template, "{id}")
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return ""
+	}
+	id, tail := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		id, tail = rest[:i], rest[i:]
+	}
+	if !strings.HasPrefix(tail, suffix) {
+		return ""
+	}
+	return id
+}
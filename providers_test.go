@@ -0,0 +1,210 @@
+// providers_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestProviders returns a registry of the built-in providers plus configs.
+func newTestProviders(t *testing.T, configs ...ProviderConfig) *providerRegistry {
+	t.Helper()
+	reg, err := newProviderRegistry(configs)
+	if err != nil {
+		t.Fatalf("newProviderRegistry: %v", err)
+	}
+	return reg
+}
+
+func TestCompilePathPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		want    bool
+	}{
+		{"/v1/messages", "/v1/messages", true},
+		{"/v1/messages", "/v1/messages/count_tokens", false},
+		{"/v1/threads/*/runs", "/v1/threads/thread_1/runs", true},
+		{"/v1/threads/*/runs", "/v1/threads//runs", false},
+		{"/v1/threads/*/runs/**", "/v1/threads/thread_1/runs/run_1/steps", true},
+		{"/backend-api/**/responses", "/backend-api/responses", true},
+		{"/backend-api/**/responses", "/backend-api/codex/v1/responses", true},
+		{"/**:generateContent", "/v1beta/models/gemini-2.5-pro:generateContent", true},
+		{"/**:generateContent", "/v1beta/models/gemini-2.5-pro:countTokens", false},
+		{"/api/v1/chat.completions", "/api/v1/chatXcompletions", false},
+	}
+
+	for _, tt := range tests {
+		re, err := compilePathPattern(tt.pattern)
+		if err != nil {
+			t.Fatalf("compilePathPattern(%q): %v", tt.pattern, err)
+		}
+		if got := re.MatchString(tt.path); got != tt.want {
+			t.Errorf("%q matches %q = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestNewProviderSpec(t *testing.T) {
+	valid := ProviderConfig{Name: "openrouter", Upstream: "openrouter.ai", Dialect: dialectOpenAIChat, ConversationPaths: []string{"/api/v1/chat/completions"}}
+	tests := []struct {
+		name    string
+		modify  func(c *ProviderConfig)
+		wantErr bool
+	}{
+		{"valid", func(c *ProviderConfig) {}, false},
+		{"uppercase name", func(c *ProviderConfig) { c.Name = "OpenRouter" }, true},
+		{"reserved name", func(c *ProviderConfig) { c.Name = "bedrock" }, true},
+		{"unknown dialect", func(c *ProviderConfig) { c.Dialect = "cohere" }, true},
+		{"bad scheme", func(c *ProviderConfig) { c.Scheme = "ftp" }, true},
+		{"upstream with path", func(c *ProviderConfig) { c.Upstream = "openrouter.ai/api" }, true},
+		{"no conversation paths", func(c *ProviderConfig) { c.ConversationPaths = nil }, true},
+		{"relative conversation path", func(c *ProviderConfig) { c.ConversationPaths = []string{"api/v1"} }, true},
+		{"bad session rule", func(c *ProviderConfig) { c.SessionID = []string{"cookie:sid"} }, true},
+		{"path rule without id", func(c *ProviderConfig) { c.SessionID = []string{"path:/v1/threads/"} }, true},
+		{"jwt prefix without upstream", func(c *ProviderConfig) { c.JWTPathPrefix = "/backend" }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			_, err := newProviderSpec(cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newProviderSpec() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewProviderRegistry_OverridesBuiltin(t *testing.T) {
+	reg := newTestProviders(t, ProviderConfig{
+		Name:              "anthropic",
+		Upstream:          "llm-gateway.internal",
+		Dialect:           dialectAnthropic,
+		ConversationPaths: []string{"/anthropic/v1/messages"},
+	})
+
+	_, upstream, path, err := reg.parseProxyURL("/anthropic/anthropic/v1/messages")
+	if err != nil || upstream != "llm-gateway.internal" || path != "/anthropic/v1/messages" {
+		t.Errorf("parseProxyURL = (%q, %q, %v)", upstream, path, err)
+	}
+	if !reg.lookup("anthropic").isConversation("/anthropic/v1/messages") || reg.lookup("anthropic").isConversation("/v1/messages") {
+		t.Error("override should replace the built-in conversation paths")
+	}
+	if reg.lookup("openai") == nil {
+		t.Error("other built-ins should remain registered")
+	}
+	if builtinRegistry.lookup("anthropic").Upstream != "api.anthropic.com" {
+		t.Error("an override must not change other registries")
+	}
+}
+
+func TestExtractSessionIDByRules(t *testing.T) {
+	rules := []string{"path:/v1/threads/{id}/", "body:metadata.conversation_id", "header:X-Session-ID"}
+	headers := http.Header{}
+	headers.Set("X-Session-ID", "header-session")
+
+	tests := []struct {
+		name    string
+		body    string
+		headers http.Header
+		path    string
+		want    string
+	}{
+		{"path wins", `{"metadata":{"conversation_id":"body-session"}}`, headers, "/v1/threads/thread_1/runs", "thread_1"},
+		{"path needs suffix", `{}`, headers, "/v1/threads/thread_1", "header-session"},
+		{"body field", `{"metadata":{"conversation_id":"body-session"}}`, headers, "/v1/chat", "body-session"},
+		{"invalid body value skipped", `{"metadata":{"conversation_id":"has spaces"}}`, headers, "/v1/chat", "header-session"},
+		{"not json", `nope`, nil, "/v1/chat", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractSessionIDByRules(rules, []byte(tt.body), tt.headers, tt.path); got != tt.want {
+				t.Errorf("extractSessionIDByRules() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_ConfiguredProvider(t *testing.T) {
+	var gotPath, gotKey string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotKey = r.Header.Get("X-Router-Key")
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hel\"}}]}\n\n"))
+		w.Write([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"lo\"},\"finish_reason\":\"stop\"}]}\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	reg := newTestProviders(t, ProviderConfig{
+		Name:              "openrouter",
+		Upstream:          upstreamHost,
+		Dialect:           dialectOpenAIChat,
+		ConversationPaths: []string{"/api/v1/chat/completions"},
+		SessionID:         []string{"header:X-Router-Session"},
+		AuthHeaders:       []string{"X-Router-Key"},
+	})
+
+	tmpDir := t.TempDir()
+	logger, _ := NewLogger(tmpDir)
+	defer logger.Close()
+	logger.SetProviders(reg)
+	sm, _ := NewSessionManager(tmpDir, logger)
+	defer sm.Close()
+	sm.providers = reg
+	emitter := &MockEventEmitter{}
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+	proxy.providers = reg
+
+	const apiKey = "or-0123456789abcdef0123456789abcdef"
+	for i := 0; i < 2; i++ {
+		// Default upstream: /openrouter/{path}
+		req := httptest.NewRequest("POST", "/openrouter/api/v1/chat/completions", strings.NewReader(`{"model":"x","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+		req.Header.Set("X-Router-Session", "router-session-1")
+		req.Header.Set("X-Router-Key", apiKey)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+	}
+	logger.Close()
+
+	if gotPath != "/api/v1/chat/completions" || gotKey != apiKey {
+		t.Errorf("upstream got path %q key %q", gotPath, gotKey)
+	}
+	if len(emitter.TurnStartEvents) != 2 || emitter.TurnStartEvents[0].Provider != "openrouter" {
+		t.Fatalf("TurnStartEvents = %+v", emitter.TurnStartEvents)
+	}
+	if emitter.TurnStartEvents[1].SessionID != emitter.TurnStartEvents[0].SessionID {
+		t.Error("requests with the same session header should share a session")
+	}
+	if got := extractDeltaText([]byte("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n"), reg.dialect("openrouter")); got != "hi" {
+		t.Errorf("extractDeltaText = %q, want dialect-based extraction", got)
+	}
+
+	var logged bool
+	filepath.Walk(tmpDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".jsonl") {
+			return nil
+		}
+		data, _ := os.ReadFile(path)
+		logged = true
+		if strings.Contains(string(data), apiKey) {
+			t.Errorf("%s contains the unobfuscated X-Router-Key", path)
+		}
+		return nil
+	})
+	if !logged {
+		t.Error("conversation requests should be logged")
+	}
+}
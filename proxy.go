@@ -6,6 +6,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"io"
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -21,6 +22,10 @@ type ProxyLogger interface {
 	LogRequest(sessionID, provider string, seq int, method, path string, headers http.Header, body []byte, requestID string) error
 	LogResponse(sessionID, provider string, seq int, status int, headers http.Header, body []byte, chunks []StreamChunk, timing ResponseTiming, requestID string) error
 	LogFork(sessionID, provider string, fromSeq int, parentSession string) error
+	LogFailover(sessionID, provider string, seq int, failover FailoverRecord, requestID string) error
+	LogRewrite(sessionID, provider string, seq int, rewrite RewriteRecord, requestID string) error
+	LogPolicyDenial(sessionID, provider string, seq int, denial PolicyDenial, requestID string) error
+	LogRealtimeEvent(sessionID, provider string, seq int, direction string, event []byte, requestID string) error
 	Close() error
 }
 
@@ -31,6 +36,15 @@ type Proxy struct {
 	eventEmitter   AgentEventEmitter
 	machineID      string
 	bedrock        *bedrockState
+	vertex         *vertexState
+	failover       *anthropicFailover
+	retry          *retryPolicy
+	keyPools       keyPools
+	vault          *vaultState
+	rewrites       rewriteRules
+	policies       policyRules
+	budgets        *budgetTracker
+	providers      *providerRegistry
 }
 
 // createPassthroughClient creates an HTTP client configured for true passthrough proxying
@@ -52,14 +66,16 @@ func createPassthroughClient() *http.Client {
 
 func NewProxy() *Proxy {
 	return &Proxy{
-		client: createPassthroughClient(),
+		client:    createPassthroughClient(),
+		providers: builtinRegistry,
 	}
 }
 
 func NewProxyWithLogger(logger *Logger) *Proxy {
 	return &Proxy{
-		client: createPassthroughClient(),
-		logger: logger,
+		client:    createPassthroughClient(),
+		logger:    logger,
+		providers: builtinRegistry,
 	}
 }
 
@@ -68,6 +84,7 @@ func NewProxyWithSessionManager(logger *Logger, sm *SessionManager) *Proxy {
 		client:         createPassthroughClient(),
 		logger:         logger,
 		sessionManager: sm,
+		providers:      builtinRegistry,
 	}
 }
 
@@ -77,6 +94,7 @@ func NewProxyWithSessionManagerAndLogger(logger ProxyLogger, sm *SessionManager)
 		client:         createPassthroughClient(),
 		logger:         logger,
 		sessionManager: sm,
+		providers:      builtinRegistry,
 	}
 }
 
@@ -89,6 +107,7 @@ func NewProxyWithEventEmitter(logger ProxyLogger, sm *SessionManager, emitter Ag
 		sessionManager: sm,
 		eventEmitter:   emitter,
 		machineID:      machineID,
+		providers:      builtinRegistry,
 	}
 }
 
@@ -176,17 +195,48 @@ func (p *Proxy) processToolResultsAndEmitEvents(reqBody []byte, sessionID, provi
 }
 
 // processResponseAndEmitEvents processes response, emits tool_call events, computes patterns, emits turn_end.
-func (p *Proxy) processResponseAndEmitEvents(parsed ParsedResponse, sessionID, provider string, state *PatternState, statusCode int, respBody string) {
+func (p *Proxy) processResponseAndEmitEvents(parsed ParsedResponse, sessionID, provider string, state *PatternState, statusCode int, respBody, requestID string) {
 	if p.eventEmitter == nil || p.sessionManager == nil {
 		return
 	}
 
-	emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, state, parsed.Content, parsed.Usage, parsed.StopReason, statusCode, respBody)
+	cost := usageCostOf(p.logger, requestID, parsed.Usage)
+	emitResponseEvents(p.eventEmitter, p.sessionManager, sessionID, provider, p.machineID, state, parsed.Content, parsed.Usage, cost, parsed.StopReason, statusCode, respBody)
+}
+
+// recordResponseUsage records the usage of a logged request's successful
+// response, read from its body or stream chunks.
+func (p *Proxy) recordResponseUsage(requestID, sessionID string, status int, body []byte, chunks []StreamChunk) {
+	if requestID == "" || status != http.StatusOK {
+		return
+	}
+	var parsed ParsedResponse
+	if chunks != nil {
+		parsed = ParseStreamingResponse(chunks)
+	} else {
+		parsed = ParseResponseBody(string(body), "")
+	}
+	p.recordUsage(requestID, sessionID, parsed.Usage)
+}
+
+// recordUsage adds a logged request's usage and its cost to the session's
+// totals in sessions.db and to the request's budgets.
+func (p *Proxy) recordUsage(requestID, sessionID string, usage UsageInfo) {
+	if usage == (UsageInfo{}) {
+		return
+	}
+	cost := usageCostOf(p.logger, requestID, usage)
+	if p.sessionManager != nil {
+		if err := p.sessionManager.AddUsage(sessionID, usage, cost); err != nil {
+			log.Printf("WARNING: recording usage of session %s: %v", sessionID, err)
+		}
+	}
+	p.budgets.charge(requestID, usage, cost)
 }
 
 // emitResponseEvents is the shared implementation for emitting response events.
 // Used by both non-streaming (processResponseAndEmitEvents) and streaming (streamResponse) paths.
-func emitResponseEvents(emitter AgentEventEmitter, sm *SessionManager, sessionID, provider, machineID string, state *PatternState, content []ContentBlock, usage UsageInfo, stopReason string, statusCode int, respBody string) {
+func emitResponseEvents(emitter AgentEventEmitter, sm *SessionManager, sessionID, provider, machineID string, state *PatternState, content []ContentBlock, usage UsageInfo, costUSD float64, stopReason string, statusCode int, respBody string) {
 	// Extract tool calls
 	toolCalls := extractToolCalls(content)
 
@@ -220,6 +270,7 @@ func emitResponseEvents(emitter AgentEventEmitter, sm *SessionManager, sessionID
 		OutputTokens:             usage.OutputTokens,
 		CacheReadInputTokens:     usage.CacheReadInputTokens,
 		CacheCreationInputTokens: usage.CacheCreationInputTokens,
+		CostUSD:                  costUSD,
 	}
 
 	// Emit turn_end
@@ -243,37 +294,44 @@ func randomHex(n int) string {
 }
 
 func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	// Route Bedrock requests before ParseProxyURL — Bedrock paths don't follow
-	// the /{provider}/{upstream}/{path} format
-	if strings.HasPrefix(r.URL.Path, "/model/") {
+	// Route Bedrock requests before parseProxyURL — Bedrock paths don't follow
+	// the /{provider}/{upstream}/{path} format. Bedrock and Vertex sign with
+	// the proxy's own cloud credentials, so the vault check below never
+	// applies to them; proxy_auth is their only client authentication.
+	if isBedrockPath(r.URL.Path) || p.bedrock.ownsPath(r.URL.Path) {
 		p.serveBedrock(w, r)
 		return
 	}
 
+	// Anthropic-on-Vertex AI paths are also sent unprefixed
+	if strings.HasPrefix(r.URL.Path, "/v1/projects/") {
+		p.serveVertex(w, r)
+		return
+	}
+
 	startTime := time.Now()
 
 	// Parse the proxy URL
-	provider, upstream, path, err := ParseProxyURL(r.URL.Path)
+	provider, upstream, path, err := p.providers.parseProxyURL(r.URL.Path)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// For OpenAI requests, dynamically route based on auth type:
+	spec := p.providers.lookup(provider)
+
+	// Providers can route based on auth type, e.g. OpenAI:
 	// - JWT tokens (ChatGPT OAuth) → chatgpt.com/backend-api/codex
 	// - API keys (sk-...) → api.openai.com
-	if provider == "openai" && upstream == "api.openai.com" {
+	if spec.JWTUpstream != "" && upstream == spec.Upstream {
 		if isJWTAuth(r.Header) {
-			upstream = "chatgpt.com"
-			path = "/backend-api/codex" + path
+			upstream = spec.JWTUpstream
+			path = spec.JWTPathPrefix + path
 		}
 	}
 
 	// Determine scheme (use http for tests, https for real)
-	scheme := "https"
-	if isLocalhost(upstream) {
-		scheme = "http"
-	}
+	scheme := spec.scheme(upstream)
 
 	// Build upstream URL
 	upstreamURL := scheme + "://" + upstream + path
@@ -281,6 +339,33 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		upstreamURL += "?" + r.URL.RawQuery
 	}
 
+	// In vault mode the client's proxy token is checked here and replaced by
+	// an upstream credential when the request is sent
+	var principal string
+	pool := p.keyPools.forUpstream(provider, upstream)
+	if p.vault != nil {
+		var vaultPool *keyPool
+		var status int
+		principal, vaultPool, status, err = p.vault.authenticate(r.Header, spec, upstream, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		if pool == nil {
+			pool = vaultPool
+		}
+		if pool == nil {
+			http.Error(w, "vault: no credential for "+provider+" upstream "+upstream, http.StatusForbidden)
+			return
+		}
+	}
+
+	// WebSocket upgrades (OpenAI Realtime) are relayed frame by frame
+	if isWebSocketUpgrade(r.Header) {
+		p.serveWebSocket(w, r, provider, upstream, path, upstreamURL, principal, pool)
+		return
+	}
+
 	// Buffer request body for logging
 	var reqBody []byte
 	if r.Body != nil {
@@ -292,6 +377,37 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		r.Body.Close()
 	}
 
+	// Apply rewrite rules. Sessions are still tracked on the original body,
+	// which is logged alongside the rewritten one.
+	origBody := reqBody
+	var rewrite *RewriteRecord
+	if rw := p.rewrites.apply(spec, path, r.Header, reqBody); len(rw.rules) > 0 {
+		rewrite = &RewriteRecord{Rules: rw.rules, OriginalBody: string(reqBody)}
+		if rw.path != path {
+			rewrite.OriginalPath = path
+			path = rw.path
+			upstreamURL = scheme + "://" + upstream + path
+			if r.URL.RawQuery != "" {
+				upstreamURL += "?" + r.URL.RawQuery
+			}
+		}
+		if rw.header != nil {
+			rewrite.OriginalHeaders = ObfuscateHeaders(r.Header, p.providers)
+			r.Header = rw.header
+		}
+		reqBody = rw.body
+	}
+
+	// Enforce policy rules on the request as it would be forwarded
+	var denial *PolicyDenial
+	if spec.isConversation(path) {
+		identity := proxyIdentity(r.Context())
+		if identity == "" {
+			identity = principal
+		}
+		denial = p.policies.check(provider, path, r.Header, identity, "", reqBody)
+	}
+
 	// Create forwarded request with buffered body
 	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, bytes.NewReader(reqBody))
 	if err != nil {
@@ -311,15 +427,23 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var isNewSession bool
 	var requestID string
 	var patternState *PatternState
-	shouldLog := p.logger != nil && isConversationEndpoint(path)
+	shouldLog := p.logger != nil && spec.isConversation(path)
 
+	metaRecorder, _ := p.logger.(requestMetaRecorder)
 	if shouldLog {
 		// Generate unique request ID for this API call
 		requestID = uuid.New().String()
+		defer p.recordIdentity(r, requestID)()
+		if metaRecorder != nil && principal != "" {
+			metaRecorder.SetRequestMeta(requestID, "principal", principal)
+		}
+		if metaRecorder != nil && rewrite != nil {
+			metaRecorder.SetRequestMeta(requestID, "rewritten_by", strings.Join(rewrite.Rules, ","))
+		}
 
 		if p.sessionManager != nil {
 			var err error
-			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, path)
+			sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(origBody, provider, upstream, r.Header, path)
 			if err != nil {
 				// Fallback to generating a new session
 				sessionID = p.generateSessionID()
@@ -363,11 +487,54 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		if isNewSession {
 			p.logger.LogSessionStart(sessionID, provider, upstream)
 		}
+		if rewrite != nil {
+			p.logger.LogRewrite(sessionID, provider, seq, *rewrite, requestID)
+		}
 		p.logger.LogRequest(sessionID, provider, seq, r.Method, path, r.Header, reqBody, requestID)
 	}
 
-	// Make request to upstream
-	resp, err := p.client.Do(proxyReq)
+	if denial != nil {
+		p.deny(w, spec.Dialect, denial, provider, sessionID, seq, requestID, patternState)
+		return
+	}
+	if shouldLog && p.budgets != nil {
+		defer p.budgets.end(requestID)
+		if !p.checkBudgets(w, spec.Dialect, provider, sessionID, seq, requestID, patternState, budgetKeyID(r.Header, spec)) {
+			return
+		}
+	}
+
+	// Make request to upstream, retrying transient failures. Each retried
+	// attempt is logged as its own response entry.
+	var logAttempt func(status int, header http.Header, body []byte, timing ResponseTiming)
+	if shouldLog {
+		logAttempt = func(status int, header http.Header, body []byte, timing ResponseTiming) {
+			p.logger.LogResponse(sessionID, provider, seq, status, header, body, nil, timing, requestID)
+		}
+	}
+	// Swap the client's credentials for a pool key on every attempt, and
+	// record which key served each logged response
+	send := p.client.Do
+	if pool != nil {
+		var onKey func(label string)
+		if shouldLog && metaRecorder != nil {
+			onKey = func(label string) { metaRecorder.SetRequestMeta(requestID, "api_key", label) }
+		}
+		send = func(req *http.Request) (*http.Response, error) {
+			return pool.send(p.client, req, onKey)
+		}
+	}
+	failoverModel := p.failover.bedrockModel(spec, upstream, r.Method, path, reqBody)
+	resp, err := p.doWithRetry(proxyReq, send, logAttempt)
+
+	// Retry failed Anthropic calls on Bedrock before anything reaches the client
+	if failoverModel != "" && p.failover.triggers(r, resp, err) {
+		if shouldLog && metaRecorder != nil {
+			metaRecorder.SetRequestMeta(requestID, "api_key", "")
+		}
+		p.serveAnthropicFailover(w, r, reqBody, resp, err, failoverModel, startTime, upstream, sessionID, seq, requestID, patternState, shouldLog)
+		return
+	}
 	if err != nil {
 		http.Error(w, "upstream request failed: "+err.Error(), http.StatusBadGateway)
 		return
@@ -382,7 +549,8 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			loggerForStream = p.logger
 			smForStream = p.sessionManager
 		}
-		streamResponse(w, resp, loggerForStream, smForStream, sessionID, provider, seq, startTime, reqBody, requestID, p.eventEmitter, p.machineID, patternState)
+		chunks, _ := streamResponse(w, resp, loggerForStream, smForStream, sessionID, provider, spec.Dialect, seq, startTime, reqBody, requestID, p.eventEmitter, p.machineID, patternState)
+		p.recordResponseUsage(requestID, sessionID, resp.StatusCode, nil, chunks)
 		return
 	}
 
@@ -411,8 +579,9 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		// Emit agent observability events
 		if p.eventEmitter != nil && patternState != nil {
 			parsed := ParseResponseBody(string(respBody), upstream)
-			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody))
+			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody), requestID)
 		}
+		p.recordResponseUsage(requestID, sessionID, resp.StatusCode, respBody, nil)
 	}
 
 	// Copy response headers
@@ -425,6 +594,50 @@ func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(respBody)
 }
 
+// beginLoggedTurn resolves the session for a signed cloud pass-through request
+// (Bedrock, Vertex), emits tool_result and turn_start events, and logs the
+// request. Returns the session ID, sequence number, and pattern state (nil when
+// event emission is disabled). Callers must check p.logger != nil first.
+func (p *Proxy) beginLoggedTurn(r *http.Request, reqBody []byte, provider, upstream, requestID string) (string, int, *PatternState) {
+	var sessionID string
+	var seq int
+	var isNewSession bool
+	var patternState *PatternState
+
+	if p.sessionManager != nil {
+		var err error
+		sessionID, seq, isNewSession, err = p.sessionManager.GetOrCreateSession(reqBody, provider, upstream, r.Header, r.URL.Path)
+		if err != nil {
+			sessionID = p.generateSessionID()
+			seq = 1
+			isNewSession = true
+		}
+
+		if p.eventEmitter != nil {
+			patternState, _ = p.sessionManager.LoadPatternState(sessionID)
+			if patternState == nil {
+				patternState = &PatternState{PendingToolIDs: make(map[string]string)}
+			}
+			errorRecovered := patternState.LastWasError
+			hadError := p.processToolResultsAndEmitEvents(reqBody, sessionID, provider, patternState)
+			patternState.LastWasError = hadError
+			patternState.TurnCount++
+			p.eventEmitter.EmitTurnStart(sessionID, provider, p.machineID, patternState.TurnCount, errorRecovered)
+		}
+	} else {
+		sessionID = p.generateSessionID()
+		seq = 1
+		isNewSession = true
+	}
+
+	if isNewSession {
+		p.logger.LogSessionStart(sessionID, provider, upstream)
+	}
+	p.logger.LogRequest(sessionID, provider, seq, r.Method, r.URL.Path, r.Header, reqBody, requestID)
+
+	return sessionID, seq, patternState
+}
+
 func copyHeaders(dst, src http.Header) {
 	for key, values := range src {
 		for _, value := range values {
@@ -478,36 +691,17 @@ func isJWTAuth(headers http.Header) bool {
 
 // isConversationEndpoint returns true for API endpoints that represent conversations
 // (i.e., have messages that can be tracked for session continuity)
-func isConversationEndpoint(path string) bool {
+func isConversationEndpoint(path string, providers *providerRegistry) bool {
 	// Bedrock
-	if strings.HasPrefix(path, "/model/") {
-		return true
-	}
-
-	// Anthropic
-	if path == "/v1/messages" {
-		return true
-	}
-
-	// OpenAI Chat/Completions
-	if path == "/v1/chat/completions" || path == "/v1/completions" || path == "/v1/responses" {
+	if isBedrockPath(path) {
 		return true
 	}
 
-	// OpenAI Threads API - matches /v1/threads/{id}/messages or /v1/threads/{id}/runs[/...]
-	if strings.HasPrefix(path, "/v1/threads/") {
-		parts := strings.Split(path, "/")
-		// /v1/threads/{id}/messages or /v1/threads/{id}/runs
-		if len(parts) >= 5 && (parts[4] == "messages" || parts[4] == "runs") {
-			return true
-		}
-	}
-
-	// ChatGPT backend API (used with OAuth authentication)
-	// Paths like /backend-api/codex/v1/responses
-	if strings.HasPrefix(path, "/backend-api/") && strings.HasSuffix(path, "/responses") {
+	// Anthropic on Vertex AI
+	if isVertexPath(path) {
 		return true
 	}
 
-	return false
+	// Registered providers (Anthropic, OpenAI, Gemini, Azure, [[providers]])
+	return providers.isConversation(path)
 }
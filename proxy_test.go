@@ -175,6 +175,22 @@ func TestIsConversationEndpoint(t *testing.T) {
 		{"/backend-api/responses", true},
 		{"/backend-api/v1/responses", true},
 
+		// Gemini
+		{"/v1beta/models/gemini-2.5-pro:generateContent", true},
+		{"/v1beta/models/gemini-2.5-pro:streamGenerateContent", true},
+		{"/v1internal:streamGenerateContent", true},
+		{"/v1beta/models/gemini-2.5-pro:countTokens", false},
+
+		// Azure OpenAI
+		{"/openai/deployments/gpt-4o/chat/completions", true},
+		{"/openai/deployments/gpt-4o/completions", true},
+		{"/openai/v1/responses", true},
+		{"/openai/v1/chat/completions", true},
+		{"/openai/responses", true},
+		{"/openai/deployments/gpt-4o/embeddings", false},
+		{"/openai/deployments//chat/completions", false},
+		{"/openai/v1/models", false},
+
 		// Non-conversation endpoints (should NOT log)
 		{"/v1/messages/count_tokens", false},
 		{"/v1/models", false},
@@ -190,7 +206,7 @@ func TestIsConversationEndpoint(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		got := isConversationEndpoint(tt.path)
+		got := isConversationEndpoint(tt.path, builtinRegistry)
 		if got != tt.expected {
 			t.Errorf("isConversationEndpoint(%q) = %v, want %v", tt.path, got, tt.expected)
 		}
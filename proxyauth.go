@@ -0,0 +1,274 @@
+// proxyauth.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy authentication.
+//
+// With proxy_auth enabled every proxied and health request must carry one
+// of the configured clients' credentials, checked before anything is sent
+// upstream:
+//
+//	X-Proxy-Authorization: Bearer <token>
+//	X-Proxy-Signature: id=<identity>,ts=<unix seconds>,sig=<hex HMAC-SHA256>
+//
+// The signature covers "<ts>\n<METHOD>\n<path and query>\n<hex SHA-256 of
+// the body>" and is accepted once, within max_skew of the proxy's clock. A
+// token may also be sent as the
+// password of a Basic Proxy-Authorization header, which is what HTTPS_PROXY
+// clients send on CONNECT. Credential headers are removed before the request
+// is forwarded, and the client's identity is added to log entries as
+// _meta.identity and to Loki as the identity label.
+
+// Proxy authentication headers.
+const (
+	proxyAuthorizationHeader = "X-Proxy-Authorization"
+	proxySignatureHeader     = "X-Proxy-Signature"
+)
+
+// proxyAuthDefaultMaxSkew bounds how old (or new) a signature timestamp may be.
+const proxyAuthDefaultMaxSkew = 5 * time.Minute
+
+// validIdentity restricts identities to label-safe characters.
+var validIdentity = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+// proxyAuth checks proxy credentials.
+type proxyAuth struct {
+	tokens  map[string]string // token → identity
+	secrets map[string][]byte // identity → HMAC secret
+	maxSkew time.Duration
+
+	mu      sync.Mutex
+	used    map[string]time.Time // accepted signature → when its timestamp expires
+	pruneAt time.Time
+}
+
+// newProxyAuth validates cfg. Returns nil if proxy authentication is disabled.
+func newProxyAuth(cfg ProxyAuthConfig) (*proxyAuth, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if len(cfg.Clients) == 0 {
+		return nil, fmt.Errorf("proxy_auth: no clients configured")
+	}
+
+	a := &proxyAuth{
+		tokens:  make(map[string]string),
+		secrets: make(map[string][]byte),
+		maxSkew: proxyAuthDefaultMaxSkew,
+		used:    make(map[string]time.Time),
+	}
+	if cfg.MaxSkewStr != "" {
+		d, err := time.ParseDuration(cfg.MaxSkewStr)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("proxy_auth: invalid max_skew %q", cfg.MaxSkewStr)
+		}
+		a.maxSkew = d
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range cfg.Clients {
+		if !validIdentity.MatchString(c.Identity) {
+			return nil, fmt.Errorf("proxy_auth: invalid identity %q", c.Identity)
+		}
+		if seen[c.Identity] {
+			return nil, fmt.Errorf("proxy_auth: duplicate identity %q", c.Identity)
+		}
+		seen[c.Identity] = true
+		if c.Token == "" && c.HMACSecret == "" {
+			return nil, fmt.Errorf("proxy_auth: client %q needs a token or hmac_secret", c.Identity)
+		}
+		if c.Token != "" {
+			if len(c.Token) < 16 {
+				return nil, fmt.Errorf("proxy_auth: token for %q is shorter than 16 characters", c.Identity)
+			}
+			if _, dup := a.tokens[c.Token]; dup {
+				return nil, fmt.Errorf("proxy_auth: clients share a token")
+			}
+			a.tokens[c.Token] = c.Identity
+		}
+		if c.HMACSecret != "" {
+			if len(c.HMACSecret) < 16 {
+				return nil, fmt.Errorf("proxy_auth: hmac_secret for %q is shorter than 16 characters", c.Identity)
+			}
+			a.secrets[c.Identity] = []byte(c.HMACSecret)
+		}
+	}
+	return a, nil
+}
+
+// authenticate returns the identity of the client that sent r.
+func (a *proxyAuth) authenticate(r *http.Request, now time.Time) (string, error) {
+	if auth := r.Header.Get(proxyAuthorizationHeader); auth != "" {
+		token, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return "", fmt.Errorf("%s must be a bearer token", proxyAuthorizationHeader)
+		}
+		if identity := a.tokenIdentity(token); identity != "" {
+			return identity, nil
+		}
+		return "", fmt.Errorf("invalid proxy token")
+	}
+
+	if sig := r.Header.Get(proxySignatureHeader); sig != "" {
+		// The body is signed too, so read it and put it back for the handler
+		var body []byte
+		if r.Body != nil {
+			var err error
+			if body, err = io.ReadAll(r.Body); err != nil {
+				return "", fmt.Errorf("failed to read request body: %w", err)
+			}
+			r.Body.Close()
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		return a.verifySignature(sig, r.Method, r.URL.RequestURI(), body, now)
+	}
+
+	// HTTPS_PROXY=http://<identity>:<token>@host:port sends the token as the
+	// Basic password on CONNECT
+	if _, token, ok := basicProxyAuth(r.Header.Get("Proxy-Authorization")); ok {
+		if identity := a.tokenIdentity(token); identity != "" {
+			return identity, nil
+		}
+		return "", fmt.Errorf("invalid proxy token")
+	}
+	return "", fmt.Errorf("missing %s, %s or Proxy-Authorization", proxyAuthorizationHeader, proxySignatureHeader)
+}
+
+// tokenIdentity returns the identity of a bearer token, or "".
+func (a *proxyAuth) tokenIdentity(token string) string {
+	// Compare against every token so timing doesn't reveal a prefix match
+	var identity string
+	for t, id := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			identity = id
+		}
+	}
+	return identity
+}
+
+// basicProxyAuth parses a "Basic" Proxy-Authorization value.
+func basicProxyAuth(value string) (user, password string, ok bool) {
+	r := http.Request{Header: http.Header{"Authorization": {value}}}
+	return r.BasicAuth()
+}
+
+// verifySignature checks an X-Proxy-Signature value for method, uri and body.
+// Each signature is accepted once.
+func (a *proxyAuth) verifySignature(value, method, uri string, body []byte, now time.Time) (string, error) {
+	fields := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[k] = v
+	}
+	identity, ts, sig := fields["id"], fields["ts"], fields["sig"]
+
+	secret, ok := a.secrets[identity]
+	if !ok {
+		return "", fmt.Errorf("invalid proxy signature")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid proxy signature timestamp")
+	}
+	signedAt := time.Unix(unix, 0)
+	if skew := now.Sub(signedAt); skew > a.maxSkew || skew < -a.maxSkew {
+		return "", fmt.Errorf("proxy signature timestamp outside %v", a.maxSkew)
+	}
+	want := proxySignature(secret, ts, method, uri, body)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return "", fmt.Errorf("invalid proxy signature")
+	}
+	if !a.markUsed(want, signedAt.Add(a.maxSkew), now) {
+		return "", fmt.Errorf("proxy signature already used")
+	}
+	return identity, nil
+}
+
+// markUsed records an accepted signature until expires, after which its
+// timestamp is rejected anyway. Returns false if it was already used.
+func (a *proxyAuth) markUsed(sig string, expires, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if now.After(a.pruneAt) {
+		for s, exp := range a.used {
+			if now.After(exp) {
+				delete(a.used, s)
+			}
+		}
+		a.pruneAt = now.Add(a.maxSkew)
+	}
+	if _, ok := a.used[sig]; ok {
+		return false
+	}
+	a.used[sig] = expires
+	return true
+}
+
+// proxySignature returns the hex HMAC-SHA256 a client sends for a request.
+func proxySignature(secret []byte, ts, method, uri string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts + "\n" + method + "\n" + uri + "\n" + sha256Hex(body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// proxyIdentityKey is the context key for the authenticated identity.
+type proxyIdentityKey struct{}
+
+// withProxyIdentity returns ctx carrying the caller's identity.
+func withProxyIdentity(ctx context.Context, identity string) context.Context {
+	return context.WithValue(ctx, proxyIdentityKey{}, identity)
+}
+
+// proxyIdentity returns the identity authenticated for a request, or "".
+func proxyIdentity(ctx context.Context) string {
+	identity, _ := ctx.Value(proxyIdentityKey{}).(string)
+	return identity
+}
+
+// recordIdentity adds the request's authenticated identity to requestID's
+// log entries. The returned func clears the request's extra _meta fields.
+func (p *Proxy) recordIdentity(r *http.Request, requestID string) func() {
+	rec, ok := p.logger.(requestMetaRecorder)
+	if !ok {
+		return func() {}
+	}
+	if identity := proxyIdentity(r.Context()); identity != "" {
+		rec.SetRequestMeta(requestID, "identity", identity)
+	}
+	return func() { rec.ClearRequestMeta(requestID) }
+}
+
+// isLoopbackBind reports whether addr (a host or IP) only listens locally.
+func isLoopbackBind(addr string) bool {
+	if addr == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// checkBindAuth refuses to listen beyond loopback unless callers must
+// authenticate, with proxy_auth or client certificates.
+func checkBindAuth(cfg Config) error {
+	if cfg.SocketOnly || isLoopbackBind(cfg.Bind) || cfg.ProxyAuth.Enabled || cfg.TLS.ClientCAFile != "" {
+		return nil
+	}
+	return fmt.Errorf("bind address %s is not loopback; enable proxy_auth or tls.client_ca_file first", cfg.Bind)
+}
@@ -0,0 +1,200 @@
+// proxyauth_test.go
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewProxyAuth(t *testing.T) {
+	token := "tok-0123456789abcdef"
+	tests := []struct {
+		name    string
+		cfg     ProxyAuthConfig
+		wantErr string
+	}{
+		{"disabled", ProxyAuthConfig{}, ""},
+		{"token", ProxyAuthConfig{Enabled: true, Clients: []ProxyAuthClient{{Identity: "ci", Token: token}}}, ""},
+		{"hmac", ProxyAuthConfig{Enabled: true, MaxSkewStr: "30s", Clients: []ProxyAuthClient{{Identity: "ci", HMACSecret: token}}}, ""},
+		{"no clients", ProxyAuthConfig{Enabled: true}, "no clients"},
+		{"bad identity", ProxyAuthConfig{Enabled: true, Clients: []ProxyAuthClient{{Identity: "a b", Token: token}}}, "invalid identity"},
+		{"duplicate identity", ProxyAuthConfig{Enabled: true, Clients: []ProxyAuthClient{{Identity: "ci", Token: token}, {Identity: "ci", Token: token + "x"}}}, "duplicate identity"},
+		{"shared token", ProxyAuthConfig{Enabled: true, Clients: []ProxyAuthClient{{Identity: "a", Token: token}, {Identity: "b", Token: token}}}, "share a token"},
+		{"no credential", ProxyAuthConfig{Enabled: true, Clients: []ProxyAuthClient{{Identity: "ci"}}}, "needs a token"},
+		{"short token", ProxyAuthConfig{Enabled: true, Clients: []ProxyAuthClient{{Identity: "ci", Token: "short"}}}, "shorter than 16"},
+		{"bad skew", ProxyAuthConfig{Enabled: true, MaxSkewStr: "soon", Clients: []ProxyAuthClient{{Identity: "ci", Token: token}}}, "invalid max_skew"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newProxyAuth(tt.cfg)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyAuth_Authenticate(t *testing.T) {
+	secret := "hmac-secret-0123456789"
+	auth, err := newProxyAuth(ProxyAuthConfig{
+		Enabled: true,
+		Clients: []ProxyAuthClient{
+			{Identity: "alice", Token: "alice-token-0123456789"},
+			{Identity: "ci", HMACSecret: secret},
+		},
+	})
+	if err != nil {
+		t.Fatalf("newProxyAuth: %v", err)
+	}
+	now := time.Now()
+	ts := strconv.FormatInt(now.Unix(), 10)
+	stale := strconv.FormatInt(now.Add(-10*time.Minute).Unix(), 10)
+	const body = `{"model":"claude-3","messages":[]}`
+	sign := func(ts, method, uri, body string) string {
+		return "id=ci,ts=" + ts + ",sig=" + proxySignature([]byte(secret), ts, method, uri, []byte(body))
+	}
+	signed := sign(ts, "POST", "/anthropic/v1/messages?beta=true", body)
+
+	tests := []struct {
+		name         string
+		header       string
+		value        string
+		wantIdentity string
+	}{
+		{"bearer", proxyAuthorizationHeader, "Bearer alice-token-0123456789", "alice"},
+		{"wrong token", proxyAuthorizationHeader, "Bearer alice-token-0000000000", ""},
+		{"not bearer", proxyAuthorizationHeader, "alice-token-0123456789", ""},
+		{"signature", proxySignatureHeader, signed, "ci"},
+		{"replayed signature", proxySignatureHeader, signed, ""},
+		{"signature for other path", proxySignatureHeader, sign(ts, "POST", "/openai/v1/chat/completions", body), ""},
+		{"signature for other body", proxySignatureHeader, sign(ts, "POST", "/anthropic/v1/messages?beta=true", `{"model":"claude-opus-4-6","messages":[]}`), ""},
+		{"stale signature", proxySignatureHeader, sign(stale, "POST", "/anthropic/v1/messages?beta=true", body), ""},
+		{"unknown signer", proxySignatureHeader, strings.Replace(sign(ts, "POST", "/anthropic/v1/messages?beta=true", body), "id=ci", "id=alice", 1), ""},
+		{"missing", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/anthropic/v1/messages?beta=true", strings.NewReader(body))
+			if tt.header != "" {
+				req.Header.Set(tt.header, tt.value)
+			}
+			identity, err := auth.authenticate(req, now)
+			if identity != tt.wantIdentity || (tt.wantIdentity == "") != (err != nil) {
+				t.Errorf("authenticate() = (%q, %v), want %q", identity, err, tt.wantIdentity)
+			}
+			if got, _ := io.ReadAll(req.Body); string(got) != body {
+				t.Errorf("body after authenticate = %q, want it intact", got)
+			}
+		})
+	}
+}
+
+func TestCheckBindAuth(t *testing.T) {
+	tests := []struct {
+		bind     string
+		auth     bool
+		clientCA string
+		wantErr  bool
+	}{
+		{"127.0.0.1", false, "", false},
+		{"localhost", false, "", false},
+		{"::1", false, "", false},
+		{"0.0.0.0", false, "", true},
+		{"10.0.0.5", false, "", true},
+		{"0.0.0.0", true, "", false},
+		{"0.0.0.0", false, "/etc/llm-proxy/clients-ca.pem", false},
+	}
+
+	for _, tt := range tests {
+		cfg := Config{Bind: tt.bind, ProxyAuth: ProxyAuthConfig{Enabled: tt.auth}, TLS: TLSConfig{ClientCAFile: tt.clientCA}}
+		if err := checkBindAuth(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("checkBindAuth(%q, auth %v, ca %q) error = %v, wantErr %v", tt.bind, tt.auth, tt.clientCA, err, tt.wantErr)
+		}
+	}
+}
+
+func TestServer_ProxyAuth(t *testing.T) {
+	var calls int
+	var gotProxyHeaders string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gotProxyHeaders = r.Header.Get(proxyAuthorizationHeader) + r.Header.Get(proxySignatureHeader)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_123"}`))
+	}))
+	defer upstream.Close()
+	reqPath := "/anthropic/" + strings.TrimPrefix(upstream.URL, "http://") + "/v1/messages"
+
+	logDir := t.TempDir()
+	srv, err := NewServer(Config{
+		Port:   8080,
+		LogDir: logDir,
+		ProxyAuth: ProxyAuthConfig{
+			Enabled: true,
+			Clients: []ProxyAuthClient{{Identity: "ci-runner", Token: "ci-token-0123456789"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(`{"messages":[]}`))
+		if token != "" {
+			req.Header.Set(proxyAuthorizationHeader, "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, path := range []string{"/health", reqPath} {
+		if w := send("POST", path, ""); w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s without credentials: status = %d, want 401 with WWW-Authenticate", path, w.Code)
+		}
+	}
+	if w := send("POST", reqPath, "wrong-token-0123456789"); w.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", w.Code)
+	}
+	if calls != 0 {
+		t.Fatalf("upstream called %d times for unauthenticated requests", calls)
+	}
+
+	if w := send("GET", "/health", "ci-token-0123456789"); w.Code != http.StatusOK {
+		t.Errorf("/health: status = %d, want 200", w.Code)
+	}
+	if w := send("POST", reqPath, "ci-token-0123456789"); w.Code != http.StatusOK {
+		t.Fatalf("proxied: status = %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 1 || gotProxyHeaders != "" {
+		t.Errorf("upstream calls = %d, proxy headers forwarded = %q", calls, gotProxyHeaders)
+	}
+
+	var logged []byte
+	filepath.Walk(logDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".jsonl") {
+			data, _ := os.ReadFile(path)
+			logged = append(logged, data...)
+		}
+		return nil
+	})
+	if !strings.Contains(string(logged), `"identity":"ci-runner"`) {
+		t.Errorf("log has no identity:\n%s", logged)
+	}
+	if strings.Contains(string(logged), "ci-token-0123456789") {
+		t.Error("log contains the proxy token")
+	}
+}
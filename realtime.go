@@ -0,0 +1,401 @@
+// realtime.go
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// WebSocket pass-through, used by the OpenAI Realtime API (/v1/realtime).
+//
+// The upgrade request is sent upstream like any other request, so key pools
+// and the vault apply. Once upstream switches protocols the client connection
+// is hijacked and frames are copied unchanged in both directions. Text
+// messages (Realtime JSON events) are read off the copy and logged as
+// realtime_event entries; binary frames (raw audio) are not logged.
+// permessage-deflate is not offered upstream, so messages stay readable.
+//
+// Turn events follow the Realtime response lifecycle: response.created starts
+// a turn, response.done ends it with the response's tool calls and usage, and
+// function_call_output items sent by the client are tool results.
+//
+// Budgets are checked when a connection opens and again after each
+// response.done is charged; once one is used up the client gets a close
+// frame (1008, policy violation) and both connections are closed.
+
+// WebSocket opcodes (RFC 6455 section 5.2)
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsClose        = 0x8
+)
+
+// wsPolicyViolation is the close status for a connection over budget.
+const wsPolicyViolation = 1008
+
+// maxRealtimeEvent is the largest text message logged. Larger messages are
+// relayed but not logged.
+const maxRealtimeEvent = 4 << 20
+
+// isWebSocketUpgrade returns true for a WebSocket opening handshake.
+func isWebSocketUpgrade(h http.Header) bool {
+	if !strings.EqualFold(h.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range h.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveWebSocket relays a WebSocket connection to upstreamURL, with a key
+// from pool in place of the client's credentials if pool is set.
+func (p *Proxy) serveWebSocket(w http.ResponseWriter, r *http.Request, provider, upstream, path, upstreamURL, principal string, pool *keyPool) {
+	startTime := time.Now()
+
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, nil)
+	if err != nil {
+		http.Error(w, "failed to create request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	copyHeaders(proxyReq.Header, r.Header)
+	proxyReq.Header.Del("Sec-WebSocket-Extensions")
+	proxyReq.Host = upstream
+
+	spec := p.providers.lookup(provider)
+	var rs *realtimeSession
+	if p.logger != nil && spec.isConversation(path) {
+		rs = p.beginRealtimeSession(r, provider, upstream, path, principal)
+		defer p.recordIdentity(r, rs.requestID)()
+	}
+
+	// Policy rules see the upgrade request, with the model from the query.
+	// Tool definitions arrive later in session.update events and aren't
+	// checked.
+	if spec.isConversation(path) {
+		identity := proxyIdentity(r.Context())
+		if identity == "" {
+			identity = principal
+		}
+		if denial := p.policies.check(provider, path, r.Header, identity, r.URL.Query().Get("model"), nil); denial != nil {
+			if rs != nil {
+				p.deny(w, spec.Dialect, denial, provider, rs.sessionID, rs.seq, rs.requestID, rs.state)
+			} else {
+				writePolicyDenial(w, spec.Dialect, denial)
+			}
+			return
+		}
+	}
+
+	// Budgets are checked when a connection opens and after each response
+	if rs != nil && p.budgets != nil {
+		defer p.budgets.end(rs.requestID)
+		if !p.checkBudgets(w, spec.Dialect, provider, rs.sessionID, rs.seq, rs.requestID, rs.state, budgetKeyID(r.Header, spec)) {
+			return
+		}
+	}
+
+	send := p.client.Do
+	if pool != nil {
+		var onKey func(label string)
+		if metaRecorder, ok := p.logger.(requestMetaRecorder); ok && rs != nil {
+			onKey = func(label string) { metaRecorder.SetRequestMeta(rs.requestID, "api_key", label) }
+		}
+		send = func(req *http.Request) (*http.Response, error) {
+			return pool.send(p.client, req, onKey)
+		}
+	}
+	resp, err := send(proxyReq)
+	if err != nil {
+		http.Error(w, "upstream request failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	ttfb := time.Since(startTime)
+
+	// A refused handshake is an ordinary HTTP response
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			http.Error(w, "failed to read response body: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if rs != nil {
+			timing := ResponseTiming{TTFBMs: ttfb.Milliseconds(), TotalMs: time.Since(startTime).Milliseconds()}
+			p.logger.LogResponse(rs.sessionID, provider, rs.seq, resp.StatusCode, resp.Header, body, nil, timing, rs.requestID)
+		}
+		copyHeaders(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		return
+	}
+	upstreamConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		http.Error(w, "upstream switched protocols without a connection", http.StatusBadGateway)
+		return
+	}
+	defer upstreamConn.Close()
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket: connection can't be hijacked", http.StatusInternalServerError)
+		return
+	}
+	clientConn, brw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer clientConn.Close()
+
+	// Relay the 101 response, including Sec-WebSocket-Accept
+	for _, warning := range w.Header().Values(budgetWarningHeader) {
+		resp.Header.Add(budgetWarningHeader, warning)
+	}
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resp.Header.Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		return
+	}
+	if rs != nil {
+		timing := ResponseTiming{TTFBMs: ttfb.Milliseconds(), TotalMs: ttfb.Milliseconds()}
+		p.logger.LogResponse(rs.sessionID, provider, rs.seq, resp.StatusCode, resp.Header, nil, nil, timing, rs.requestID)
+	}
+
+	var onClient, onServer func([]byte)
+	if rs != nil {
+		// Called from the server relay between messages, so the close frame
+		// isn't interleaved with a relayed one
+		rs.stop = func(reason string) {
+			clientConn.Write(wsCloseFrame(wsPolicyViolation, reason))
+			clientConn.Close()
+			upstreamConn.Close()
+		}
+		onClient = func(msg []byte) { rs.event("client", msg) }
+		onServer = func(msg []byte) { rs.event("server", msg) }
+	}
+	done := make(chan struct{}, 2)
+	go func() {
+		relayWebSocket(upstreamConn, brw.Reader, onClient)
+		done <- struct{}{}
+	}()
+	go func() {
+		relayWebSocket(clientConn, upstreamConn, onServer)
+		done <- struct{}{}
+	}()
+	<-done
+	clientConn.Close()
+	upstreamConn.Close()
+	<-done
+}
+
+// realtimeSession logs the events of one WebSocket connection.
+type realtimeSession struct {
+	p         *Proxy
+	provider  string
+	sessionID string
+	seq       int
+	requestID string
+
+	mu    sync.Mutex    // events arrive from both directions
+	state *PatternState // nil without event emission
+
+	stop func(reason string) // closes both connections, from the server relay
+}
+
+// beginRealtimeSession starts a session for a WebSocket connection and logs
+// its handshake request.
+func (p *Proxy) beginRealtimeSession(r *http.Request, provider, upstream, path, principal string) *realtimeSession {
+	rs := &realtimeSession{p: p, provider: provider, requestID: uuid.New().String()}
+	if metaRecorder, ok := p.logger.(requestMetaRecorder); ok && principal != "" {
+		metaRecorder.SetRequestMeta(rs.requestID, "principal", principal)
+	}
+
+	isNewSession := true
+	if p.sessionManager != nil {
+		var err error
+		rs.sessionID, rs.seq, isNewSession, err = p.sessionManager.GetOrCreateSession(nil, provider, upstream, r.Header, path)
+		if err != nil {
+			rs.sessionID, rs.seq, isNewSession = p.generateSessionID(), 1, true
+		}
+		if p.eventEmitter != nil {
+			rs.state, _ = p.sessionManager.LoadPatternState(rs.sessionID)
+			if rs.state == nil {
+				rs.state = &PatternState{PendingToolIDs: make(map[string]string)}
+			}
+		}
+	} else {
+		rs.sessionID, rs.seq = p.generateSessionID(), 1
+	}
+
+	if isNewSession {
+		p.logger.LogSessionStart(rs.sessionID, provider, upstream)
+	}
+	p.logger.LogRequest(rs.sessionID, provider, rs.seq, r.Method, path, r.Header, nil, rs.requestID)
+	return rs
+}
+
+// event logs a text message sent by direction ("client" or "server") and
+// emits the turn events it implies. Messages that aren't JSON are skipped.
+func (rs *realtimeSession) event(direction string, msg []byte) {
+	var ev map[string]interface{}
+	if json.Unmarshal(msg, &ev) != nil {
+		return
+	}
+	p := rs.p
+	p.logger.LogRealtimeEvent(rs.sessionID, rs.provider, rs.seq, direction, msg, rs.requestID)
+
+	eventType, _ := ev["type"].(string)
+	if direction == "server" && eventType == "response.done" {
+		resp, _ := ev["response"].(map[string]interface{})
+		var parsed ParsedResponse
+		parseResponsesAPIResponse(resp, &parsed)
+		p.recordUsage(rs.requestID, rs.sessionID, parsed.Usage)
+		if d := p.budgets.exceeded(rs.requestID); d != nil && rs.stop != nil {
+			p.logger.LogPolicyDenial(rs.sessionID, rs.provider, rs.seq, *d, rs.requestID)
+			defer rs.stop(d.Reason)
+		}
+	}
+
+	if rs.state == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	switch {
+	case direction == "client" && eventType == "conversation.item.create":
+		item, _ := ev["item"].(map[string]interface{})
+		block, ok := parseResponsesItem(item)
+		if !ok || block.Type != "tool_result" {
+			return
+		}
+		toolName := "unknown"
+		if name, exists := rs.state.PendingToolIDs[block.ToolID]; exists {
+			toolName = name
+			delete(rs.state.PendingToolIDs, block.ToolID)
+		}
+		p.eventEmitter.EmitToolResult(rs.sessionID, rs.provider, p.machineID, toolName, block.ToolID, false)
+
+	case direction == "server" && eventType == "response.created":
+		errorRecovered := rs.state.LastWasError
+		rs.state.LastWasError = false
+		rs.state.TurnCount++
+		p.eventEmitter.EmitTurnStart(rs.sessionID, rs.provider, p.machineID, rs.state.TurnCount, errorRecovered)
+
+	case direction == "server" && eventType == "response.done":
+		resp, _ := ev["response"].(map[string]interface{})
+		var parsed ParsedResponse
+		parseResponsesAPIResponse(resp, &parsed)
+		rs.state.LastWasError = parsed.StopReason == "failed"
+		emitResponseEvents(p.eventEmitter, p.sessionManager, rs.sessionID, rs.provider, p.machineID, rs.state, parsed.Content, parsed.Usage, usageCostOf(p.logger, rs.requestID, parsed.Usage), parsed.StopReason, http.StatusOK, string(msg))
+	}
+}
+
+// wsCloseFrame returns an unmasked (server to client) close frame. reason is
+// cut to fit a control frame's 125-byte payload.
+func wsCloseFrame(status uint16, reason string) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	frame := []byte{0x80 | wsClose, byte(2 + len(reason)), 0, 0}
+	binary.BigEndian.PutUint16(frame[2:], status)
+	return append(frame, reason...)
+}
+
+// relayWebSocket copies frames from src to dst unchanged until either side
+// fails or closes; after a close frame the peer ends the connection. onText,
+// if set, is called with each complete text message, unmasked; the slice is
+// reused after it returns.
+func relayWebSocket(dst io.Writer, src io.Reader, onText func([]byte)) error {
+	br := bufio.NewReader(src)
+	var msg []byte
+	inText := false   // a fragmented text message is in progress
+	tooLarge := false // ...and is too large to log
+	for {
+		var hdr [14]byte
+		if _, err := io.ReadFull(br, hdr[:2]); err != nil {
+			return err
+		}
+		fin := hdr[0]&0x80 != 0
+		opcode := hdr[0] & 0x0f
+		masked := hdr[1]&0x80 != 0
+		n := uint64(hdr[1] & 0x7f)
+		size := 2
+		switch n {
+		case 126:
+			if _, err := io.ReadFull(br, hdr[2:4]); err != nil {
+				return err
+			}
+			n = uint64(binary.BigEndian.Uint16(hdr[2:4]))
+			size = 4
+		case 127:
+			if _, err := io.ReadFull(br, hdr[2:10]); err != nil {
+				return err
+			}
+			n = binary.BigEndian.Uint64(hdr[2:10])
+			size = 10
+		}
+		var mask []byte
+		if masked {
+			if _, err := io.ReadFull(br, hdr[size:size+4]); err != nil {
+				return err
+			}
+			mask = hdr[size : size+4]
+			size += 4
+		}
+
+		if opcode == wsText {
+			inText, tooLarge, msg = true, false, msg[:0]
+		}
+		text := inText && (opcode == wsText || opcode == wsContinuation)
+		if text && (tooLarge || onText == nil || uint64(len(msg))+n > maxRealtimeEvent) {
+			tooLarge = true
+		}
+
+		if text && !tooLarge {
+			frame := make([]byte, size+int(n))
+			copy(frame, hdr[:size])
+			if _, err := io.ReadFull(br, frame[size:]); err != nil {
+				return err
+			}
+			if _, err := dst.Write(frame); err != nil {
+				return err
+			}
+			start := len(msg)
+			msg = append(msg, frame[size:]...)
+			if mask != nil {
+				for i := range msg[start:] {
+					msg[start+i] ^= mask[i%4]
+				}
+			}
+		} else {
+			if _, err := dst.Write(hdr[:size]); err != nil {
+				return err
+			}
+			if _, err := io.CopyN(dst, br, int64(n)); err != nil {
+				return err
+			}
+		}
+
+		if text && fin {
+			if !tooLarge {
+				onText(msg)
+			}
+			inText, tooLarge, msg = false, false, msg[:0]
+		}
+	}
+}
@@ -0,0 +1,398 @@
+// realtime_test.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTestFrame writes a single WebSocket frame, masked as clients send them
+// if masked is set.
+func writeTestFrame(w io.Writer, fin bool, opcode byte, payload []byte, masked bool) error {
+	var hdr []byte
+	b0 := opcode
+	if fin {
+		b0 |= 0x80
+	}
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n < 126:
+		hdr = []byte{b0, maskBit | byte(n)}
+	case n <= 0xffff:
+		hdr = []byte{b0, maskBit | 126, 0, 0}
+		binary.BigEndian.PutUint16(hdr[2:], uint16(n))
+	default:
+		hdr = make([]byte, 10)
+		hdr[0], hdr[1] = b0, maskBit|127
+		binary.BigEndian.PutUint64(hdr[2:], uint64(n))
+	}
+	data := append([]byte(nil), payload...)
+	if masked {
+		key := []byte{0x12, 0x34, 0x56, 0x78}
+		hdr = append(hdr, key...)
+		for i := range data {
+			data[i] ^= key[i%4]
+		}
+	}
+	_, err := w.Write(append(hdr, data...))
+	return err
+}
+
+// readTestFrame reads a single unfragmented WebSocket frame sent unmasked.
+func readTestFrame(r *bufio.Reader) (byte, []byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return 0, nil, err
+	}
+	n := int(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return 0, nil, err
+		}
+		n = int(binary.BigEndian.Uint64(ext[:]))
+	}
+	var mask []byte
+	if hdr[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err := io.ReadFull(r, mask); err != nil {
+			return 0, nil, err
+		}
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		if mask != nil {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return hdr[0] & 0x0f, payload, nil
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	tests := []struct {
+		name       string
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{"websocket", "Upgrade", "websocket", true},
+		{"token list", "keep-alive, Upgrade", "WebSocket", true},
+		{"no connection token", "keep-alive", "websocket", false},
+		{"other protocol", "Upgrade", "h2c", false},
+		{"plain request", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.Header{}
+			if tt.connection != "" {
+				h.Set("Connection", tt.connection)
+			}
+			if tt.upgrade != "" {
+				h.Set("Upgrade", tt.upgrade)
+			}
+			if got := isWebSocketUpgrade(h); got != tt.want {
+				t.Errorf("isWebSocketUpgrade() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelayWebSocket(t *testing.T) {
+	large := `{"type":"input_audio_buffer.append","audio":"` + strings.Repeat("A", 300) + `"}`
+	var src bytes.Buffer
+	writeTestFrame(&src, true, wsText, []byte(`{"type":"session.update"}`), true)
+	writeTestFrame(&src, true, 0x2, []byte{0xde, 0xad, 0xbe, 0xef}, true) // binary audio
+	writeTestFrame(&src, false, wsText, []byte(`{"type":"resp`), true)
+	writeTestFrame(&src, true, 0x9, []byte("ping"), true) // control frame inside a fragmented message
+	writeTestFrame(&src, true, wsContinuation, []byte(`onse.create"}`), true)
+	writeTestFrame(&src, true, wsText, []byte(large), true)
+	writeTestFrame(&src, true, 0x8, []byte{0x03, 0xe8}, true) // close
+	sent := append([]byte(nil), src.Bytes()...)
+
+	var dst bytes.Buffer
+	var messages []string
+	err := relayWebSocket(&dst, &src, func(msg []byte) { messages = append(messages, string(msg)) })
+	if err != io.EOF {
+		t.Errorf("relayWebSocket() error = %v, want io.EOF", err)
+	}
+	if !bytes.Equal(dst.Bytes(), sent) {
+		t.Error("relayed bytes differ from the source")
+	}
+	want := []string{`{"type":"session.update"}`, `{"type":"response.create"}`, large}
+	if strings.Join(messages, "\n") != strings.Join(want, "\n") {
+		t.Errorf("messages = %q, want %q", messages, want)
+	}
+}
+
+// realtimeUpstream is a WebSocket server that plays a short Realtime session:
+// it sends serverEvents, then records client text messages until the client
+// closes.
+func realtimeUpstream(t *testing.T, serverEvents []string, gotClient chan<- []string, gotHeader chan<- http.Header) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader <- r.Header.Clone()
+		h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		brw.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(h[:]) + "\r\n\r\n")
+		brw.Flush()
+
+		for _, ev := range serverEvents {
+			writeTestFrame(conn, true, wsText, []byte(ev), false)
+		}
+		writeTestFrame(conn, true, 0x2, []byte{1, 2, 3}, false) // binary audio
+
+		var client []string
+		for {
+			opcode, payload, err := readTestFrame(brw.Reader)
+			if err != nil {
+				break
+			}
+			if opcode == 0x8 {
+				writeTestFrame(conn, true, 0x8, payload, false)
+				break
+			}
+			if opcode == wsText {
+				client = append(client, string(payload))
+			}
+		}
+		gotClient <- client
+	}))
+}
+
+func TestProxy_RealtimeWebSocket(t *testing.T) {
+	serverEvents := []string{
+		`{"type":"session.created","session":{"id":"sess_1","model":"gpt-realtime"}}`,
+		`{"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}`,
+		`{"type":"response.done","response":{"id":"resp_1","status":"completed",` +
+			`"output":[{"type":"function_call","name":"get_weather","call_id":"call_1","arguments":"{}"}],` +
+			`"usage":{"input_tokens":100,"output_tokens":20,"input_token_details":{"cached_tokens":40}}}}`,
+	}
+	clientEvent := `{"type":"conversation.item.create","item":{"type":"function_call_output","call_id":"call_1","output":"sunny"}}`
+
+	gotClient := make(chan []string, 1)
+	gotHeader := make(chan http.Header, 1)
+	upstream := realtimeUpstream(t, serverEvents, gotClient, gotHeader)
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	fileLogger, _ := NewLogger(tmpDir)
+	defer fileLogger.Close()
+	sm, _ := NewSessionManager(tmpDir, fileLogger)
+	defer sm.Close()
+	logger := newMockFileLogger()
+	emitter := &MockEventEmitter{}
+	proxy := NewProxyWithEventEmitter(logger, sm, emitter, "test-machine")
+
+	served := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTP(w, r)
+		close(served)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	req, _ := http.NewRequest("GET", srv.URL+"/openai/"+upstreamHost+"/v1/realtime?model=gpt-realtime", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate")
+	req.Header.Set("Authorization", "Bearer sk-test")
+	req.Write(conn)
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatalf("ReadResponse: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("handshake = %d %v, want 101 with the upstream's accept key", resp.StatusCode, resp.Header)
+	}
+	header := <-gotHeader
+	if header.Get("Sec-WebSocket-Extensions") != "" || header.Get("Authorization") != "Bearer sk-test" {
+		t.Errorf("upstream headers = %v, want credentials without extensions", header)
+	}
+
+	for i, want := range serverEvents {
+		opcode, payload, err := readTestFrame(br)
+		if err != nil || opcode != wsText || string(payload) != want {
+			t.Fatalf("server event %d = (%d, %q, %v), want %q", i, opcode, payload, err, want)
+		}
+	}
+	if opcode, _, err := readTestFrame(br); err != nil || opcode != 0x2 {
+		t.Fatalf("expected a binary frame, got opcode %d (%v)", opcode, err)
+	}
+
+	writeTestFrame(conn, true, wsText, []byte(clientEvent), true)
+	writeTestFrame(conn, true, 0x2, []byte{4, 5, 6}, true)
+	writeTestFrame(conn, true, 0x8, []byte{0x03, 0xe8}, true)
+	if opcode, _, err := readTestFrame(br); err != nil || opcode != 0x8 {
+		t.Fatalf("expected the upstream's close frame, got opcode %d (%v)", opcode, err)
+	}
+	if client := <-gotClient; len(client) != 1 || client[0] != clientEvent {
+		t.Errorf("upstream received %q, want the client event", client)
+	}
+	<-served
+
+	// Handshake request and response, then one entry per JSON event
+	if len(logger.requestCalls) != 1 || logger.requestCalls[0].path != "/v1/realtime" {
+		t.Fatalf("requests = %+v, want the handshake", logger.requestCalls)
+	}
+	if len(logger.responseCalls) != 1 || logger.responseCalls[0].status != http.StatusSwitchingProtocols {
+		t.Errorf("responses = %+v, want the 101", logger.responseCalls)
+	}
+	var events []string
+	for _, c := range logger.realtimeCalls {
+		if c.requestID != logger.requestCalls[0].requestID {
+			t.Errorf("event %s has request ID %q, want the handshake's", c.event, c.requestID)
+		}
+		events = append(events, c.direction+" "+c.event)
+	}
+	wantEvents := []string{"server " + serverEvents[0], "server " + serverEvents[1], "server " + serverEvents[2], "client " + clientEvent}
+	if strings.Join(events, "\n") != strings.Join(wantEvents, "\n") {
+		t.Errorf("logged events:\n%s\nwant:\n%s", strings.Join(events, "\n"), strings.Join(wantEvents, "\n"))
+	}
+
+	// response.created/response.done map onto a turn with usage and tool calls
+	if len(emitter.TurnStartEvents) != 1 || emitter.TurnStartEvents[0].TurnDepth != 1 {
+		t.Errorf("turn_start events = %+v, want one at depth 1", emitter.TurnStartEvents)
+	}
+	if len(emitter.TurnEndEvents) != 1 {
+		t.Fatalf("turn_end events = %+v, want one", emitter.TurnEndEvents)
+	}
+	end := emitter.TurnEndEvents[0]
+	wantTokens := TokenData{InputTokens: 60, OutputTokens: 20, CacheReadInputTokens: 40}
+	if end.StopReason != "completed" || end.Tokens != wantTokens {
+		t.Errorf("turn_end = %+v, want completed with %+v", end, wantTokens)
+	}
+	if len(emitter.ToolCallEvents) != 1 || emitter.ToolCallEvents[0].ToolName != "get_weather" {
+		t.Errorf("tool_call events = %+v, want get_weather", emitter.ToolCallEvents)
+	}
+	if len(emitter.ToolResultEvents) != 1 || emitter.ToolResultEvents[0].ToolName != "get_weather" || emitter.ToolResultEvents[0].ToolUseID != "call_1" {
+		t.Errorf("tool_result events = %+v, want get_weather call_1", emitter.ToolResultEvents)
+	}
+}
+
+func TestProxy_RealtimeRefusedHandshake(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"message":"invalid model"}}`, http.StatusBadRequest)
+	}))
+	defer upstream.Close()
+
+	logger := newMockFileLogger()
+	proxy := NewProxyWithSessionManagerAndLogger(logger, nil)
+
+	req := httptest.NewRequest("GET", "/openai/"+strings.TrimPrefix(upstream.URL, "http://")+"/v1/realtime?model=nope", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "invalid model") {
+		t.Errorf("response = %d %q, want the upstream's 400", w.Code, w.Body.String())
+	}
+	if len(logger.responseCalls) != 1 || logger.responseCalls[0].status != http.StatusBadRequest {
+		t.Errorf("logged responses = %+v, want the 400", logger.responseCalls)
+	}
+}
+
+func TestProxy_RealtimeBudget(t *testing.T) {
+	serverEvents := []string{
+		`{"type":"response.done","response":{"id":"resp_1","status":"completed","output":[],` +
+			`"usage":{"input_tokens":100,"output_tokens":20}}}`,
+	}
+	gotClient := make(chan []string, 1)
+	gotHeader := make(chan http.Header, 1)
+	upstream := realtimeUpstream(t, serverEvents, gotClient, gotHeader)
+	defer upstream.Close()
+
+	tmpDir := t.TempDir()
+	fileLogger, _ := NewLogger(tmpDir)
+	defer fileLogger.Close()
+	sm, _ := NewSessionManager(tmpDir, fileLogger)
+	defer sm.Close()
+	logger := newMockFileLogger()
+	proxy := NewProxyWithSessionManagerAndLogger(logger, sm)
+	proxy.budgets, _ = newBudgetTracker(BudgetConfig{SessionTokens: 100}, sm.db, "test-machine")
+
+	served := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxy.ServeHTTP(w, r)
+		close(served)
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+	req, _ := http.NewRequest("GET", srv.URL+"/openai/"+upstreamHost+"/v1/realtime?model=gpt-realtime", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Write(conn)
+
+	br := bufio.NewReader(conn)
+	if resp, err := http.ReadResponse(br, req); err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("handshake = %v, %v; want 101", resp, err)
+	}
+	if opcode, _, err := readTestFrame(br); err != nil || opcode != wsText {
+		t.Fatalf("expected response.done, got opcode %d (%v)", opcode, err)
+	}
+
+	// The response used up the session budget: the proxy closes the connection
+	opcode, payload, err := readTestFrame(br)
+	if err != nil || opcode != wsClose || len(payload) < 2 || binary.BigEndian.Uint16(payload) != wsPolicyViolation {
+		t.Fatalf("expected a 1008 close frame, got opcode %d %q (%v)", opcode, payload, err)
+	}
+	if !strings.Contains(string(payload[2:]), "session budget is used up: 120 of 100 tokens") {
+		t.Errorf("close reason = %q", payload[2:])
+	}
+	<-served
+
+	if len(logger.denialCalls) != 1 || logger.denialCalls[0].Rule != "budget" {
+		t.Errorf("policy denials = %+v, want the budget", logger.denialCalls)
+	}
+}
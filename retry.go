@@ -0,0 +1,232 @@
+// retry.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	mrand "math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retries for transient upstream failures.
+//
+// The request body is buffered before it is sent, so a request that fails
+// with a connection error or a retryable status before any response bytes
+// reach the client can be replayed unchanged. Waits follow the server's hint
+// (retry-after, retry-after-ms, anthropic-ratelimit-*-reset) when there is
+// one, else jittered exponential backoff. Every failed attempt is logged as
+// its own response entry under the turn's seq and request ID.
+
+// Retry defaults, used for unset RetryConfig fields.
+const (
+	retryDefaultBaseDelay = 1 * time.Second
+	retryDefaultMaxDelay  = 30 * time.Second
+	retryDefaultMaxTotal  = 2 * time.Minute
+)
+
+// defaultRetryStatuses are the statuses retried when none are configured:
+// rate limited, unavailable and overloaded.
+var defaultRetryStatuses = []int{429, 503, 529}
+
+// retryMaxErrorBody caps how much of a failed attempt's body is logged (1 MB).
+const retryMaxErrorBody = 1 << 20
+
+// retryPolicy decides whether and when to retry a request.
+type retryPolicy struct {
+	maxRetries int
+	statuses   map[int]bool
+	baseDelay  time.Duration
+	maxDelay   time.Duration
+	maxTotal   time.Duration
+}
+
+// newRetryPolicy builds a policy from cfg, applying defaults for unset
+// values. Returns nil if retries are disabled.
+func newRetryPolicy(cfg RetryConfig) (*retryPolicy, error) {
+	if cfg.MaxRetries < 0 {
+		return nil, fmt.Errorf("retry: max_retries must not be negative")
+	}
+	if cfg.MaxRetries == 0 {
+		return nil, nil
+	}
+
+	rp := &retryPolicy{
+		maxRetries: cfg.MaxRetries,
+		statuses:   make(map[int]bool),
+		baseDelay:  retryDefaultBaseDelay,
+		maxDelay:   retryDefaultMaxDelay,
+		maxTotal:   retryDefaultMaxTotal,
+	}
+
+	statuses := cfg.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatuses
+	}
+	for _, status := range statuses {
+		if status < 400 || status > 599 {
+			return nil, fmt.Errorf("retry: status %d is not an error status", status)
+		}
+		rp.statuses[status] = true
+	}
+
+	for _, d := range []struct {
+		name string
+		str  string
+		dst  *time.Duration
+	}{
+		{"base_delay", cfg.BaseDelayStr, &rp.baseDelay},
+		{"max_delay", cfg.MaxDelayStr, &rp.maxDelay},
+		{"max_total", cfg.MaxTotalStr, &rp.maxTotal},
+	} {
+		if d.str == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.str)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("retry: invalid %s %q", d.name, d.str)
+		}
+		*d.dst = parsed
+	}
+	if rp.baseDelay > rp.maxDelay {
+		return nil, fmt.Errorf("retry: base_delay %v exceeds max_delay %v", rp.baseDelay, rp.maxDelay)
+	}
+	return rp, nil
+}
+
+// retryable reports whether an attempt's result may be retried. Connection
+// errors are retried unless the client went away.
+func (rp *retryPolicy) retryable(ctx context.Context, resp *http.Response, err error) bool {
+	if err != nil {
+		return ctx.Err() == nil
+	}
+	return rp.statuses[resp.StatusCode]
+}
+
+// delay returns how long to wait before retry number retry (1-based): the
+// server's hint if the response carries one, else exponential backoff from
+// baseDelay, capped at maxDelay, jittered over its upper half.
+func (rp *retryPolicy) delay(retry int, resp *http.Response, now time.Time) time.Duration {
+	if resp != nil {
+		if d, ok := serverRetryDelay(resp.Header, now); ok {
+			return d
+		}
+	}
+	backoff := rp.maxDelay
+	if retry < 32 {
+		if d := rp.baseDelay << (retry - 1); d > 0 && d < rp.maxDelay {
+			backoff = d
+		}
+	}
+	half := backoff / 2
+	return half + time.Duration(mrand.Int63n(int64(backoff-half)+1))
+}
+
+// serverRetryDelay returns the wait requested by response headers:
+// retry-after (seconds or HTTP date), retry-after-ms, or the latest
+// anthropic-ratelimit-*-reset among exhausted limits (remaining = 0).
+func serverRetryDelay(h http.Header, now time.Time) (time.Duration, bool) {
+	if ms := h.Get("retry-after-ms"); ms != "" {
+		if n, err := strconv.ParseFloat(ms, 64); err == nil && n >= 0 {
+			return time.Duration(n * float64(time.Millisecond)), true
+		}
+	}
+	if ra := h.Get("retry-after"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return clampDelay(t.Sub(now)), true
+		}
+	}
+
+	const prefix = "Anthropic-Ratelimit-"
+	var latest time.Time
+	for key, values := range h {
+		if !strings.HasPrefix(key, prefix) || !strings.HasSuffix(key, "-Reset") || len(values) == 0 {
+			continue
+		}
+		limit := strings.TrimSuffix(key, "-Reset")
+		if h.Get(limit+"-Remaining") != "0" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, values[0]); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	if !latest.IsZero() {
+		return clampDelay(latest.Sub(now)), true
+	}
+	return 0, false
+}
+
+// clampDelay returns d, or 0 if the requested time has already passed.
+func clampDelay(d time.Duration) time.Duration {
+	if d < 0 {
+		return 0
+	}
+	return d
+}
+
+// doWithRetry sends req with send, retrying per p.retry until an attempt
+// succeeds or is not retryable, retries run out, or the next wait would pass
+// maxTotal. req must have GetBody set (http.NewRequest does this for a
+// *bytes.Reader body). Each retried attempt is consumed and passed to
+// logAttempt (if not nil); the last attempt's response or error is returned
+// unread.
+func (p *Proxy) doWithRetry(req *http.Request, send func(*http.Request) (*http.Response, error), logAttempt func(status int, header http.Header, body []byte, timing ResponseTiming)) (*http.Response, error) {
+	attemptStart := time.Now()
+	resp, err := send(req)
+	if p.retry == nil || req.GetBody == nil {
+		return resp, err
+	}
+
+	ctx := req.Context()
+	start := attemptStart
+	for retry := 1; retry <= p.retry.maxRetries && p.retry.retryable(ctx, resp, err); retry++ {
+		wait := p.retry.delay(retry, resp, time.Now())
+		if time.Since(start)+wait > p.retry.maxTotal {
+			break
+		}
+
+		// Consume the failed attempt so it can be logged and its connection reused
+		status := http.StatusBadGateway
+		var header http.Header
+		var body []byte
+		if err != nil {
+			body = []byte("upstream request failed: " + err.Error() + "\n")
+		} else {
+			status, header = resp.StatusCode, resp.Header
+			body, _ = io.ReadAll(io.LimitReader(resp.Body, retryMaxErrorBody))
+			resp.Body.Close()
+		}
+		if logAttempt != nil {
+			elapsed := time.Since(attemptStart).Milliseconds()
+			logAttempt(status, header, body, ResponseTiming{TTFBMs: elapsed, TotalMs: elapsed})
+		}
+		log.Printf("Retrying %s%s in %v after %d (retry %d/%d)", req.URL.Host, req.URL.Path, wait.Round(time.Millisecond), status, retry, p.retry.maxRetries)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+
+		replay, bodyErr := req.GetBody()
+		if bodyErr != nil {
+			return nil, bodyErr
+		}
+		req = req.Clone(ctx)
+		req.Body = replay
+
+		attemptStart = time.Now()
+		resp, err = send(req)
+	}
+	return resp, err
+}
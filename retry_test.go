@@ -0,0 +1,235 @@
+// retry_test.go
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RetryConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", RetryConfig{}, true, false},
+		{"defaults", RetryConfig{MaxRetries: 3}, false, false},
+		{"negative", RetryConfig{MaxRetries: -1}, true, true},
+		{"success status", RetryConfig{MaxRetries: 3, Statuses: []int{200}}, true, true},
+		{"bad duration", RetryConfig{MaxRetries: 3, MaxTotalStr: "soon"}, true, true},
+		{"base over max", RetryConfig{MaxRetries: 3, BaseDelayStr: "1m", MaxDelayStr: "10s"}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp, err := newRetryPolicy(tt.cfg)
+			if (err != nil) != tt.wantErr || (rp == nil) != tt.wantNil {
+				t.Errorf("newRetryPolicy() = (%+v, %v), wantNil %v wantErr %v", rp, err, tt.wantNil, tt.wantErr)
+			}
+		})
+	}
+
+	rp, _ := newRetryPolicy(RetryConfig{MaxRetries: 2})
+	if !rp.statuses[429] || !rp.statuses[503] || !rp.statuses[529] || rp.statuses[500] {
+		t.Errorf("default statuses = %v", rp.statuses)
+	}
+}
+
+func TestServerRetryDelay(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+		wantOK bool
+	}{
+		{"none", header(), 0, false},
+		{"retry-after seconds", header("retry-after", "7"), 7 * time.Second, true},
+		{"retry-after date", header("retry-after", now.Add(3*time.Second).Format(http.TimeFormat)), 3 * time.Second, true},
+		{"retry-after past date", header("retry-after", now.Add(-time.Minute).Format(http.TimeFormat)), 0, true},
+		{"retry-after-ms wins", header("retry-after-ms", "1500", "retry-after", "2"), 1500 * time.Millisecond, true},
+		{"exhausted limit reset", header(
+			"anthropic-ratelimit-requests-remaining", "10",
+			"anthropic-ratelimit-requests-reset", now.Add(50*time.Second).Format(time.RFC3339),
+			"anthropic-ratelimit-output-tokens-remaining", "0",
+			"anthropic-ratelimit-output-tokens-reset", now.Add(4*time.Second).Format(time.RFC3339),
+		), 4 * time.Second, true},
+		{"no exhausted limit", header(
+			"anthropic-ratelimit-requests-remaining", "10",
+			"anthropic-ratelimit-requests-reset", now.Add(50*time.Second).Format(time.RFC3339),
+		), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := serverRetryDelay(tt.header, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("serverRetryDelay() = (%v, %v), want (%v, %v)", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_DelayBackoff(t *testing.T) {
+	rp := &retryPolicy{baseDelay: 100 * time.Millisecond, maxDelay: time.Second}
+
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second}, // capped at max_delay
+		{64, 500 * time.Millisecond, time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if d := rp.delay(tt.retry, nil, time.Now()); d < tt.min || d > tt.max {
+				t.Errorf("delay(%d) = %v, want in [%v, %v]", tt.retry, d, tt.min, tt.max)
+			}
+		}
+	}
+}
+
+// newTestRetryProxy returns a proxy with retries enabled that logs to a
+// mock file logger.
+func newTestRetryProxy(t *testing.T, cfg RetryConfig) (*Proxy, *mockFileLogger) {
+	t.Helper()
+	rp, err := newRetryPolicy(cfg)
+	if err != nil {
+		t.Fatalf("newRetryPolicy: %v", err)
+	}
+	logger := newMockFileLogger()
+	proxy := NewProxyWithSessionManagerAndLogger(logger, nil)
+	proxy.retry = rp
+	return proxy, logger
+}
+
+func TestProxy_RetriesThenSucceeds(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := make([]byte, r.ContentLength)
+		r.Body.Read(body)
+		if !strings.Contains(string(body), "Hello") {
+			t.Errorf("attempt %d got body %q", atomic.LoadInt32(&calls)+1, body)
+		}
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("retry-after", "0")
+			w.WriteHeader(529)
+			w.Write([]byte(`{"type":"error","error":{"type":"overloaded_error"}}`))
+		case 2:
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"content":[{"type":"text","text":"Hi"}]}`))
+		}
+	}))
+	defer upstream.Close()
+
+	proxy, logger := newTestRetryProxy(t, RetryConfig{MaxRetries: 3, BaseDelayStr: "1ms", MaxDelayStr: "5ms"})
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	req := httptest.NewRequest("POST", "/anthropic/"+host+"/v1/messages", strings.NewReader(`{"model":"claude-3","messages":[{"role":"user","content":"Hello"}]}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || atomic.LoadInt32(&calls) != 3 {
+		t.Fatalf("status = %d after %d calls, want 200 after 3", w.Code, calls)
+	}
+	if len(logger.requestCalls) != 1 {
+		t.Errorf("requestCalls = %d, want 1", len(logger.requestCalls))
+	}
+	var statuses []int
+	for _, call := range logger.responseCalls {
+		statuses = append(statuses, call.status)
+		if call.requestID != logger.requestCalls[0].requestID || call.seq != logger.requestCalls[0].seq {
+			t.Errorf("attempt logged with request %q seq %d", call.requestID, call.seq)
+		}
+	}
+	if len(statuses) != 3 || statuses[0] != 529 || statuses[1] != 429 || statuses[2] != 200 {
+		t.Errorf("logged statuses = %v, want [529 429 200]", statuses)
+	}
+	if !strings.Contains(string(logger.responseCalls[0].body), "overloaded_error") {
+		t.Errorf("retried attempt body = %q", logger.responseCalls[0].body)
+	}
+}
+
+func TestProxy_RetryStopsAtLimits(t *testing.T) {
+	tests := []struct {
+		name       string
+		cfg        RetryConfig
+		retryAfter string
+		status     int
+		wantCalls  int32
+	}{
+		{"retries exhausted", RetryConfig{MaxRetries: 2, BaseDelayStr: "1ms", MaxDelayStr: "1ms"}, "", 529, 3},
+		{"wait past max_total", RetryConfig{MaxRetries: 2, MaxTotalStr: "1s"}, "30", 429, 1},
+		{"status not retried", RetryConfig{MaxRetries: 2}, "", 500, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int32
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				if tt.retryAfter != "" {
+					w.Header().Set("retry-after", tt.retryAfter)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer upstream.Close()
+
+			proxy, _ := newTestRetryProxy(t, tt.cfg)
+			host := strings.TrimPrefix(upstream.URL, "http://")
+			req := httptest.NewRequest("POST", "/anthropic/"+host+"/v1/messages", strings.NewReader(`{}`))
+			w := httptest.NewRecorder()
+			proxy.ServeHTTP(w, req)
+
+			if w.Code != tt.status || atomic.LoadInt32(&calls) != tt.wantCalls {
+				t.Errorf("status = %d after %d calls, want %d after %d", w.Code, calls, tt.status, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestProxy_RetriesConnectionErrors(t *testing.T) {
+	var calls int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			// Drop the connection without a response
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		w.Write([]byte(`{"content":[]}`))
+	}))
+	defer upstream.Close()
+
+	proxy, logger := newTestRetryProxy(t, RetryConfig{MaxRetries: 1, BaseDelayStr: "1ms", MaxDelayStr: "1ms"})
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	req := httptest.NewRequest("POST", "/anthropic/"+host+"/v1/messages", strings.NewReader(`{}`))
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("status = %d after %d calls, want 200 after 2", w.Code, calls)
+	}
+	if len(logger.responseCalls) != 2 || logger.responseCalls[0].status != http.StatusBadGateway {
+		t.Errorf("responseCalls = %+v, want a 502 attempt then the 200", logger.responseCalls)
+	}
+}
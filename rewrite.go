@@ -0,0 +1,307 @@
+// rewrite.go
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Request rewrite rules ([[rewrite]] in the config file).
+//
+// A rule matches on provider, path, model, headers and client session ID;
+// empty match fields match anything. Matching rules are applied in order,
+// each seeing the previous rules' changes, so one rule can alias a model and
+// a later one cap the aliased model's max_tokens. Rules that change a request
+// are recorded in a rewrite entry holding the original body, and in
+// _meta.rewritten_by on all of the request's entries.
+
+// maxTokensFields are the top-level output token limits across dialects.
+var maxTokensFields = []string{"max_tokens", "max_completion_tokens", "max_output_tokens"}
+
+// rewriteRule is a validated RewriteRuleConfig.
+type rewriteRule struct {
+	RewriteRuleConfig
+	path *regexp.Regexp // nil matches any path
+}
+
+// rewriteRules are applied in config order.
+type rewriteRules []*rewriteRule
+
+// rewrittenRequest is a request after rewrite rules were applied.
+type rewrittenRequest struct {
+	rules  []string // names of the rules that changed it
+	path   string
+	header http.Header // nil unless a rule changed the headers
+	body   []byte
+}
+
+// newRewriteRules validates cfgs against the providers registry. Returns nil
+// if no rules are configured.
+func newRewriteRules(cfgs []RewriteRuleConfig, providers *providerRegistry) (rewriteRules, error) {
+	var rules rewriteRules
+	names := make(map[string]bool)
+	for _, cfg := range cfgs {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("rewrite: every rule needs a name")
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("rewrite: duplicate rule name %q", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		if cfg.Provider != "" && providers.lookup(cfg.Provider) == nil {
+			return nil, fmt.Errorf("rewrite %q: unknown provider %q", cfg.Name, cfg.Provider)
+		}
+		rule := &rewriteRule{RewriteRuleConfig: cfg}
+		if cfg.Path != "" {
+			re, err := compilePathPattern(cfg.Path)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite %q: %w", cfg.Name, err)
+			}
+			rule.path = re
+		}
+		globs := append([]string{cfg.Model, cfg.ClientSession}, cfg.RemoveBetas...)
+		for _, value := range cfg.Headers {
+			globs = append(globs, value)
+		}
+		for _, glob := range globs {
+			if _, err := path.Match(glob, ""); err != nil {
+				return nil, fmt.Errorf("rewrite %q: invalid pattern %q", cfg.Name, glob)
+			}
+		}
+		if cfg.MaxTokens < 0 {
+			return nil, fmt.Errorf("rewrite %q: max_tokens must not be negative", cfg.Name)
+		}
+		if cfg.SetModel == "" && cfg.MaxTokens == 0 && len(cfg.RemoveBetas) == 0 && len(cfg.AddBetas) == 0 && cfg.UserID == "" {
+			return nil, fmt.Errorf("rewrite %q: no rewrite (set_model, max_tokens, remove_betas, add_betas or user_id)", cfg.Name)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// names lists the rules for the startup log.
+func (rr rewriteRules) names() string {
+	var names []string
+	for _, rule := range rr {
+		names = append(names, rule.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+// apply runs the rules against a request to spec's provider. The original
+// header and body are never modified. Bodies that aren't a JSON object only
+// get header rewrites.
+func (rr rewriteRules) apply(spec *providerSpec, reqPath string, header http.Header, body []byte) rewrittenRequest {
+	out := rewrittenRequest{path: reqPath, body: body}
+	if len(rr) == 0 {
+		return out
+	}
+
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		fields = nil
+	}
+	clientSession := spec.clientSessionID(body, header, reqPath)
+	bodyChanged := false
+
+	for _, rule := range rr {
+		model := requestModel(fields, out.path)
+		if !rule.matches(spec.Name, out.path, header, model, clientSession) {
+			continue
+		}
+		changed := false
+
+		if rule.SetModel != "" && rule.SetModel != model {
+			if _, ok := fields["model"]; ok {
+				fields["model"] = marshalRaw(rule.SetModel)
+				changed, bodyChanged = true, true
+			} else if model != "" && isGeminiConversationPath(out.path) {
+				out.path = strings.Replace(out.path, "/models/"+model+":", "/models/"+rule.SetModel+":", 1)
+				changed = true
+			}
+		}
+		if rule.MaxTokens > 0 && capMaxTokens(fields, rule.MaxTokens) {
+			changed, bodyChanged = true, true
+		}
+		if rule.UserID != "" && fields != nil && setMetadataUserID(fields, rule.UserID) {
+			changed, bodyChanged = true, true
+		}
+		if len(rule.RemoveBetas) > 0 || len(rule.AddBetas) > 0 {
+			if h, ok := rewriteBetas(header, betaHeader(spec.Dialect), rule.RemoveBetas, rule.AddBetas); ok {
+				header, out.header = h, h
+				changed = true
+			}
+		}
+
+		if changed {
+			out.rules = append(out.rules, rule.Name)
+		}
+	}
+
+	if bodyChanged {
+		out.body = marshalRaw(fields)
+	}
+	return out
+}
+
+// matches reports whether every match field set on the rule matches.
+func (rule *rewriteRule) matches(provider, reqPath string, header http.Header, model, clientSession string) bool {
+	if rule.Provider != "" && rule.Provider != provider {
+		return false
+	}
+	if rule.path != nil && !rule.path.MatchString(reqPath) {
+		return false
+	}
+	if rule.Model != "" && !globMatch(rule.Model, model) {
+		return false
+	}
+	if rule.ClientSession != "" && !globMatch(rule.ClientSession, clientSession) {
+		return false
+	}
+	for name, glob := range rule.Headers {
+		if !globMatch(glob, header.Get(name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// globMatch matches value against a glob; an empty value never matches.
+func globMatch(glob, value string) bool {
+	if value == "" {
+		return false
+	}
+	ok, _ := path.Match(glob, value)
+	return ok
+}
+
+// requestModel returns the request's model from the body, or from the path
+// for Gemini.
+func requestModel(fields map[string]json.RawMessage, reqPath string) string {
+	var model string
+	if raw, ok := fields["model"]; ok && json.Unmarshal(raw, &model) == nil && model != "" {
+		return model
+	}
+	if isGeminiConversationPath(reqPath) {
+		return extractGeminiModel(reqPath)
+	}
+	return ""
+}
+
+// capMaxTokens lowers the request's output token limits (top-level, or
+// Gemini's generationConfig.maxOutputTokens) to max. Reports whether any
+// was lowered.
+func capMaxTokens(fields map[string]json.RawMessage, max int) bool {
+	changed := false
+	for _, field := range maxTokensFields {
+		var n float64
+		if raw, ok := fields[field]; ok && json.Unmarshal(raw, &n) == nil && n > float64(max) {
+			fields[field] = marshalRaw(max)
+			changed = true
+		}
+	}
+
+	var gen map[string]json.RawMessage
+	if raw, ok := fields["generationConfig"]; ok && json.Unmarshal(raw, &gen) == nil {
+		var n float64
+		if raw, ok := gen["maxOutputTokens"]; ok && json.Unmarshal(raw, &n) == nil && n > float64(max) {
+			gen["maxOutputTokens"] = marshalRaw(max)
+			fields["generationConfig"] = marshalRaw(gen)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// setMetadataUserID sets metadata.user_id unless the client sent one, which
+// may carry its session ID (Claude Code does).
+func setMetadataUserID(fields map[string]json.RawMessage, userID string) bool {
+	metadata := make(map[string]json.RawMessage)
+	if raw, ok := fields["metadata"]; ok {
+		if json.Unmarshal(raw, &metadata) != nil {
+			return false
+		}
+	}
+	if _, ok := metadata["user_id"]; ok {
+		return false
+	}
+	metadata["user_id"] = marshalRaw(userID)
+	fields["metadata"] = marshalRaw(metadata)
+	return true
+}
+
+// betaHeader returns the header carrying beta feature flags in a dialect.
+func betaHeader(dialect string) string {
+	if isOpenAIDialect(dialect) {
+		return "OpenAI-Beta"
+	}
+	return "Anthropic-Beta"
+}
+
+// rewriteBetas removes betas matching a remove glob from the comma-separated
+// name header and adds the add betas it lacks. Returns a modified copy of h
+// and true if the header changed.
+func rewriteBetas(h http.Header, name string, remove, add []string) (http.Header, bool) {
+	var betas []string
+	changed := false
+	for _, value := range h.Values(name) {
+		for _, beta := range strings.Split(value, ",") {
+			beta = strings.TrimSpace(beta)
+			if beta == "" {
+				continue
+			}
+			removed := false
+			for _, glob := range remove {
+				if ok, _ := path.Match(glob, beta); ok {
+					removed = true
+					break
+				}
+			}
+			if removed {
+				changed = true
+			} else {
+				betas = append(betas, beta)
+			}
+		}
+	}
+	for _, beta := range add {
+		present := false
+		for _, b := range betas {
+			if b == beta {
+				present = true
+				break
+			}
+		}
+		if !present {
+			betas = append(betas, beta)
+			changed = true
+		}
+	}
+	if !changed {
+		return h, false
+	}
+
+	out := h.Clone()
+	out.Del(name)
+	if len(betas) > 0 {
+		out.Set(name, strings.Join(betas, ","))
+	}
+	return out, true
+}
+
+// marshalRaw encodes v without escaping HTML, so text the client sent is
+// forwarded as it was. Strings, numbers and maps of already-parsed JSON
+// always encode.
+func marshalRaw(v interface{}) json.RawMessage {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,333 @@
+// rewrite_test.go
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRewriteRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfgs    []RewriteRuleConfig
+		wantErr string
+	}{
+		{"none", nil, ""},
+		{"valid", []RewriteRuleConfig{{Name: "opus", Provider: "anthropic", Path: "/v1/messages", Model: "claude-3-opus*", SetModel: "claude-opus-4-6"}}, ""},
+		{"missing name", []RewriteRuleConfig{{SetModel: "m"}}, "needs a name"},
+		{"duplicate name", []RewriteRuleConfig{{Name: "a", SetModel: "m"}, {Name: "a", MaxTokens: 10}}, "duplicate"},
+		{"unknown provider", []RewriteRuleConfig{{Name: "a", Provider: "nope", SetModel: "m"}}, "unknown provider"},
+		{"relative path", []RewriteRuleConfig{{Name: "a", Path: "v1/messages", SetModel: "m"}}, "must start with /"},
+		{"bad glob", []RewriteRuleConfig{{Name: "a", Model: "claude-[", SetModel: "m"}}, "invalid pattern"},
+		{"bad header glob", []RewriteRuleConfig{{Name: "a", Headers: map[string]string{"X-Team": "["}, SetModel: "m"}}, "invalid pattern"},
+		{"negative max_tokens", []RewriteRuleConfig{{Name: "a", MaxTokens: -1}}, "negative"},
+		{"no rewrite", []RewriteRuleConfig{{Name: "a", Model: "claude-*"}}, "no rewrite"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRewriteRules(tt.cfgs, builtinRegistry)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRewriteRules_Apply(t *testing.T) {
+	tests := []struct {
+		name      string
+		rules     []RewriteRuleConfig
+		provider  string
+		path      string
+		header    map[string]string
+		body      string
+		wantRules string
+		wantPath  string
+		wantBody  string
+		wantBeta  string // "-" when headers must be unchanged
+	}{
+		{
+			name:      "model alias",
+			rules:     []RewriteRuleConfig{{Name: "opus", Model: "claude-3-opus*", SetModel: "claude-opus-4-6"}},
+			provider:  "anthropic",
+			path:      "/v1/messages",
+			body:      `{"model":"claude-3-opus-20240229","max_tokens":100}`,
+			wantRules: "opus",
+			wantBody:  `{"max_tokens":100,"model":"claude-opus-4-6"}`,
+			wantBeta:  "-",
+		},
+		{
+			name:     "no match leaves the body untouched",
+			rules:    []RewriteRuleConfig{{Name: "opus", Model: "claude-3-opus*", SetModel: "claude-opus-4-6"}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			body:     `{"model": "claude-sonnet-4-5", "max_tokens": 100}`,
+			wantBody: `{"model": "claude-sonnet-4-5", "max_tokens": 100}`,
+			wantBeta: "-",
+		},
+		{
+			name:     "provider and path must match",
+			rules:    []RewriteRuleConfig{{Name: "cap", Provider: "openai", Path: "/v1/chat/completions", MaxTokens: 10}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			body:     `{"max_tokens":100}`,
+			wantBody: `{"max_tokens":100}`,
+			wantBeta: "-",
+		},
+		{
+			name:      "max_tokens cap",
+			rules:     []RewriteRuleConfig{{Name: "cap", MaxTokens: 4096}},
+			provider:  "openai",
+			path:      "/v1/responses",
+			body:      `{"max_output_tokens":32000,"input":"<b>hi</b> & bye"}`,
+			wantRules: "cap",
+			wantBody:  `{"input":"<b>hi</b> & bye","max_output_tokens":4096}`,
+			wantBeta:  "-",
+		},
+		{
+			name:     "max_tokens under the cap",
+			rules:    []RewriteRuleConfig{{Name: "cap", MaxTokens: 4096}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			body:     `{"max_tokens":1024}`,
+			wantBody: `{"max_tokens":1024}`,
+			wantBeta: "-",
+		},
+		{
+			name:      "aliased model is capped by a later rule",
+			rules:     []RewriteRuleConfig{{Name: "alias", Model: "old-model", SetModel: "new-model"}, {Name: "cap-new", Model: "new-model", MaxTokens: 50}},
+			provider:  "anthropic",
+			path:      "/v1/messages",
+			body:      `{"model":"old-model","max_tokens":100}`,
+			wantRules: "alias,cap-new",
+			wantBody:  `{"max_tokens":50,"model":"new-model"}`,
+			wantBeta:  "-",
+		},
+		{
+			name:      "user_id injected",
+			rules:     []RewriteRuleConfig{{Name: "team", UserID: "team-infra"}},
+			provider:  "anthropic",
+			path:      "/v1/messages",
+			body:      `{"model":"m","metadata":{"tag":"x"}}`,
+			wantRules: "team",
+			wantBody:  `{"metadata":{"tag":"x","user_id":"team-infra"},"model":"m"}`,
+			wantBeta:  "-",
+		},
+		{
+			name:     "client user_id kept",
+			rules:    []RewriteRuleConfig{{Name: "team", UserID: "team-infra"}},
+			provider: "anthropic",
+			path:     "/v1/messages",
+			body:     `{"metadata":{"user_id":"user_abc_session_123"}}`,
+			wantBody: `{"metadata":{"user_id":"user_abc_session_123"}}`,
+			wantBeta: "-",
+		},
+		{
+			name:      "betas stripped and added",
+			rules:     []RewriteRuleConfig{{Name: "betas", RemoveBetas: []string{"context-1m-*"}, AddBetas: []string{"token-efficient-tools-2025-02-19"}}},
+			provider:  "anthropic",
+			path:      "/v1/messages",
+			header:    map[string]string{"Anthropic-Beta": "context-1m-2025-08-07, interleaved-thinking-2025-05-14"},
+			body:      `{}`,
+			wantRules: "betas",
+			wantBody:  `{}`,
+			wantBeta:  "interleaved-thinking-2025-05-14,token-efficient-tools-2025-02-19",
+		},
+		{
+			name:      "header match",
+			rules:     []RewriteRuleConfig{{Name: "ci", Headers: map[string]string{"X-Team": "ci-*"}, SetModel: "cheap"}},
+			provider:  "openai",
+			path:      "/v1/chat/completions",
+			header:    map[string]string{"X-Team": "ci-runners"},
+			body:      `{"model":"gpt-5"}`,
+			wantRules: "ci",
+			wantBody:  `{"model":"cheap"}`,
+			wantBeta:  "-",
+		},
+		{
+			name:     "missing header does not match",
+			rules:    []RewriteRuleConfig{{Name: "ci", Headers: map[string]string{"X-Team": "*"}, SetModel: "cheap"}},
+			provider: "openai",
+			path:     "/v1/chat/completions",
+			body:     `{"model":"gpt-5"}`,
+			wantBody: `{"model":"gpt-5"}`,
+			wantBeta: "-",
+		},
+		{
+			name:      "client session match",
+			rules:     []RewriteRuleConfig{{Name: "pinned", ClientSession: "pinned-*", SetModel: "claude-opus-4-6"}},
+			provider:  "openai",
+			path:      "/v1/responses",
+			header:    map[string]string{"Session_id": "pinned-42"},
+			body:      `{"model":"gpt-5","input":"hi"}`,
+			wantRules: "pinned",
+			wantBody:  `{"input":"hi","model":"claude-opus-4-6"}`,
+			wantBeta:  "-",
+		},
+		{
+			name:      "gemini model in the path",
+			rules:     []RewriteRuleConfig{{Name: "flash", Provider: "gemini", Model: "gemini-1.5-*", SetModel: "gemini-2.5-flash", MaxTokens: 1000}},
+			provider:  "gemini",
+			path:      "/v1beta/models/gemini-1.5-pro:generateContent",
+			body:      `{"contents":[],"generationConfig":{"maxOutputTokens":8192}}`,
+			wantRules: "flash",
+			wantPath:  "/v1beta/models/gemini-2.5-flash:generateContent",
+			wantBody:  `{"contents":[],"generationConfig":{"maxOutputTokens":1000}}`,
+			wantBeta:  "-",
+		},
+		{
+			name:      "non-JSON body only gets header rewrites",
+			rules:     []RewriteRuleConfig{{Name: "all", MaxTokens: 10, AddBetas: []string{"b1"}}},
+			provider:  "anthropic",
+			path:      "/v1/messages",
+			body:      `not json`,
+			wantRules: "all",
+			wantBody:  `not json`,
+			wantBeta:  "b1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := newRewriteRules(tt.rules, builtinRegistry)
+			if err != nil {
+				t.Fatalf("newRewriteRules: %v", err)
+			}
+			header := http.Header{}
+			for k, v := range tt.header {
+				header.Set(k, v)
+			}
+			before := header.Clone()
+
+			got := rules.apply(builtinRegistry.lookup(tt.provider), tt.path, header, []byte(tt.body))
+
+			if strings.Join(got.rules, ",") != tt.wantRules {
+				t.Errorf("rules = %v, want %q", got.rules, tt.wantRules)
+			}
+			wantPath := tt.wantPath
+			if wantPath == "" {
+				wantPath = tt.path
+			}
+			if got.path != wantPath {
+				t.Errorf("path = %q, want %q", got.path, wantPath)
+			}
+			if string(got.body) != tt.wantBody {
+				t.Errorf("body = %s, want %s", got.body, tt.wantBody)
+			}
+			if tt.wantBeta == "-" {
+				if got.header != nil {
+					t.Errorf("header = %v, want unchanged", got.header)
+				}
+			} else if got.header == nil || got.header.Get(betaHeader(builtinRegistry.dialect(tt.provider))) != tt.wantBeta {
+				t.Errorf("header = %v, want beta %q", got.header, tt.wantBeta)
+			}
+			if !headersEqual(header, before) {
+				t.Errorf("original header modified: %v", header)
+			}
+		})
+	}
+}
+
+func headersEqual(a, b http.Header) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if strings.Join(v, "\n") != strings.Join(b[k], "\n") {
+			return false
+		}
+	}
+	return true
+}
+
+func TestServer_RewriteRules(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	var gotBody, gotBeta string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		gotBody, gotBeta = string(body), r.Header.Get("Anthropic-Beta")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"msg_1","content":[{"type":"text","text":"hi"}],"usage":{"input_tokens":1,"output_tokens":1}}`))
+	}))
+	defer upstream.Close()
+	upstreamHost := strings.TrimPrefix(upstream.URL, "http://")
+
+	srv, err := NewServer(Config{
+		Port:   8080,
+		LogDir: tmpDir,
+		Rewrites: []RewriteRuleConfig{
+			{Name: "opus-upgrade", Model: "claude-3-opus*", SetModel: "claude-opus-4-6", RemoveBetas: []string{"old-beta"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	original := `{"model":"claude-3-opus-20240229","max_tokens":100,"messages":[{"role":"user","content":"hi"}]}`
+	req := httptest.NewRequest("POST", "/anthropic/"+upstreamHost+"/v1/messages", strings.NewReader(original))
+	req.Header.Set("Anthropic-Beta", "old-beta")
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(gotBody, `"model":"claude-opus-4-6"`) || gotBeta != "" {
+		t.Errorf("upstream got body %s beta %q, want the rewritten model and no beta", gotBody, gotBeta)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	logFiles, _ := filepath.Glob(filepath.Join(tmpDir, upstreamHost, time.Now().Format("2006-01-02"), "*.jsonl"))
+	if len(logFiles) != 1 {
+		t.Fatalf("log files = %v, want one", logFiles)
+	}
+	f, err := os.Open(logFiles[0])
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	entries := make(map[string]map[string]interface{})
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &entry)
+		entries[entry["type"].(string)] = entry
+	}
+
+	rewrite, ok := entries["rewrite"]
+	if !ok {
+		t.Fatal("no rewrite entry logged")
+	}
+	record := rewrite["rewrite"].(map[string]interface{})
+	if record["original_body"] != original {
+		t.Errorf("original_body = %v, want %s", record["original_body"], original)
+	}
+	if headers, _ := record["original_headers"].(map[string]interface{}); headers["Anthropic-Beta"] == nil {
+		t.Errorf("original_headers = %v, want the original beta header", record["original_headers"])
+	}
+	if body, _ := entries["request"]["body"].(string); body != gotBody {
+		t.Errorf("request body = %s, want what was sent upstream (%s)", body, gotBody)
+	}
+	for _, typ := range []string{"rewrite", "request", "response"} {
+		meta, _ := entries[typ]["_meta"].(map[string]interface{})
+		if meta["rewritten_by"] != "opus-upgrade" {
+			t.Errorf("%s _meta.rewritten_by = %v, want opus-upgrade", typ, meta["rewritten_by"])
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -16,14 +17,31 @@ type Server struct {
 	lokiExporter   *LokiExporter
 	multiWriter    *MultiWriter
 	sessionManager *SessionManager
+	auth           *proxyAuth
+	forward        *forwardProxy
 }
 
 func NewServer(cfg Config) (*Server, error) {
+	auth, err := newProxyAuth(cfg.ProxyAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Register built-in and [[providers]] routes
+	providers, err := newProviderRegistry(cfg.Providers)
+	if err != nil {
+		return nil, err
+	}
+	if len(cfg.Providers) > 0 {
+		log.Printf("Providers: %s", strings.Join(providers.names(), ","))
+	}
+
 	// Create file logger (primary)
 	fileLogger, err := NewLogger(cfg.LogDir)
 	if err != nil {
 		return nil, err
 	}
+	fileLogger.SetProviders(providers)
 
 	// Create LokiExporter if enabled and URL is set
 	var lokiExporter *LokiExporter
@@ -63,6 +81,7 @@ func NewServer(cfg Config) (*Server, error) {
 		lokiPusher = lokiExporter
 	}
 	multiWriter := NewMultiWriter(fileLogger, lokiPusher)
+	multiWriter.SetProviders(providers)
 
 	sessionManager, err := NewSessionManager(cfg.LogDir, fileLogger)
 	if err != nil {
@@ -72,26 +91,125 @@ func NewServer(cfg Config) (*Server, error) {
 		fileLogger.Close()
 		return nil, err
 	}
+	sessionManager.providers = providers
+
+	// Close the loggers and session database if a later step fails
+	started := false
+	defer func() {
+		if started {
+			return
+		}
+		if lokiExporter != nil {
+			lokiExporter.Close()
+		}
+		sessionManager.Close()
+		fileLogger.Close()
+	}()
 
 	// Get event emitter from multiWriter (returns nil if Loki not configured)
 	eventEmitter := multiWriter.EventEmitter()
 	machineID := multiWriter.MachineID()
 
 	proxy := NewProxyWithEventEmitter(multiWriter, sessionManager, eventEmitter, machineID)
+	proxy.providers = providers
 
-	// Initialize Bedrock if region is configured
-	if cfg.BedrockRegion != "" {
-		bedrock, bedrockErr := initBedrock(cfg.BedrockRegion)
+	// Initialize Bedrock if a region is configured
+	if cfg.BedrockRegion != "" || len(cfg.BedrockRegions) > 0 {
+		bedrock, bedrockErr := initBedrock(cfg.BedrockRegion, cfg.BedrockRegions, cfg.BedrockCredentials, cfg.BedrockConcurrency)
 		if bedrockErr != nil {
-			if lokiExporter != nil {
-				lokiExporter.Close()
-			}
-			sessionManager.Close()
-			fileLogger.Close()
 			return nil, bedrockErr
 		}
 		proxy.bedrock = bedrock
-		log.Printf("Bedrock: enabled (region=%s)", cfg.BedrockRegion)
+		log.Printf("Bedrock: enabled (region=%s, regions=%s, credentials=%s)", bedrock.region, strings.Join(bedrock.regionNames(), ","), strings.Join(bedrock.credentialNames(), ","))
+	}
+
+	// Initialize Anthropic → Bedrock failover if enabled
+	failover, failoverErr := newAnthropicFailover(cfg.AnthropicFailover, proxy.bedrock)
+	if failoverErr != nil {
+		return nil, failoverErr
+	}
+	if failover != nil {
+		proxy.failover = failover
+		log.Printf("Anthropic failover: enabled (bedrock region=%s, models=%s)", failover.region.region, strings.Join(failover.patterns, ","))
+	}
+
+	retry, retryErr := newRetryPolicy(cfg.Retry)
+	if retryErr != nil {
+		return nil, retryErr
+	}
+	if retry != nil {
+		proxy.retry = retry
+		log.Printf("Retries: enabled (max_retries=%d, max_total=%v)", retry.maxRetries, retry.maxTotal)
+	}
+
+	pools, poolsErr := newKeyPools(cfg.KeyPools, providers)
+	if poolsErr != nil {
+		return nil, poolsErr
+	}
+	if pools != nil {
+		proxy.keyPools = pools
+		log.Printf("Key pools: %s", pools.describe())
+	}
+
+	vault, vaultErr := newVaultState(cfg.Vault, providers)
+	if vaultErr != nil {
+		return nil, vaultErr
+	}
+	if vault != nil {
+		proxy.vault = vault
+		log.Printf("Vault: enabled (%s)", vault.path)
+	}
+
+	rewrites, rewritesErr := newRewriteRules(cfg.Rewrites, providers)
+	if rewritesErr != nil {
+		return nil, rewritesErr
+	}
+	if rewrites != nil {
+		proxy.rewrites = rewrites
+		log.Printf("Rewrite rules: %s", rewrites.names())
+	}
+
+	policies, policiesErr := newPolicyRules(cfg.Policies, providers)
+	if policiesErr != nil {
+		return nil, policiesErr
+	}
+	if policies != nil {
+		proxy.policies = policies
+		log.Printf("Policy rules: %s", policies.names())
+	}
+
+	prices, pricesErr := newModelPrices(cfg.Pricing)
+	if pricesErr != nil {
+		return nil, pricesErr
+	}
+	fileLogger.SetModelPrices(prices)
+	log.Printf("Pricing: %s", prices.describe())
+
+	budgets, budgetsErr := newBudgetTracker(cfg.Budget, sessionManager.db, machineID)
+	if budgetsErr != nil {
+		return nil, budgetsErr
+	}
+	if budgets != nil {
+		proxy.budgets = budgets
+		log.Printf("Budgets: %s", budgets.describe())
+	}
+
+	// Initialize Vertex AI if enabled
+	if cfg.Vertex.Enabled {
+		vertex, vertexErr := initVertex(cfg.Vertex)
+		if vertexErr != nil {
+			return nil, vertexErr
+		}
+		proxy.vertex = vertex
+		log.Printf("Vertex AI: enabled")
+	}
+
+	forward, forwardErr := newForwardProxy(cfg.ForwardProxy, proxy, providers)
+	if forwardErr != nil {
+		return nil, forwardErr
+	}
+	if forward != nil {
+		log.Printf("Forward proxy: enabled (intercepting %s)", forward.describe())
 	}
 
 	s := &Server{
@@ -102,14 +220,60 @@ func NewServer(cfg Config) (*Server, error) {
 		lokiExporter:   lokiExporter,
 		multiWriter:    multiWriter,
 		sessionManager: sessionManager,
+		auth:           auth,
+		forward:        forward,
+	}
+	if auth != nil {
+		log.Printf("Proxy auth: enabled (%d clients)", len(cfg.ProxyAuth.Clients))
 	}
 	s.mux.HandleFunc("/health", s.handleHealth)
 	s.mux.HandleFunc("/health/loki", s.handleHealthLoki)
 	s.mux.HandleFunc("/health/bedrock", s.handleHealthBedrock)
+	s.mux.HandleFunc("/budgets", s.handleBudgets)
+	s.mux.HandleFunc("/budgets/reset", s.handleBudgetReset)
+	started = true
 	return s, nil
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Authenticate the caller before anything else, including health checks.
+	// A verified client certificate identifies the caller by itself; otherwise
+	// proxy credentials are required. They are never forwarded upstream.
+	identity, err := clientCertIdentity(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if identity == "" && s.auth != nil {
+		identity, err = s.auth.authenticate(r, time.Now())
+		if err != nil {
+			// CONNECT clients (HTTPS_PROXY) expect the proxy auth challenge
+			if r.Method == http.MethodConnect {
+				w.Header().Set("Proxy-Authenticate", `Basic realm="llm-proxy"`)
+				http.Error(w, "proxy authentication failed: "+err.Error(), http.StatusProxyAuthRequired)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Bearer realm="llm-proxy"`)
+			http.Error(w, "proxy authentication failed: "+err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+	if identity != "" {
+		r.Header.Del(proxyAuthorizationHeader)
+		r.Header.Del(proxySignatureHeader)
+		r.Header.Del("Proxy-Authorization")
+		r = r.WithContext(withProxyIdentity(r.Context(), identity))
+	}
+
+	if r.Method == http.MethodConnect {
+		if s.forward == nil {
+			http.Error(w, "forward proxy mode is not enabled", http.StatusMethodNotAllowed)
+			return
+		}
+		s.forward.ServeHTTP(w, r)
+		return
+	}
+
 	// Check if it's a known endpoint
 	if r.URL.Path == "/health" {
 		s.handleHealth(w, r)
@@ -123,6 +287,14 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		s.handleHealthBedrock(w, r)
 		return
 	}
+	if r.URL.Path == "/budgets" {
+		s.handleBudgets(w, r)
+		return
+	}
+	if r.URL.Path == "/budgets/reset" {
+		s.handleBudgetReset(w, r)
+		return
+	}
 
 	// Otherwise, proxy the request
 	s.proxy.ServeHTTP(w, r)
@@ -171,9 +343,12 @@ func (s *Server) handleHealthLoki(w http.ResponseWriter, r *http.Request) {
 
 // BedrockHealthResponse is the JSON response for /health/bedrock endpoint
 type BedrockHealthResponse struct {
-	Status       string `json:"status"`
-	Region       string `json:"region,omitempty"`
-	DecodeErrors int64  `json:"decode_errors"`
+	Status       string                    `json:"status"`
+	Region       string                    `json:"region,omitempty"`
+	Regions      []string                  `json:"regions,omitempty"`
+	DecodeErrors int64                     `json:"decode_errors"`
+	Credentials  []BedrockCredentialHealth `json:"credentials,omitempty"`
+	Limits       []BedrockLimiterHealth    `json:"limits,omitempty"`
 }
 
 func (s *Server) handleHealthBedrock(w http.ResponseWriter, r *http.Request) {
@@ -189,10 +364,91 @@ func (s *Server) handleHealthBedrock(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(BedrockHealthResponse{
 		Status:       "ok",
 		Region:       s.proxy.bedrock.region,
+		Regions:      s.proxy.bedrock.regionNames(),
 		DecodeErrors: atomic.LoadInt64(&s.proxy.bedrock.decodeErrors),
+		Credentials:  s.proxy.bedrock.credentialHealth(),
+		Limits:       s.proxy.bedrock.limits.health(),
+	})
+}
+
+// BudgetsResponse is the JSON response for /budgets endpoint
+type BudgetsResponse struct {
+	Status  string         `json:"status"`
+	Budgets []BudgetStatus `json:"budgets,omitempty"`
+}
+
+func (s *Server) handleBudgets(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	budgets := s.proxy.budgets
+	if budgets == nil {
+		json.NewEncoder(w).Encode(BudgetsResponse{Status: "disabled"})
+		return
+	}
+	if !s.authorizeBudgetAdmin(w, r) {
+		return
+	}
+	usages, err := budgets.db.ListBudgetUsage()
+	if err != nil {
+		http.Error(w, "budgets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(BudgetsResponse{
+		Status:  "ok",
+		Budgets: budgetStatuses(usages, budgets.limits),
 	})
 }
 
+// handleBudgetReset clears budget usage: POST /budgets/reset?scope=key, with
+// an optional subject to reset only that subject.
+func (s *Server) handleBudgetReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	budgets := s.proxy.budgets
+	if budgets == nil {
+		http.Error(w, "budgets are not enabled", http.StatusNotFound)
+		return
+	}
+	if !s.authorizeBudgetAdmin(w, r) {
+		return
+	}
+	scope, subject, err := parseBudgetTarget(r.URL.Query().Get("scope"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q := r.URL.Query().Get("subject"); q != "" {
+		subject = q
+	}
+	n, err := budgets.db.ResetBudgetUsage(scope, subject)
+	if err != nil {
+		http.Error(w, "budgets: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Budgets: reset %d %s record(s)", n, scope)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"reset": n})
+}
+
+// authorizeBudgetAdmin refuses budget endpoint requests without the
+// [budget] admin_token. Returns false if it refused the request.
+func (s *Server) authorizeBudgetAdmin(w http.ResponseWriter, r *http.Request) bool {
+	budgets := s.proxy.budgets
+	if budgets.adminToken == "" {
+		http.Error(w, "budget endpoints need [budget] admin_token", http.StatusForbidden)
+		return false
+	}
+	if !budgets.authorizeAdmin(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="llm-proxy budgets"`)
+		http.Error(w, "invalid budget admin token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func (s *Server) Close() error {
 	var err error
 	if s.sessionManager != nil {
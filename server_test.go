@@ -127,8 +127,8 @@ func TestNewServer_LokiInvalidURL(t *testing.T) {
 
 	// Capture log output
 	var logBuf bytes.Buffer
+	defer log.SetOutput(log.Writer())
 	log.SetOutput(&logBuf)
-	defer log.SetOutput(nil)
 
 	cfg := Config{
 		Port:   8080,
@@ -2,9 +2,16 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // DefaultPortfilePath returns the standard location for the portfile.
@@ -14,21 +21,120 @@ func DefaultPortfilePath() string {
 	return filepath.Join(home, ".local", "state", "llm-proxy", "port")
 }
 
-// WritePortfile writes the given port number to the specified file.
+// DefaultSocketPath returns the standard location for the Unix socket,
+// next to the portfile: ~/.local/state/llm-proxy/proxy.sock
+func DefaultSocketPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "llm-proxy", "proxy.sock")
+}
+
+// Portfile records where the running service listens. The first line is the
+// TCP port (0 when listening only on a socket), optionally followed by
+// "socket=<path>", "tls=1" when the port serves HTTPS and, in forward-proxy
+// mode, "ca_dir=<dir>" lines, so a file holding just a port is still valid.
+type Portfile struct {
+	Port   int
+	Socket string
+	TLS    bool
+	CADir  string
+}
+
+// WritePortfile writes pf to the specified file.
 // It creates parent directories if they don't exist.
-func WritePortfile(path string, port int) error {
+func WritePortfile(path string, pf Portfile) error {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	return os.WriteFile(path, []byte(strconv.Itoa(port)), 0644)
+	content := strconv.Itoa(pf.Port)
+	if pf.Socket != "" {
+		content += "\nsocket=" + pf.Socket
+	}
+	if pf.TLS {
+		content += "\ntls=1"
+	}
+	if pf.CADir != "" {
+		content += "\nca_dir=" + pf.CADir
+	}
+	return os.WriteFile(path, []byte(content), 0644)
 }
 
-// ReadPortfile reads and parses a port number from the specified file.
-func ReadPortfile(path string) (int, error) {
+// ReadPortfile reads and parses the specified portfile.
+func ReadPortfile(path string) (Portfile, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return 0, err
+		return Portfile{}, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	port, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return Portfile{}, err
+	}
+	pf := Portfile{Port: port}
+	for _, line := range lines[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(line), "=")
+		switch key {
+		case "socket":
+			pf.Socket = value
+		case "tls":
+			pf.TLS = value == "1"
+		case "ca_dir":
+			pf.CADir = value
+		}
+	}
+	if pf.Port == 0 && pf.Socket == "" {
+		return Portfile{}, fmt.Errorf("portfile %s has neither a port nor a socket", path)
+	}
+	return pf, nil
+}
+
+// Scheme returns the scheme of the proxy's TCP port: "https" with [tls],
+// otherwise "http".
+func (pf Portfile) Scheme() string {
+	if pf.TLS {
+		return "https"
+	}
+	return "http"
+}
+
+// Client returns an HTTP client and base URL for reaching the proxy,
+// through the socket when there is one. The socket always serves plain HTTP.
+// Over HTTPS the certificate isn't verified: it may name another host than
+// localhost, and the client only checks that the proxy answers.
+func (pf Portfile) Client() (*http.Client, string) {
+	if pf.Socket == "" {
+		base := fmt.Sprintf("%s://localhost:%d", pf.Scheme(), pf.Port)
+		if !pf.TLS {
+			return http.DefaultClient, base
+		}
+		transport := &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}
+		return &http.Client{Transport: transport}, base
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", pf.Socket)
+		},
+	}
+	return &http.Client{Transport: transport}, "http://llm-proxy"
+}
+
+// Healthy reports whether the proxy recorded in pf answers its health check.
+// A 401 means it is running with proxy_auth.
+func (pf Portfile) Healthy() bool {
+	client, base := pf.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/health", nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
 	}
-	return strconv.Atoi(string(data))
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnauthorized
 }
@@ -0,0 +1,16 @@
+//go:build !unix
+
+// service_other.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+)
+
+// listenUnix is only supported on Unix systems, where the socket can be
+// restricted to the current user.
+func listenUnix(path string) (net.Listener, error) {
+	return nil, fmt.Errorf("socket %s: Unix sockets are not supported on %s", path, runtime.GOOS)
+}
@@ -2,9 +2,13 @@
 package main
 
 import (
+	"fmt"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -26,7 +30,7 @@ func TestWritePortfile(t *testing.T) {
 	tmpDir := t.TempDir()
 	portfile := filepath.Join(tmpDir, "port")
 
-	err := WritePortfile(portfile, 52847)
+	err := WritePortfile(portfile, Portfile{Port: 52847})
 	if err != nil {
 		t.Fatalf("WritePortfile failed: %v", err)
 	}
@@ -41,7 +45,7 @@ func TestWritePortfileCreatesDirectory(t *testing.T) {
 	tmpDir := t.TempDir()
 	portfile := filepath.Join(tmpDir, "nested", "dir", "port")
 
-	err := WritePortfile(portfile, 12345)
+	err := WritePortfile(portfile, Portfile{Port: 12345})
 	if err != nil {
 		t.Fatalf("WritePortfile failed: %v", err)
 	}
@@ -62,12 +66,12 @@ func TestReadPortfile(t *testing.T) {
 		t.Fatalf("Failed to write test file: %v", err)
 	}
 
-	port, err := ReadPortfile(portfile)
+	pf, err := ReadPortfile(portfile)
 	if err != nil {
 		t.Fatalf("ReadPortfile failed: %v", err)
 	}
-	if port != 54321 {
-		t.Errorf("Expected 54321, got %d", port)
+	if pf.Port != 54321 || pf.Socket != "" {
+		t.Errorf("Expected port 54321 and no socket, got %+v", pf)
 	}
 }
 
@@ -97,6 +101,61 @@ func TestReadPortfileInvalidContent(t *testing.T) {
 	}
 }
 
+func TestPortfileWithSocket(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		name string
+		pf   Portfile
+		want string
+	}{
+		{"port and socket", Portfile{Port: 52847, Socket: "/home/alice/.local/state/llm-proxy/proxy.sock"}, "52847\nsocket=/home/alice/.local/state/llm-proxy/proxy.sock"},
+		{"socket only", Portfile{Socket: "/run/user/1000/llm-proxy.sock"}, "0\nsocket=/run/user/1000/llm-proxy.sock"},
+		{"https", Portfile{Port: 52847, TLS: true}, "52847\ntls=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			portfile := filepath.Join(tmpDir, strings.ReplaceAll(tt.name, " ", "-"))
+			if err := WritePortfile(portfile, tt.pf); err != nil {
+				t.Fatalf("WritePortfile failed: %v", err)
+			}
+			if data, _ := os.ReadFile(portfile); string(data) != tt.want {
+				t.Errorf("portfile = %q, want %q", data, tt.want)
+			}
+			if pf, err := ReadPortfile(portfile); err != nil || pf != tt.pf {
+				t.Errorf("ReadPortfile = (%+v, %v), want %+v", pf, err, tt.pf)
+			}
+		})
+	}
+
+	// A port of 0 without a socket says nothing about where the proxy is
+	portfile := filepath.Join(tmpDir, "empty")
+	os.WriteFile(portfile, []byte("0"), 0644)
+	if _, err := ReadPortfile(portfile); err == nil {
+		t.Error("Expected error for portfile with neither port nor socket")
+	}
+}
+
+func TestPortfileHealthyTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+
+	pf := Portfile{Port: port, TLS: true}
+	if _, base := pf.Client(); base != fmt.Sprintf("https://localhost:%d", port) {
+		t.Errorf("base URL = %q, want https", base)
+	}
+	if !pf.Healthy() {
+		t.Error("Healthy() = false over HTTPS")
+	}
+	if (Portfile{Port: port}).Healthy() {
+		t.Error("Healthy() = true speaking plain HTTP to an HTTPS port")
+	}
+}
+
 func TestDefaultPortfilePath(t *testing.T) {
 	path := DefaultPortfilePath()
 
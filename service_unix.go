@@ -0,0 +1,76 @@
+//go:build unix
+
+// service_unix.go
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// listenUnix listens on a Unix socket at path that only the current user can
+// connect to. A socket left behind by a proxy that is no longer running is
+// replaced; one that still accepts connections is an error.
+//
+// The socket is created in a private temporary directory next to path and
+// renamed into place once it is 0600, since path's directory may already
+// exist with wider permissions (WritePortfile creates it 0755).
+func listenUnix(path string) (net.Listener, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another proxy", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp(dir, ".llm-proxy-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmpPath := filepath.Join(tmpDir, filepath.Base(path))
+	ln, err := net.Listen("unix", tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	// Only the 0700 temporary directory keeps other users out until the
+	// chmod; the socket moves to path once it is private
+	ul := ln.(*net.UnixListener)
+	ul.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &unixListener{UnixListener: ul, path: path}, nil
+}
+
+// unixListener removes its socket on Close. net.UnixListener would only
+// remove the temporary path it was created at.
+type unixListener struct {
+	*net.UnixListener
+	path   string
+	unlink sync.Once
+}
+
+func (l *unixListener) Close() error {
+	err := l.UnixListener.Close()
+	l.unlink.Do(func() { os.Remove(l.path) })
+	return err
+}
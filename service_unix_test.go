@@ -0,0 +1,79 @@
+//go:build unix
+
+// service_unix_test.go
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "proxy.sock")
+
+	ln, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("listenUnix: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v (err %v), want 0600", info.Mode().Perm(), err)
+	}
+
+	// Serving: health checks through the socket work, and a second proxy
+	// can't take the socket over
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})}
+	go srv.Serve(ln)
+	if !(Portfile{Socket: path}).Healthy() {
+		t.Error("Healthy() = false through the socket")
+	}
+	if _, err := listenUnix(path); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("second listenUnix error = %v, want in use", err)
+	}
+	srv.Close()
+
+	// A socket left behind by a crashed proxy is replaced
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = listenUnix(path)
+	if err != nil {
+		t.Fatalf("listenUnix over stale socket: %v", err)
+	}
+	ln.Close()
+
+	// A directory others can read still never exposes the socket with wider
+	// permissions, leaves no temporary directory behind, and loses the
+	// socket when the listener closes
+	shared := t.TempDir()
+	os.Chmod(shared, 0755)
+	path = filepath.Join(shared, "proxy.sock")
+	ln, err = listenUnix(path)
+	if err != nil {
+		t.Fatalf("listenUnix in a 0755 directory: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("socket mode = %v (err %v), want 0600", info.Mode().Perm(), err)
+	}
+	if entries, _ := os.ReadDir(shared); len(entries) != 1 {
+		t.Errorf("directory has %d entries, want just the socket", len(entries))
+	}
+	ln.Close()
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("socket still exists after Close (err %v)", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "not-a-socket")
+	os.WriteFile(file, nil, 0600)
+	if _, err := listenUnix(file); err == nil {
+		t.Error("Expected error for a path that isn't a socket")
+	}
+}
@@ -14,6 +14,9 @@ type SessionManager struct {
 	db      *SessionDB
 	logger  *Logger // For logging fork events
 	mu      sync.Mutex
+
+	// providers resolves a provider's session ID rules and dialect
+	providers *providerRegistry
 }
 
 func NewSessionManager(baseDir string, logger *Logger) (*SessionManager, error) {
@@ -25,9 +28,10 @@ func NewSessionManager(baseDir string, logger *Logger) (*SessionManager, error)
 	}
 
 	return &SessionManager{
-		baseDir: baseDir,
-		db:      db,
-		logger:  logger,
+		baseDir:   baseDir,
+		db:        db,
+		logger:    logger,
+		providers: builtinRegistry,
 	}, nil
 }
 
@@ -42,7 +46,7 @@ func (sm *SessionManager) GetOrCreateSession(body []byte, provider, upstream str
 	defer sm.mu.Unlock()
 
 	// Check if the client provided a session ID (e.g., Claude Code via metadata.user_id)
-	clientSessionID := ExtractClientSessionID(body, provider, headers, path)
+	clientSessionID := sm.providers.lookup(provider).clientSessionID(body, headers, path)
 	if clientSessionID != "" {
 		return sm.getOrCreateByClientSessionID(clientSessionID, provider, upstream)
 	}
@@ -150,6 +154,13 @@ func (sm *SessionManager) UpdatePatternState(sessionID string, state *PatternSta
 	return sm.db.UpdatePatternState(sessionID, state)
 }
 
+// AddUsage adds a response's usage and cost to the session's totals.
+func (sm *SessionManager) AddUsage(sessionID string, usage UsageInfo, costUSD float64) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.db.AddSessionUsage(sessionID, usage, costUSD)
+}
+
 // ComputePatterns updates pattern state based on response data.
 // firstToolName is the first tool_use in the response (empty if no tools).
 // Returns isRetry for use in turn_end event.
@@ -3,7 +3,6 @@ package main
 
 import (
 	"fmt"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -170,13 +169,20 @@ func Uninstall() error {
 // 1. Installs systemd user service
 // 2. Enables and starts the service
 // 3. Patches shell RC files
-func FullSetup() error {
+//
+// It also creates the forward-proxy CA, limited to caHosts.
+func FullSetup(caHosts []string) error {
 	// Find our binary path
 	binaryPath, err := os.Executable()
 	if err != nil {
 		return err
 	}
 
+	// Create the local CA for forward-proxy mode
+	if _, err := ensureLocalCA(DefaultCADir(), caHosts); err != nil {
+		return fmt.Errorf("failed to create local CA: %w", err)
+	}
+
 	// Install systemd service
 	if err := InstallSystemdService(binaryPath); err != nil {
 		return fmt.Errorf("failed to install service: %w", err)
@@ -205,7 +211,7 @@ func FullSetup() error {
 // It checks if the proxy is running by reading the portfile and making a health check.
 func Status() {
 	portfile := DefaultPortfilePath()
-	port, err := ReadPortfile(portfile)
+	pf, err := ReadPortfile(portfile)
 	if err != nil {
 		fmt.Println("Status: NOT RUNNING")
 		fmt.Printf("Portfile: %s (not found)\n", portfile)
@@ -213,22 +219,27 @@ func Status() {
 	}
 
 	// Check if actually responding
-	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/health", port))
-	if err != nil || resp.StatusCode != 200 {
-		if resp != nil {
-			resp.Body.Close()
-		}
+	if !pf.Healthy() {
 		fmt.Println("Status: NOT RUNNING (stale portfile)")
-		fmt.Printf("Port: %d\n", port)
+		printListeners(pf)
 		return
 	}
-	resp.Body.Close()
 
 	home, _ := os.UserHomeDir()
 	logDir := filepath.Join(home, ".llm-provider-logs")
 
 	fmt.Println("Status: RUNNING")
-	fmt.Printf("Port: %d\n", port)
+	printListeners(pf)
 	fmt.Printf("Logs: %s\n", logDir)
 	fmt.Printf("Portfile: %s\n", portfile)
 }
+
+// printListeners prints the port and socket recorded in a portfile.
+func printListeners(pf Portfile) {
+	if pf.Port != 0 {
+		fmt.Printf("Port: %d\n", pf.Port)
+	}
+	if pf.Socket != "" {
+		fmt.Printf("Socket: %s\n", pf.Socket)
+	}
+}
@@ -21,10 +21,10 @@ func isStreamingRequest(body []byte) bool {
 	return req.Stream
 }
 
-// isStreamingResponse checks if the response is SSE
+// isStreamingResponse checks if the response is SSE or an NDJSON stream (Ollama)
 func isStreamingResponse(resp *http.Response) bool {
 	contentType := resp.Header.Get("Content-Type")
-	return strings.HasPrefix(contentType, "text/event-stream")
+	return strings.HasPrefix(contentType, "text/event-stream") || isNDJSONResponse(resp)
 }
 
 // StreamingResponseWriter wraps http.ResponseWriter to capture chunks and accumulate text
@@ -34,17 +34,17 @@ type StreamingResponseWriter struct {
 	startTime       time.Time
 	lastChunk       time.Time
 	accumulatedText strings.Builder
-	provider        string
+	dialect         string
 }
 
-func NewStreamingResponseWriter(w http.ResponseWriter, provider string) *StreamingResponseWriter {
+func NewStreamingResponseWriter(w http.ResponseWriter, dialect string) *StreamingResponseWriter {
 	now := time.Now()
 	return &StreamingResponseWriter{
 		ResponseWriter: w,
 		chunks:         make([]StreamChunk, 0),
 		startTime:      now,
 		lastChunk:      now,
-		provider:       provider,
+		dialect:        dialect,
 	}
 }
 
@@ -60,7 +60,7 @@ func (s *StreamingResponseWriter) Write(data []byte) (int, error) {
 	s.lastChunk = now
 
 	// Extract and accumulate text deltas for fingerprinting
-	if text := extractDeltaText(data, s.provider); text != "" {
+	if text := extractDeltaText(data, s.dialect); text != "" {
 		s.accumulatedText.WriteString(text)
 	}
 
@@ -81,8 +81,13 @@ func (s *StreamingResponseWriter) AccumulatedText() string {
 	return s.accumulatedText.String()
 }
 
-// extractDeltaText extracts text content from SSE delta events (provider-aware)
-func extractDeltaText(data []byte, provider string) string {
+// extractDeltaText extracts text content from SSE delta events (dialect-aware)
+func extractDeltaText(data []byte, dialect string) string {
+	// Ollama: NDJSON lines, no SSE framing
+	if dialect == dialectOllama {
+		return extractOllamaDeltaText(data)
+	}
+
 	line := string(data)
 
 	// SSE format: "data: {...}\n"
@@ -102,7 +107,7 @@ func extractDeltaText(data []byte, provider string) string {
 		return ""
 	}
 
-	if provider == "anthropic" {
+	if dialect == dialectAnthropic {
 		// Anthropic: {"type":"content_block_delta","delta":{"type":"text_delta","text":"..."}}
 		if event["type"] != "content_block_delta" {
 			return ""
@@ -112,7 +117,17 @@ func extractDeltaText(data []byte, provider string) string {
 				return text
 			}
 		}
-	} else if provider == "openai" {
+	} else if dialect == dialectGemini {
+		// Gemini: {"candidates":[{"content":{"parts":[{"text":"..."}]}}]}
+		return extractGeminiDeltaText(event)
+	} else if dialect == dialectOpenAIResponses {
+		// Responses API: {"type":"response.output_text.delta","delta":"..."}
+		if event["type"] == "response.output_text.delta" {
+			if text, ok := event["delta"].(string); ok {
+				return text
+			}
+		}
+	} else if isOpenAIDialect(dialect) {
 		// OpenAI: {"choices":[{"delta":{"content":"..."}}]}
 		if choices, ok := event["choices"].([]interface{}); ok && len(choices) > 0 {
 			if choice, ok := choices[0].(map[string]interface{}); ok {
@@ -128,15 +143,19 @@ func extractDeltaText(data []byte, provider string) string {
 	return ""
 }
 
-// streamResponse handles streaming responses from upstream
-func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogger, sm *SessionManager, sessionID, provider string, seq int, startTime time.Time, reqBody []byte, requestID string, emitter AgentEventEmitter, machineID string, patternState *PatternState) error {
-	sw := NewStreamingResponseWriter(w, provider)
+// streamResponse handles streaming responses from upstream. Returns the
+// chunks streamed, with an error if the stream broke off; usage in the
+// chunks of a broken stream was still spent upstream, so they are logged
+// and emitted like a complete stream's, with the error as _meta.stream_error.
+func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogger, sm *SessionManager, sessionID, provider, dialect string, seq int, startTime time.Time, reqBody []byte, requestID string, emitter AgentEventEmitter, machineID string, patternState *PatternState) ([]StreamChunk, error) {
+	sw := NewStreamingResponseWriter(w, dialect)
 
 	// Copy headers
 	copyHeaders(w.Header(), resp.Header)
 	w.WriteHeader(resp.StatusCode)
 
 	// Stream the response
+	var streamErr error
 	reader := bufio.NewReader(resp.Body)
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -145,12 +164,15 @@ func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogg
 			sw.Flush()
 		}
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err != io.EOF {
+				streamErr = err
 			}
-			return err
+			break
 		}
 	}
+	if rec, ok := logger.(requestMetaRecorder); ok && streamErr != nil && requestID != "" {
+		rec.SetRequestMeta(requestID, "stream_error", streamErr.Error())
+	}
 
 	// Log the complete streaming response
 	if logger != nil {
@@ -171,10 +193,10 @@ func streamResponse(w http.ResponseWriter, resp *http.Response, logger ProxyLogg
 		parsed := ParseStreamingResponse(sw.chunks)
 
 		// Use shared event emission logic
-		emitResponseEvents(emitter, sm, sessionID, provider, machineID, patternState, parsed.Content, parsed.Usage, parsed.StopReason, resp.StatusCode, "")
+		emitResponseEvents(emitter, sm, sessionID, provider, machineID, patternState, parsed.Content, parsed.Usage, usageCostOf(logger, requestID, parsed.Usage), parsed.StopReason, resp.StatusCode, "")
 	}
 
-	return nil
+	return sw.chunks, streamErr
 }
 
 // escapeJSON escapes special characters for JSON string embedding
@@ -201,7 +201,7 @@ func TestExtractDeltaTextOpenAI(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := extractDeltaText([]byte(tt.data), "openai")
+			result := extractDeltaText([]byte(tt.data), dialectOpenAIChat)
 			if result != tt.expected {
 				t.Errorf("extractDeltaText(%q, openai) = %q, want %q", tt.data, result, tt.expected)
 			}
@@ -0,0 +1,100 @@
+// tls.go
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync/atomic"
+)
+
+// TLS listener.
+//
+// The certificate, key and client CA bundle are read at startup and again on
+// SIGHUP, so renewed certificates are picked up without a restart. A reload
+// that fails keeps serving the previous files. With client_ca_file set every
+// connection must present a certificate signed by that CA, and the
+// certificate's subject CN becomes the caller's identity.
+
+// tlsReloader serves the most recently loaded TLS settings.
+type tlsReloader struct {
+	cfg     TLSConfig
+	current atomic.Pointer[tls.Config]
+}
+
+// newTLSReloader loads cfg's files. Returns nil if TLS is not configured.
+func newTLSReloader(cfg TLSConfig) (*tlsReloader, error) {
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		if cfg.ClientCAFile != "" {
+			return nil, fmt.Errorf("tls: client_ca_file requires cert_file and key_file")
+		}
+		return nil, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("tls: cert_file and key_file must both be set")
+	}
+
+	r := &tlsReloader{cfg: cfg}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload re-reads the certificate, key and client CA files. On error the
+// previously loaded settings stay in use.
+func (r *tlsReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	conf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if r.cfg.ClientCAFile != "" {
+		pem, err := os.ReadFile(r.cfg.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("tls: no certificates in %s", r.cfg.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	r.current.Store(conf)
+	return nil
+}
+
+// config returns the listener's TLS config. Each handshake uses the settings
+// loaded most recently.
+func (r *tlsReloader) config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// clientCertIdentity returns the subject CN of r's verified client
+// certificate, or "" if the connection has none. A CN that isn't a valid
+// identity is an error, since it ends up in log metadata and Loki labels.
+func clientCertIdentity(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", nil
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	if !validIdentity.MatchString(cn) {
+		return "", fmt.Errorf("client certificate CN %q is not a valid identity", cn)
+	}
+	return cn, nil
+}
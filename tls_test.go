@@ -0,0 +1,253 @@
+// tls_test.go
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate and its key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate for cn, signed by parent or self-signed
+// if parent is nil.
+func newTestCert(t *testing.T, cn string, serial int64, isCA bool, parent *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if isCA {
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+// write stores the certificate and key as PEM files in dir.
+func (c *testCert) write(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey: %v", err)
+	}
+	certFile, keyFile = filepath.Join(dir, name+".pem"), filepath.Join(dir, name+"-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return certFile, keyFile
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+func TestNewTLSReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "proxy", 1, false, nil).write(t, dir, "server")
+
+	tests := []struct {
+		name    string
+		cfg     TLSConfig
+		wantNil bool
+		wantErr bool
+	}{
+		{"disabled", TLSConfig{}, true, false},
+		{"cert and key", TLSConfig{CertFile: certFile, KeyFile: keyFile}, false, false},
+		{"missing key", TLSConfig{CertFile: certFile}, true, true},
+		{"client CA without cert", TLSConfig{ClientCAFile: certFile}, true, true},
+		{"unreadable cert", TLSConfig{CertFile: filepath.Join(dir, "nope.pem"), KeyFile: keyFile}, true, true},
+		{"client CA not PEM", TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: keyFile}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newTLSReloader(tt.cfg)
+			if (err != nil) != tt.wantErr || (r == nil) != tt.wantNil {
+				t.Errorf("newTLSReloader() = (%v, %v), wantNil %v wantErr %v", r, err, tt.wantNil, tt.wantErr)
+			}
+		})
+	}
+}
+
+// serveTLS serves handler over TLS with r's config and returns its address.
+func serveTLS(t *testing.T, r *tlsReloader, handler http.Handler) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	// Rejected handshakes are expected; keep them off the shared logger
+	srv := &http.Server{Handler: handler, ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(tls.NewListener(ln, r.config()))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestTLSReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "proxy", 1, false, nil).write(t, dir, "server")
+	r, err := newTLSReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("newTLSReloader: %v", err)
+	}
+	addr := serveTLS(t, r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	serial := func() int64 {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+	}
+	if got := serial(); got != 1 {
+		t.Fatalf("serial = %d, want 1", got)
+	}
+
+	// A renewed certificate is served after reload
+	newTestCert(t, "proxy", 2, false, nil).write(t, dir, "server")
+	if err := r.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if got := serial(); got != 2 {
+		t.Errorf("serial after reload = %d, want 2", got)
+	}
+
+	// A broken file keeps the previous certificate
+	os.WriteFile(certFile, []byte("garbage"), 0644)
+	if err := r.reload(); err == nil {
+		t.Error("expected error reloading a broken certificate")
+	}
+	if got := serial(); got != 2 {
+		t.Errorf("serial after failed reload = %d, want 2", got)
+	}
+}
+
+func TestServer_ClientCertIdentity(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", 1, true, nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "proxy", 2, false, ca).write(t, dir, "server")
+	client := newTestCert(t, "build-bot", 3, false, ca)
+	stranger := newTestCert(t, "stranger", 4, false, nil)
+	badCN := newTestCert(t, "build bot {env=\"prod\"}", 5, false, ca)
+
+	r, err := newTLSReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("newTLSReloader: %v", err)
+	}
+	addr := serveTLS(t, r, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := clientCertIdentity(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(identity))
+	}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(certs ...tls.Certificate) (string, int, error) {
+		c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+		resp, err := c.Get("https://" + addr + "/health")
+		if err != nil {
+			return "", 0, err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.StatusCode, nil
+	}
+
+	if identity, status, err := get(client.tlsCertificate()); err != nil || status != http.StatusOK || identity != "build-bot" {
+		t.Errorf("identity = (%q, %d, %v), want build-bot", identity, status, err)
+	}
+	if _, status, err := get(badCN.tlsCertificate()); err != nil || status != http.StatusForbidden {
+		t.Errorf("CN with label characters: status = (%d, %v), want 403", status, err)
+	}
+	if _, _, err := get(); err == nil {
+		t.Error("expected handshake failure without a client certificate")
+	}
+	if _, _, err := get(stranger.tlsCertificate()); err == nil {
+		t.Error("expected handshake failure with a certificate from another CA")
+	}
+}
+
+func TestServer_ClientCertSatisfiesProxyAuth(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test-ca", 1, true, nil)
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newTestCert(t, "proxy", 2, false, ca).write(t, dir, "server")
+
+	srv, err := NewServer(Config{
+		Port:   8080,
+		LogDir: t.TempDir(),
+		ProxyAuth: ProxyAuthConfig{
+			Enabled: true,
+			Clients: []ProxyAuthClient{{Identity: "ci-runner", Token: "ci-token-0123456789"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer srv.Close()
+
+	r, err := newTLSReloader(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("newTLSReloader: %v", err)
+	}
+	addr := serveTLS(t, r, srv)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{newTestCert(t, "build-bot", 3, false, ca).tlsCertificate()},
+	}}}
+	resp, err := c.Get("https://" + addr + "/health")
+	if err != nil {
+		t.Fatalf("GET /health: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "ok") {
+		t.Errorf("status = %d, body %q; want 200 without proxy credentials", resp.StatusCode, body)
+	}
+}
@@ -3,41 +3,62 @@ package main
 
 import (
 	"errors"
+	"regexp"
 	"strings"
 )
 
 var (
 	ErrInvalidProxyPath = errors.New("invalid proxy path: expected /{provider}/{upstream}/{path}")
-	ErrUnknownProvider  = errors.New("unknown provider: must be 'anthropic' or 'openai'")
+	ErrUnknownProvider  = errors.New("unknown provider: must be a built-in provider (anthropic, openai, gemini, azure, ollama) or defined in [[providers]]")
 )
 
-var validProviders = map[string]bool{
-	"anthropic": true,
-	"openai":    true,
-}
-
-// ParseProxyURL extracts provider, upstream host, and remaining path from a proxy URL.
-// Expected format: /{provider}/{upstream}/{remaining_path}
-func ParseProxyURL(urlPath string) (provider, upstream, path string, err error) {
+// parseProxyURL extracts provider, upstream host, and remaining path from a proxy URL.
+// Expected format: /{provider}/{upstream}/{remaining_path}. Providers with a
+// default upstream also accept /{provider}/{remaining_path} when the second
+// segment doesn't look like a host.
+func (reg *providerRegistry) parseProxyURL(urlPath string) (provider, upstream, path string, err error) {
 	// Remove leading slash and split
 	trimmed := strings.TrimPrefix(urlPath, "/")
 	parts := strings.SplitN(trimmed, "/", 3)
 
-	if len(parts) < 3 {
+	if len(parts) < 2 {
 		return "", "", "", ErrInvalidProxyPath
 	}
 
 	provider = parts[0]
-	upstream = parts[1]
-	path = "/" + parts[2]
-
-	if !validProviders[provider] {
+	spec := reg.lookup(provider)
+	if spec == nil {
+		if len(parts) < 3 {
+			return "", "", "", ErrInvalidProxyPath
+		}
 		return "", "", "", ErrUnknownProvider
 	}
 
+	if spec.Upstream != "" && parts[1] != "" && !looksLikeHost(parts[1]) {
+		return provider, spec.Upstream, "/" + strings.Join(parts[1:], "/"), nil
+	}
+
+	if len(parts) < 3 {
+		return "", "", "", ErrInvalidProxyPath
+	}
+	upstream = parts[1]
+	path = "/" + parts[2]
+
 	if upstream == "" {
 		return "", "", "", ErrInvalidProxyPath
 	}
 
 	return provider, upstream, path, nil
 }
+
+// hostSegment matches host[:port] path segments.
+var hostSegment = regexp.MustCompile(`^[A-Za-z0-9.-]+(:[0-9]+)?$`)
+
+// looksLikeHost returns true if a path segment is a host name rather than the
+// start of an API path: a dotted name or localhost, with an optional port.
+func looksLikeHost(segment string) bool {
+	if !hostSegment.MatchString(segment) {
+		return false
+	}
+	return strings.Contains(segment, ".") || isLocalhost(segment)
+}
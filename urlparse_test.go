@@ -35,6 +35,46 @@ func TestParseProxyURL(t *testing.T) {
 			wantUp:   "api.anthropic.com",
 			wantPath: "/v1/messages/count_tokens",
 		},
+		{
+			name:     "gemini generate content",
+			path:     "/gemini/generativelanguage.googleapis.com/v1beta/models/gemini-2.5-pro:streamGenerateContent",
+			wantProv: "gemini",
+			wantUp:   "generativelanguage.googleapis.com",
+			wantPath: "/v1beta/models/gemini-2.5-pro:streamGenerateContent",
+		},
+		{
+			name:     "azure deployment",
+			path:     "/azure/myres.openai.azure.com/openai/deployments/gpt-4o/chat/completions",
+			wantProv: "azure",
+			wantUp:   "myres.openai.azure.com",
+			wantPath: "/openai/deployments/gpt-4o/chat/completions",
+		},
+		{
+			name:     "default upstream",
+			path:     "/anthropic/v1/messages",
+			wantProv: "anthropic",
+			wantUp:   "api.anthropic.com",
+			wantPath: "/v1/messages",
+		},
+		{
+			name:     "localhost upstream",
+			path:     "/openai/localhost:8080/v1/chat/completions",
+			wantProv: "openai",
+			wantUp:   "localhost:8080",
+			wantPath: "/v1/chat/completions",
+		},
+		{
+			name:     "default upstream with colon in path",
+			path:     "/gemini/v1internal:streamGenerateContent",
+			wantProv: "gemini",
+			wantUp:   "generativelanguage.googleapis.com",
+			wantPath: "/v1internal:streamGenerateContent",
+		},
+		{
+			name:    "unknown provider",
+			path:    "/cohere/api.cohere.com/v1/chat",
+			wantErr: true,
+		},
 		{
 			name:    "missing provider",
 			path:    "/api.anthropic.com/v1/messages",
@@ -61,7 +101,7 @@ func TestParseProxyURL(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			prov, upstream, path, err := ParseProxyURL(tt.path)
+			prov, upstream, path, err := builtinRegistry.parseProxyURL(tt.path)
 
 			if tt.wantErr {
 				if err == nil {
@@ -0,0 +1,475 @@
+// vault.go
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credential vault.
+//
+// In vault mode clients never hold provider keys. They authenticate with
+// short-lived proxy tokens (llmp-{id}-{secret}) sent in the provider's usual
+// auth header, and the proxy swaps in the real upstream credential from the
+// vault file. Tokens belong to a principal (user:alice, team:ml), may be
+// limited to some providers, and are issued and revoked with --vault-*
+// commands. The vault file holds plaintext keys, so it must be 0600; only
+// token hashes are stored. A running proxy reloads the file when it changes,
+// so revocations apply to the next request.
+
+// vaultTokenPrefix starts every proxy-issued token.
+const vaultTokenPrefix = "llmp-"
+
+// vaultDefaultTTL is the lifetime of a token issued without --vault-ttl.
+const vaultDefaultTTL = 12 * time.Hour
+
+// validPrincipal restricts principals to user:{name} or team:{name}.
+var validPrincipal = regexp.MustCompile(`^(user|team):[A-Za-z0-9._@-]+$`)
+
+// validTokenID matches the public part of a token.
+var validTokenID = regexp.MustCompile(`^[0-9a-f]{8}$`)
+
+var (
+	errVaultNoToken      = errors.New("vault: missing proxy token")
+	errVaultInvalidToken = errors.New("vault: invalid proxy token")
+	errVaultExpiredToken = errors.New("vault: proxy token expired")
+	errVaultRevokedToken = errors.New("vault: proxy token revoked")
+)
+
+// vaultCredential is a real upstream credential.
+type vaultCredential struct {
+	Provider string `json:"provider"`
+	Upstream string `json:"upstream,omitempty"` // default: the provider's upstream
+	Header   string `json:"header,omitempty"`   // default: the provider's first auth header
+	Key      string `json:"key"`
+}
+
+// vaultToken is an issued proxy token. Only the token's hash is stored.
+type vaultToken struct {
+	ID        string     `json:"id"`
+	Principal string     `json:"principal"`
+	Providers []string   `json:"providers,omitempty"` // empty = all providers
+	Hash      string     `json:"hash"`                // hex SHA-256 of the full token
+	Created   time.Time  `json:"created"`
+	Expires   time.Time  `json:"expires"`
+	Revoked   *time.Time `json:"revoked,omitempty"`
+}
+
+// allows reports whether the token may be used with provider.
+func (t *vaultToken) allows(provider string) bool {
+	if len(t.Providers) == 0 {
+		return true
+	}
+	for _, p := range t.Providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// vaultFile is the on-disk vault.
+type vaultFile struct {
+	Credentials []vaultCredential `json:"credentials"`
+	Tokens      []vaultToken      `json:"tokens"`
+}
+
+// defaultVaultPath returns ~/.config/llm-proxy/vault.json.
+func defaultVaultPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".config", "llm-proxy", "vault.json")
+}
+
+// loadVaultFile reads the vault at path. A missing file is an empty vault;
+// a file readable or writable by group or others is refused.
+func loadVaultFile(path string) (*vaultFile, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return &vaultFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		return nil, fmt.Errorf("vault %s has mode %04o; run chmod 600 %s", path, perm, path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var vf vaultFile
+	if err := json.Unmarshal(data, &vf); err != nil {
+		return nil, fmt.Errorf("vault %s: %w", path, err)
+	}
+	return &vf, nil
+}
+
+// save writes the vault to path with mode 0600, replacing it atomically.
+func (vf *vaultFile) save(path string) error {
+	data, err := json.MarshalIndent(vf, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".vault-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// issue adds a token for principal that expires after ttl and returns it.
+// The token itself is not stored and can't be recovered.
+func (vf *vaultFile) issue(reg *providerRegistry, principal string, providers []string, ttl time.Duration, now time.Time) (string, *vaultToken, error) {
+	if !validPrincipal.MatchString(principal) {
+		return "", nil, fmt.Errorf("principal %q must be user:{name} or team:{name}", principal)
+	}
+	if ttl <= 0 {
+		return "", nil, fmt.Errorf("ttl must be positive")
+	}
+	for _, provider := range providers {
+		if reg.lookup(provider) == nil {
+			return "", nil, fmt.Errorf("unknown provider %q", provider)
+		}
+	}
+
+	// Unlike randomHex, never fall back to predictable bytes
+	b := make([]byte, 36)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	id := hex.EncodeToString(b[:4])
+	token := vaultTokenPrefix + id + "-" + hex.EncodeToString(b[4:])
+	hash := sha256.Sum256([]byte(token))
+	vf.Tokens = append(vf.Tokens, vaultToken{
+		ID:        id,
+		Principal: principal,
+		Providers: providers,
+		Hash:      hex.EncodeToString(hash[:]),
+		Created:   now.UTC(),
+		Expires:   now.Add(ttl).UTC(),
+	})
+	return token, &vf.Tokens[len(vf.Tokens)-1], nil
+}
+
+// revoke marks the token with the given ID, or every token of a principal,
+// as revoked. Returns the number of tokens revoked.
+func (vf *vaultFile) revoke(idOrPrincipal string, now time.Time) int {
+	revokedAt := now.UTC()
+	n := 0
+	for i := range vf.Tokens {
+		t := &vf.Tokens[i]
+		if t.Revoked != nil || (t.ID != idOrPrincipal && t.Principal != idOrPrincipal) {
+			continue
+		}
+		t.Revoked = &revokedAt
+		n++
+	}
+	return n
+}
+
+// setCredential stores key for provider and upstream, replacing any
+// existing credential for them.
+func (vf *vaultFile) setCredential(reg *providerRegistry, provider, upstream, key string) error {
+	if reg.lookup(provider) == nil {
+		return fmt.Errorf("unknown provider %q", provider)
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return fmt.Errorf("empty key")
+	}
+	cred := vaultCredential{Provider: provider, Upstream: upstream, Key: key}
+	for i, c := range vf.Credentials {
+		if c.Provider == provider && c.Upstream == upstream {
+			vf.Credentials[i] = cred
+			return nil
+		}
+	}
+	vf.Credentials = append(vf.Credentials, cred)
+	return nil
+}
+
+// vaultSnapshot is a loaded vault ready for authentication.
+type vaultSnapshot struct {
+	tokens map[string]*vaultToken // by ID
+	pools  keyPools               // one pool per provider upstream
+}
+
+func newVaultSnapshot(vf *vaultFile, providers *providerRegistry) (*vaultSnapshot, error) {
+	snap := &vaultSnapshot{tokens: make(map[string]*vaultToken)}
+	for i := range vf.Tokens {
+		snap.tokens[vf.Tokens[i].ID] = &vf.Tokens[i]
+	}
+
+	// Credentials for the same upstream share a pool, whether the upstream
+	// was given or left to the provider's default
+	var cfgs []KeyPoolConfig
+	index := make(map[string]int)
+	for _, c := range vf.Credentials {
+		upstream := c.Upstream
+		if spec := providers.lookup(c.Provider); spec != nil && upstream == "" {
+			upstream = spec.Upstream
+		}
+		id := keyPoolID(c.Provider, upstream)
+		if i, ok := index[id]; ok {
+			cfgs[i].Keys = append(cfgs[i].Keys, c.Key)
+			continue
+		}
+		index[id] = len(cfgs)
+		cfgs = append(cfgs, KeyPoolConfig{Provider: c.Provider, Upstream: upstream, Header: c.Header, Keys: []string{c.Key}})
+	}
+	pools, err := newKeyPools(cfgs, providers)
+	if err != nil {
+		return nil, err
+	}
+	snap.pools = pools
+	return snap, nil
+}
+
+// vaultState is the vault as seen by a running proxy.
+type vaultState struct {
+	path      string
+	providers *providerRegistry
+
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	snap    *vaultSnapshot
+}
+
+// newVaultState loads the vault. Returns nil if vault mode is disabled.
+func newVaultState(cfg VaultConfig, providers *providerRegistry) (*vaultState, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	v := &vaultState{path: cfg.Path, providers: providers}
+	if v.path == "" {
+		v.path = defaultVaultPath()
+	}
+	if err := v.reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// reload reads the vault file if it changed since the last load. On error
+// the previous snapshot stays in use.
+func (v *vaultState) reload() error {
+	info, err := os.Stat(v.path)
+	var modTime time.Time
+	var size int64
+	if err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.snap != nil && modTime.Equal(v.modTime) && size == v.size {
+		return nil
+	}
+
+	vf, err := loadVaultFile(v.path)
+	if err != nil {
+		return err
+	}
+	snap, err := newVaultSnapshot(vf, v.providers)
+	if err != nil {
+		return fmt.Errorf("vault %s: %w", v.path, err)
+	}
+	v.snap, v.modTime, v.size = snap, modTime, size
+	return nil
+}
+
+// current returns the latest snapshot, reloading the file if it changed.
+func (v *vaultState) current() *vaultSnapshot {
+	if err := v.reload(); err != nil {
+		log.Printf("WARNING: vault reload failed, keeping previous state: %v", err)
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.snap
+}
+
+// vaultTokenFrom returns the proxy token in the provider's auth headers.
+func vaultTokenFrom(header http.Header, spec *providerSpec) string {
+	for _, name := range spec.AuthHeaders {
+		for _, value := range header.Values(name) {
+			value = strings.TrimPrefix(value, "Bearer ")
+			if strings.HasPrefix(value, vaultTokenPrefix) {
+				return value
+			}
+		}
+	}
+	return ""
+}
+
+// authenticate checks the proxy token on a request to upstream via spec and
+// returns its principal and the pool holding the upstream credential (nil
+// if the vault has none). The returned status is 401 for a bad token and
+// 403 for a token not allowed to use the provider.
+func (v *vaultState) authenticate(header http.Header, spec *providerSpec, upstream string, now time.Time) (string, *keyPool, int, error) {
+	token := vaultTokenFrom(header, spec)
+	if token == "" {
+		return "", nil, http.StatusUnauthorized, errVaultNoToken
+	}
+
+	// llmp-{id}-{secret}
+	id, _, _ := strings.Cut(strings.TrimPrefix(token, vaultTokenPrefix), "-")
+	snap := v.current()
+	t, ok := snap.tokens[id]
+	if !validTokenID.MatchString(id) || !ok {
+		return "", nil, http.StatusUnauthorized, errVaultInvalidToken
+	}
+	hash := sha256.Sum256([]byte(token))
+	if subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(t.Hash)) != 1 {
+		return "", nil, http.StatusUnauthorized, errVaultInvalidToken
+	}
+	if t.Revoked != nil {
+		return "", nil, http.StatusUnauthorized, errVaultRevokedToken
+	}
+	if !now.Before(t.Expires) {
+		return "", nil, http.StatusUnauthorized, errVaultExpiredToken
+	}
+	if !t.allows(spec.Name) {
+		return "", nil, http.StatusForbidden, fmt.Errorf("vault: %s may not use provider %s", t.Principal, spec.Name)
+	}
+	return t.Principal, snap.pools.forUpstream(spec.Name, upstream), http.StatusOK, nil
+}
+
+// VaultCommand is a --vault-* CLI operation.
+type VaultCommand struct {
+	Issue     string        // principal to issue a token for
+	Providers string        // comma-separated providers the token may use
+	TTL       time.Duration // token lifetime
+	Revoke    string        // token ID or principal to revoke
+	List      bool          // list tokens
+	SetKey    string        // provider to store a credential for, read from stdin
+	Upstream  string        // upstream for SetKey (default: the provider's)
+}
+
+// isSet reports whether any vault command was requested.
+func (c VaultCommand) isSet() bool {
+	return c.Issue != "" || c.Revoke != "" || c.List || c.SetKey != ""
+}
+
+// runVaultCommand performs cmd on the vault at path, reading a credential
+// from stdin and writing output to out. Provider names are checked against
+// reg. An issued token is the only thing written to out, so it can be
+// captured by scripts.
+func runVaultCommand(cmd VaultCommand, path string, reg *providerRegistry, stdin io.Reader, out io.Writer, now time.Time) error {
+	if path == "" {
+		path = defaultVaultPath()
+	}
+	vf, err := loadVaultFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case cmd.Issue != "":
+		var providers []string
+		if cmd.Providers != "" {
+			providers = strings.Split(cmd.Providers, ",")
+		}
+		ttl := cmd.TTL
+		if ttl == 0 {
+			ttl = vaultDefaultTTL
+		}
+		token, t, err := vf.issue(reg, cmd.Issue, providers, ttl, now)
+		if err != nil {
+			return err
+		}
+		if err := vf.save(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s\n", token)
+		fmt.Fprintf(os.Stderr, "Issued token %s for %s, expires %s\n", t.ID, t.Principal, t.Expires.Format(time.RFC3339))
+		return nil
+
+	case cmd.Revoke != "":
+		n := vf.revoke(cmd.Revoke, now)
+		if n == 0 {
+			return fmt.Errorf("no active token matches %q", cmd.Revoke)
+		}
+		if err := vf.save(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Revoked %d token(s)\n", n)
+		return nil
+
+	case cmd.List:
+		tokens := append([]vaultToken{}, vf.Tokens...)
+		sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+		for _, t := range tokens {
+			status := "active"
+			switch {
+			case t.Revoked != nil:
+				status = "revoked"
+			case !now.Before(t.Expires):
+				status = "expired"
+			}
+			providers := "all"
+			if len(t.Providers) > 0 {
+				providers = strings.Join(t.Providers, ",")
+			}
+			fmt.Fprintf(out, "%s  %-24s %-8s providers=%s expires=%s\n", t.ID, t.Principal, status, providers, t.Expires.Format(time.RFC3339))
+		}
+		for _, c := range vf.Credentials {
+			upstream := c.Upstream
+			if upstream == "" {
+				upstream = "(default)"
+			}
+			fmt.Fprintf(out, "credential  %s %s %s\n", c.Provider, upstream, ObfuscateAPIKey(c.Key))
+		}
+		return nil
+
+	case cmd.SetKey != "":
+		key, err := io.ReadAll(io.LimitReader(stdin, 64<<10))
+		if err != nil {
+			return err
+		}
+		if err := vf.setCredential(reg, cmd.SetKey, cmd.Upstream, string(key)); err != nil {
+			return err
+		}
+		if err := vf.save(path); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Stored %s credential\n", cmd.SetKey)
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+// vault_test.go
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadVaultFile(t *testing.T) {
+	dir := t.TempDir()
+
+	vf, err := loadVaultFile(filepath.Join(dir, "missing.json"))
+	if err != nil || len(vf.Tokens) != 0 {
+		t.Errorf("missing vault = (%+v, %v), want empty", vf, err)
+	}
+
+	loose := filepath.Join(dir, "loose.json")
+	os.WriteFile(loose, []byte(`{}`), 0644)
+	if _, err := loadVaultFile(loose); err == nil || !strings.Contains(err.Error(), "chmod 600") {
+		t.Errorf("expected error for world-readable vault, got %v", err)
+	}
+
+	path := filepath.Join(dir, "vault.json")
+	vf.setCredential(builtinRegistry, "anthropic", "", "sk-ant-real")
+	if err := vf.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("vault mode = %04o, want 0600", info.Mode().Perm())
+	}
+	if vf, err := loadVaultFile(path); err != nil || len(vf.Credentials) != 1 || vf.Credentials[0].Key != "sk-ant-real" {
+		t.Errorf("reloaded vault = (%+v, %v)", vf, err)
+	}
+}
+
+func TestNewVaultSnapshot_DefaultUpstream(t *testing.T) {
+	// A key stored for the default upstream and one stored for it by name
+	// share one pool
+	vf := &vaultFile{}
+	vf.setCredential(builtinRegistry, "anthropic", "", "sk-ant-api03-first-key")
+	vf.setCredential(builtinRegistry, "anthropic", "api.anthropic.com", "sk-ant-api03-second-key")
+
+	snap, err := newVaultSnapshot(vf, builtinRegistry)
+	if err != nil {
+		t.Fatalf("newVaultSnapshot: %v", err)
+	}
+	if len(snap.pools) != 1 {
+		t.Fatalf("pools = %v, want one", snap.pools)
+	}
+	if pool := snap.pools.forUpstream("anthropic", "api.anthropic.com"); pool == nil || len(pool.keys) != 2 {
+		t.Errorf("api.anthropic.com pool = %+v, want both keys", pool)
+	}
+}
+
+func TestVaultFile_Issue(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		principal string
+		providers []string
+		ttl       time.Duration
+		wantErr   bool
+	}{
+		{"user", "user:alice", nil, time.Hour, false},
+		{"team scoped", "team:ml-infra", []string{"anthropic", "openai"}, time.Hour, false},
+		{"bare name", "alice", nil, time.Hour, true},
+		{"unknown provider", "user:alice", []string{"nope"}, time.Hour, true},
+		{"no ttl", "user:alice", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vf := &vaultFile{}
+			token, vt, err := vf.issue(builtinRegistry, tt.principal, tt.providers, tt.ttl, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("issue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(token, vaultTokenPrefix+vt.ID+"-") || strings.Contains(vt.Hash, token) {
+				t.Errorf("token = %q, stored = %+v", token, vt)
+			}
+		})
+	}
+}
+
+// newTestVault writes a vault with an anthropic credential and returns its
+// state and a token for principal limited to providers.
+func newTestVault(t *testing.T, reg *providerRegistry, principal string, providers ...string) (*vaultState, string, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "vault.json")
+	vf := &vaultFile{}
+	if err := vf.setCredential(reg, "anthropic", "", "sk-ant-REDACTED"); err != nil {
+		t.Fatalf("setCredential: %v", err)
+	}
+	token, _, err := vf.issue(reg, principal, providers, time.Hour, time.Now())
+	if err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	if err := vf.save(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	v, err := newVaultState(VaultConfig{Enabled: true, Path: path}, reg)
+	if err != nil {
+		t.Fatalf("newVaultState: %v", err)
+	}
+	return v, token, path
+}
+
+func TestVaultState_Authenticate(t *testing.T) {
+	v, token, path := newTestVault(t, builtinRegistry, "team:ml", "anthropic")
+	anthropic, openai := builtinRegistry.lookup("anthropic"), builtinRegistry.lookup("openai")
+	now := time.Now()
+
+	header := func(name, value string) http.Header {
+		h := http.Header{}
+		h.Set(name, value)
+		return h
+	}
+
+	tests := []struct {
+		name       string
+		header     http.Header
+		spec       *providerSpec
+		upstream   string
+		at         time.Time
+		wantStatus int
+		wantErr    error
+	}{
+		{"x-api-key", header("x-api-key", token), anthropic, "api.anthropic.com", now, http.StatusOK, nil},
+		{"bearer", header("Authorization", "Bearer "+token), anthropic, "api.anthropic.com", now, http.StatusOK, nil},
+		{"no token", header("x-api-key", "sk-ant-real"), anthropic, "api.anthropic.com", now, http.StatusUnauthorized, errVaultNoToken},
+		{"wrong secret", header("x-api-key", token[:len(token)-4]+"0000"), anthropic, "api.anthropic.com", now, http.StatusUnauthorized, errVaultInvalidToken},
+		{"unknown id", header("x-api-key", "llmp-00000000-abcd"), anthropic, "api.anthropic.com", now, http.StatusUnauthorized, errVaultInvalidToken},
+		{"expired", header("x-api-key", token), anthropic, "api.anthropic.com", now.Add(2 * time.Hour), http.StatusUnauthorized, errVaultExpiredToken},
+		{"provider not allowed", header("Authorization", "Bearer "+token), openai, "api.openai.com", now, http.StatusForbidden, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, pool, status, err := v.authenticate(tt.header, tt.spec, tt.upstream, tt.at)
+			if status != tt.wantStatus || (tt.wantErr != nil && !errors.Is(err, tt.wantErr)) {
+				t.Fatalf("authenticate() = (%q, %d, %v), want status %d err %v", principal, status, err, tt.wantStatus, tt.wantErr)
+			}
+			if status == http.StatusOK && (principal != "team:ml" || pool == nil) {
+				t.Errorf("authenticate() = (%q, pool %v)", principal, pool)
+			}
+		})
+	}
+
+	// A revocation written by the CLI applies to the running proxy
+	var out bytes.Buffer
+	if err := runVaultCommand(VaultCommand{Revoke: "team:ml"}, path, builtinRegistry, nil, &out, now); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, _, _, err := v.authenticate(header("x-api-key", token), anthropic, "api.anthropic.com", now); !errors.Is(err, errVaultRevokedToken) {
+		t.Errorf("after revoke: err = %v, want %v", err, errVaultRevokedToken)
+	}
+}
+
+func TestRunVaultCommand(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vault.json")
+	now := time.Now()
+
+	var out bytes.Buffer
+	if err := runVaultCommand(VaultCommand{SetKey: "openai"}, path, builtinRegistry, strings.NewReader("sk-proj-abcdefghijklmnop\n"), &out, now); err != nil {
+		t.Fatalf("set-key: %v", err)
+	}
+
+	out.Reset()
+	if err := runVaultCommand(VaultCommand{Issue: "user:alice", TTL: time.Hour}, path, builtinRegistry, nil, &out, now); err != nil {
+		t.Fatalf("issue: %v", err)
+	}
+	token := strings.TrimSpace(out.String())
+	if !strings.HasPrefix(token, vaultTokenPrefix) {
+		t.Fatalf("issue output = %q, want only the token", out.String())
+	}
+
+	out.Reset()
+	if err := runVaultCommand(VaultCommand{List: true}, path, builtinRegistry, nil, &out, now); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	list := out.String()
+	if !strings.Contains(list, "user:alice") || !strings.Contains(list, "active") || !strings.Contains(list, "sk-proj-...mnop") {
+		t.Errorf("list =\n%s", list)
+	}
+	if strings.Contains(list, token) || strings.Contains(list, "abcdefghijklmnop") {
+		t.Errorf("list leaks secrets:\n%s", list)
+	}
+
+	if err := runVaultCommand(VaultCommand{Revoke: "ffffffff"}, path, builtinRegistry, nil, &out, now); err == nil {
+		t.Error("expected error revoking an unknown token")
+	}
+}
+
+func TestProxy_VaultMode(t *testing.T) {
+	var gotKey, gotAuth string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKey, gotAuth = r.Header.Get("x-api-key"), r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"content":[{"type":"text","text":"Hi"}]}`))
+	}))
+	defer upstream.Close()
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	providers := newTestProviders(t, ProviderConfig{
+		Name:              "anthropic",
+		Upstream:          host,
+		Dialect:           dialectAnthropic,
+		ConversationPaths: []string{"/v1/messages"},
+		AuthHeaders:       []string{"x-api-key", "authorization"},
+	})
+	v, token, _ := newTestVault(t, providers, "user:alice")
+
+	loki := newMockLokiExporter(nil)
+	proxy := NewProxyWithSessionManagerAndLogger(NewMultiWriter(newMockFileLogger(), loki), nil)
+	proxy.vault = v
+	proxy.providers = providers
+
+	send := func(path, apiKey string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(`{"model":"claude-3","messages":[{"role":"user","content":"Hello"}]}`))
+		req.Header.Set("x-api-key", apiKey)
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := send("/anthropic/v1/messages", token); code != http.StatusOK {
+		t.Fatalf("status = %d", code)
+	}
+	if gotKey != "sk-ant-REDACTED" || gotAuth != "" {
+		t.Errorf("upstream got x-api-key %q, Authorization %q; want the vault key only", gotKey, gotAuth)
+	}
+	for _, call := range loki.pushCalls {
+		meta, _ := call.entry["_meta"].(map[string]interface{})
+		if call.entry["type"] != "session_start" && meta["principal"] != "user:alice" {
+			t.Errorf("%s entry _meta = %v, want principal", call.entry["type"], meta)
+		}
+	}
+
+	gotKey = ""
+	if code := send("/anthropic/v1/messages", "sk-ant-api03-developer-key"); code != http.StatusUnauthorized || gotKey != "" {
+		t.Errorf("real key: status = %d, upstream called = %v; want 401", code, gotKey != "")
+	}
+	// The token is valid but the vault has no key for this upstream
+	if code := send("/anthropic/other.example.com/v1/messages", token); code != http.StatusForbidden {
+		t.Errorf("other upstream: status = %d, want 403", code)
+	}
+}
@@ -0,0 +1,271 @@
+// vertex.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Anthropic-on-Vertex AI pass-through.
+//
+// Clients send the Vertex path unchanged (ANTHROPIC_VERTEX_BASE_URL points at
+// the proxy) without credentials. The proxy mints a Google OAuth access token
+// from a service-account key or ADC file, forwards to
+// {location}-aiplatform.googleapis.com, and logs the Anthropic-format
+// payloads like any other Anthropic traffic.
+
+// vertexPathPattern matches Vertex publisher model endpoints for Claude.
+// Project, location and model are constrained to prevent SSRF via the host.
+var vertexPathPattern = regexp.MustCompile(`^/v1/projects/([a-z][a-z0-9-]{4,28}[a-z0-9])/locations/([a-z0-9-]+)/publishers/anthropic/models/([a-zA-Z0-9._@-]+):(rawPredict|streamRawPredict)$`)
+
+// vertexMaxRequestBody is the max request body size for Vertex requests (16 MB).
+const vertexMaxRequestBody = 16 << 20
+
+// vertexMaxResponseBody is the max non-streaming response body size (16 MB).
+// Larger responses get a 502 rather than a truncated body.
+const vertexMaxResponseBody = 16 << 20
+
+// isVertexForwardHeader returns true for the client headers sent on to
+// Vertex: the content headers, User-Agent and the Anthropic-* headers
+// (anthropic-beta, anthropic-version). Credentials are replaced by the
+// proxy's token.
+func isVertexForwardHeader(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	switch name {
+	case "Content-Type", "Accept", "User-Agent":
+		return true
+	}
+	return strings.HasPrefix(name, "Anthropic-")
+}
+
+// vertexCountTokensModel is the pseudo-model Vertex uses for token counting,
+// which is not a conversation turn.
+const vertexCountTokensModel = "count-tokens"
+
+// vertexRoute holds the components of a Vertex publisher model path.
+type vertexRoute struct {
+	project   string
+	location  string
+	model     string
+	streaming bool
+}
+
+// parseVertexPath extracts and validates project, location and model from a
+// Vertex AI path.
+// Path format: /v1/projects/{p}/locations/{l}/publishers/anthropic/models/{model}:streamRawPredict
+func parseVertexPath(path string) (vertexRoute, error) {
+	m := vertexPathPattern.FindStringSubmatch(path)
+	if m == nil {
+		return vertexRoute{}, fmt.Errorf("invalid Vertex AI path: %q", path)
+	}
+	return vertexRoute{
+		project:   m[1],
+		location:  m[2],
+		model:     m[3],
+		streaming: m[4] == "streamRawPredict",
+	}, nil
+}
+
+// isVertexPath returns true for Anthropic-on-Vertex publisher model paths.
+func isVertexPath(path string) bool {
+	return vertexPathPattern.MatchString(path)
+}
+
+// vertexHost returns the Vertex AI API host for a location.
+// The "global" location uses the un-prefixed host.
+func vertexHost(location string) string {
+	if location == "global" {
+		return "aiplatform.googleapis.com"
+	}
+	return location + "-aiplatform.googleapis.com"
+}
+
+// vertexState holds per-proxy Vertex AI resources initialized at startup.
+type vertexState struct {
+	tokens *googleTokenSource
+	client *http.Client
+}
+
+// initVertex initializes Vertex AI resources. Returns nil if Vertex is not enabled.
+func initVertex(cfg VertexConfig) (*vertexState, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+
+	tokens, err := loadGoogleTokenSource(cfg.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &vertexState{
+		tokens: tokens,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DisableCompression:    true,
+				ResponseHeaderTimeout: 300 * time.Second,
+				ForceAttemptHTTP2:     true,
+			},
+			Timeout: 0,
+		},
+	}, nil
+}
+
+// serveVertex handles Anthropic-on-Vertex pass-through requests. The proxy adds
+// a Google OAuth bearer token, forwards to Vertex, and logs the response.
+// streamRawPredict returns plain Anthropic SSE, so streaming reuses streamResponse.
+func (p *Proxy) serveVertex(w http.ResponseWriter, r *http.Request) {
+	if p.vertex == nil {
+		http.Error(w, "Vertex AI not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	startTime := time.Now()
+
+	route, err := parseVertexPath(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var reqBody []byte
+	if r.Body != nil {
+		reqBody, err = io.ReadAll(io.LimitReader(r.Body, vertexMaxRequestBody+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusInternalServerError)
+			return
+		}
+		r.Body.Close()
+		if len(reqBody) > vertexMaxRequestBody {
+			http.Error(w, fmt.Sprintf("request body exceeds %d bytes", vertexMaxRequestBody), http.StatusRequestEntityTooLarge)
+			return
+		}
+	}
+
+	// Get the token before logging, so a request that can't be sent isn't
+	// logged without a response
+	token, err := p.vertex.tokens.Token(r.Context())
+	if err != nil {
+		log.Printf("WARNING: Vertex token error: %v (model=%s)", err, route.model)
+		http.Error(w, "failed to obtain Google access token", http.StatusBadGateway)
+		return
+	}
+
+	// Use provider=anthropic — Vertex payloads use the Anthropic JSON format
+	provider := "anthropic"
+	upstream := vertexHost(route.location)
+
+	var denial *PolicyDenial
+	if route.model != vertexCountTokensModel {
+		denial = p.policies.check(provider, r.URL.Path, r.Header, proxyIdentity(r.Context()), route.model, reqBody)
+	}
+
+	var sessionID string
+	var seq int
+	var requestID string
+	var patternState *PatternState
+
+	shouldLog := p.logger != nil && route.model != vertexCountTokensModel
+
+	if shouldLog {
+		requestID = uuid.New().String()
+
+		// Set transport context for Loki transport/model labels on response entries
+		if mw, ok := p.logger.(*MultiWriter); ok {
+			mw.SetTransportContext(requestID, "vertex", route.model)
+			defer mw.ClearTransportContext(requestID)
+		}
+		defer p.recordIdentity(r, requestID)()
+
+		sessionID, seq, patternState = p.beginLoggedTurn(r, reqBody, provider, upstream, requestID)
+	}
+
+	if denial != nil {
+		p.deny(w, dialectAnthropic, denial, provider, sessionID, seq, requestID, patternState)
+		return
+	}
+	if shouldLog && p.budgets != nil {
+		defer p.budgets.end(requestID)
+		if !p.checkBudgets(w, dialectAnthropic, provider, sessionID, seq, requestID, patternState, "") {
+			return
+		}
+	}
+
+	upstreamURL := "https://" + upstream + r.URL.Path
+	if r.URL.RawQuery != "" {
+		upstreamURL += "?" + r.URL.RawQuery
+	}
+
+	proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, upstreamURL, bytes.NewReader(reqBody))
+	if err != nil {
+		http.Error(w, "failed to create request", http.StatusInternalServerError)
+		return
+	}
+
+	// Allowlisted headers only — the client's own Authorization (if any) is replaced
+	for name, values := range r.Header {
+		if isVertexForwardHeader(name) {
+			for _, v := range values {
+				proxyReq.Header.Add(name, v)
+			}
+		}
+	}
+	proxyReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := p.vertex.client.Do(proxyReq)
+	if err != nil {
+		http.Error(w, "upstream request failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if isStreamingResponse(resp) {
+		var loggerForStream ProxyLogger
+		var smForStream *SessionManager
+		if shouldLog {
+			loggerForStream = p.logger
+			smForStream = p.sessionManager
+		}
+		chunks, _ := streamResponse(w, resp, loggerForStream, smForStream, sessionID, provider, dialectAnthropic, seq, startTime, reqBody, requestID, p.eventEmitter, p.machineID, patternState)
+		p.recordResponseUsage(requestID, sessionID, resp.StatusCode, nil, chunks)
+		return
+	}
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, vertexMaxResponseBody+1))
+	if err != nil {
+		http.Error(w, "failed to read response body", http.StatusBadGateway)
+		return
+	}
+	if len(respBody) > vertexMaxResponseBody {
+		log.Printf("WARNING: Vertex response exceeded %d bytes (model=%s session=%s)", vertexMaxResponseBody, route.model, sessionID)
+		http.Error(w, fmt.Sprintf("upstream response exceeds %d bytes", vertexMaxResponseBody), http.StatusBadGateway)
+		return
+	}
+
+	totalTime := time.Since(startTime)
+
+	if shouldLog {
+		timing := ResponseTiming{
+			TTFBMs:  totalTime.Milliseconds(),
+			TotalMs: totalTime.Milliseconds(),
+		}
+		p.logger.LogResponse(sessionID, provider, seq, resp.StatusCode, resp.Header, respBody, nil, timing, requestID)
+
+		if p.eventEmitter != nil && patternState != nil {
+			parsed := ParseResponseBody(string(respBody), upstream)
+			p.processResponseAndEmitEvents(parsed, sessionID, provider, patternState, resp.StatusCode, string(respBody), requestID)
+		}
+		p.recordResponseUsage(requestID, sessionID, resp.StatusCode, respBody, nil)
+	}
+
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}